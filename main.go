@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"gtrace/src/common/config"
 	"gtrace/src/ports_adapters/primary/cli"
+	"gtrace/src/ports_adapters/primary/http_server"
 	"gtrace/src/ports_adapters/secondary/service/app"
+	"gtrace/src/ports_adapters/secondary/service/storage"
 
 	clir "gtrace/src/domain/cli"
 
 	"log/slog"
 	"os"
+	"os/signal"
 	"reflect"
+	"syscall"
 )
 
 func main() {
@@ -34,19 +38,61 @@ func main() {
 func startCli(conf config.CommandCli) {
 	logger := config.InitLogger(conf.LogLvl)
 	logger.Info("starting cli")
-	application := app.InitApp(logger)
+	application := app.InitApp(logger, appConfig(conf.Store))
 	c := cli.NewCli(*application)
-	cliRouter(conf, "gotrace", context.Context(context.TODO()), c.GoTrace)
+	tag, handler := "gotrace", c.GoTrace
+	switch {
+	case conf.Analyze != nil:
+		tag, handler = "analyze", c.AnalyzeTrace
+	case conf.Runs != nil:
+		tag, handler = "runs", c.Runs
+	}
+	// прерывание останавливает трассируемую программу; записанная часть трассы всё равно анализируется
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err := cliRouter(conf, tag, ctx, handler); err != nil {
+		stop()
+		os.Exit(1)
+	}
 
 }
 
 func startServer(conf config.ServerCli) {
 	logger := config.InitLogger(conf.LogLvl)
 	logger.Info("starting server")
+	appConf := appConfig(conf.Store)
+	appConf.Jobs, appConf.JobTimeout = conf.Jobs, conf.JobTimeout
+	application := app.InitApp(logger, appConf)
+	server := http_server.NewServer(*application, logger, http_server.Config{
+		Host:     conf.Host,
+		Port:     conf.Port,
+		Live:     conf.Live,
+		Projects: conf.Projects,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err := server.Run(ctx); err != nil {
+		logger.Error("Ошибка HTTP-сервера", "error", err)
+		os.Exit(1)
+	}
+}
 
+// appConfig — настройки приложения с хранилищем трасс из флагов
+func appConfig(store config.Store) app.Config {
+	return app.Config{
+		Store: store.Dir,
+		Retention: storage.Retention{
+			MaxCount: store.MaxRuns,
+			MaxAge:   store.MaxAge,
+			MaxSize:  store.MaxSizeMB << 20,
+		},
+	}
 }
 
-func cliRouter(cmd config.CommandCli, tag string, ctx context.Context, fn func(r *clir.Request) error) {
+// cliRouter вызывает обработчик команды с тегом tag; ошибка обработчика возвращается,
+// чтобы процесс завершился с ненулевым кодом
+func cliRouter(cmd config.CommandCli, tag string, ctx context.Context, fn func(r *clir.Request) error) error {
 	val := reflect.ValueOf(cmd)
 	typ := val.Type()
 
@@ -64,7 +110,7 @@ func cliRouter(cmd config.CommandCli, tag string, ctx context.Context, fn func(r
 				nestedStruct = fieldValue.Interface()
 			} else {
 				slog.Warn(fmt.Sprintf("command %s is nil", tag))
-				return
+				return nil
 			}
 			r := &clir.Request{
 				Ctx:  ctx,
@@ -74,11 +120,11 @@ func cliRouter(cmd config.CommandCli, tag string, ctx context.Context, fn func(r
 			err := fn(r)
 			if err != nil {
 				slog.Error(err.Error())
-				return
+				return err
 			}
-			return
+			return nil
 		}
 	}
 	slog.Warn(fmt.Sprintf("command %s not found", tag))
-	return
+	return nil
 }
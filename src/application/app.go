@@ -1,11 +1,43 @@
 package application
 
-import "gtrace/src/application/commands"
+import (
+	"gtrace/src/application/commands"
+	"gtrace/src/application/jobs"
+	"gtrace/src/application/live"
+	"gtrace/src/application/queries"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+)
 
 type App struct {
 	Commands Command
+	Queries  Query
+	// Jobs — очередь заданий трассировки (Commands.GoTraceCli с ограничением числа и времени выполнения)
+	Jobs *jobs.Queue
+	// Live — живые трассы программ, отправляющих события на сервер во время выполнения
+	Live *live.Hub
+	// Traces — хранилище трасс; сервер периодически применяет его политику хранения
+	Traces *storage.Traces
 }
 
 type Command struct {
-	GoTraceCli commands.GoTraceCommand
+	GoTraceCli   commands.GoTraceCommand
+	AnalyzeTrace commands.AnalyzeTrace
+	ImportTrace  commands.ImportTrace
+	TagTrace     commands.TagTrace
+	DeleteTrace  commands.DeleteTrace
+}
+
+// Query — запросы к сохранённым трассам
+type Query struct {
+	ListTraces       queries.ListTraces
+	GetTrace         queries.GetTrace
+	TraceGraph       queries.TraceGraph
+	TraceReport      queries.TraceReport
+	TraceEvents      queries.TraceEvents
+	GoroutineDetails queries.GoroutineDetails
+	ChannelDetails   queries.ChannelDetails
+	LiveSessions     queries.LiveSessions
+	LiveSession      queries.LiveSession
+	LiveGraph        queries.LiveGraph
+	LiveEvents       queries.LiveEvents
 }
@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"gtrace/src/common/decorator"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"log/slog"
+	"os"
+)
+
+type analyzeTraceCommand struct {
+	parserService *parser.Parser
+	logger        *slog.Logger
+}
+
+// AnalyzeTraceCommand — анализ готовой трассы без инструментирования и запуска проекта: трассы gtrace
+// (текстовой или двоичной), трассы выполнения Go (runtime/trace) либо дампа горутин. Несколько трасс
+// ([имя=]путь) объединяются в один граф; Align — "start" или "wall", Offsets — явные сдвиги часов
+// трасс в виде имя=длительность. Filter ограничивает отчёт и граф в DotPath; Events — вместо отчёта
+// вывести события трассы, прошедшие фильтр
+type AnalyzeTraceCommand struct {
+	TracePaths []string
+	Align      string
+	Offsets    []string
+	Strict     bool
+	Filter     string
+	DotPath    string
+	Events     bool
+}
+
+type AnalyzeTrace decorator.CommandDecorator[AnalyzeTraceCommand, any]
+
+func NewAnalyzeTraceCommand(parserService *parser.Parser, logger *slog.Logger) decorator.CommandDecorator[AnalyzeTraceCommand, any] {
+	handler := &analyzeTraceCommand{
+		parserService: parserService,
+		logger:        logger,
+	}
+	return decorator.ApplyCommandDecorator[AnalyzeTraceCommand, any](handler, logger)
+}
+
+func (h *analyzeTraceCommand) Handle(ctx context.Context, command AnalyzeTraceCommand) (any, error) {
+	h.logger.Info("Начало выполнения команды Analyze", "tracePaths", command.TracePaths)
+
+	query, err := parseQuery(command.Filter)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := mergeInputs(command)
+	if err != nil {
+		return nil, err
+	}
+	align := parseAlign(command.Align)
+
+	if command.Events {
+		written, err := h.parserService.WriteEvents(inputs, align, parseMode(command.Strict), query, os.Stdout)
+		if err != nil {
+			return nil, fmt.Errorf("разбор трассы: %w", err)
+		}
+		h.logger.Info("События трассы выведены", "events", written)
+		return written, nil
+	}
+
+	graph, err := h.parserService.ParseFiles(inputs, align, parseMode(command.Strict))
+	if err != nil {
+		return nil, fmt.Errorf("разбор трассы: %w", err)
+	}
+
+	result := TraceResult{Graph: graph, Report: NewTraceReport(graph, query)}
+	if command.DotPath != "" {
+		view := graph
+		if query != nil {
+			view = graph.Filter(query)
+		}
+		if err := os.WriteFile(command.DotPath, []byte(view.ToDot()), 0o644); err != nil {
+			return result, fmt.Errorf("запись графа: %w", err)
+		}
+	}
+	return result, result.Report.Err()
+}
+
+// mergeInputs разбирает пути трасс ([имя=]путь) и явные сдвиги их часов
+func mergeInputs(command AnalyzeTraceCommand) ([]parser.MergeInput, error) {
+	inputs := make([]parser.MergeInput, 0, len(command.TracePaths))
+	for _, spec := range command.TracePaths {
+		inputs = append(inputs, parser.ParseMergeInput(spec))
+	}
+	if len(inputs) == 1 && len(command.Offsets) == 0 {
+		// одна трасса не объединяется: путь берётся как есть, даже если содержит "="
+		inputs[0] = parser.MergeInput{Path: command.TracePaths[0]}
+	}
+	if err := applyOffsets(inputs, command.Offsets); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+// applyOffsets задаёт трассам явные сдвиги часов (имя=длительность); имя — имя источника трассы
+func applyOffsets(inputs []parser.MergeInput, offsets []string) error {
+	for _, spec := range offsets {
+		name, offset, err := parser.ParseOffset(spec)
+		if err != nil {
+			return err
+		}
+		found := false
+		for i := range inputs {
+			if inputs[i].SourceName() == name {
+				inputs[i].Offset = offset
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("offset for unknown trace %q", name)
+		}
+	}
+	return nil
+}
+
+// parseAlign выбирает способ выравнивания часов трасс: "wall" или по умолчанию "start"
+func parseAlign(align string) parser.Align {
+	if align == "wall" {
+		return parser.AlignWall
+	}
+	return parser.AlignStart
+}
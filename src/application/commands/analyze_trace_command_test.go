@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"context"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"testing"
+	"time"
+)
+
+// Дамп горутин и трасса выполнения Go не содержат завершения программы: горутины, работающие
+// в момент дампа или к концу трассы, не делают анализ неуспешным
+func TestAnalyzeUninstrumentedInputs(t *testing.T) {
+	dump := filepath.Join(t.TempDir(), "dump.txt")
+	healthy := "goroutine 1 [running]:\nmain.main()\n\t/app/main.go:12 +0x25\n\n" +
+		"goroutine 7 [sleep]:\ntime.Sleep(0x3b9aca00)\n\t/usr/local/go/src/runtime/time.go:338 +0x165\n" +
+		"main.worker()\n\t/app/main.go:20 +0x1a\ncreated by main.main in goroutine 1\n\t/app/main.go:10 +0x1e\n"
+	if err := os.WriteFile(dump, []byte(healthy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "дамп горутин", path: dump},
+		{name: "трасса выполнения Go", path: recordRuntimeTrace(t)},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := NewAnalyzeTraceCommand(parser.NewParser(logger), logger)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := handler.Handle(context.Background(), AnalyzeTraceCommand{TracePaths: []string{tt.path}})
+			if err != nil {
+				t.Fatalf("анализ завершился ошибкой: %v", err)
+			}
+			report := result.(TraceResult).Report
+			if len(report.Leaks.Unfinished) == 0 {
+				t.Fatalf("во входных данных нет работающих горутин:\n%s", report)
+			}
+			if report.Leaks.Recorded {
+				t.Errorf("вход считается трассой gtrace:\n%s", report)
+			}
+		})
+	}
+}
+
+// recordRuntimeTrace записывает трассу выполнения, которая заканчивается, пока запущенная горутина спит
+func recordRuntimeTrace(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "running.trace")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := trace.Start(f); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(100 * time.Millisecond)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	trace.Stop()
+	<-done
+	return path
+}
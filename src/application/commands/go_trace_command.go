@@ -1,19 +1,27 @@
 package commands
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"gtrace/src/common/decorator"
+	domain "gtrace/src/domain/parser"
 	"gtrace/src/ports_adapters/secondary/service/instrumented"
 	"gtrace/src/ports_adapters/secondary/service/parser"
-
+	"gtrace/src/ports_adapters/secondary/service/storage"
 	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type goTraceCommand struct {
 	instrumentedService *instrumented.Instrumented
 	parserService       *parser.Parser
+	traces              *storage.Traces
 	logger              *slog.Logger
 }
 
@@ -21,18 +29,81 @@ type TraceCommand struct {
 	Cleared    bool
 	TargetPath string
 	OutputPath string
+	// Strict — некорректная запись трассы завершает команду ошибкой, а не попадает в диагностику
+	Strict bool
+	// Filter — выражение фильтра (см. domain.ParseQuery): отчёт строится только по прошедшим его
+	// горутинам и каналам
+	Filter string
+	// Project — проект в метаданных сохранённой трассы (пусто — абсолютный путь TargetPath),
+	// например имя загруженного архива
+	Project string
+	// Live — адрес сервера gtrace (tcp:<IP>:<порт> или unix:<путь>), которому программа во время
+	// выполнения отправляет трассу в дополнение к файлу
+	Live string
+	// OnStage, если задан, вызывается при переходе к следующему этапу выполнения
+	OnStage func(Stage)
 }
 
+// Stage — этап выполнения TraceCommand
+type Stage string
+
+const (
+	StageInstrumenting Stage = "instrumenting"
+	StageRunning       Stage = "running"
+	StageParsing       Stage = "parsing"
+)
+
+const (
+	// terminateDelay — сколько ждать завершения программы по SIGTERM, прежде чем уничтожить её группу процессов
+	terminateDelay = 5 * time.Second
+	// killDelay — сколько ждать закрытия вывода программы после уничтожения её группы процессов
+	killDelay = 5 * time.Second
+)
+
 const (
 	instrumentedLog = "instrumented.log"
+	programLog      = "program.log"
+	// programLogTail — сколько последних строк вывода программы попадает в ошибку запуска
+	programLogTail = 20
+)
+
+var (
+	// ErrGoroutineLeak возвращается, если к завершению программы остались незавершённые горутины
+	ErrGoroutineLeak = errors.New("обнаружены утечки горутин")
+	// ErrDeadlock возвращается, если в трассе найдена взаимная блокировка
+	ErrDeadlock = errors.New("обнаружена взаимная блокировка")
+	// ErrNoShutdown возвращается, если трасса закончилась без завершения программы, а горутины
+	// к её концу не завершились: утечки по такой трассе не определяются
+	ErrNoShutdown = errors.New("трасса закончилась без завершения программы")
 )
 
+// TraceReport — результат анализа трассы
+type TraceReport struct {
+	Leaks       domain.LeakReport
+	Deadlocks   domain.DeadlockReport
+	Blocking    domain.BlockingProfile
+	Incidents   domain.IncidentReport
+	Diagnostics domain.DiagnosticReport
+	StackGroups domain.StackGroupReport
+	Sources     domain.SourcesReport
+}
+
+// TraceResult — результат команд трассировки и анализа: граф трассы целиком и отчёт по нему
+// (с фильтром — по подграфу). Вывод отчёта — дело вызывающего
+type TraceResult struct {
+	// TraceID — ID трассы в хранилище; пустой, если трассу сохранить не удалось
+	TraceID string
+	Graph   *domain.GorutineGraph
+	Report  TraceReport
+}
+
 type GoTraceCommand decorator.CommandDecorator[TraceCommand, any]
 
-func NewGoTraceCommand(parserService *parser.Parser, logger *slog.Logger, instrument *instrumented.Instrumented) decorator.CommandDecorator[TraceCommand, any] {
+func NewGoTraceCommand(parserService *parser.Parser, traces *storage.Traces, logger *slog.Logger, instrument *instrumented.Instrumented) decorator.CommandDecorator[TraceCommand, any] {
 	handler := &goTraceCommand{
 		instrumentedService: instrument,
 		parserService:       parserService,
+		traces:              traces,
 		logger:              logger,
 	}
 	return decorator.ApplyCommandDecorator[TraceCommand, any](handler, logger)
@@ -41,26 +112,240 @@ func NewGoTraceCommand(parserService *parser.Parser, logger *slog.Logger, instru
 func (h *goTraceCommand) Handle(ctx context.Context, command TraceCommand) (any, error) {
 	h.logger.Info("Начало выполнения команды Trace", "targetPath", command.TargetPath, "outputPath", command.OutputPath)
 
+	query, err := parseQuery(command.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	command.stage(StageInstrumenting)
 	if err := h.instrumentedService.Processed(command.TargetPath, command.OutputPath); err != nil {
 		h.logger.Error("Ошибка при инструментировании проекта", "error", err)
 		return nil, fmt.Errorf("инструментирование проекта: %w", err)
 	}
 
-	cmd := exec.Command("sh", "-c", fmt.Sprintf("cd %s && go run main.go > %s", command.OutputPath, instrumentedLog))
-	h.logger.Debug("Формирование команды запуска", "cmd", cmd.String())
+	// Трасса пишется в отдельный файл через приёмник рантайма gtrace, вывод программы — в programLog
+	tracePath, err := filepath.Abs(filepath.Join(command.OutputPath, instrumentedLog))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(tracePath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	output, err := os.Create(filepath.Join(command.OutputPath, programLog))
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("запуск программы отменён: %w", err)
+	}
+
+	// Отмена ctx (в том числе по таймауту) останавливает всю группу процессов: go run запускает
+	// собранную программу дочерним процессом. Группа получает SIGTERM, и программа, подписанная
+	// на него (сама или через GTRACE_SIGNALS), сбрасывает трассу и записывает завершение по сигналу;
+	// через terminateDelay группа уничтожается. Записанная к этому моменту трасса разбирается
+	cmd := exec.CommandContext(ctx, "go", "run", ".")
+	setProcessGroup(cmd)
+	stopper := &processGroupStopper{cmd: cmd, delay: terminateDelay}
+	cmd.Cancel = stopper.cancel
+	cmd.WaitDelay = terminateDelay + killDelay
+	cmd.Dir = command.OutputPath
+	sink := "file:" + tracePath
+	if command.Live != "" {
+		sink += "," + command.Live
+	}
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", instrumented.SinkEnv, sink),
+		fmt.Sprintf("%s=binary", instrumented.FormatEnv))
+	cmd.Stdout = output
+	cmd.Stderr = output
+	h.logger.Debug("Формирование команды запуска", "cmd", cmd.String(), "trace", tracePath)
 
+	command.stage(StageRunning)
+	started := time.Now()
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
-	if err := cmd.Wait(); err != nil {
-		h.logger.Error("Ошибка завершения команды", "error", err)
-		return nil, fmt.Errorf("wait command: %w", err)
+	// Программа могла завершиться с ненулевым кодом или упасть (в том числе из-за взаимной блокировки):
+	// трасса всё равно разбирается и анализируется
+	waitErr := cmd.Wait()
+	stopper.stop()
+	duration := time.Since(started)
+	if waitErr != nil {
+		h.logger.Warn("Программа завершилась с ошибкой", "error", waitErr, "output", output.Name())
 	}
-	graph, err := h.parserService.ParseFromFile(fmt.Sprintf("%s/%s", command.OutputPath, instrumentedLog))
+	stopped := ctx.Err()
+	if stopped != nil {
+		h.logger.Warn("Программа остановлена, разбирается записанная часть трассы", "reason", stopped)
+	}
+
+	// Трассы нет: программа не запустилась (например, не собралась), причина — в её выводе
+	if info, err := os.Stat(tracePath); waitErr != nil && (err != nil || info.Size() == 0) {
+		return nil, fmt.Errorf("запуск программы: %w\n%s", waitErr, logTail(output.Name(), programLogTail))
+	}
+
+	command.stage(StageParsing)
+	graph, err := h.parserService.ParseFromFile(tracePath, parseMode(command.Strict))
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(graph)
 
-	return nil, nil
+	result := TraceResult{Graph: graph, Report: NewTraceReport(graph, query)}
+	result.TraceID = h.saveRun(command, tracePath, duration, graph)
+	if stopped != nil {
+		return result, fmt.Errorf("программа остановлена: %w", stopped)
+	}
+	if err := result.Report.Err(); err != nil {
+		return result, err
+	}
+	if waitErr != nil && graph.Shutdown == nil {
+		h.logger.Error("Ошибка завершения команды", "error", waitErr)
+		return result, fmt.Errorf("wait command: %w", waitErr)
+	}
+
+	return result, nil
+}
+
+// saveRun сохраняет трассу запуска в хранилище и возвращает её ID. Трасса уже разобрана и отчёт
+// построен, поэтому ошибка хранилища не прерывает команду, а только попадает в лог
+func (h *goTraceCommand) saveRun(command TraceCommand, tracePath string, duration time.Duration, graph *domain.GorutineGraph) string {
+	id, dir, err := h.traces.Create()
+	if err == nil {
+		err = copyFile(filepath.Join(dir, "1.trace"), tracePath)
+	}
+	if err == nil {
+		meta := storage.Meta{
+			Kind:     storage.KindRun,
+			Project:  command.TargetPath,
+			Commit:   gitCommit(command.TargetPath),
+			Duration: duration,
+			Files:    []storage.File{{Path: "1.trace"}},
+			Strict:   command.Strict,
+		}
+		if command.Project != "" {
+			meta.Project = command.Project
+		} else if abs, err := filepath.Abs(command.TargetPath); err == nil {
+			meta.Project = abs
+		}
+		meta.Args = programArgs(graph)
+		_, err = h.traces.Save(id, meta, graph)
+	}
+	if err != nil {
+		h.logger.Warn("Не удалось сохранить трассу в хранилище", "error", err)
+		if id != "" {
+			h.traces.Discard(id)
+		}
+		return ""
+	}
+	return id
+}
+
+// programArgs возвращает аргументы программы из заголовка трассы без имени исполняемого файла:
+// go run собирает его во временный каталог
+func programArgs(graph *domain.GorutineGraph) []string {
+	if graph.Header == nil || len(graph.Header.Args) < 2 {
+		return nil
+	}
+	return graph.Header.Args[1:]
+}
+
+// gitCommit возвращает коммит проекта с суффиксом "-dirty" при незафиксированных изменениях;
+// пустая строка — проект не в git или git не установлен
+func gitCommit(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+	if status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output(); err == nil && len(bytes.TrimSpace(status)) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// logTail возвращает последние lines строк файла вывода
+func logTail(path string, lines int) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	all := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	return strings.Join(all[max(len(all)-lines, 0):], "\n")
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return saveUpload(dst, in)
+}
+
+func (c TraceCommand) stage(stage Stage) {
+	if c.OnStage != nil {
+		c.OnStage(stage)
+	}
+}
+
+// NewTraceReport строит отчёт по графу; с фильтром — по подграфу прошедших его горутин и каналов.
+// Утечки и взаимные блокировки ищутся по всему графу и затем ограничиваются подграфом: горутины вне
+// подграфа тоже могут разбудить заблокированные
+func NewTraceReport(graph *domain.GorutineGraph, query *domain.Query) TraceReport {
+	view := graph
+	leaks, deadlocks, incidents := graph.Leaks(), graph.Deadlocks(), graph.IncidentsReport()
+	if query != nil {
+		view = graph.Filter(query)
+		leaks, deadlocks, incidents = leaks.Only(view), deadlocks.Only(view), incidents.Only(view)
+	}
+	return TraceReport{
+		Leaks:       leaks,
+		Deadlocks:   deadlocks,
+		Blocking:    view.Blocking,
+		Incidents:   incidents,
+		Diagnostics: graph.DiagnosticsReport(),
+		StackGroups: view.StackGroups(),
+		Sources:     graph.SourcesReport(),
+	}
+}
+
+func (r TraceReport) String() string {
+	return r.Sources.String() + r.Leaks.String() + r.Deadlocks.String() + r.Blocking.String() + r.Incidents.String() + r.StackGroups.String() +
+		r.Diagnostics.String()
+}
+
+// Err возвращает ErrDeadlock, ErrGoroutineLeak или ErrNoShutdown, если отчёт нашёл взаимную
+// блокировку, утечку или незавершённые горутины в трассе gtrace без завершения программы.
+// Горутины, работающие к концу трассы выполнения Go или в момент дампа, ошибкой не считаются
+func (r TraceReport) Err() error {
+	switch {
+	case r.Deadlocks.Found():
+		return fmt.Errorf("%w: заблокировано горутин: %d", ErrDeadlock, len(r.Deadlocks.Blocked))
+	case len(r.Leaks.Leaked) > 0:
+		return fmt.Errorf("%w: %d", ErrGoroutineLeak, len(r.Leaks.Leaked))
+	case len(r.Leaks.Unfinished) > 0 && r.Leaks.Recorded:
+		return fmt.Errorf("%w: горутин без завершения: %d", ErrNoShutdown, len(r.Leaks.Unfinished))
+	}
+	return nil
+}
+
+// parseQuery разбирает выражение фильтра; пустое выражение — без фильтра
+func parseQuery(expr string) (*domain.Query, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	query, err := domain.ParseQuery(expr)
+	if err != nil {
+		return nil, fmt.Errorf("фильтр: %w", err)
+	}
+	return query, nil
+}
+
+// parseMode выбирает режим разбора трассы
+func parseMode(strict bool) parser.Mode {
+	if strict {
+		return parser.ModeStrict
+	}
+	return parser.ModeLenient
 }
@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gtrace/src/common/decorator"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type importTraceCommand struct {
+	parserService *parser.Parser
+	traces        *storage.Traces
+	logger        *slog.Logger
+}
+
+// TraceUpload — загруженная трасса: имя источника (пустое — имя файла) и содержимое
+type TraceUpload struct {
+	Name     string
+	Filename string
+	Reader   io.Reader
+}
+
+// ImportTraceCommand — разбор загруженных трасс (любого поддерживаемого формата) и сохранение
+// результата в хранилище. Несколько трасс объединяются в один граф, как в AnalyzeTraceCommand
+type ImportTraceCommand struct {
+	Uploads []TraceUpload
+	Align   string
+	Offsets []string
+	Strict  bool
+}
+
+// ImportResult — сохранённая трасса: её ID в хранилище (TraceResult.TraceID) и отчёт по графу
+type ImportResult struct {
+	TraceResult
+}
+
+type ImportTrace decorator.CommandDecorator[ImportTraceCommand, any]
+
+func NewImportTraceCommand(parserService *parser.Parser, traces *storage.Traces, logger *slog.Logger) decorator.CommandDecorator[ImportTraceCommand, any] {
+	handler := &importTraceCommand{
+		parserService: parserService,
+		traces:        traces,
+		logger:        logger,
+	}
+	return decorator.ApplyCommandDecorator[ImportTraceCommand, any](handler, logger)
+}
+
+func (h *importTraceCommand) Handle(ctx context.Context, command ImportTraceCommand) (any, error) {
+	h.logger.Info("Начало выполнения команды Import", "uploads", len(command.Uploads))
+	if len(command.Uploads) == 0 {
+		return nil, errors.New("trace file is required")
+	}
+
+	id, dir, err := h.traces.Create()
+	if err != nil {
+		return nil, err
+	}
+	meta, graph, err := h.store(dir, command)
+	if err == nil {
+		_, err = h.traces.Save(id, meta, graph)
+	}
+	if err != nil {
+		h.traces.Discard(id)
+		return nil, err
+	}
+
+	result := TraceResult{TraceID: id, Graph: graph, Report: NewTraceReport(graph, nil)}
+	return ImportResult{TraceResult: result}, nil
+}
+
+// store сохраняет загруженные трассы в каталог dir и разбирает их
+func (h *importTraceCommand) store(dir string, command ImportTraceCommand) (storage.Meta, *domain.GorutineGraph, error) {
+	inputs := make([]parser.MergeInput, 0, len(command.Uploads))
+	files := make([]string, 0, len(command.Uploads))
+	for i, upload := range command.Uploads {
+		name := upload.Name
+		if name == "" {
+			base := filepath.Base(upload.Filename)
+			name = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+		path := filepath.Join(dir, strconv.Itoa(i+1)+".trace")
+		if err := saveUpload(path, upload.Reader); err != nil {
+			return storage.Meta{}, nil, fmt.Errorf("сохранение трассы %s: %w", name, err)
+		}
+		inputs = append(inputs, parser.MergeInput{Name: name, Path: path})
+		files = append(files, filepath.Base(upload.Filename))
+	}
+	if err := applyOffsets(inputs, command.Offsets); err != nil {
+		return storage.Meta{}, nil, err
+	}
+
+	align, mode := parseAlign(command.Align), parseMode(command.Strict)
+	graph, err := h.parserService.ParseFiles(inputs, align, mode)
+	if err != nil {
+		return storage.Meta{}, nil, fmt.Errorf("разбор трассы: %w", err)
+	}
+
+	meta := storage.Meta{
+		Kind:    storage.KindUpload,
+		Project: strings.Join(files, ", "),
+		Align:   command.Align,
+		Strict:  command.Strict,
+	}
+	if len(inputs) == 1 {
+		meta.Args = programArgs(graph)
+	}
+	for _, input := range inputs {
+		meta.Files = append(meta.Files, storage.File{Name: input.Name, Path: filepath.Base(input.Path), Offset: input.Offset})
+	}
+	return meta, graph, nil
+}
+
+func saveUpload(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// processGroupStopper останавливает группу процессов команды при отмене: сначала просит её завершиться
+// (SIGTERM, в Windows — CTRL_BREAK), чтобы программа, подписанная на сигнал, сбросила трассу
+// и записала завершение, а через delay уничтожает группу
+type processGroupStopper struct {
+	cmd   *exec.Cmd
+	delay time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// cancel подходит для exec.Cmd.Cancel
+func (s *processGroupStopper) cancel() error {
+	s.mu.Lock()
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.delay, func() { killProcessGroup(s.cmd) })
+	}
+	s.mu.Unlock()
+	return terminateProcessGroup(s.cmd)
+}
+
+// stop отменяет уничтожение группы; вызывается после завершения команды
+func (s *processGroupStopper) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Отмена команды сначала посылает группе процессов SIGTERM и уничтожает группу, только если
+// программа не завершилась за отведённое время
+func TestProcessGroupStopper(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh не найден")
+	}
+	tests := []struct {
+		name   string
+		script string
+		// code — код завершения; signal — сигнал, убивший процесс
+		code   int
+		signal syscall.Signal
+	}{
+		{name: "программа обрабатывает SIGTERM", script: "trap 'exit 7' TERM; while :; do sleep 0.05; done", code: 7},
+		{name: "программа игнорирует SIGTERM", script: "trap '' TERM; while :; do sleep 0.05; done", code: -1, signal: syscall.SIGKILL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cmd := exec.CommandContext(ctx, "sh", "-c", tt.script)
+			setProcessGroup(cmd)
+			stopper := &processGroupStopper{cmd: cmd, delay: 200 * time.Millisecond}
+			cmd.Cancel = stopper.cancel
+			cmd.WaitDelay = 5 * time.Second
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			err := cmd.Wait()
+			stopper.stop()
+
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("ошибка %v", err)
+			}
+			status := exitErr.Sys().(syscall.WaitStatus)
+			if exitErr.ExitCode() != tt.code || tt.signal != 0 && status.Signal() != tt.signal {
+				t.Errorf("код %d, сигнал %v; ожидались %d и %v", exitErr.ExitCode(), status.Signal(), tt.code, tt.signal)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package commands
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup запускает команду в отдельной группе процессов
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// terminateProcessGroup посылает группе процессов команды CTRL_BREAK: программа на Go получает его
+// как os.Interrupt. Событие доставляется, только если у группы есть консоль
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	if ok, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(cmd.Process.Pid)); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// killProcessGroup уничтожает дерево процессов команды: go run и собранную им программу
+func killProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
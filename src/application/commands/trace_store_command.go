@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"context"
+	"gtrace/src/common/decorator"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"log/slog"
+)
+
+// ErrTraceNotFound возвращается, если трассы с таким ID нет в хранилище
+var ErrTraceNotFound = storage.ErrTraceNotFound
+
+type tagTraceCommand struct {
+	traces *storage.Traces
+	logger *slog.Logger
+}
+
+// TagTraceCommand — добавление и снятие тегов трассы в хранилище
+type TagTraceCommand struct {
+	TraceID string
+	Add     []string
+	Remove  []string
+}
+
+type TagTrace decorator.CommandDecorator[TagTraceCommand, any]
+
+func NewTagTraceCommand(traces *storage.Traces, logger *slog.Logger) decorator.CommandDecorator[TagTraceCommand, any] {
+	handler := &tagTraceCommand{
+		traces: traces,
+		logger: logger,
+	}
+	return decorator.ApplyCommandDecorator[TagTraceCommand, any](handler, logger)
+}
+
+// Handle возвращает теги трассы после изменения
+func (h *tagTraceCommand) Handle(ctx context.Context, command TagTraceCommand) (any, error) {
+	h.logger.Info("Начало выполнения команды Tag", "trace", command.TraceID, "add", command.Add, "remove", command.Remove)
+	meta, err := h.traces.Tag(command.TraceID, command.Add, command.Remove)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Tags, nil
+}
+
+type deleteTraceCommand struct {
+	traces *storage.Traces
+	logger *slog.Logger
+}
+
+// DeleteTraceCommand — удаление трассы из хранилища
+type DeleteTraceCommand struct {
+	TraceID string
+}
+
+type DeleteTrace decorator.CommandDecorator[DeleteTraceCommand, any]
+
+func NewDeleteTraceCommand(traces *storage.Traces, logger *slog.Logger) decorator.CommandDecorator[DeleteTraceCommand, any] {
+	handler := &deleteTraceCommand{
+		traces: traces,
+		logger: logger,
+	}
+	return decorator.ApplyCommandDecorator[DeleteTraceCommand, any](handler, logger)
+}
+
+func (h *deleteTraceCommand) Handle(ctx context.Context, command DeleteTraceCommand) (any, error) {
+	h.logger.Info("Начало выполнения команды Delete", "trace", command.TraceID)
+	return nil, h.traces.Delete(command.TraceID)
+}
@@ -0,0 +1,301 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gtrace/src/application/commands"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State — состояние задания
+type State string
+
+const (
+	StateQueued        State = "queued"
+	StateInstrumenting State = State(commands.StageInstrumenting)
+	StateRunning       State = State(commands.StageRunning)
+	StateParsing       State = State(commands.StageParsing)
+	StateDone          State = "done"
+	StateFailed        State = "failed"
+)
+
+// Finished сообщает, что задание завершилось
+func (s State) Finished() bool {
+	return s == StateDone || s == StateFailed
+}
+
+// keepFinished — сколько завершённых заданий помнит очередь; результаты вытесненных заданий
+// остаются в хранилище трасс
+const keepFinished = 100
+
+var (
+	// ErrJobNotFound возвращается, если задания с таким ID нет
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished возвращается при отмене уже завершённого задания
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// Job — задание трассировки: TraceCommand в очереди
+type Job struct {
+	ID     string
+	State  State
+	Source string
+	// Command — команда задания; OnStage задаёт очередь
+	Command  commands.TraceCommand
+	Error    string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+	// Cancelled — задание отменено (через Cancel или по таймауту)
+	Cancelled bool
+	// Result — граф и отчёт; заполняется и для заданий, нашедших утечку или взаимную блокировку,
+	// и для отменённых, если записанную часть трассы удалось разобрать
+	Result *commands.TraceResult
+}
+
+// Request — задание для очереди
+type Request struct {
+	Command commands.TraceCommand
+	// Source — описание проекта для списка заданий
+	Source string
+	// Finish, если задан, вызывается после завершения задания (например, чтобы удалить рабочий каталог)
+	Finish func(Job)
+}
+
+// Queue выполняет задания трассировки: не больше workers одновременно, каждое — не дольше timeout
+// (0 — без ограничения). Остальные ждут свободного места в очереди
+type Queue struct {
+	handler commands.GoTraceCommand
+	timeout time.Duration
+	slots   chan struct{}
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	running sync.WaitGroup
+}
+
+// job — задание с функцией отмены его контекста
+type job struct {
+	Job
+	cancel context.CancelFunc
+	finish func(Job)
+}
+
+func NewQueue(handler commands.GoTraceCommand, workers int, timeout time.Duration, logger *slog.Logger) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		handler: handler,
+		timeout: timeout,
+		slots:   make(chan struct{}, workers),
+		logger:  logger,
+		jobs:    make(map[string]*job),
+	}
+}
+
+// Submit ставит задание в очередь и возвращает его. Задание выполняется, пока не отменён ctx,
+// не вызван Cancel и не истёк таймаут очереди
+func (q *Queue) Submit(ctx context.Context, req Request) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	j := &job{
+		Job: Job{
+			ID:      id,
+			State:   StateQueued,
+			Source:  req.Source,
+			Command: req.Command,
+			Created: time.Now(),
+		},
+		cancel: cancel,
+		finish: req.Finish,
+	}
+	j.Command.OnStage = func(stage commands.Stage) {
+		q.update(j, func(j *Job) { j.State = State(stage) })
+	}
+
+	q.mu.Lock()
+	q.jobs[id] = j
+	snapshot := j.Job
+	q.mu.Unlock()
+
+	q.running.Add(1)
+	go func() {
+		defer q.running.Done()
+		defer cancel()
+		q.run(ctx, j)
+	}()
+	q.logger.Info("Задание поставлено в очередь", "job", id, "source", req.Source)
+	return snapshot, nil
+}
+
+func (q *Queue) run(ctx context.Context, j *job) {
+	// место могло освободиться одновременно с отменой: отменённое задание не запускается
+	select {
+	case q.slots <- struct{}{}:
+		defer func() { <-q.slots }()
+	case <-ctx.Done():
+	}
+	if err := ctx.Err(); err != nil {
+		q.complete(j, nil, fmt.Errorf("задание отменено в очереди: %w", err))
+		return
+	}
+
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+	q.update(j, func(j *Job) { j.Started = time.Now() })
+	q.logger.Info("Запуск задания", "job", j.ID, "source", j.Source)
+
+	result, err := q.handler.Handle(ctx, j.Command)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("превышено время выполнения задания (%s): %w", q.timeout, err)
+	}
+	var res *commands.TraceResult
+	if r, ok := result.(commands.TraceResult); ok {
+		res = &r
+	}
+	q.complete(j, res, err)
+}
+
+// complete завершает задание. Утечка, взаимная блокировка или трасса без завершения программы —
+// результат анализа, а не сбой задания
+func (q *Queue) complete(j *job, result *commands.TraceResult, err error) {
+	q.update(j, func(job *Job) {
+		job.Finished = time.Now()
+		job.Result = result
+		job.Cancelled = errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+		if err != nil && !errors.Is(err, commands.ErrDeadlock) && !errors.Is(err, commands.ErrGoroutineLeak) &&
+			!errors.Is(err, commands.ErrNoShutdown) {
+			job.State = StateFailed
+			job.Error = err.Error()
+			return
+		}
+		job.State = StateDone
+	})
+	snapshot, _ := q.Get(j.ID)
+	q.logger.Info("Задание завершено", "job", j.ID, "state", snapshot.State, "error", snapshot.Error)
+	if j.finish != nil {
+		j.finish(snapshot)
+	}
+	q.forget()
+}
+
+// forget удаляет самые давно завершённые задания сверх keepFinished вместе с их графами
+func (q *Queue) forget() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var finished []*job
+	for _, j := range q.jobs {
+		if j.State.Finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= keepFinished {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].Finished.Before(finished[k].Finished)
+	})
+	for _, j := range finished[:len(finished)-keepFinished] {
+		delete(q.jobs, j.ID)
+	}
+}
+
+func (q *Queue) update(j *job, fn func(*Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(&j.Job)
+}
+
+// Get возвращает копию задания
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return j.Job, nil
+}
+
+// List возвращает копии заданий в порядке постановки в очередь
+func (q *Queue) List() []Job {
+	q.mu.Lock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, j.Job)
+	}
+	q.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+// Cancel отменяет задание: ожидающее в очереди снимается, выполняющееся останавливается вместе
+// со всей группой процессов программы, записанная часть трассы разбирается
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	var state State
+	if ok {
+		state = j.State
+	}
+	q.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if state.Finished() {
+		return fmt.Errorf("%w: %s", ErrJobFinished, id)
+	}
+	q.logger.Info("Отмена задания", "job", id, "state", state)
+	j.cancel()
+	return nil
+}
+
+// Wait ждёт завершения всех заданий; false — ctx истёк раньше
+func (q *Queue) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// CancelAll отменяет все незавершённые задания
+func (q *Queue) CancelAll() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if !j.State.Finished() {
+			j.cancel()
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
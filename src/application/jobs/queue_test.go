@@ -0,0 +1,272 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gtrace/src/application/commands"
+	"io"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// traceHandler — команда трассировки, которая сразу возвращает пустой результат
+type traceHandler struct{}
+
+func (traceHandler) Handle(ctx context.Context, command commands.TraceCommand) (any, error) {
+	return commands.TraceResult{TraceID: command.TargetPath}, nil
+}
+
+// Очередь помнит не больше keepFinished завершённых заданий, вытесняются самые давно завершённые
+func TestQueueForgetsOldFinishedJobs(t *testing.T) {
+	q := NewQueue(traceHandler{}, 1, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var first Job
+	for i := range keepFinished + 5 {
+		job, err := q.Submit(context.Background(), Request{Command: commands.TraceCommand{TargetPath: "p"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = job
+		}
+		// задания выполняются по одному: время завершения совпадает с порядком постановки
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		q.Wait(ctx)
+		cancel()
+	}
+
+	jobs := q.List()
+	if len(jobs) != keepFinished {
+		t.Fatalf("заданий %d, ожидалось %d", len(jobs), keepFinished)
+	}
+	if _, err := q.Get(first.ID); err == nil {
+		t.Fatalf("самое старое задание %s не вытеснено", first.ID)
+	}
+	for _, job := range jobs {
+		if job.Result == nil || job.State != StateDone {
+			t.Fatalf("задание %s: состояние %s, результат %v", job.ID, job.State, job.Result)
+		}
+	}
+}
+
+// blockingHandler — команда трассировки, которая проходит стадии задания и ждёт release или отмены
+// контекста; считает одновременно выполняющиеся задания
+type blockingHandler struct {
+	release chan struct{}
+	started chan string
+
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{release: make(chan struct{}), started: make(chan string, 100)}
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, command commands.TraceCommand) (any, error) {
+	h.mu.Lock()
+	h.running++
+	h.peak = max(h.peak, h.running)
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.running--
+		h.mu.Unlock()
+	}()
+
+	command.OnStage(commands.StageInstrumenting)
+	command.OnStage(commands.StageRunning)
+	h.started <- command.TargetPath
+	select {
+	case <-h.release:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("программа остановлена: %w", ctx.Err())
+	}
+	command.OnStage(commands.StageParsing)
+	return commands.TraceResult{TraceID: command.TargetPath}, nil
+}
+
+func newTestQueue(handler commands.GoTraceCommand, workers int, timeout time.Duration) *Queue {
+	return NewQueue(handler, workers, timeout, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func submit(t *testing.T, q *Queue, path string) Job {
+	t.Helper()
+	job, err := q.Submit(context.Background(), Request{Command: commands.TraceCommand{TargetPath: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func wait(t *testing.T, q *Queue) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !q.Wait(ctx) {
+		t.Fatal("задания не завершились")
+	}
+}
+
+// Одновременно выполняется не больше workers заданий, остальные ждут в очереди
+func TestQueueConcurrencyLimit(t *testing.T) {
+	h := newBlockingHandler()
+	q := newTestQueue(h, 2, 0)
+	var ids []string
+	for i := range 5 {
+		ids = append(ids, submit(t, q, strconv.Itoa(i)).ID)
+	}
+	<-h.started
+	<-h.started
+	select {
+	case path := <-h.started:
+		t.Fatalf("задание %s запущено сверх ограничения", path)
+	case <-time.After(50 * time.Millisecond):
+	}
+	queued := 0
+	for _, id := range ids {
+		if job, _ := q.Get(id); job.State == StateQueued {
+			queued++
+		}
+	}
+	if queued != 3 {
+		t.Errorf("в очереди %d заданий, ожидалось 3", queued)
+	}
+
+	close(h.release)
+	wait(t, q)
+	if h.peak != 2 {
+		t.Errorf("одновременно выполнялось %d заданий, ожидалось 2", h.peak)
+	}
+	for _, job := range q.List() {
+		if job.State != StateDone {
+			t.Errorf("задание %s: состояние %s", job.ID, job.State)
+		}
+	}
+}
+
+// stepHandler — команда трассировки, которая на каждой стадии ждёт, пока тест проверит состояние задания
+type stepHandler struct {
+	reached chan commands.Stage
+	proceed chan struct{}
+}
+
+func (h stepHandler) Handle(ctx context.Context, command commands.TraceCommand) (any, error) {
+	for _, stage := range []commands.Stage{commands.StageInstrumenting, commands.StageRunning, commands.StageParsing} {
+		command.OnStage(stage)
+		h.reached <- stage
+		<-h.proceed
+	}
+	return commands.TraceResult{}, nil
+}
+
+// Задание проходит состояния queued → instrumenting → running → parsing → done
+func TestQueueStates(t *testing.T) {
+	h := stepHandler{reached: make(chan commands.Stage), proceed: make(chan struct{})}
+	q := newTestQueue(h, 1, 0)
+	job := submit(t, q, "p")
+	states := []State{job.State}
+	for range 3 {
+		<-h.reached
+		got, err := q.Get(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, got.State)
+		h.proceed <- struct{}{}
+	}
+	wait(t, q)
+	got, _ := q.Get(job.ID)
+	states = append(states, got.State)
+
+	want := []State{StateQueued, StateInstrumenting, StateRunning, StateParsing, StateDone}
+	if !reflect.DeepEqual(states, want) {
+		t.Fatalf("состояния %v, ожидались %v", states, want)
+	}
+	if got.Started.IsZero() || got.Finished.IsZero() || got.Result == nil {
+		t.Errorf("завершённое задание: %+v", got)
+	}
+}
+
+// Задание, превысившее таймаут очереди, останавливается и завершается ошибкой
+func TestQueueTimeout(t *testing.T) {
+	h := newBlockingHandler()
+	q := newTestQueue(h, 1, 50*time.Millisecond)
+	job := submit(t, q, "p")
+	wait(t, q)
+
+	got, _ := q.Get(job.ID)
+	if got.State != StateFailed || !got.Cancelled || !strings.Contains(got.Error, "превышено время выполнения") {
+		t.Fatalf("задание после таймаута: состояние %s, отменено %v, ошибка %q", got.State, got.Cancelled, got.Error)
+	}
+}
+
+// Cancel снимает задание из очереди и останавливает выполняющееся; завершённое и неизвестное
+// задания отменить нельзя
+func TestQueueCancel(t *testing.T) {
+	h := newBlockingHandler()
+	q := newTestQueue(h, 1, 0)
+	running := submit(t, q, "running")
+	<-h.started
+	queued := submit(t, q, "queued")
+
+	if err := q.Cancel(queued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, q)
+
+	got, _ := q.Get(queued.ID)
+	if got.State != StateFailed || !got.Cancelled || !got.Started.IsZero() || !strings.Contains(got.Error, "отменено в очереди") {
+		t.Errorf("задание, отменённое в очереди: %+v", got)
+	}
+	got, _ = q.Get(running.ID)
+	if got.State != StateFailed || !got.Cancelled || got.Started.IsZero() {
+		t.Errorf("остановленное задание: %+v", got)
+	}
+	select {
+	case path := <-h.started:
+		t.Errorf("отменённое задание %s запущено", path)
+	default:
+	}
+
+	if err := q.Cancel(running.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("отмена завершённого задания: %v", err)
+	}
+	if err := q.Cancel("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("отмена неизвестного задания: %v", err)
+	}
+}
+
+// Wait ждёт, пока задания выполняются, CancelAll останавливает их все
+func TestQueueCancelAllAndWait(t *testing.T) {
+	h := newBlockingHandler()
+	q := newTestQueue(h, 2, 0)
+	for i := range 3 {
+		submit(t, q, strconv.Itoa(i))
+	}
+	<-h.started
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if q.Wait(ctx) {
+		t.Fatal("Wait вернулся, пока задания выполняются")
+	}
+
+	q.CancelAll()
+	wait(t, q)
+	for _, job := range q.List() {
+		if job.State != StateFailed || !job.Cancelled {
+			t.Errorf("задание %s: состояние %s, отменено %v", job.ID, job.State, job.Cancelled)
+		}
+	}
+}
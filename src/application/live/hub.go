@@ -0,0 +1,478 @@
+package live
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound возвращается, если живой трассы с таким ID нет
+var ErrSessionNotFound = errors.New("live session not found")
+
+const (
+	// subscriberQueue — сколько событий может ждать отправки подписчику; подписчик, который
+	// отстал сильнее, отключается, чтобы не задерживать разбор трассы
+	subscriberQueue = 1024
+	// keepEnded — сколько завершённых сессий помнит Hub; их трассы остаются в хранилище
+	keepEnded = 100
+	// keepEdges и keepFinished — окно живого графа: последние рёбра и недавно завершённые горутины.
+	// Граф долгоживущей программы иначе растёт с каждым событием; полная трасса остаётся в хранилище
+	keepEdges    = 100_000
+	keepFinished = 10_000
+	// stateInterval — как часто пересчитывается состояние сессии: подписчики, которые запрашивают его
+	// чаще, получают один и тот же расчёт
+	stateInterval = 250 * time.Millisecond
+)
+
+// Hub принимает живые трассы: инструментированная программа с GTRACE_SINK=tcp:<адрес> или
+// unix:<путь> отправляет события на сервер по мере выполнения. События каждого соединения
+// дописываются в граф его сессии и рассылаются подписчикам; когда соединение закрывается,
+// трасса сохраняется в хранилище и её граф разбирается заново из записанного файла целиком
+type Hub struct {
+	traces *storage.Traces
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	conns    map[net.Conn]struct{}
+	ingests  sync.WaitGroup
+}
+
+func NewHub(traces *storage.Traces, logger *slog.Logger) *Hub {
+	return &Hub{
+		traces:   traces,
+		logger:   logger,
+		sessions: make(map[string]*Session),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen открывает адрес для живых трасс: tcp:<адрес>:<порт> или unix:<путь к сокету>.
+// Оставшийся от прошлого запуска файл сокета удаляется
+func Listen(spec string) (net.Listener, error) {
+	kind, addr, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "tcp":
+		return net.Listen("tcp", addr)
+	case "unix":
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", addr)
+	}
+	return nil, fmt.Errorf("live address %q: expected tcp:<host>:<port> or unix:<path>", spec)
+}
+
+// Serve принимает соединения программ до отмены ctx. После отмены соединения закрываются,
+// записанные части трасс сохраняются; Serve возвращается, когда все сессии завершены
+func (h *Hub) Serve(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() {
+		ln.Close()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for conn := range h.conns {
+			conn.Close()
+		}
+	})
+	defer stop()
+
+	h.logger.Info("Приём живых трасс", "addr", ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			h.ingests.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		h.mu.Lock()
+		if ctx.Err() != nil {
+			h.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
+
+		h.ingests.Add(1)
+		go func() {
+			defer h.ingests.Done()
+			h.ingest(conn)
+			h.mu.Lock()
+			delete(h.conns, conn)
+			h.mu.Unlock()
+		}()
+	}
+}
+
+// ingest читает события соединения в сессию. Трасса одновременно пишется в каталог хранилища,
+// чтобы после закрытия соединения её можно было сохранить без повторной записи
+func (h *Hub) ingest(conn net.Conn) {
+	defer conn.Close()
+	s := h.newSession(conn.RemoteAddr().String())
+	h.logger.Info("Живая трасса подключена", "session", s.ID, "remote", s.Remote)
+
+	var input io.Reader = conn
+	traceID, file, err := h.create()
+	if err != nil {
+		h.logger.Warn("Живая трасса не будет сохранена", "session", s.ID, "error", err)
+	} else {
+		input = io.TeeReader(conn, file)
+	}
+
+	readErr := s.read(input)
+	if file != nil {
+		if err := file.Close(); err != nil && readErr == nil {
+			readErr = err
+		}
+	}
+	if traceID != "" {
+		traceID = h.save(s, traceID)
+	}
+	s.end(traceID, readErr)
+	h.logger.Info("Живая трасса завершена", "session", s.ID, "events", s.Info().Events, "trace", traceID, "error", readErr)
+	h.forget()
+}
+
+// create создаёт в хранилище трассу для записи событий сессии
+func (h *Hub) create() (string, *os.File, error) {
+	traceID, dir, err := h.traces.Create()
+	if err != nil {
+		return "", nil, err
+	}
+	file, err := os.Create(filepath.Join(dir, "1.trace"))
+	if err != nil {
+		h.traces.Discard(traceID)
+		return "", nil, err
+	}
+	return traceID, file, nil
+}
+
+// save сохраняет трассу сессии в хранилище и возвращает её ID; пустая трасса не сохраняется.
+// Граф живой сессии — только окно трассы, поэтому хранилище разбирает записанный файл
+func (h *Hub) save(s *Session, traceID string) string {
+	s.mu.Lock()
+	events, started, header := s.events, s.Started, s.builder.Graph().Header
+	s.mu.Unlock()
+	if events == 0 {
+		h.traces.Discard(traceID)
+		return ""
+	}
+	meta := storage.Meta{
+		Kind:     storage.KindLive,
+		Project:  s.Remote,
+		Duration: time.Since(started),
+		Files:    []storage.File{{Path: "1.trace"}},
+	}
+	if header != nil && len(header.Args) > 0 {
+		meta.Project = header.Args[0]
+		meta.Args = header.Args[1:]
+	}
+	if _, err := h.traces.Save(traceID, meta, nil); err != nil {
+		h.logger.Warn("Не удалось сохранить живую трассу", "session", s.ID, "error", err)
+		h.traces.Discard(traceID)
+		return ""
+	}
+	return traceID
+}
+
+func (h *Hub) newSession(remote string) *Session {
+	b := make([]byte, 8)
+	rand.Read(b)
+	s := &Session{
+		ID:           hex.EncodeToString(b),
+		Remote:       remote,
+		Started:      time.Now(),
+		builder:      parser.NewGraphBuilder(),
+		matcher:      parser.NewEventMatcher(),
+		subscribers:  make(map[*subscriber]struct{}),
+		keepEdges:    keepEdges,
+		keepFinished: keepFinished,
+	}
+	h.mu.Lock()
+	h.sessions[s.ID] = s
+	h.mu.Unlock()
+	return s
+}
+
+// forget удаляет самые старые завершённые сессии сверх keepEnded
+func (h *Hub) forget() {
+	var ended []Info
+	for _, info := range h.List() {
+		if !info.Ended.IsZero() {
+			ended = append(ended, info)
+		}
+	}
+	if len(ended) <= keepEnded {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, info := range ended[:len(ended)-keepEnded] {
+		delete(h.sessions, info.ID)
+	}
+}
+
+// Get возвращает сессию по ID
+func (h *Hub) Get(id string) (*Session, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return s, nil
+}
+
+// List возвращает сессии в порядке подключения
+func (h *Hub) List() []Info {
+	h.mu.Lock()
+	sessions := make([]*Session, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mu.Unlock()
+	list := make([]Info, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s.Info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Started.Before(list[j].Started)
+	})
+	return list
+}
+
+// Session — живая трасса одного соединения: граф, который достраивается по событиям, и подписчики.
+// Граф сессии — окно трассы: в нём остаются последние keepEdges рёбер и keepFinished завершённых
+// горутин, живые горутины и каналы не вытесняются
+type Session struct {
+	ID      string
+	Remote  string
+	Started time.Time
+
+	mu          sync.Mutex
+	builder     *parser.GraphBuilder
+	matcher     *parser.EventMatcher
+	events      int
+	ended       time.Time
+	traceID     string
+	err         error
+	subscribers map[*subscriber]struct{}
+	// finished — завершённые горутины в графе, trimmed — вытесненные из него
+	finished, trimmed       int
+	keepEdges, keepFinished int
+
+	// stateMu держится на время расчёта состояния, чтобы подписчики одного тика дождались
+	// одного расчёта, а не считали каждый свой
+	stateMu sync.Mutex
+	state   *State
+}
+
+// Info — состояние сессии
+type Info struct {
+	ID      string
+	Remote  string
+	Started time.Time
+	// Ended — время закрытия соединения (нулевое, пока программа отправляет события)
+	Ended  time.Time
+	Events int
+	// Trimmed — завершённые горутины, вытесненные из графа сессии
+	Trimmed int
+	// Program и Args — исполняемый файл и аргументы программы из заголовка трассы
+	Program string
+	Args    []string
+	// TraceID — трасса сессии в хранилище, сохраняется после закрытия соединения
+	TraceID string
+	Error   string
+}
+
+// Update — событие живой трассы для подписчика: номер события в сессии и строка текстового формата
+type Update struct {
+	Seq  int
+	Kind string
+	Line string
+}
+
+type subscriber struct {
+	query   *domain.Query
+	updates chan Update
+	// lagged — подписчик отключён, потому что не успевал получать события
+	lagged bool
+}
+
+// ErrLagged возвращается подписчику, который не успевал получать события и был отключён
+var ErrLagged = errors.New("subscriber fell behind the live trace")
+
+// read дописывает события из input в граф сессии и рассылает их подписчикам до конца потока
+func (s *Session) read(input io.Reader) error {
+	events, err := parser.NewStreamEventReader(input, parser.ModeLenient)
+	if err != nil {
+		return err
+	}
+	for {
+		ev, err := events.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		s.add(ev)
+	}
+	s.mu.Lock()
+	graph := s.builder.Graph()
+	graph.Diagnostics, graph.OmittedDiagnostics = events.Diagnostics(), events.OmittedDiagnostics()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Session) add(ev domain.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builder.Add(ev)
+	s.matcher.Observe(ev)
+	s.events++
+	if _, ok := ev.(*domain.FuncEndEvent); ok {
+		s.finished++
+	}
+	// граф обрезается, когда окно переполнено вдвое, поэтому обрезка обходится в O(1) на событие
+	if graph := s.builder.Graph(); s.finished > 2*s.keepFinished || len(graph.Edges) > 2*s.keepEdges {
+		s.trimmed += graph.Trim(s.keepEdges, s.keepFinished)
+		s.finished = min(s.finished, s.keepFinished)
+	}
+	if len(s.subscribers) == 0 {
+		return
+	}
+	update := Update{Seq: s.events, Kind: ev.Kind(), Line: parser.EncodeEvent(ev)}
+	for sub := range s.subscribers {
+		if sub.query != nil && !s.matcher.Match(ev, sub.query) {
+			continue
+		}
+		select {
+		case sub.updates <- update:
+		default:
+			sub.lagged = true
+			close(sub.updates)
+			delete(s.subscribers, sub)
+		}
+	}
+}
+
+func (s *Session) end(traceID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = time.Now()
+	s.traceID = traceID
+	s.err = err
+	for sub := range s.subscribers {
+		close(sub.updates)
+		delete(s.subscribers, sub)
+	}
+}
+
+// Info возвращает состояние сессии
+func (s *Session) Info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := Info{
+		ID:      s.ID,
+		Remote:  s.Remote,
+		Started: s.Started,
+		Ended:   s.ended,
+		Events:  s.events,
+		Trimmed: s.trimmed,
+		TraceID: s.traceID,
+	}
+	if header := s.builder.Graph().Header; header != nil && len(header.Args) > 0 {
+		info.Program, info.Args = header.Args[0], header.Args[1:]
+	}
+	if s.err != nil {
+		info.Error = s.err.Error()
+	}
+	return info
+}
+
+// State — состояние сессии, общее для всех подписчиков: снимок графа и его анализ. Не изменяется
+type State struct {
+	// Events — число событий в графе на момент снимка
+	Events    int
+	Graph     *domain.GorutineGraph
+	Summary   domain.Summary
+	Deadlocks domain.DeadlockReport
+	ended     bool
+	at        time.Time
+}
+
+// State возвращает состояние сессии. Пока программа отправляет события, состояние пересчитывается
+// не чаще раза в stateInterval и только если с прошлого расчёта были события; после закрытия
+// соединения — один раз по всему графу
+func (s *Session) State() *State {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.mu.Lock()
+	events, ended := s.events, !s.ended.IsZero()
+	if last := s.state; last != nil && last.ended == ended &&
+		(last.Events == events || !ended && time.Since(last.at) < stateInterval) {
+		s.mu.Unlock()
+		return last
+	}
+	graph := s.builder.Graph().Snapshot()
+	s.mu.Unlock()
+
+	s.state = &State{
+		Events:    events,
+		Graph:     graph,
+		Summary:   graph.Summary(),
+		Deadlocks: graph.Deadlocks(),
+		ended:     ended,
+		at:        time.Now(),
+	}
+	return s.state
+}
+
+// Subscribe подписывает на события сессии, прошедшие фильтр (nil — все). Канал закрывается, когда
+// соединение программы закрыто, после cancel или если подписчик отстал (тогда lagged сообщает ErrLagged)
+func (s *Session) Subscribe(query *domain.Query) (updates <-chan Update, lagged func() error, cancel func()) {
+	sub := &subscriber{query: query, updates: make(chan Update, subscriberQueue)}
+	s.mu.Lock()
+	if s.ended.IsZero() {
+		s.subscribers[sub] = struct{}{}
+	} else {
+		close(sub.updates)
+	}
+	s.mu.Unlock()
+
+	lagged = func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sub.lagged {
+			return ErrLagged
+		}
+		return nil
+	}
+	cancel = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[sub]; ok {
+			close(sub.updates)
+			delete(s.subscribers, sub)
+		}
+	}
+	return sub.updates, lagged, cancel
+}
@@ -0,0 +1,252 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trace — программа запускает горутину, получает от неё значение и завершается
+const trace = `[GTRACE] trace_header 1 go1.24.0 4 1000000000 ./app -v
+[GTRACE] channel_create 1 main.go:5 main.go:4 1000000100 0
+[GTRACE] go_spawn 1 2 main.worker main.go:6 main.go:4 1000000200
+[GTRACE] func_start 2 main.worker main.go:6 1000000300 2
+[GTRACE] channel_send 2 1 main.go:7 main.go:6 1000000400
+[GTRACE] channel_receive 1 1 main.go:8 main.go:4 1000000500
+[GTRACE] func_end 2 main.worker main.go:6 1000000600 return
+[GTRACE] shutdown 1 return main.go:9 1000000700 0
+`
+
+func newTestHub(t *testing.T) (*Hub, *storage.Traces) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	traces := storage.NewTraces(t.TempDir(), storage.Retention{}, parser.NewParser(logger), logger)
+	return NewHub(traces, logger), traces
+}
+
+// events разбирает текстовую трассу
+func events(t *testing.T, text string) []domain.Event {
+	t.Helper()
+	reader, err := parser.NewEventReader(strings.NewReader(text), parser.ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []domain.Event
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return all
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, ev)
+	}
+}
+
+// Трасса соединения читается в сессию, после закрытия соединения сохраняется в хранилище целиком,
+// а Serve после отмены возвращается, дождавшись сессий
+func TestHubIngest(t *testing.T) {
+	hub, traces := newTestHub(t)
+	ln, err := Listen("tcp:127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- hub.Serve(ctx, ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(conn, trace); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	var info Info
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if list := hub.List(); len(list) == 1 && !list[0].Ended.IsZero() {
+			info = list[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("сессия не завершилась: %+v", hub.List())
+		}
+	}
+	if info.Events != 8 || info.Program != "./app" || strings.Join(info.Args, " ") != "-v" || info.Error != "" {
+		t.Fatalf("сессия: %+v", info)
+	}
+	saved, err := traces.Get(info.TraceID)
+	if err != nil {
+		t.Fatalf("трасса сессии не сохранена: %v", err)
+	}
+	if saved.Meta.Kind != storage.KindLive || saved.Meta.Project != "./app" {
+		t.Errorf("метаданные трассы: %+v", saved.Meta)
+	}
+	if saved.Graph.Shutdown == nil || saved.Graph.Gorutines["2"].State != domain.StateReturned {
+		t.Errorf("граф трассы: %+v", saved.Graph)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve не вернулся после отмены")
+	}
+}
+
+// Подписчик получает события по порядку, с фильтром — только совпавшие; после закрытия
+// соединения канал закрывается, а состояние сессии пересчитывается по всему графу
+func TestSessionSubscribe(t *testing.T) {
+	hub, _ := newTestHub(t)
+	s := hub.newSession("test")
+	all, _, cancelAll := s.Subscribe(nil)
+	defer cancelAll()
+	query, err := domain.ParseQuery("kind = send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sends, _, cancelSends := s.Subscribe(query)
+	defer cancelSends()
+
+	evs := events(t, trace)
+	for _, ev := range evs[:4] {
+		s.add(ev)
+	}
+	running := s.State()
+	if running.Events != 4 || running.Graph.Gorutines["2"].State != domain.StateRunning {
+		t.Fatalf("состояние до конца трассы: %+v", running)
+	}
+	for _, ev := range evs[4:] {
+		s.add(ev)
+	}
+	s.end("", nil)
+
+	var seqs []string
+	for update := range all {
+		seqs = append(seqs, fmt.Sprintf("%d:%s", update.Seq, update.Kind))
+	}
+	want := "1:trace_header 2:channel_create 3:go_spawn 4:func_start 5:channel_send 6:channel_receive 7:func_end 8:shutdown"
+	if got := strings.Join(seqs, " "); got != want {
+		t.Errorf("события %s, ожидались %s", got, want)
+	}
+	var filtered []Update
+	for update := range sends {
+		filtered = append(filtered, update)
+	}
+	if len(filtered) != 1 || filtered[0].Seq != 5 || !strings.HasPrefix(filtered[0].Line, "[GTRACE] channel_send ") {
+		t.Errorf("события с фильтром: %+v", filtered)
+	}
+
+	ended := s.State()
+	if ended == running || ended.Events != 8 || ended.Graph.Shutdown == nil {
+		t.Errorf("состояние после конца трассы: %+v", ended)
+	}
+	if s.State() != ended {
+		t.Error("состояние завершённой сессии пересчитано повторно")
+	}
+	late, _, cancelLate := s.Subscribe(nil)
+	defer cancelLate()
+	if _, ok := <-late; ok {
+		t.Error("подписка на завершённую сессию получила событие")
+	}
+}
+
+// Подписчики, запрашивающие состояние в пределах stateInterval, получают один расчёт
+func TestSessionStateIsShared(t *testing.T) {
+	hub, _ := newTestHub(t)
+	s := hub.newSession("test")
+	evs := events(t, trace)
+	s.add(evs[0])
+	first := s.State()
+	s.add(evs[1])
+	if s.State() != first {
+		t.Fatal("состояние пересчитано раньше stateInterval")
+	}
+	time.Sleep(stateInterval)
+	if next := s.State(); next == first || next.Events != 2 {
+		t.Fatalf("состояние не пересчитано после stateInterval: %+v", next)
+	}
+}
+
+// Подписчик, который не забирает события, отключается, не задерживая разбор трассы
+func TestSessionLaggedSubscriber(t *testing.T) {
+	hub, _ := newTestHub(t)
+	s := hub.newSession("test")
+	updates, lagged, cancel := s.Subscribe(nil)
+	defer cancel()
+
+	ev := events(t, trace)[1]
+	for range subscriberQueue + 1 {
+		s.add(ev)
+	}
+	received := 0
+	for range updates {
+		received++
+	}
+	if received != subscriberQueue {
+		t.Errorf("получено %d событий, ожидалось %d", received, subscriberQueue)
+	}
+	if err := lagged(); !errors.Is(err, ErrLagged) {
+		t.Errorf("ошибка %v, ожидалась %v", err, ErrLagged)
+	}
+}
+
+// Граф сессии — окно трассы: давно завершённые горутины и старые рёбра вытесняются,
+// живые горутины остаются
+func TestSessionWindow(t *testing.T) {
+	hub, _ := newTestHub(t)
+	s := hub.newSession("test")
+	s.keepEdges, s.keepFinished = 4, 3
+
+	var text strings.Builder
+	text.WriteString("[GTRACE] go_spawn 1 1 main.idle main.go:3 main.go:2 1000\n")
+	text.WriteString("[GTRACE] func_start 100 main.idle main.go:3 1001 1\n")
+	const spawned = 20
+	for i := 2; i < spawned+2; i++ {
+		ts := 1000 * i
+		fmt.Fprintf(&text, "[GTRACE] go_spawn 1 %d main.worker main.go:6 main.go:4 %d\n", i, ts)
+		fmt.Fprintf(&text, "[GTRACE] func_start %d main.worker main.go:6 %d %d\n", 100+i, ts+1, i)
+		fmt.Fprintf(&text, "[GTRACE] func_end %d main.worker main.go:6 %d return\n", 100+i, ts+2)
+	}
+	for _, ev := range events(t, text.String()) {
+		s.add(ev)
+	}
+
+	graph := s.State().Graph
+	finished := 0
+	for _, gr := range graph.Gorutines {
+		if gr.State == domain.StateReturned {
+			finished++
+		}
+	}
+	if finished < s.keepFinished || finished > 2*s.keepFinished {
+		t.Errorf("завершённых горутин в графе %d, окно %d", finished, s.keepFinished)
+	}
+	if len(graph.Edges) > 2*s.keepEdges {
+		t.Errorf("рёбер в графе %d, окно %d", len(graph.Edges), s.keepEdges)
+	}
+	if graph.Gorutines["100"].State != domain.StateRunning {
+		t.Error("живая горутина вытеснена из графа")
+	}
+	if last := fmt.Sprint(100 + spawned + 1); graph.Gorutines[last].State != domain.StateReturned {
+		t.Errorf("последняя завершённая горутина %s вытеснена", last)
+	}
+	if info := s.Info(); info.Trimmed != spawned-finished {
+		t.Errorf("вытеснено %d горутин, ожидалось %d", info.Trimmed, spawned-finished)
+	}
+}
@@ -0,0 +1,207 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gtrace/src/application/live"
+	"gtrace/src/common/decorator"
+	domain "gtrace/src/domain/parser"
+	"log/slog"
+	"time"
+)
+
+// LiveState — живая трасса на текущий момент: итог анализа и горутины, которые сейчас ждут
+// операций с каналами
+type LiveState struct {
+	Info    live.Info
+	Summary domain.Summary
+	// Blocked — горутины, заблокированные на каналах, и горутины, которые могли бы их разбудить
+	Blocked []domain.BlockedGoroutine
+	// AllAsleep — заблокированы все живые горутины
+	AllAsleep bool
+}
+
+// newLiveState собирает состояние из общего для подписчиков анализа сессии; фильтр применяется
+// к нему для каждого подписчика
+func newLiveState(s *live.Session, filter *domain.Query) LiveState {
+	state := s.State()
+	deadlocks := state.Deadlocks
+	if filter != nil {
+		deadlocks = deadlocks.Only(state.Graph.Filter(filter))
+	}
+	return LiveState{
+		Info:      s.Info(),
+		Summary:   state.Summary,
+		Blocked:   deadlocks.Blocked,
+		AllAsleep: deadlocks.AllAsleep,
+	}
+}
+
+// getSession возвращает сессию живой трассы и разобранный фильтр (nil — без фильтра)
+func getSession(hub *live.Hub, id string, filter string) (*live.Session, *domain.Query, error) {
+	s, err := hub.Get(id)
+	if errors.Is(err, live.ErrSessionNotFound) {
+		return nil, nil, fmt.Errorf("%w: live session %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter == "" {
+		return s, nil, nil
+	}
+	query, err := domain.ParseQuery(filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidFilter, err)
+	}
+	return s, query, nil
+}
+
+type liveSessionsQuery struct {
+	hub *live.Hub
+}
+
+// LiveSessionsQuery — живые трассы в порядке подключения, включая недавно завершённые
+type LiveSessionsQuery struct{}
+
+type LiveSessions decorator.QueryDecorator[LiveSessionsQuery, []live.Info]
+
+func NewLiveSessionsQuery(hub *live.Hub, logger *slog.Logger) decorator.QueryDecorator[LiveSessionsQuery, []live.Info] {
+	return decorator.ApplyQueryDecorator[LiveSessionsQuery, []live.Info](&liveSessionsQuery{hub: hub}, logger)
+}
+
+func (h *liveSessionsQuery) Handle(ctx context.Context, query LiveSessionsQuery) ([]live.Info, error) {
+	return h.hub.List(), nil
+}
+
+type liveSessionQuery struct {
+	hub *live.Hub
+}
+
+// LiveSessionQuery — состояние живой трассы; с фильтром — только заблокированные горутины подграфа
+type LiveSessionQuery struct {
+	SessionID string
+	Filter    string
+}
+
+type LiveSession decorator.QueryDecorator[LiveSessionQuery, LiveState]
+
+func NewLiveSessionQuery(hub *live.Hub, logger *slog.Logger) decorator.QueryDecorator[LiveSessionQuery, LiveState] {
+	return decorator.ApplyQueryDecorator[LiveSessionQuery, LiveState](&liveSessionQuery{hub: hub}, logger)
+}
+
+func (h *liveSessionQuery) Handle(ctx context.Context, query LiveSessionQuery) (LiveState, error) {
+	s, filter, err := getSession(h.hub, query.SessionID, query.Filter)
+	if err != nil {
+		return LiveState{}, err
+	}
+	return newLiveState(s, filter), nil
+}
+
+type liveGraphQuery struct {
+	hub *live.Hub
+}
+
+// LiveGraphQuery — снимок графа живой трассы; с фильтром — подграф
+type LiveGraphQuery struct {
+	SessionID string
+	Filter    string
+}
+
+type LiveGraph decorator.QueryDecorator[LiveGraphQuery, *domain.GorutineGraph]
+
+func NewLiveGraphQuery(hub *live.Hub, logger *slog.Logger) decorator.QueryDecorator[LiveGraphQuery, *domain.GorutineGraph] {
+	return decorator.ApplyQueryDecorator[LiveGraphQuery, *domain.GorutineGraph](&liveGraphQuery{hub: hub}, logger)
+}
+
+func (h *liveGraphQuery) Handle(ctx context.Context, query LiveGraphQuery) (*domain.GorutineGraph, error) {
+	s, filter, err := getSession(h.hub, query.SessionID, query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	graph := s.State().Graph
+	if filter == nil {
+		return graph, nil
+	}
+	return graph.Filter(filter), nil
+}
+
+type liveEventsQuery struct {
+	hub *live.Hub
+}
+
+// LiveUpdate — сообщение подписчику живой трассы: событие или состояние трассы
+type LiveUpdate struct {
+	Event *live.Update
+	State *LiveState
+}
+
+// LiveEventsQuery — подписка на живую трассу: Send получает состояние трассы при подписке,
+// события, прошедшие фильтр, по мере поступления и новое состояние не чаще раза в Interval, если
+// с прошлого были события. Подписка длится, пока программа не закроет соединение или не отменён ctx;
+// результат — число отправленных событий
+type LiveEventsQuery struct {
+	SessionID string
+	Filter    string
+	Interval  time.Duration
+	Send      func(LiveUpdate) error
+}
+
+type LiveEvents decorator.QueryDecorator[LiveEventsQuery, int]
+
+func NewLiveEventsQuery(hub *live.Hub, logger *slog.Logger) decorator.QueryDecorator[LiveEventsQuery, int] {
+	return decorator.ApplyQueryDecorator[LiveEventsQuery, int](&liveEventsQuery{hub: hub}, logger)
+}
+
+func (h *liveEventsQuery) Handle(ctx context.Context, query LiveEventsQuery) (int, error) {
+	s, filter, err := getSession(h.hub, query.SessionID, query.Filter)
+	if err != nil {
+		return 0, err
+	}
+	updates, lagged, cancel := s.Subscribe(filter)
+	defer cancel()
+
+	sendState := func() error {
+		state := newLiveState(s, filter)
+		return query.Send(LiveUpdate{State: &state})
+	}
+	initial := newLiveState(s, filter)
+	if err := query.Send(LiveUpdate{State: &initial}); err != nil || !initial.Info.Ended.IsZero() {
+		return 0, err
+	}
+	interval := query.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sent, changed := 0, false
+	for {
+		select {
+		case <-ctx.Done():
+			return sent, nil
+		case <-ticker.C:
+			if !changed {
+				continue
+			}
+			changed = false
+			if err := sendState(); err != nil {
+				return sent, err
+			}
+		case update, ok := <-updates:
+			if !ok {
+				// соединение программы закрыто: последнее состояние — с сохранённой трассой
+				if err := lagged(); err != nil {
+					return sent, err
+				}
+				return sent, sendState()
+			}
+			if err := query.Send(LiveUpdate{Event: &update}); err != nil {
+				return sent, err
+			}
+			sent++
+			changed = true
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package queries
+
+import (
+	"errors"
+	"fmt"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+)
+
+var (
+	// ErrNotFound возвращается, если в хранилище нет трассы или в трассе нет горутины или канала
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidFilter возвращается, если выражение фильтра не разбирается
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// getTrace возвращает трассу из хранилища и разобранный фильтр (nil — без фильтра)
+func getTrace(traces *storage.Traces, id string, filter string) (*storage.Trace, *domain.Query, error) {
+	trace, err := traces.Get(id)
+	if errors.Is(err, storage.ErrTraceNotFound) {
+		return nil, nil, fmt.Errorf("%w: trace %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter == "" {
+		return trace, nil, nil
+	}
+	query, err := domain.ParseQuery(filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidFilter, err)
+	}
+	return trace, query, nil
+}
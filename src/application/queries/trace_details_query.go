@@ -0,0 +1,67 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"gtrace/src/common/decorator"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"log/slog"
+)
+
+type goroutineDetailsQuery struct {
+	traces *storage.Traces
+}
+
+// GoroutineQuery — подробности о горутине сохранённой трассы; Goroutine — ключ горутины в графе
+// (в объединённой трассе — источник/номер)
+type GoroutineQuery struct {
+	TraceID   string
+	Goroutine string
+}
+
+type GoroutineDetails decorator.QueryDecorator[GoroutineQuery, domain.GoroutineDetails]
+
+func NewGoroutineDetailsQuery(traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[GoroutineQuery, domain.GoroutineDetails] {
+	return decorator.ApplyQueryDecorator[GoroutineQuery, domain.GoroutineDetails](&goroutineDetailsQuery{traces: traces}, logger)
+}
+
+func (h *goroutineDetailsQuery) Handle(ctx context.Context, query GoroutineQuery) (domain.GoroutineDetails, error) {
+	trace, _, err := getTrace(h.traces, query.TraceID, "")
+	if err != nil {
+		return domain.GoroutineDetails{}, err
+	}
+	details, ok := trace.Graph.GoroutineDetails(query.Goroutine)
+	if !ok {
+		return domain.GoroutineDetails{}, fmt.Errorf("%w: goroutine %s", ErrNotFound, query.Goroutine)
+	}
+	return details, nil
+}
+
+type channelDetailsQuery struct {
+	traces *storage.Traces
+}
+
+// ChannelQuery — подробности о канале сохранённой трассы; Channel — имя канала (chan_N) или его номер
+type ChannelQuery struct {
+	TraceID string
+	Channel string
+}
+
+type ChannelDetails decorator.QueryDecorator[ChannelQuery, domain.ChannelDetails]
+
+func NewChannelDetailsQuery(traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[ChannelQuery, domain.ChannelDetails] {
+	return decorator.ApplyQueryDecorator[ChannelQuery, domain.ChannelDetails](&channelDetailsQuery{traces: traces}, logger)
+}
+
+func (h *channelDetailsQuery) Handle(ctx context.Context, query ChannelQuery) (domain.ChannelDetails, error) {
+	trace, _, err := getTrace(h.traces, query.TraceID, "")
+	if err != nil {
+		return domain.ChannelDetails{}, err
+	}
+	details, ok := trace.Graph.ChannelDetails(query.Channel)
+	if !ok {
+		return domain.ChannelDetails{}, fmt.Errorf("%w: channel %s", ErrNotFound, query.Channel)
+	}
+	return details, nil
+}
@@ -0,0 +1,41 @@
+package queries
+
+import (
+	"context"
+	"gtrace/src/common/decorator"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"io"
+	"log/slog"
+)
+
+type traceEventsQuery struct {
+	parserService *parser.Parser
+	traces        *storage.Traces
+}
+
+// TraceEventsQuery — события сохранённой трассы, прошедшие фильтр, строками текстового формата
+// в Output. События заново читаются из исходных файлов трассы; результат — число событий
+type TraceEventsQuery struct {
+	TraceID string
+	Filter  string
+	Output  io.Writer
+}
+
+type TraceEvents decorator.QueryDecorator[TraceEventsQuery, int]
+
+func NewTraceEventsQuery(parserService *parser.Parser, traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[TraceEventsQuery, int] {
+	handler := &traceEventsQuery{
+		parserService: parserService,
+		traces:        traces,
+	}
+	return decorator.ApplyQueryDecorator[TraceEventsQuery, int](handler, logger)
+}
+
+func (h *traceEventsQuery) Handle(ctx context.Context, query TraceEventsQuery) (int, error) {
+	trace, filter, err := getTrace(h.traces, query.TraceID, query.Filter)
+	if err != nil {
+		return 0, err
+	}
+	return h.parserService.WriteEvents(trace.Inputs, trace.Align, trace.Mode, filter, query.Output)
+}
@@ -0,0 +1,61 @@
+package queries
+
+import (
+	"context"
+	"gtrace/src/application/commands"
+	"gtrace/src/common/decorator"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"log/slog"
+)
+
+type traceGraphQuery struct {
+	traces *storage.Traces
+}
+
+// TraceGraphQuery — граф сохранённой трассы; с фильтром (см. domain.ParseQuery) — подграф
+type TraceGraphQuery struct {
+	TraceID string
+	Filter  string
+}
+
+type TraceGraph decorator.QueryDecorator[TraceGraphQuery, *domain.GorutineGraph]
+
+func NewTraceGraphQuery(traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[TraceGraphQuery, *domain.GorutineGraph] {
+	return decorator.ApplyQueryDecorator[TraceGraphQuery, *domain.GorutineGraph](&traceGraphQuery{traces: traces}, logger)
+}
+
+func (h *traceGraphQuery) Handle(ctx context.Context, query TraceGraphQuery) (*domain.GorutineGraph, error) {
+	trace, filter, err := getTrace(h.traces, query.TraceID, query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return trace.Graph, nil
+	}
+	return trace.Graph.Filter(filter), nil
+}
+
+type traceReportQuery struct {
+	traces *storage.Traces
+}
+
+// TraceReportQuery — отчёт анализа сохранённой трассы; с фильтром — по подграфу, как у команд
+type TraceReportQuery struct {
+	TraceID string
+	Filter  string
+}
+
+type TraceReport decorator.QueryDecorator[TraceReportQuery, commands.TraceReport]
+
+func NewTraceReportQuery(traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[TraceReportQuery, commands.TraceReport] {
+	return decorator.ApplyQueryDecorator[TraceReportQuery, commands.TraceReport](&traceReportQuery{traces: traces}, logger)
+}
+
+func (h *traceReportQuery) Handle(ctx context.Context, query TraceReportQuery) (commands.TraceReport, error) {
+	trace, filter, err := getTrace(h.traces, query.TraceID, query.Filter)
+	if err != nil {
+		return commands.TraceReport{}, err
+	}
+	return commands.NewTraceReport(trace.Graph, filter), nil
+}
@@ -0,0 +1,100 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gtrace/src/common/decorator"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
+	"log/slog"
+	"time"
+)
+
+// StoredTrace — трасса в хранилище: метаданные запуска или загрузки, источники и итог анализа
+type StoredTrace struct {
+	ID      string
+	Kind    string
+	Created time.Time
+	Project string
+	Commit  string
+	Args    []string
+	// Duration — время выполнения программы (0 — не известно)
+	Duration time.Duration
+	Tags     []string
+	// Sources — имена объединённых трасс (пусто для одной трассы)
+	Sources []string
+	Summary domain.Summary
+	Size    int64
+}
+
+func newStoredTrace(meta storage.Meta) StoredTrace {
+	stored := StoredTrace{
+		ID:       meta.ID,
+		Kind:     meta.Kind,
+		Created:  meta.Created,
+		Project:  meta.Project,
+		Commit:   meta.Commit,
+		Args:     meta.Args,
+		Duration: meta.Duration,
+		Tags:     meta.Tags,
+		Summary:  meta.Summary,
+		Size:     meta.Size,
+	}
+	if len(meta.Files) > 1 {
+		for _, f := range meta.Files {
+			stored.Sources = append(stored.Sources, f.Name)
+		}
+	}
+	return stored
+}
+
+type listTracesQuery struct {
+	traces *storage.Traces
+}
+
+// ListTracesQuery — трассы в хранилище в порядке сохранения; Tag — только трассы с этим тегом
+type ListTracesQuery struct {
+	Tag string
+}
+
+type ListTraces decorator.QueryDecorator[ListTracesQuery, []StoredTrace]
+
+func NewListTracesQuery(traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[ListTracesQuery, []StoredTrace] {
+	return decorator.ApplyQueryDecorator[ListTracesQuery, []StoredTrace](&listTracesQuery{traces: traces}, logger)
+}
+
+func (h *listTracesQuery) Handle(ctx context.Context, query ListTracesQuery) ([]StoredTrace, error) {
+	list := h.traces.List(query.Tag)
+	stored := make([]StoredTrace, 0, len(list))
+	for _, meta := range list {
+		stored = append(stored, newStoredTrace(meta))
+	}
+	return stored, nil
+}
+
+type getTraceQuery struct {
+	traces *storage.Traces
+}
+
+// GetTraceQuery — трасса из хранилища по ID
+type GetTraceQuery struct {
+	TraceID string
+}
+
+type GetTrace decorator.QueryDecorator[GetTraceQuery, StoredTrace]
+
+func NewGetTraceQuery(traces *storage.Traces, logger *slog.Logger) decorator.QueryDecorator[GetTraceQuery, StoredTrace] {
+	return decorator.ApplyQueryDecorator[GetTraceQuery, StoredTrace](&getTraceQuery{traces: traces}, logger)
+}
+
+func (h *getTraceQuery) Handle(ctx context.Context, query GetTraceQuery) (StoredTrace, error) {
+	meta, err := h.traces.Meta(query.TraceID)
+	if errors.Is(err, storage.ErrTraceNotFound) {
+		return StoredTrace{}, fmt.Errorf("%w: trace %s", ErrNotFound, query.TraceID)
+	}
+	if err != nil {
+		return StoredTrace{}, err
+	}
+	return newStoredTrace(meta), nil
+}
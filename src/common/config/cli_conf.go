@@ -2,9 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"github.com/urfave/cli/v2"
 	_ "log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Config interface {
@@ -14,19 +18,102 @@ type Config interface {
 type GoTrace struct {
 	TargetProject string
 	OutputProject string
+	Strict        bool
+	Filter        string
+	// Timeout — наибольшее время выполнения программы (0 — без ограничения)
+	Timeout time.Duration
+	// Live — адрес сервера gtrace, которому программа дополнительно отправляет живую трассу
+	Live string
+}
+
+// Analyze — анализ готовых трасс; несколько трасс объединяются, Align и Offsets выравнивают их часы
+type Analyze struct {
+	TracePaths []string
+	Align      string
+	Offsets    []string
+	Strict     bool
+	// Filter — выражение фильтра; DotPath — файл для графа в формате DOT; Events — вывести события
+	Filter  string
+	DotPath string
+	Events  bool
+}
+
+// Runs — работа с хранилищем трасс: Action — list, show, tag или delete
+type Runs struct {
+	Action string
+	IDs    []string
+	// Tag — в list: только трассы с этим тегом
+	Tag string
+	// Tags — в tag: добавляемые теги или, с Remove, снимаемые
+	Tags   []string
+	Remove bool
+	// Filter и DotPath — в show: фильтр отчёта и файл для графа в формате DOT
+	Filter  string
+	DotPath string
+}
+
+// Store — хранилище трасс: каталог (пустой — каталог по умолчанию) и политика хранения.
+// Нулевой предел не ограничивает
+type Store struct {
+	Dir       string
+	MaxRuns   int
+	MaxAge    time.Duration
+	MaxSizeMB int64
 }
 
 type CommandCli struct {
 	GoTrace *GoTrace `cli_command:"gotrace"`
+	Analyze *Analyze `cli_command:"analyze"`
+	Runs    *Runs    `cli_command:"runs"`
+	Store   Store
 	LogLvl  uint8
 }
 
 type ServerCli struct {
+	// Host — адрес, на котором слушает HTTP API (по умолчанию только локальный)
+	Host   string
 	Port   string
 	LogLvl uint8
+	// Jobs — сколько заданий трассировки выполняется одновременно, JobTimeout — наибольшее время
+	// выполнения задания (0 — без ограничения)
+	Jobs       int
+	JobTimeout time.Duration
+	Store      Store
+	// Live — адрес приёма живых трасс: tcp:<адрес>:<порт> или unix:<путь> (пусто — не принимать)
+	Live string
+	// Projects — каталог, внутри которого задания принимают проекты по локальному пути
+	// (пусто — только загруженные архивы)
+	Projects string
 }
 
 func (c *CommandCli) Validate() error {
+	if err := c.Store.Validate(); err != nil {
+		return err
+	}
+	if c.Runs != nil {
+		if c.Runs.Action != "list" && len(c.Runs.IDs) == 0 {
+			return errors.New("trace id is required")
+		}
+		if c.Runs.Action == "show" && len(c.Runs.IDs) > 1 {
+			return errors.New("show takes a single trace id (flags go before it)")
+		}
+		if c.Runs.Action == "tag" && len(c.Runs.Tags) == 0 {
+			return errors.New("at least one tag is required")
+		}
+		return nil
+	}
+	if c.Analyze != nil {
+		if len(c.Analyze.TracePaths) == 0 {
+			return errors.New("trace file is required")
+		}
+		if c.Analyze.Align != "start" && c.Analyze.Align != "wall" {
+			return errors.New("align must be start or wall")
+		}
+		return nil
+	}
+	if err := validLive(c.GoTrace.Live); err != nil {
+		return err
+	}
 	if c.GoTrace.TargetProject == "" {
 		return errors.New("target project is required")
 	}
@@ -40,9 +127,111 @@ func (c *ServerCli) Validate() error {
 	if c.Port == "" {
 		return errors.New("port is required")
 	}
+	if c.Jobs < 1 {
+		return errors.New("jobs must be at least 1")
+	}
+	if c.JobTimeout < 0 {
+		return errors.New("job timeout must not be negative")
+	}
+	if c.Projects != "" && !filepath.IsAbs(c.Projects) {
+		return fmt.Errorf("projects directory %q must be absolute", c.Projects)
+	}
+	if err := validLive(c.Live); err != nil {
+		return err
+	}
+	return c.Store.Validate()
+}
+
+// validLive проверяет адрес живой трассы: пустой, tcp:<адрес>:<порт> или unix:<путь>
+func validLive(addr string) error {
+	if addr == "" || strings.HasPrefix(addr, "tcp:") || strings.HasPrefix(addr, "unix:") {
+		return nil
+	}
+	return errors.New("live address must be tcp:<host>:<port> or unix:<path>")
+}
+
+func (s Store) Validate() error {
+	if s.MaxRuns < 0 || s.MaxAge < 0 || s.MaxSizeMB < 0 {
+		return errors.New("store limits must not be negative")
+	}
 	return nil
 }
 
+// storeFlags — флаги хранилища трасс, общие для команд, которые его используют
+func storeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "store",
+			EnvVars: []string{"GTRACE_STORE"},
+			Usage:   "Trace store directory (default: gtrace/traces in the user cache directory)",
+		},
+		&cli.IntFlag{
+			Name:    "store-max-runs",
+			EnvVars: []string{"GTRACE_STORE_MAX_RUNS"},
+			Value:   100,
+			Usage:   "Keep at most this many traces, 0 for no limit",
+		},
+		&cli.DurationFlag{
+			Name:    "store-max-age",
+			EnvVars: []string{"GTRACE_STORE_MAX_AGE"},
+			Value:   30 * 24 * time.Hour,
+			Usage:   "Delete traces older than this, 0 for no limit",
+		},
+		&cli.Int64Flag{
+			Name:    "store-max-size",
+			EnvVars: []string{"GTRACE_STORE_MAX_SIZE"},
+			Value:   1024,
+			Usage:   "Total size of stored traces in MB, 0 for no limit",
+		},
+	}
+}
+
+func storeFromContext(c *cli.Context) Store {
+	return Store{
+		Dir:       c.String("store"),
+		MaxRuns:   c.Int("store-max-runs"),
+		MaxAge:    c.Duration("store-max-age"),
+		MaxSizeMB: c.Int64("store-max-size"),
+	}
+}
+
+// runsCommand — подкоманда действия над хранилищем трасс
+func runsCommand(result *Config, action string, usage string, argsUsage string, flags ...cli.Flag) *cli.Command {
+	flags = append(flags, &cli.UintFlag{
+		Name:    "log",
+		Aliases: []string{"l"},
+		Value:   0,
+		Usage:   "Log level (0-3)",
+	})
+	return &cli.Command{
+		Name:      action,
+		Usage:     usage,
+		ArgsUsage: argsUsage,
+		Flags:     append(flags, storeFlags()...),
+		Action: func(c *cli.Context) error {
+			runs := &Runs{
+				Action:  action,
+				Tag:     c.String("tag"),
+				Remove:  c.Bool("remove"),
+				Filter:  c.String("filter"),
+				DotPath: c.String("dot"),
+			}
+			args := c.Args().Slice()
+			if action == "tag" && len(args) > 0 {
+				runs.IDs, runs.Tags = args[:1], args[1:]
+			} else if action != "list" {
+				runs.IDs = args
+			}
+			*result = &CommandCli{
+				Runs:   runs,
+				Store:  storeFromContext(c),
+				LogLvl: uint8(c.Uint("log")),
+			}
+			return (*result).Validate()
+		},
+	}
+}
+
 func Execute() (Config, error) {
 	var result Config
 
@@ -53,32 +242,64 @@ func Execute() (Config, error) {
 			{
 				Name:  "server",
 				Usage: "Run as web server",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "host",
+						EnvVars: []string{"GTRACE_HOST"},
+						Value:   "127.0.0.1",
+						Usage:   "Address to listen on; the API has no authentication, expose it with care",
+					},
 					&cli.StringFlag{
 						Name:    "port",
 						Aliases: []string{"p"},
 						Value:   "8080",
 						Usage:   "Port to listen on",
 					},
+					&cli.IntFlag{
+						Name:  "jobs",
+						Value: 2,
+						Usage: "Number of tracing jobs run at once",
+					},
+					&cli.DurationFlag{
+						Name:  "job-timeout",
+						Value: 10 * time.Minute,
+						Usage: "Wall-clock limit of a tracing job, 0 for none",
+					},
+					&cli.StringFlag{
+						Name:    "live",
+						EnvVars: []string{"GTRACE_LIVE"},
+						Usage:   "Accept live traces from running programs on tcp:<host>:<port> or unix:<path>",
+					},
+					&cli.StringFlag{
+						Name:    "projects",
+						EnvVars: []string{"GTRACE_PROJECTS"},
+						Usage:   "Directory under which jobs may take projects by local path (default: archive uploads only)",
+					},
 					&cli.UintFlag{
 						Name:    "log",
 						Aliases: []string{"l"},
 						Value:   0,
 						Usage:   "Log level (0-3)",
 					},
-				},
+				}, storeFlags()...),
 				Action: func(c *cli.Context) error {
 					result = &ServerCli{
-						LogLvl: uint8(c.Uint("log")),
-						Port:   c.String("port"),
+						LogLvl:     uint8(c.Uint("log")),
+						Host:       c.String("host"),
+						Port:       c.String("port"),
+						Jobs:       c.Int("jobs"),
+						JobTimeout: c.Duration("job-timeout"),
+						Store:      storeFromContext(c),
+						Live:       c.String("live"),
+						Projects:   c.String("projects"),
 					}
-					return nil
+					return result.Validate()
 				},
 			},
 			{
 				Name:  "run",
 				Usage: "Run CLI commands",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "target",
 						Aliases:  []string{"t"},
@@ -91,23 +312,130 @@ func Execute() (Config, error) {
 						Usage:   "Output project",
 						Value:   "",
 					},
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "Fail on malformed trace records instead of skipping them",
+					},
+					&cli.StringFlag{
+						Name:    "filter",
+						Aliases: []string{"q"},
+						Usage:   `Report only goroutines and channels matching a filter, e.g. 'goroutine.func =~ "worker"'`,
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Stop the traced program after this long and analyse the partial trace",
+					},
+					&cli.StringFlag{
+						Name:  "live",
+						Usage: "Also stream the trace to a gtrace server started with --live, tcp:<ip>:<port> or unix:<path>",
+					},
 					&cli.UintFlag{
 						Name:    "log",
 						Aliases: []string{"l"},
 						Value:   0,
 						Usage:   "Log level (0-3)",
 					},
-				},
+				}, storeFlags()...),
 				Action: func(c *cli.Context) error {
 					result = &CommandCli{
 						GoTrace: &GoTrace{
 							TargetProject: c.String("target"),
 							OutputProject: c.String("output"),
+							Strict:        c.Bool("strict"),
+							Filter:        c.String("filter"),
+							Timeout:       c.Duration("timeout"),
+							Live:          c.String("live"),
+						},
+						Store:  storeFromContext(c),
+						LogLvl: uint8(c.Uint("log")),
+					}
+					return result.Validate()
+				},
+			},
+			{
+				Name:  "analyze",
+				Usage: "Analyze a recorded trace: gtrace text or binary, a runtime/trace file or a goroutine dump",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "trace",
+						Aliases:  []string{"f"},
+						Usage:    "Trace file, [name=]path (required; repeat to merge several traces)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "align",
+						Value: "start",
+						Usage: "Clock alignment of merged traces: start (trace start times) or wall (as recorded)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "offset",
+						Usage: "Explicit clock offset of a merged trace, name=duration (e.g. b=-1.5s)",
+					},
+					&cli.StringFlag{
+						Name:  "dot",
+						Usage: "Write the (filtered) goroutine graph in DOT format to a file",
+					},
+					&cli.BoolFlag{
+						Name:  "events",
+						Usage: "Print trace events matching the filter instead of the report",
+					},
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "Fail on malformed trace records instead of skipping them",
+					},
+					&cli.StringFlag{
+						Name:    "filter",
+						Aliases: []string{"q"},
+						Usage:   `Report only goroutines and channels matching a filter, e.g. 'goroutine.func =~ "worker"'`,
+					},
+					&cli.UintFlag{
+						Name:    "log",
+						Aliases: []string{"l"},
+						Value:   0,
+						Usage:   "Log level (0-3)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					result = &CommandCli{
+						Analyze: &Analyze{
+							TracePaths: c.StringSlice("trace"),
+							Align:      c.String("align"),
+							Offsets:    c.StringSlice("offset"),
+							Strict:     c.Bool("strict"),
+							Filter:     c.String("filter"),
+							DotPath:    c.String("dot"),
+							Events:     c.Bool("events"),
 						},
 						LogLvl: uint8(c.Uint("log")),
 					}
-					result.Validate()
-					return nil
+					return result.Validate()
+				},
+			},
+			{
+				Name:  "runs",
+				Usage: "List, show, tag and delete stored traces",
+				Subcommands: []*cli.Command{
+					runsCommand(&result, "list", "List stored traces", "",
+						&cli.StringFlag{
+							Name:  "tag",
+							Usage: "Only traces with this tag",
+						}),
+					runsCommand(&result, "show", "Show a stored trace and its report", "[--filter expr] [--dot file] <id>",
+						&cli.StringFlag{
+							Name:    "filter",
+							Aliases: []string{"q"},
+							Usage:   `Report only goroutines and channels matching a filter, e.g. 'goroutine.func =~ "worker"'`,
+						},
+						&cli.StringFlag{
+							Name:  "dot",
+							Usage: "Write the (filtered) goroutine graph in DOT format to a file",
+						}),
+					runsCommand(&result, "tag", "Add tags to a stored trace", "[--remove] <id> <tag>...",
+						&cli.BoolFlag{
+							Name:  "remove",
+							Usage: "Remove the tags instead of adding them",
+						}),
+					runsCommand(&result, "delete", "Delete stored traces", "<id>..."),
 				},
 			},
 		},
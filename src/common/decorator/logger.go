@@ -41,3 +41,30 @@ func (d CommandLoggingDecorator[T, C]) Handle(ctx context.Context, cmd T) (C, er
 
 	return result, err
 }
+
+type QueryLoggingDecorator[Q any, R any] struct {
+	base   QueryDecorator[Q, R]
+	logger *slog.Logger
+}
+
+// Handle выполняет запрос; запросы не меняют состояние и выполняются часто, поэтому успешные
+// пишутся в лог на уровне Debug
+func (d QueryLoggingDecorator[Q, R]) Handle(ctx context.Context, query Q) (R, error) {
+	start := time.Now()
+	handlerType := fmt.Sprintf("%T", query)
+
+	result, err := d.base.Handle(ctx, query)
+	if err != nil {
+		d.logger.Error("Failed to execute query",
+			slog.String("query", handlerType),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("error", err.Error()),
+		)
+	} else {
+		d.logger.Debug("Query executed successfully",
+			slog.String("query", handlerType),
+			slog.Duration("duration", time.Since(start)),
+		)
+	}
+	return result, err
+}
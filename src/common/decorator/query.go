@@ -0,0 +1,17 @@
+package decorator
+
+import (
+	"context"
+	"log/slog"
+)
+
+type QueryDecorator[Q any, R any] interface {
+	Handle(context.Context, Q) (R, error)
+}
+
+func ApplyQueryDecorator[Q any, R any](handler QueryDecorator[Q, R], logger *slog.Logger) QueryDecorator[Q, R] {
+	return QueryLoggingDecorator[Q, R]{
+		logger: logger,
+		base:   handler,
+	}
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlockedTime — время, которое горутины провели в ожидании операций с каналами
+type BlockedTime struct {
+	Ops   int
+	Total time.Duration
+	Max   time.Duration
+}
+
+// BlockingProfile — время ожидания операций с каналами по каналам, местам операций и горутинам
+type BlockingProfile struct {
+	ByChannel   map[string]BlockedTime
+	BySite      map[string]BlockedTime
+	ByGoroutine map[string]BlockedTime
+}
+
+// Record учитывает завершённую операцию с каналом и время её ожидания
+func (p *BlockingProfile) Record(channel, site, goroutine string, wait time.Duration) {
+	if p.ByChannel == nil {
+		p.ByChannel = make(map[string]BlockedTime)
+		p.BySite = make(map[string]BlockedTime)
+		p.ByGoroutine = make(map[string]BlockedTime)
+	}
+	add := func(m map[string]BlockedTime, key string) {
+		bt := m[key]
+		bt.Ops++
+		bt.Total += wait
+		bt.Max = max(bt.Max, wait)
+		m[key] = bt
+	}
+	add(p.ByChannel, channel)
+	add(p.BySite, site)
+	add(p.ByGoroutine, goroutine)
+}
+
+// blockingTop — сколько самых долгих записей показывать в отчёте по каждому разрезу
+const blockingTop = 10
+
+func (p BlockingProfile) String() string {
+	if len(p.ByChannel) == 0 {
+		return "время ожидания операций с каналами не записано\n"
+	}
+
+	var sb strings.Builder
+	section := func(title string, m map[string]BlockedTime) {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if m[keys[i]].Total != m[keys[j]].Total {
+				return m[keys[i]].Total > m[keys[j]].Total
+			}
+			return keys[i] < keys[j]
+		})
+		if len(keys) > blockingTop {
+			keys = keys[:blockingTop]
+		}
+
+		sb.WriteString(title + ":\n")
+		for _, key := range keys {
+			bt := m[key]
+			sb.WriteString(fmt.Sprintf("  %-40s всего %-12s операций %-6d макс. %s\n", key, bt.Total, bt.Ops, bt.Max))
+		}
+	}
+	section("ожидание по каналам", p.ByChannel)
+	section("ожидание по местам операций", p.BySite)
+	section("ожидание по горутинам", p.ByGoroutine)
+	return sb.String()
+}
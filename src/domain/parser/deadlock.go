@@ -0,0 +1,303 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BlockedGoroutine — горутина, заблокированная на операции с каналом к концу трассы
+type BlockedGoroutine struct {
+	Goroutine Goroutine
+	Op        ChannelOp
+	// WaitsFor — живые горутины, которые могли бы выполнить парную операцию
+	// (получить из канала, отправить в него или закрыть его)
+	WaitsFor []string
+}
+
+// DeadlockReport — результат анализа графа ожидания горутин
+type DeadlockReport struct {
+	Blocked []BlockedGoroutine
+	// Cycles — горутины, которые ждут друг друга по кругу и не могут быть разбужены никем снаружи
+	Cycles [][]string
+	// AllAsleep — все живые горутины заблокированы, а завершение программы не записано
+	AllAsleep bool
+	channels  map[string]Channel
+}
+
+// Found сообщает, что в трассе есть взаимная блокировка
+func (r DeadlockReport) Found() bool {
+	return len(r.Cycles) > 0 || r.AllAsleep
+}
+
+// Deadlocks строит граф ожидания по операциям с каналами, которые к концу трассы остались без пары,
+// и ищет в нём циклы. Начало операции логируется до её выполнения, поэтому анализ работает и по трассе
+// программы, убитой во время взаимной блокировки: отправка или получение без события завершения и без пары
+// на небуферизованном или заполненном канале считается заблокированной. Каналы, созданные вне инструментированного кода,
+// не считаются блокирующими: их размер буфера и отправители не известны
+func (g *GorutineGraph) Deadlocks() DeadlockReport {
+	report := DeadlockReport{channels: g.Channels}
+
+	alive := make(map[string]bool)
+	for id, gr := range g.Gorutines {
+		if gr.State == StateRunning || gr.State == StateUnknown && g.Shutdown == nil {
+			alive[id] = true
+		}
+	}
+
+	blocked := g.blockedOps(alive)
+	ids := make([]string, 0, len(blocked))
+	for id := range blocked {
+		ids = append(ids, id)
+	}
+	sortIDs(ids)
+
+	waitsFor := make(map[string][]string, len(blocked))
+	for _, id := range ids {
+		op := blocked[id]
+		ops := []ChannelOp{op}
+		if op.Kind == "select" {
+			ops = op.Cases
+		}
+		seen := make(map[string]bool)
+		for _, o := range ops {
+			for _, partner := range g.partners(o) {
+				if partner != id && alive[partner] && !seen[partner] {
+					seen[partner] = true
+					waitsFor[id] = append(waitsFor[id], partner)
+				}
+			}
+		}
+		sortIDs(waitsFor[id])
+		report.Blocked = append(report.Blocked, BlockedGoroutine{
+			Goroutine: g.Gorutines[id],
+			Op:        op,
+			WaitsFor:  waitsFor[id],
+		})
+	}
+
+	// Взаимно заблокированы горутины, каждую из которых могут разбудить только такие же горутины
+	stuck := make(map[string]bool, len(blocked))
+	for id := range blocked {
+		stuck[id] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for id := range stuck {
+			for _, partner := range waitsFor[id] {
+				if !stuck[partner] {
+					delete(stuck, id)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	report.Cycles = cycles(ids, stuck, waitsFor)
+
+	report.AllAsleep = g.Shutdown == nil && len(alive) > 0 && len(blocked) == len(alive)
+	return report
+}
+
+// blockedOps возвращает операции живых горутин, которые не могут завершиться
+func (g *GorutineGraph) blockedOps(alive map[string]bool) map[string]ChannelOp {
+	blocked := make(map[string]ChannelOp)
+
+	// Ожидающие операции на канале — последние по времени начала: пары образуются в порядке очереди
+	waiting := make(map[string][]string)
+	for id := range alive {
+		op := g.Gorutines[id].LastOp
+		switch {
+		case op == nil, op.Done:
+		case op.Kind == "select":
+			if !op.Default && g.selectBlocked(*op) {
+				blocked[id] = *op
+			}
+		default:
+			key := op.Kind + " " + op.Channel
+			waiting[key] = append(waiting[key], id)
+		}
+	}
+	for key, ids := range waiting {
+		kind, channel, _ := strings.Cut(key, " ")
+		pending := g.pending(channel, kind)
+		if pending == 0 {
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return tsLess(g.Gorutines[ids[i]].LastOp.TS, g.Gorutines[ids[j]].LastOp.TS)
+		})
+		if pending < 0 || pending > len(ids) {
+			pending = len(ids)
+		}
+		for _, id := range ids[len(ids)-pending:] {
+			blocked[id] = *g.Gorutines[id].LastOp
+		}
+	}
+	return blocked
+}
+
+// pending возвращает число операций kind на канале, которые остались без пары;
+// -1 — канал блокирует всегда (nil-канал)
+func (g *GorutineGraph) pending(channel string, kind string) int {
+	ch, ok := g.Channels[channel]
+	if !ok {
+		return 0
+	}
+	if ch.ID == "0" {
+		return -1
+	}
+	capacity, err := strconv.Atoi(ch.Cap)
+	if ch.File == "" || err != nil {
+		return 0
+	}
+
+	var n int
+	switch kind {
+	case "send":
+		n = ch.Sends - ch.Receives - capacity
+	case "receive":
+		if ch.Closed {
+			return 0
+		}
+		n = ch.Receives - ch.Sends
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// selectBlocked сообщает, что ни одна ветка select без default не может быть выбрана
+func (g *GorutineGraph) selectBlocked(op ChannelOp) bool {
+	for _, c := range op.Cases {
+		ch, ok := g.Channels[c.Channel]
+		if !ok {
+			return false
+		}
+		if ch.ID == "0" {
+			continue
+		}
+		capacity, err := strconv.Atoi(ch.Cap)
+		if ch.File == "" || err != nil {
+			return false
+		}
+		switch c.Kind {
+		case "receive":
+			if ch.Closed || ch.Sends > ch.Receives {
+				return false
+			}
+		case "send":
+			if ch.Sends-ch.Receives < capacity {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// partners возвращает горутины, которые могут выполнить парную операцию
+func (g *GorutineGraph) partners(op ChannelOp) []string {
+	ch := g.Channels[op.Channel]
+	if ch.ID == "0" {
+		return nil
+	}
+	if op.Kind == "send" {
+		return ch.Receivers
+	}
+	return ch.Senders
+}
+
+// cycles возвращает сильно связные компоненты графа ожидания среди взаимно заблокированных горутин
+// (алгоритм Тарьяна), в которых больше одной горутины
+func cycles(ids []string, stuck map[string]bool, waitsFor map[string][]string) [][]string {
+	var (
+		result  [][]string
+		stack   []string
+		index   = make(map[string]int)
+		low     = make(map[string]int)
+		onStack = make(map[string]bool)
+		visit   func(id string)
+	)
+	visit = func(id string) {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		for _, next := range waitsFor[id] {
+			if !stuck[next] {
+				continue
+			}
+			if _, seen := index[next]; !seen {
+				visit(next)
+				low[id] = min(low[id], low[next])
+			} else if onStack[next] {
+				low[id] = min(low[id], index[next])
+			}
+		}
+
+		if low[id] != index[id] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == id {
+				break
+			}
+		}
+		if len(component) > 1 {
+			sortIDs(component)
+			result = append(result, component)
+		}
+	}
+
+	for _, id := range ids {
+		if _, seen := index[id]; !seen && stuck[id] {
+			visit(id)
+		}
+	}
+	return result
+}
+
+func (r DeadlockReport) String() string {
+	var sb strings.Builder
+	if len(r.Blocked) == 0 {
+		sb.WriteString("заблокированных горутин не обнаружено\n")
+		return sb.String()
+	}
+
+	for _, cycle := range r.Cycles {
+		sb.WriteString(fmt.Sprintf("взаимная блокировка: горутины %s ждут друг друга\n", strings.Join(cycle, ", ")))
+	}
+	if r.AllAsleep {
+		sb.WriteString("все горутины заблокированы (all goroutines are asleep)\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("заблокированных горутин: %d\n", len(r.Blocked)))
+	for _, b := range r.Blocked {
+		sb.WriteString(fmt.Sprintf("  %s\n", goroutineTitle(b.Goroutine)))
+		sb.WriteString(fmt.Sprintf("    операция: %s\n", describeOp(b.Op, r.channels)))
+		if len(b.WaitsFor) == 0 {
+			sb.WriteString("    разбудить её некому\n")
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("    ждёт горутины: %s\n", strings.Join(b.WaitsFor, ", ")))
+	}
+	return sb.String()
+}
+
+// tsLess сравнивает временные метки трассы (наносекунды) как числа
+func tsLess(a, b string) bool {
+	x, errA := strconv.ParseInt(a, 10, 64)
+	y, errB := strconv.ParseInt(b, 10, 64)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return x < y
+}
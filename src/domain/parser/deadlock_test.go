@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+// blockedOn — живая горутина, последняя операция которой не завершилась
+func blockedOn(id string, op ChannelOp) Goroutine {
+	return Goroutine{ID: id, Func: "main.g" + id, State: StateRunning, LastOp: &op}
+}
+
+// sendOp и receiveOp — незавершённые операции с каналом, начатые в момент ts
+func sendOp(channel, ts string) ChannelOp {
+	return ChannelOp{Kind: "send", Channel: channel, Site: "/app/main.go:" + ts, TS: ts}
+}
+
+func receiveOp(channel, ts string) ChannelOp {
+	return ChannelOp{Kind: "receive", Channel: channel, Site: "/app/main.go:" + ts, TS: ts}
+}
+
+// Граф ожидания строится по операциям без пары: циклы и "all goroutines are asleep" находятся
+// по графам, построенным вручную, а операции, которые ещё могут завершиться, блокировкой не считаются
+func TestDeadlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		goroutines []Goroutine
+		channels   []Channel
+		shutdown   *Shutdown
+		// blocked — заблокированные горутины и горутины, которых они ждут
+		blocked   map[string][]string
+		cycles    [][]string
+		allAsleep bool
+	}{
+		{
+			// 1 отправляет в chan_1, который читает только 2; 2 отправляет в chan_2, который читает только 1.
+			// Горутина 3 работает, поэтому спят не все
+			name: "цикл из двух горутин",
+			goroutines: []Goroutine{
+				blockedOn("1", sendOp("chan_1", "10")),
+				blockedOn("2", sendOp("chan_2", "20")),
+				{ID: "3", State: StateRunning},
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "0", Sends: 1, Senders: []string{"1"}, Receivers: []string{"2"}},
+				{ID: "2", Name: "chan_2", File: "/app/main.go:4", Cap: "0", Sends: 1, Senders: []string{"2"}, Receivers: []string{"1"}},
+			},
+			blocked: map[string][]string{"1": {"2"}, "2": {"1"}},
+			cycles:  [][]string{{"1", "2"}},
+		},
+		{
+			// 1 ждёт значения, которое никто не отправит: цикла нет, но спят все живые горутины
+			name: "все спят без цикла",
+			goroutines: []Goroutine{
+				blockedOn("1", receiveOp("chan_1", "10")),
+				{ID: "2", State: StateReturned},
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "0", Receives: 1, Receivers: []string{"1"}, Senders: []string{"2"}},
+			},
+			blocked:   map[string][]string{"1": nil},
+			allAsleep: true,
+		},
+		{
+			// программа завершилась: висящая горутина — утечка, а не взаимная блокировка
+			name: "все спят, но программа завершилась",
+			goroutines: []Goroutine{
+				blockedOn("1", receiveOp("chan_1", "10")),
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "0", Receives: 1, Receivers: []string{"1"}},
+			},
+			shutdown: &Shutdown{Reason: "return", Code: "0"},
+			blocked:  map[string][]string{"1": nil},
+		},
+		{
+			// 1 ждёт в select на chan_1 и chan_2, отправить в них может только 2, а 2 ждёт значения из chan_3 от 1
+			name: "select на нескольких каналах",
+			goroutines: []Goroutine{
+				blockedOn("1", ChannelOp{Kind: "select", Site: "/app/main.go:10", TS: "10", Cases: []ChannelOp{
+					{Kind: "receive", Channel: "chan_1"}, {Kind: "receive", Channel: "chan_2"},
+				}}),
+				blockedOn("2", receiveOp("chan_3", "20")),
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "0", Senders: []string{"2"}, Receivers: []string{"1"}},
+				{ID: "2", Name: "chan_2", File: "/app/main.go:4", Cap: "1", Senders: []string{"2"}, Receivers: []string{"1"}},
+				{ID: "3", Name: "chan_3", File: "/app/main.go:5", Cap: "0", Receives: 1, Senders: []string{"1"}, Receivers: []string{"2"}},
+			},
+			blocked:   map[string][]string{"1": {"2"}, "2": {"1"}},
+			cycles:    [][]string{{"1", "2"}},
+			allAsleep: true,
+		},
+		{
+			// в chan_2 есть значение: ветка select может быть выбрана
+			name: "select с готовой веткой",
+			goroutines: []Goroutine{
+				blockedOn("1", ChannelOp{Kind: "select", Site: "/app/main.go:10", TS: "10", Cases: []ChannelOp{
+					{Kind: "receive", Channel: "chan_1"}, {Kind: "receive", Channel: "chan_2"},
+				}}),
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "0", Receivers: []string{"1"}},
+				{ID: "2", Name: "chan_2", File: "/app/main.go:4", Cap: "1", Sends: 1, Senders: []string{"2"}, Receivers: []string{"1"}},
+			},
+		},
+		{
+			// отправка в nil-канал блокирует всегда, и разбудить её некому; горутина 2 работает
+			name: "nil-канал",
+			goroutines: []Goroutine{
+				blockedOn("1", sendOp("chan_0", "10")),
+				{ID: "2", State: StateRunning},
+			},
+			channels: []Channel{{ID: "0", Name: "chan_0"}},
+			blocked:  map[string][]string{"1": nil},
+		},
+		{
+			// в буфере на два значения место есть у двух отправок из трёх: заблокирована последняя
+			name: "буферизованный канал",
+			goroutines: []Goroutine{
+				blockedOn("1", sendOp("chan_1", "10")),
+				blockedOn("2", sendOp("chan_1", "30")),
+				blockedOn("3", sendOp("chan_1", "20")),
+				{ID: "4", State: StateRunning},
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "2", Sends: 3, Senders: []string{"1", "2", "3"}, Receivers: []string{"4"}},
+			},
+			blocked: map[string][]string{"2": {"4"}},
+		},
+		{
+			// трасса оборвана после начала операций: у отправки в chan_1 есть получатель, а канал
+			// chan_2 создан вне инструментированного кода — ни одна операция не блокирует
+			name: "оборванная трасса",
+			goroutines: []Goroutine{
+				blockedOn("1", sendOp("chan_1", "10")),
+				blockedOn("2", receiveOp("chan_1", "11")),
+				blockedOn("3", receiveOp("chan_2", "12")),
+			},
+			channels: []Channel{
+				{ID: "1", Name: "chan_1", File: "/app/main.go:3", Cap: "0", Sends: 1, Receives: 1, Senders: []string{"1"}, Receivers: []string{"2"}},
+				{ID: "2", Name: "chan_2", Receives: 1, Receivers: []string{"3"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GorutineGraph{Gorutines: map[string]Goroutine{}, Channels: map[string]Channel{}, Shutdown: tt.shutdown}
+			for _, gr := range tt.goroutines {
+				g.Gorutines[gr.ID] = gr
+			}
+			for _, ch := range tt.channels {
+				g.Channels[ch.Name] = ch
+			}
+
+			report := g.Deadlocks()
+			blocked := map[string][]string{}
+			for _, b := range report.Blocked {
+				blocked[b.Goroutine.ID] = b.WaitsFor
+			}
+			if tt.blocked == nil {
+				tt.blocked = map[string][]string{}
+			}
+			if !reflect.DeepEqual(blocked, tt.blocked) {
+				t.Errorf("заблокированы %v, ожидались %v", blocked, tt.blocked)
+			}
+			if !reflect.DeepEqual(report.Cycles, tt.cycles) {
+				t.Errorf("циклы %v, ожидались %v", report.Cycles, tt.cycles)
+			}
+			if report.AllAsleep != tt.allAsleep {
+				t.Errorf("все спят: %v, ожидалось %v", report.AllAsleep, tt.allAsleep)
+			}
+			if found := len(tt.cycles) > 0 || tt.allAsleep; report.Found() != found {
+				t.Errorf("взаимная блокировка %v, ожидалась %v", report.Found(), found)
+			}
+		})
+	}
+}
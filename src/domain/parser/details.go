@@ -0,0 +1,142 @@
+package parser
+
+import "strings"
+
+// GoroutineDetails — всё, что трасса знает о горутине
+type GoroutineDetails struct {
+	Goroutine Goroutine
+	// Children — горутины, запущенные этой горутиной
+	Children []string
+	// Channels — каналы, с которыми работала горутина, включая ожидание в select
+	Channels []Channel
+	Edges    []Edge
+	// Blocking — время ожидания завершённых операций горутины (nil — ожиданий не было)
+	Blocking *BlockedTime
+	// Blocked — операция, на которой горутина заблокирована к концу трассы (nil — не заблокирована)
+	Blocked *BlockedGoroutine
+	// Leaked — горутина не завершилась к завершению программы (записанному в трассе)
+	Leaked    bool
+	Incidents []Incident
+}
+
+// ChannelDetails — всё, что трасса знает о канале
+type ChannelDetails struct {
+	Channel   Channel
+	Senders   []Goroutine
+	Receivers []Goroutine
+	Edges     []Edge
+	// Blocking — время ожидания завершённых операций с каналом (nil — ожиданий не было)
+	Blocking *BlockedTime
+	// Blocked — горутины, заблокированные на канале к концу трассы (в том числе в select)
+	Blocked   []BlockedGoroutine
+	Incidents []Incident
+}
+
+// GoroutineDetails возвращает подробности о горутине; id — ключ горутины в графе (в объединённой
+// трассе — с пространством имён источника). false — горутины нет
+func (g *GorutineGraph) GoroutineDetails(id string) (GoroutineDetails, bool) {
+	gr, ok := g.Gorutines[id]
+	if !ok {
+		return GoroutineDetails{}, false
+	}
+	details := GoroutineDetails{Goroutine: gr}
+	for _, childID := range sortedIDs(g.Gorutines) {
+		if g.Gorutines[childID].Parent == id {
+			details.Children = append(details.Children, childID)
+		}
+	}
+	for _, name := range g.channelsByGoroutine()[id] {
+		details.Channels = append(details.Channels, g.Channels[name])
+	}
+	details.Edges = g.edgesOf(id)
+	if bt, ok := g.Blocking.ByGoroutine[id]; ok {
+		details.Blocking = &bt
+	}
+	for _, b := range g.Deadlocks().Blocked {
+		if b.Goroutine.ID == id {
+			details.Blocked = &b
+			break
+		}
+	}
+	details.Leaked = gr.State == StateRunning && g.Shutdown != nil
+	for _, inc := range g.Incidents {
+		if inc.Goroutine == id {
+			details.Incidents = append(details.Incidents, inc)
+		}
+	}
+	return details, true
+}
+
+// ChannelDetails возвращает подробности о канале; key — имя канала в графе (chan_N) или его номер
+// (в объединённой трассе — с пространством имён источника). false — канала нет
+func (g *GorutineGraph) ChannelDetails(key string) (ChannelDetails, bool) {
+	name, ok := g.channelName(key)
+	if !ok {
+		return ChannelDetails{}, false
+	}
+	ch := g.Channels[name]
+	details := ChannelDetails{Channel: ch}
+	for _, id := range ch.Senders {
+		if gr, ok := g.Gorutines[id]; ok {
+			details.Senders = append(details.Senders, gr)
+		}
+	}
+	for _, id := range ch.Receivers {
+		if gr, ok := g.Gorutines[id]; ok {
+			details.Receivers = append(details.Receivers, gr)
+		}
+	}
+	details.Edges = g.edgesOf(name)
+	if bt, ok := g.Blocking.ByChannel[name]; ok {
+		details.Blocking = &bt
+	}
+	for _, b := range g.Deadlocks().Blocked {
+		if opUses(b.Op, name) {
+			details.Blocked = append(details.Blocked, b)
+		}
+	}
+	for _, inc := range g.Incidents {
+		if inc.Channel == name {
+			details.Incidents = append(details.Incidents, inc)
+		}
+	}
+	return details, true
+}
+
+// channelName находит имя канала по имени или номеру
+func (g *GorutineGraph) channelName(key string) (string, bool) {
+	if _, ok := g.Channels[key]; ok {
+		return key, true
+	}
+	ns, local := splitNamespace(key)
+	if strings.HasPrefix(local, "chan_") {
+		return "", false
+	}
+	name := Namespaced(ns, "chan_"+local)
+	_, ok := g.Channels[name]
+	return name, ok
+}
+
+// edgesOf возвращает рёбра узла графа
+func (g *GorutineGraph) edgesOf(key string) []Edge {
+	var edges []Edge
+	for _, e := range g.Edges {
+		if e.From == key || e.To == key {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// opUses сообщает, что операция (или одна из веток select) выполняется с каналом
+func opUses(op ChannelOp, channel string) bool {
+	if op.Channel == channel {
+		return true
+	}
+	for _, c := range op.Cases {
+		if c.Channel == channel {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic — запись трассы, которую не удалось разобрать
+type Diagnostic struct {
+	// Line — номер строки текстовой трассы или номер записи двоичной (заголовок — запись 0)
+	Line int
+	// Kind — тип записи (пусто, если не удалось определить и его)
+	Kind   string
+	Reason string
+	// Source — объединяемая трасса, в которой встретилась запись (пусто для одной трассы)
+	Source string
+}
+
+func (d Diagnostic) Error() string {
+	prefix := ""
+	if d.Source != "" {
+		prefix = d.Source + ": "
+	}
+	if d.Kind == "" {
+		return fmt.Sprintf("%sline %d: %s", prefix, d.Line, d.Reason)
+	}
+	return fmt.Sprintf("%sline %d (%s): %s", prefix, d.Line, d.Kind, d.Reason)
+}
+
+// DiagnosticReport — отчёт о пропущенных при разборе записях трассы
+type DiagnosticReport struct {
+	Diagnostics []Diagnostic
+	// Omitted — пропущенные записи, не вошедшие в Diagnostics
+	Omitted int
+}
+
+// DiagnosticsReport строит отчёт о пропущенных записях в порядке их следования в трассе
+func (g *GorutineGraph) DiagnosticsReport() DiagnosticReport {
+	return DiagnosticReport{Diagnostics: g.Diagnostics, Omitted: g.OmittedDiagnostics}
+}
+
+func (r DiagnosticReport) String() string {
+	if len(r.Diagnostics) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("пропущено записей трассы: %d\n", len(r.Diagnostics)+r.Omitted))
+	for _, d := range r.Diagnostics {
+		kind := d.Kind
+		if kind == "" {
+			kind = "?"
+		}
+		source := ""
+		if d.Source != "" {
+			source = d.Source + ", "
+		}
+		sb.WriteString(fmt.Sprintf("  %sстрока %d, %s: %s\n", source, d.Line, kind, d.Reason))
+	}
+	if r.Omitted > 0 {
+		sb.WriteString(fmt.Sprintf("  и ещё %d\n", r.Omitted))
+	}
+	return sb.String()
+}
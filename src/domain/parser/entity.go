@@ -2,13 +2,95 @@ package parser
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type GorutineGraph struct {
 	Gorutines map[string]Goroutine
 	Channels  map[string]Channel
 	Edges     []Edge
+	// Shutdown — событие завершения программы (nil, если программа упала или трасса оборвана)
+	Shutdown *Shutdown
+	// Blocking — время ожидания завершённых операций с каналами
+	Blocking BlockingProfile
+	// Header — заголовок трассы (nil для трасс, записанных до появления заголовка)
+	Header *TraceHeader
+	// Incidents — ошибки операций с каналами, перехваченные рантаймом gtrace
+	Incidents []Incident
+	// Diagnostics — записи трассы, пропущенные при разборе: подробно хранятся только первые,
+	// OmittedDiagnostics — число остальных
+	Diagnostics        []Diagnostic
+	OmittedDiagnostics int
+	// Sources — объединённые трассы (пусто, если граф построен по одной трассе). Shutdown графа
+	// задан, только если программа завершилась во всех источниках
+	Sources []TraceSource
+}
+
+// IncidentKind — вид инцидента
+type IncidentKind string
+
+const (
+	// IncidentCloseError — паника при закрытии канала: повторное закрытие или закрытие nil-канала
+	IncidentCloseError IncidentKind = "close_error"
+)
+
+// Incident — ошибка операции с каналом: кто, с каким каналом, где и когда
+type Incident struct {
+	Kind      IncidentKind
+	Goroutine string
+	Channel   string
+	Site      string
+	TS        string
+	Message   string
+}
+
+// Версии заголовка импортированных входов; у трасс gtrace версия — номер формата
+const (
+	// TraceVersionRuntime — трасса выполнения Go (runtime/trace)
+	TraceVersionRuntime = "runtime"
+	// TraceVersionDump — дамп горутин: снимок программы в один момент, а не оборванная трасса
+	TraceVersionDump = "dump"
+)
+
+// TraceHeader — заголовок трассы: версия формата и окружение трассируемой программы
+type TraceHeader struct {
+	Version    string
+	GoVersion  string
+	GOMAXPROCS string
+	Start      string
+	Args       []string
+}
+
+// Recorded сообщает, что трасса записана инструментированной программой (nil — трасса gtrace
+// без заголовка). Трасса выполнения Go и дамп горутин завершения программы не содержат
+func (h *TraceHeader) Recorded() bool {
+	return h == nil || h.Version != TraceVersionRuntime && h.Version != TraceVersionDump
+}
+
+// Recorded сообщает, что граф построен только по трассам gtrace: только в них отсутствие
+// завершения программы означает, что программа вышла в обход gtrace, упала или трасса оборвана
+func (g *GorutineGraph) Recorded() bool {
+	if len(g.Sources) == 0 {
+		return g.Header.Recorded()
+	}
+	for _, src := range g.Sources {
+		if !src.Header.Recorded() {
+			return false
+		}
+	}
+	return true
+}
+
+// Shutdown — завершение трассируемой программы: возврат из main, os.Exit или паника в main
+type Shutdown struct {
+	Goroutine string
+	Reason    string
+	File      string
+	TS        string
+	Code      string
 }
 
 type Goroutine struct {
@@ -16,16 +98,76 @@ type Goroutine struct {
 	Func string
 	File string
 	TS   string
+	// Parent — горутина, выполнившая go-оператор, SpawnSite — место этого оператора
+	Parent    string
+	SpawnSite string
+	// EndTS — время завершения (пусто, если горутина не завершилась)
+	EndTS string
+	// State — итоговое состояние горутины; PanicValue и Stack заполняются для StatePanicked
+	State      GoroutineState
+	PanicValue string
+	Stack      string
+	// LastOp — последняя операция с каналом, на которой видели горутину
+	LastOp *ChannelOp
+	// WaitReason и Waiting — причина и время ожидания из дампа горутин ("chan receive", "select",
+	// "semacquire"); для дампа Stack — стек горутины на момент снимка
+	WaitReason string
+	Waiting    time.Duration
+}
+
+// ChannelOp — операция горутины с каналом: send, receive или select. Для select канал не задан,
+// а в Cases перечислены ветки с каналами; Default — у select есть ветка default
+type ChannelOp struct {
+	Kind    string
+	Channel string
+	Site    string
+	TS      string
+	Cases   []ChannelOp
+	Default bool
+	// Done — операция завершилась (записано событие завершения или выбрана ветка select)
+	Done bool
+}
+
+// GoroutineState — итоговое состояние горутины по трассе
+type GoroutineState string
+
+const (
+	// StateUnknown — горутина известна только по операциям (например, main), её запуск не трассировался
+	StateUnknown GoroutineState = ""
+	// StateRunning — func_end не было: горутина работала на момент окончания трассы
+	StateRunning  GoroutineState = "running"
+	StateReturned GoroutineState = "returned"
+	StatePanicked GoroutineState = "panicked"
+	StateGoexit   GoroutineState = "goexit"
+)
+
+// SpawnNode — узел дерева запусков горутин
+type SpawnNode struct {
+	Goroutine Goroutine
+	Children  []*SpawnNode
 }
 type Channel struct {
+	ID   string
 	Name string
+	// File — место создания канала (пусто, если канал создан вне инструментированного кода)
 	File string
 	TS   string
+	Cap  string
+	// Sends и Receives — число начатых отправок и получений, включая выбранные ветки select
+	Sends    int
+	Receives int
+	Closed   bool
+	// Senders и Receivers — горутины, которые отправляли в канал или получали из него
+	// (в том числе ожидали в select); закрывшая канал горутина считается отправителем
+	Senders   []string
+	Receivers []string
 }
 type Edge struct {
 	From  string
 	To    string
 	Label string
+	// TS — время операции или go-оператора (пусто, если не записано)
+	TS string
 }
 
 func (g *GorutineGraph) ToDot() string {
@@ -34,7 +176,11 @@ func (g *GorutineGraph) ToDot() string {
 	sb.WriteString("  // Nodes\n")
 
 	for _, g := range g.Gorutines {
-		sb.WriteString(fmt.Sprintf("  \"%s (ID: %s)\\n%s\\n%s\";\n", g.Func, g.ID, g.File, g.TS))
+		label := fmt.Sprintf("%s (ID: %s)\\n%s\\n%s", g.Func, g.ID, g.File, g.TS)
+		if g.WaitReason != "" {
+			label += fmt.Sprintf("\\n[%s]", waitTitle(g))
+		}
+		sb.WriteString(fmt.Sprintf("  \"%s\";\n", label))
 	}
 
 	for _, ch := range g.Channels {
@@ -42,6 +188,11 @@ func (g *GorutineGraph) ToDot() string {
 		if closer, ok := g.getCloseBy(ch.Name); ok {
 			label += fmt.Sprintf(" (closed by %s)", closer)
 		}
+		for _, inc := range g.Incidents {
+			if inc.Channel == ch.Name {
+				label += fmt.Sprintf(" [%s by %s: %s]", inc.Kind, inc.Goroutine, inc.Message)
+			}
+		}
 		label += fmt.Sprintf("\\n%s\\n%s", ch.File, ch.TS)
 		sb.WriteString(fmt.Sprintf("  \"%s\";\n", label))
 	}
@@ -55,6 +206,197 @@ func (g *GorutineGraph) ToDot() string {
 	return sb.String()
 }
 
+// SpawnTree возвращает дерево запусков горутин родитель → потомки.
+// Корни — горутины без известного родителя (например, main)
+func (g *GorutineGraph) SpawnTree() []*SpawnNode {
+	nodes := make(map[string]*SpawnNode, len(g.Gorutines))
+	for id, gr := range g.Gorutines {
+		nodes[id] = &SpawnNode{Goroutine: gr}
+	}
+
+	var roots []*SpawnNode
+	for _, id := range sortedIDs(g.Gorutines) {
+		node := nodes[id]
+		if parent, ok := nodes[node.Goroutine.Parent]; ok && node.Goroutine.Parent != id {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// Crashed возвращает горутины, завершившиеся паникой: паника в горутине, не перехваченная
+// её собственным кодом, завершает процесс
+func (g *GorutineGraph) Crashed() []Goroutine {
+	var crashed []Goroutine
+	for _, id := range sortedIDs(g.Gorutines) {
+		if gr := g.Gorutines[id]; gr.State == StatePanicked {
+			crashed = append(crashed, gr)
+		}
+	}
+	return crashed
+}
+
+// LeakReport — отчёт об утечках: горутины, у которых был func_start, но не было func_end
+// к моменту завершения программы. Если завершение программы в трассе не записано (программа вышла
+// в обход gtrace — os.Exit или log.Fatal в зависимостях, — упала, убита сигналом или трасса оборвана),
+// незавершённые горутины утечками не считаются: трасса закончилась раньше них. Они перечисляются
+// в Unfinished. Recorded — граф построен по трассам gtrace (см. GorutineGraph.Recorded): в дампе
+// горутин и трассе выполнения Go завершения программы нет и быть не может
+type LeakReport struct {
+	Shutdown   *Shutdown
+	Recorded   bool
+	Leaked     []Goroutine
+	Unfinished []Goroutine
+	channels   map[string]Channel
+}
+
+// Leaks строит отчёт об утечках горутин
+func (g *GorutineGraph) Leaks() LeakReport {
+	report := LeakReport{Shutdown: g.Shutdown, Recorded: g.Recorded(), channels: g.Channels}
+	for _, id := range sortedIDs(g.Gorutines) {
+		gr := g.Gorutines[id]
+		switch {
+		case gr.State != StateRunning:
+		case g.Shutdown == nil:
+			report.Unfinished = append(report.Unfinished, gr)
+		default:
+			report.Leaked = append(report.Leaked, gr)
+		}
+	}
+	return report
+}
+
+func (r LeakReport) String() string {
+	var sb strings.Builder
+	if r.Shutdown == nil {
+		if r.Recorded {
+			sb.WriteString("трасса закончилась без завершения программы: программа вышла в обход gtrace " +
+				"(os.Exit или log.Fatal в зависимостях), упала, убита сигналом или трасса оборвана\n")
+		}
+		if len(r.Unfinished) > 0 {
+			sb.WriteString(fmt.Sprintf("горутин без завершения к концу трассы: %d (утечки не определяются)\n", len(r.Unfinished)))
+			r.writeGoroutines(&sb, r.Unfinished)
+		}
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("программа завершилась (%s, код %s) в %s\n", r.Shutdown.Reason, r.Shutdown.Code, r.Shutdown.File))
+	if len(r.Leaked) == 0 {
+		sb.WriteString("утечек горутин не обнаружено\n")
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("незавершённых горутин: %d\n", len(r.Leaked)))
+	r.writeGoroutines(&sb, r.Leaked)
+	return sb.String()
+}
+
+// writeGoroutines перечисляет горутины с местом запуска и последней операцией
+func (r LeakReport) writeGoroutines(sb *strings.Builder, goroutines []Goroutine) {
+	for _, gr := range goroutines {
+		sb.WriteString(fmt.Sprintf("  %s\n", goroutineTitle(gr)))
+		if gr.SpawnSite != "" {
+			sb.WriteString(fmt.Sprintf("    запущена в %s горутиной %s\n", gr.SpawnSite, gr.Parent))
+		}
+		if gr.LastOp == nil {
+			sb.WriteString("    операций с каналами не было\n")
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("    последняя операция: %s\n", describeOp(*gr.LastOp, r.channels)))
+	}
+}
+
+// IncidentReport — отчёт об инцидентах с каналами
+type IncidentReport struct {
+	Incidents []Incident
+	channels  map[string]Channel
+}
+
+// IncidentsReport строит отчёт об инцидентах в порядке их возникновения
+func (g *GorutineGraph) IncidentsReport() IncidentReport {
+	return IncidentReport{Incidents: g.Incidents, channels: g.Channels}
+}
+
+func (r IncidentReport) String() string {
+	if len(r.Incidents) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("инцидентов с каналами: %d\n", len(r.Incidents)))
+	for _, inc := range r.Incidents {
+		op := describeOp(ChannelOp{Kind: "close", Channel: inc.Channel, Site: inc.Site}, r.channels)
+		sb.WriteString(fmt.Sprintf("  %s: горутина %s, %s\n", inc.Kind, inc.Goroutine, op))
+		sb.WriteString(fmt.Sprintf("    %s\n", inc.Message))
+	}
+	return sb.String()
+}
+
+// goroutineTitle возвращает заголовок горутины для отчётов; функция не известна, если запуск
+// горутины не трассировался (например, у main)
+func goroutineTitle(gr Goroutine) string {
+	if gr.Func == "" {
+		return "горутина " + gr.ID
+	}
+	return fmt.Sprintf("горутина %s %s", gr.ID, gr.Func)
+}
+
+// describeOp описывает операцию с каналом для отчётов: направление, канал с местом создания и место операции
+func describeOp(op ChannelOp, channels map[string]Channel) string {
+	describeChan := func(name string) string {
+		if ch, ok := channels[name]; ok && ch.File != "" {
+			return fmt.Sprintf("%s (создан в %s)", name, ch.File)
+		}
+		return name
+	}
+
+	desc := op.Kind
+	switch {
+	case op.Channel != "":
+		desc += " " + describeChan(op.Channel)
+	case op.Kind == "select":
+		cases := make([]string, 0, len(op.Cases)+1)
+		for _, c := range op.Cases {
+			cases = append(cases, c.Kind+" "+describeChan(c.Channel))
+		}
+		if op.Default {
+			cases = append(cases, "default")
+		}
+		desc += " [" + strings.Join(cases, ", ") + "]"
+	}
+	if op.Site != "" {
+		desc += " в " + op.Site
+	}
+	return desc
+}
+
+// sortedIDs возвращает идентификаторы горутин по возрастанию номера
+func sortedIDs(gorutines map[string]Goroutine) []string {
+	ids := make([]string, 0, len(gorutines))
+	for id := range gorutines {
+		ids = append(ids, id)
+	}
+	sortIDs(ids)
+	return ids
+}
+
+// sortIDs сортирует идентификаторы горутин по источнику и по возрастанию номера
+func sortIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		nsA, localA := splitNamespace(ids[i])
+		nsB, localB := splitNamespace(ids[j])
+		if nsA != nsB {
+			return nsA < nsB
+		}
+		a, errA := strconv.Atoi(localA)
+		b, errB := strconv.Atoi(localB)
+		if errA != nil || errB != nil {
+			return ids[i] < ids[j]
+		}
+		return a < b
+	})
+}
+
 func (g *GorutineGraph) getCloseBy(channelName string) (string, bool) {
 
 	closeBy := make(map[string]string)
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// Незавершённые горутины — утечки, только если завершение программы записано в трассе; без него
+// (выход в обход gtrace, сигнал, обрыв трассы) они перечисляются отдельно
+func TestLeaks(t *testing.T) {
+	goroutines := map[string]Goroutine{
+		"1": {ID: "1", Func: "main.main", State: StateReturned},
+		"2": {ID: "2", Func: "main.worker", Parent: "1", SpawnSite: "/app/main.go:5", State: StateRunning},
+		"3": {ID: "3", Func: "main.logger", Parent: "1", SpawnSite: "/app/main.go:6", State: StateReturned},
+		"4": {ID: "4", Func: "main.idle", Parent: "1", SpawnSite: "/app/main.go:7", State: StateRunning},
+	}
+	tests := []struct {
+		name       string
+		header     *TraceHeader
+		shutdown   *Shutdown
+		leaked     []string
+		unfinished []string
+		report     string
+		// noReport — строка, которой в отчёте быть не должно
+		noReport string
+	}{
+		{
+			name:     "завершение записано",
+			shutdown: &Shutdown{Reason: "return", Code: "0", File: "/app/main.go:9"},
+			leaked:   []string{"2", "4"},
+			report:   "незавершённых горутин: 2",
+		},
+		{
+			name:       "трасса без завершения",
+			unfinished: []string{"2", "4"},
+			report:     "трасса закончилась без завершения программы",
+		},
+		{
+			// в дампе и трассе выполнения Go завершения программы не бывает: это не признак сбоя
+			name:       "дамп горутин",
+			header:     &TraceHeader{Version: TraceVersionDump},
+			unfinished: []string{"2", "4"},
+			report:     "горутин без завершения к концу трассы: 2",
+			noReport:   "трасса закончилась без завершения программы",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GorutineGraph{Gorutines: goroutines, Shutdown: tt.shutdown, Header: tt.header}
+			report := g.Leaks()
+			ids := func(list []Goroutine) []string {
+				var ids []string
+				for _, gr := range list {
+					ids = append(ids, gr.ID)
+				}
+				return ids
+			}
+			if got := ids(report.Leaked); strings.Join(got, ",") != strings.Join(tt.leaked, ",") {
+				t.Errorf("утечки %v, ожидались %v", got, tt.leaked)
+			}
+			if got := ids(report.Unfinished); strings.Join(got, ",") != strings.Join(tt.unfinished, ",") {
+				t.Errorf("незавершённые %v, ожидались %v", got, tt.unfinished)
+			}
+			if !strings.Contains(report.String(), tt.report) {
+				t.Errorf("отчёт:\n%s\nне содержит %q", report, tt.report)
+			}
+			if tt.noReport != "" && strings.Contains(report.String(), tt.noReport) {
+				t.Errorf("отчёт:\n%s\nсодержит %q", report, tt.noReport)
+			}
+			if details, _ := g.GoroutineDetails("2"); details.Leaked != (tt.shutdown != nil) {
+				t.Errorf("горутина 2 утекла: %v", details.Leaked)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Timestamp — временная метка события в наносекундах Unix
+type Timestamp int64
+
+func (t Timestamp) String() string {
+	return strconv.FormatInt(int64(t), 10)
+}
+
+// Sub возвращает время от u до t
+func (t Timestamp) Sub(u Timestamp) time.Duration {
+	return time.Duration(t - u)
+}
+
+// GoroutineID — номер горутины в рантайме Go (0 — не удалось определить)
+type GoroutineID uint64
+
+func (g GoroutineID) String() string {
+	return strconv.FormatUint(uint64(g), 10)
+}
+
+// ChannelID — номер канала, выданный рантаймом gtrace (0 — nil-канал)
+type ChannelID uint64
+
+func (c ChannelID) String() string {
+	return strconv.FormatUint(uint64(c), 10)
+}
+
+// Key возвращает ключ канала в графе — единственное представление канала в Channels и Edges
+func (c ChannelID) Key() string {
+	return fmt.Sprintf("chan_%d", c)
+}
+
+// Site — место в исходниках в формате "файл:строка"
+type Site string
+
+// Event — событие трассы. Конкретный тип события определяет его поля; события неизвестных
+// типов (из более новой версии рантайма) представлены RawEvent
+type Event interface {
+	Kind() string
+	// Time возвращает временную метку события (для заголовка — время начала трассы);
+	// false — у события нет метки (снимок состояния горутины, RawEvent)
+	Time() (Timestamp, bool)
+	// Shift сдвигает временную метку события (выравнивание часов объединяемых трасс);
+	// времена ожидания не меняются
+	Shift(d time.Duration)
+}
+
+// Op — общие поля операций горутины: кто, где (место в исходном проекте и в инструментированном) и когда
+type Op struct {
+	Goroutine GoroutineID
+	Site      Site
+	Caller    Site
+	TS        Timestamp
+}
+
+// TraceHeaderEvent — заголовок трассы
+type TraceHeaderEvent struct {
+	Version    string
+	GoVersion  string
+	GOMAXPROCS int
+	Start      Timestamp
+	Args       []string
+}
+
+// GoSpawnEvent — go-оператор в родительской горутине; Spawn связывает его с FuncStartEvent дочерней
+type GoSpawnEvent struct {
+	Op
+	Spawn uint64
+	Func  string
+}
+
+// FuncStartEvent — начало горутины
+type FuncStartEvent struct {
+	Goroutine GoroutineID
+	Func      string
+	Caller    Site
+	TS        Timestamp
+	Spawn     uint64
+}
+
+// FuncEndEvent — завершение горутины; PanicValue и Stack заполнены для Reason == "panic"
+type FuncEndEvent struct {
+	Goroutine  GoroutineID
+	Func       string
+	Caller     Site
+	TS         Timestamp
+	Reason     string
+	PanicValue string
+	Stack      string
+}
+
+// ChannelCreateEvent — создание канала
+type ChannelCreateEvent struct {
+	Channel ChannelID
+	Site    Site
+	Caller  Site
+	TS      Timestamp
+	Cap     int
+}
+
+// ChannelSendEvent — начало отправки в канал
+type ChannelSendEvent struct {
+	Op
+	Channel ChannelID
+}
+
+// ChannelReceiveEvent — начало получения из канала
+type ChannelReceiveEvent struct {
+	Op
+	Channel ChannelID
+}
+
+// ChannelOpDoneEvent — завершение отправки или получения (Dir — send или receive) со временем ожидания
+type ChannelOpDoneEvent struct {
+	Op
+	Channel ChannelID
+	Dir     string
+	Wait    time.Duration
+}
+
+// ChannelCloseEvent — закрытие канала
+type ChannelCloseEvent struct {
+	Op
+	Channel ChannelID
+}
+
+// ChannelCloseErrorEvent — паника при закрытии канала (повторное закрытие, nil-канал)
+type ChannelCloseErrorEvent struct {
+	Op
+	Channel ChannelID
+	Message string
+}
+
+// SelectCase — ветка select с каналом
+type SelectCase struct {
+	Dir     string
+	Channel ChannelID
+}
+
+// SelectEnterEvent — вход в select; Default — у select есть ветка default
+type SelectEnterEvent struct {
+	Op
+	Cases   []SelectCase
+	Default bool
+}
+
+// SelectCaseChosenEvent — выбрана ветка select с каналом
+type SelectCaseChosenEvent struct {
+	Op
+	Dir     string
+	Channel ChannelID
+}
+
+// SelectDefaultEvent — выбрана ветка default
+type SelectDefaultEvent struct {
+	Op
+}
+
+// ShutdownEvent — завершение программы: возврат из main, os.Exit или паника в main
+type ShutdownEvent struct {
+	Goroutine GoroutineID
+	Reason    string
+	Caller    Site
+	TS        Timestamp
+	Code      int
+}
+
+// GoroutineStateEvent — состояние горутины из снимка (дампа горутин): причина ожидания,
+// время ожидания и стек
+type GoroutineStateEvent struct {
+	Goroutine GoroutineID
+	Reason    string
+	Wait      time.Duration
+	Site      Site
+	Stack     string
+}
+
+// SourcedEvent — событие одной из объединяемых трасс; Source — пространство имён источника
+// (см. Namespaced), номера горутин, каналов и запусков события действуют только внутри источника
+type SourcedEvent struct {
+	Source string
+	Event
+}
+
+// RawEvent — событие типа, который не известен этой версии gtrace
+type RawEvent struct {
+	Type   string
+	Fields []string
+}
+
+func (*TraceHeaderEvent) Kind() string       { return "trace_header" }
+func (*GoSpawnEvent) Kind() string           { return "go_spawn" }
+func (*FuncStartEvent) Kind() string         { return "func_start" }
+func (*FuncEndEvent) Kind() string           { return "func_end" }
+func (*ChannelCreateEvent) Kind() string     { return "channel_create" }
+func (*ChannelSendEvent) Kind() string       { return "channel_send" }
+func (*ChannelReceiveEvent) Kind() string    { return "channel_receive" }
+func (e *ChannelOpDoneEvent) Kind() string   { return "channel_" + e.Dir + "_done" }
+func (*ChannelCloseEvent) Kind() string      { return "channel_close" }
+func (*ChannelCloseErrorEvent) Kind() string { return "channel_close_error" }
+func (*SelectEnterEvent) Kind() string       { return "select_enter" }
+func (*SelectCaseChosenEvent) Kind() string  { return "select_case_chosen" }
+func (*SelectDefaultEvent) Kind() string     { return "select_default" }
+func (*ShutdownEvent) Kind() string          { return "shutdown" }
+func (*GoroutineStateEvent) Kind() string    { return "goroutine_state" }
+func (e *RawEvent) Kind() string             { return e.Type }
+
+func (o *Op) Time() (Timestamp, bool)                 { return o.TS, true }
+func (e *TraceHeaderEvent) Time() (Timestamp, bool)   { return e.Start, true }
+func (e *FuncStartEvent) Time() (Timestamp, bool)     { return e.TS, true }
+func (e *FuncEndEvent) Time() (Timestamp, bool)       { return e.TS, true }
+func (e *ChannelCreateEvent) Time() (Timestamp, bool) { return e.TS, true }
+func (e *ShutdownEvent) Time() (Timestamp, bool)      { return e.TS, true }
+func (*GoroutineStateEvent) Time() (Timestamp, bool)  { return 0, false }
+func (*RawEvent) Time() (Timestamp, bool)             { return 0, false }
+
+func (o *Op) Shift(d time.Duration)                 { o.TS += Timestamp(d) }
+func (e *TraceHeaderEvent) Shift(d time.Duration)   { e.Start += Timestamp(d) }
+func (e *FuncStartEvent) Shift(d time.Duration)     { e.TS += Timestamp(d) }
+func (e *FuncEndEvent) Shift(d time.Duration)       { e.TS += Timestamp(d) }
+func (e *ChannelCreateEvent) Shift(d time.Duration) { e.TS += Timestamp(d) }
+func (e *ShutdownEvent) Shift(d time.Duration)      { e.TS += Timestamp(d) }
+func (*GoroutineStateEvent) Shift(time.Duration)    {}
+func (*RawEvent) Shift(time.Duration)               {}
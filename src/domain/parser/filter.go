@@ -0,0 +1,391 @@
+package parser
+
+import (
+	"strconv"
+	"time"
+)
+
+// Filter возвращает подграф из горутин и каналов, прошедших фильтр, и рёбер между ними, время
+// которых проходит условия на time. Горутина
+// проверяется по своим полям, а по полям channel.* — по каналам, с которыми работала; канал —
+// по своим полям и по горутинам, которые с ним работали. Поэтому фильтр по горутинам оставляет и их каналы,
+// а фильтр по каналу — горутины, которые его использовали. kind узла — метки его рёбер.
+// Горутины и каналы в подграфе не меняются: их счётчики и последние операции посчитаны по всей трассе
+func (g *GorutineGraph) Filter(q *Query) *GorutineGraph {
+	view := &filterView{
+		graph:    g,
+		start:    g.start(),
+		adjacent: g.adjacency(),
+		channels: g.channelsByGoroutine(),
+		near:     make(map[string]map[string]bool),
+	}
+
+	filtered := &GorutineGraph{
+		Gorutines:   make(map[string]Goroutine),
+		Channels:    make(map[string]Channel),
+		Edges:       []Edge{},
+		Shutdown:    g.Shutdown,
+		Header:      g.Header,
+		Diagnostics: g.Diagnostics,
+		Sources:     g.Sources,
+
+		OmittedDiagnostics: g.OmittedDiagnostics,
+	}
+	for id, gr := range g.Gorutines {
+		if q.Match(goroutineSubject{view, gr}, view.neighbourhood) {
+			filtered.Gorutines[id] = gr
+		}
+	}
+	for name, ch := range g.Channels {
+		if q.Match(channelSubject{view, ch}, view.neighbourhood) {
+			filtered.Channels[name] = ch
+		}
+	}
+	for _, e := range g.Edges {
+		if filtered.has(e.From) && filtered.has(e.To) && q.Match(edgeSubject{view, e}, view.neighbourhood) {
+			filtered.Edges = append(filtered.Edges, e)
+		}
+	}
+	for _, inc := range g.Incidents {
+		if filtered.has(inc.Goroutine) || filtered.has(inc.Channel) {
+			filtered.Incidents = append(filtered.Incidents, inc)
+		}
+	}
+	filtered.Blocking = g.Blocking.only(filtered)
+	return filtered
+}
+
+// Only оставляет в отчёте заблокированные горутины из подграфа и циклы, затрагивающие их.
+// Взаимную блокировку нужно искать по всему графу: в подграфе нет горутин, которые могли бы разбудить
+// оставшиеся
+func (r DeadlockReport) Only(view *GorutineGraph) DeadlockReport {
+	only := DeadlockReport{channels: r.channels}
+	for _, b := range r.Blocked {
+		if view.has(b.Goroutine.ID) {
+			only.Blocked = append(only.Blocked, b)
+		}
+	}
+	only.AllAsleep = r.AllAsleep && len(only.Blocked) > 0
+	for _, cycle := range r.Cycles {
+		for _, id := range cycle {
+			if view.has(id) {
+				only.Cycles = append(only.Cycles, cycle)
+				break
+			}
+		}
+	}
+	return only
+}
+
+// Only оставляет в отчёте горутины из подграфа; каналы в описаниях операций остаются из всего графа
+func (r LeakReport) Only(view *GorutineGraph) LeakReport {
+	only := LeakReport{Shutdown: r.Shutdown, Recorded: r.Recorded, channels: r.channels}
+	for _, gr := range r.Leaked {
+		if view.has(gr.ID) {
+			only.Leaked = append(only.Leaked, gr)
+		}
+	}
+	for _, gr := range r.Unfinished {
+		if view.has(gr.ID) {
+			only.Unfinished = append(only.Unfinished, gr)
+		}
+	}
+	return only
+}
+
+// Only оставляет в отчёте инциденты с горутинами или каналами из подграфа
+func (r IncidentReport) Only(view *GorutineGraph) IncidentReport {
+	only := IncidentReport{channels: r.channels}
+	for _, inc := range r.Incidents {
+		if view.has(inc.Goroutine) || view.has(inc.Channel) {
+			only.Incidents = append(only.Incidents, inc)
+		}
+	}
+	return only
+}
+
+// has сообщает, что узел (горутина или канал) есть в графе
+func (g *GorutineGraph) has(key string) bool {
+	if _, ok := g.Gorutines[key]; ok {
+		return true
+	}
+	_, ok := g.Channels[key]
+	return ok
+}
+
+// only оставляет в профиле ожидания каналы и горутины подграфа; места операций остаются,
+// если остался хотя бы один канал из профиля
+func (p BlockingProfile) only(view *GorutineGraph) BlockingProfile {
+	var only BlockingProfile
+	for key, bt := range p.ByChannel {
+		if view.has(key) {
+			setBlocked(&only.ByChannel, key, bt)
+		}
+	}
+	for key, bt := range p.ByGoroutine {
+		if view.has(key) {
+			setBlocked(&only.ByGoroutine, key, bt)
+		}
+	}
+	if len(only.ByChannel) > 0 {
+		only.BySite = p.BySite
+	}
+	return only
+}
+
+func setBlocked(m *map[string]BlockedTime, key string, bt BlockedTime) {
+	if *m == nil {
+		*m = make(map[string]BlockedTime)
+	}
+	(*m)[key] = bt
+}
+
+// start возвращает начало трассы: время из заголовка или самую раннюю временную метку графа
+func (g *GorutineGraph) start() int64 {
+	if g.Header != nil {
+		if start, err := strconv.ParseInt(g.Header.Start, 10, 64); err == nil && start != 0 {
+			return start
+		}
+	}
+	var start int64
+	for _, gr := range g.Gorutines {
+		if ts, err := strconv.ParseInt(gr.TS, 10, 64); err == nil && ts != 0 && (start == 0 || ts < start) {
+			start = ts
+		}
+	}
+	for _, ch := range g.Channels {
+		if ts, err := strconv.ParseInt(ch.TS, 10, 64); err == nil && ts != 0 && (start == 0 || ts < start) {
+			start = ts
+		}
+	}
+	return start
+}
+
+// adjacency возвращает соседей узлов графа и метки их рёбер без учёта направления
+func (g *GorutineGraph) adjacency() map[string][]Edge {
+	adjacent := make(map[string][]Edge)
+	for _, e := range g.Edges {
+		adjacent[e.From] = append(adjacent[e.From], e)
+		adjacent[e.To] = append(adjacent[e.To], e)
+	}
+	return adjacent
+}
+
+// channelsByGoroutine возвращает каналы, с которыми работала каждая горутина, включая ожидание в select
+func (g *GorutineGraph) channelsByGoroutine() map[string][]string {
+	channels := make(map[string][]string)
+	for name, ch := range g.Channels {
+		seen := make(map[string]bool)
+		for _, participants := range [][]string{ch.Senders, ch.Receivers} {
+			for _, id := range participants {
+				if !seen[id] {
+					seen[id] = true
+					channels[id] = append(channels[id], name)
+				}
+			}
+		}
+	}
+	return channels
+}
+
+// filterView — граф, к узлам которого применяется фильтр
+type filterView struct {
+	graph    *GorutineGraph
+	start    int64
+	adjacent map[string][]Edge
+	channels map[string][]string
+	near     map[string]map[string]bool
+}
+
+// neighbourhood возвращает узлы не дальше hops рёбер от горутины (обход в ширину без учёта направления)
+func (v *filterView) neighbourhood(goroutine string, hops int) map[string]bool {
+	key := goroutine + "@" + strconv.Itoa(hops)
+	if near, ok := v.near[key]; ok {
+		return near
+	}
+	near := map[string]bool{goroutine: true}
+	frontier := []string{goroutine}
+	for i := 0; i < hops && len(frontier) > 0; i++ {
+		var next []string
+		for _, node := range frontier {
+			for _, e := range v.adjacent[node] {
+				other := e.To
+				if other == node {
+					other = e.From
+				}
+				if !near[other] {
+					near[other] = true
+					next = append(next, other)
+				}
+			}
+		}
+		frontier = next
+	}
+	v.near[key] = near
+	return near
+}
+
+// labels возвращает метки рёбер узла
+func (v *filterView) labels(key string) []string {
+	var labels []string
+	for _, e := range v.adjacent[key] {
+		labels = append(labels, e.Label)
+	}
+	return labels
+}
+
+// since возвращает время от начала трассы; false — метки нет
+func (v *filterView) since(ts string) (time.Duration, bool) {
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || ts == "" {
+		return 0, false
+	}
+	return time.Duration(t - v.start), true
+}
+
+// goroutineSubject — горутина графа как субъект фильтра
+type goroutineSubject struct {
+	view *filterView
+	gr   Goroutine
+}
+
+func (s goroutineSubject) Key() string {
+	return s.gr.ID
+}
+
+func (s goroutineSubject) Field(name string) ([]string, bool) {
+	gr := s.gr
+	switch name {
+	case "kind":
+		return s.view.labels(gr.ID), true
+	case "site":
+		sites := []string{gr.File, gr.SpawnSite}
+		if gr.LastOp != nil {
+			sites = append(sites, gr.LastOp.Site)
+		}
+		return sites, true
+	case "source":
+		source, _ := splitNamespace(gr.ID)
+		return []string{source}, true
+	case "goroutine.id":
+		_, local := splitNamespace(gr.ID)
+		return []string{gr.ID, local}, true
+	case "goroutine.func":
+		return []string{gr.Func}, true
+	case "goroutine.site":
+		return []string{gr.File}, true
+	case "goroutine.state":
+		return []string{string(gr.State)}, true
+	case "goroutine.wait":
+		return []string{gr.WaitReason}, true
+	case "goroutine.parent":
+		return []string{gr.Parent}, true
+	}
+
+	// поля канала — по каналам, с которыми работала горутина
+	var values []string
+	for _, channel := range s.view.channels[gr.ID] {
+		v, _ := channelSubject{s.view, s.view.graph.Channels[channel]}.Field(name)
+		values = append(values, v...)
+	}
+	return values, true
+}
+
+func (s goroutineSubject) Span() (time.Duration, time.Duration, bool) {
+	from, ok := s.view.since(s.gr.TS)
+	if !ok {
+		from = 0
+	}
+	to, ok := s.view.since(s.gr.EndTS)
+	if !ok {
+		to = time.Duration(1<<63 - 1)
+	}
+	return from, to, true
+}
+
+// channelSubject — канал графа как субъект фильтра
+type channelSubject struct {
+	view *filterView
+	ch   Channel
+}
+
+func (s channelSubject) Key() string {
+	return s.ch.Name
+}
+
+func (s channelSubject) Field(name string) ([]string, bool) {
+	ch := s.ch
+	switch name {
+	case "kind":
+		return s.view.labels(ch.Name), true
+	case "site", "channel.site":
+		return []string{ch.File}, true
+	case "source":
+		source, _ := splitNamespace(ch.Name)
+		return []string{source}, true
+	case "channel.id":
+		return []string{ch.Name, ch.ID}, true
+	case "channel.cap":
+		return []string{ch.Cap}, true
+	}
+
+	// поля горутины — по горутинам, которые работали с каналом
+	var values []string
+	for _, participants := range [][]string{ch.Senders, ch.Receivers} {
+		for _, id := range participants {
+			if gr, ok := s.view.graph.Gorutines[id]; ok {
+				v, _ := goroutineSubject{s.view, gr}.Field(name)
+				values = append(values, v...)
+			}
+		}
+	}
+	return values, true
+}
+
+// Span канала — от начала работы первой горутины, использовавшей канал, до завершения последней;
+// канал без участников существует в момент создания (или закрытия)
+func (s channelSubject) Span() (time.Duration, time.Duration, bool) {
+	var from, to time.Duration
+	found := false
+	for _, participants := range [][]string{s.ch.Senders, s.ch.Receivers} {
+		for _, id := range participants {
+			gr, ok := s.view.graph.Gorutines[id]
+			if !ok {
+				continue
+			}
+			f, t, _ := goroutineSubject{s.view, gr}.Span()
+			if !found || f < from {
+				from = f
+			}
+			if !found || t > to {
+				to = t
+			}
+			found = true
+		}
+	}
+	if found {
+		return from, to, true
+	}
+	at, ok := s.view.since(s.ch.TS)
+	return at, at, ok
+}
+
+// edgeSubject — ребро графа как субъект фильтра: известно только время операции, поэтому ребро
+// между оставшимися узлами отбрасывают лишь условия на время
+type edgeSubject struct {
+	view *filterView
+	edge Edge
+}
+
+func (s edgeSubject) Key() string {
+	return ""
+}
+
+func (s edgeSubject) Field(name string) ([]string, bool) {
+	return nil, false
+}
+
+func (s edgeSubject) Span() (time.Duration, time.Duration, bool) {
+	at, ok := s.view.since(s.edge.TS)
+	return at, at, ok
+}
@@ -0,0 +1,499 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Язык фильтров трассы. Выражение — сравнения полей, объединённые and, or, not и скобками:
+//
+//	goroutine.func =~ "worker" and not kind = close
+//	channel.site = "pipeline.go:42"
+//	time in [2s, 5s]
+//	near goroutine 17 within 2 hops
+//
+// Поля: kind (тип события или операции: send, receive, close, select, spawn, ...), time (время
+// от начала трассы), site (место операции), source (объединённая трасса), goroutine.id, goroutine.func,
+// goroutine.site, goroutine.state, goroutine.wait (причина ожидания из дампа), goroutine.parent,
+// channel.id, channel.site, channel.cap. Операторы: = и != (места сравниваются по концу пути:
+// "pipeline.go:42" совпадает с "/app/pipeline.go:42"), =~ и !~ (регулярное выражение), <, <=, >, >=
+// (числа и длительности), in [от, до] (для time). Значение — слово, число, длительность или строка в кавычках.
+//
+// Выражение применяется к субъектам: событиям трассы и узлам графа. Поле, которое субъекту
+// неизвестно (например, состояние горутины для события или окрестность горутины в потоке событий),
+// делает сравнение неопределённым; неопределённое выражение субъект не отбрасывает
+
+// Subject — то, к чему применяется фильтр: событие трассы, горутина или канал графа
+type Subject interface {
+	// Key — ключ узла графа (пусто для события)
+	Key() string
+	// Field возвращает значения поля; сравнение выполняется, если совпало хотя бы одно значение.
+	// known == false — поле субъекту неизвестно
+	Field(name string) (values []string, known bool)
+	// Span возвращает интервал существования субъекта от начала трассы; у события from == to
+	Span() (from, to time.Duration, known bool)
+}
+
+// Neighbourhood возвращает ключи узлов графа не дальше hops рёбер от горутины (nil — граф не известен)
+type Neighbourhood func(goroutine string, hops int) map[string]bool
+
+// Query — разобранное выражение фильтра
+type Query struct {
+	source string
+	root   queryNode
+}
+
+// ParseQuery разбирает выражение фильтра
+func ParseQuery(expr string) (*Query, error) {
+	tokens, err := lexQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("invalid query: unexpected %q at %d", tok.text, tok.pos)
+	}
+	return &Query{source: expr, root: root}, nil
+}
+
+func (q *Query) String() string {
+	return q.source
+}
+
+// Match сообщает, что субъект проходит фильтр: выражение истинно или неопределённо.
+// near — окрестности горутин графа; nil для потока событий
+func (q *Query) Match(s Subject, near Neighbourhood) bool {
+	return q.root.eval(s, near) != truthNo
+}
+
+// truth — значение выражения в трёхзначной логике: неизвестное поле не решает исход
+type truth uint8
+
+const (
+	truthNo truth = iota
+	truthYes
+	truthUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthYes
+	}
+	return truthNo
+}
+
+type queryNode interface {
+	eval(s Subject, near Neighbourhood) truth
+}
+
+type andNode struct{ left, right queryNode }
+type orNode struct{ left, right queryNode }
+type notNode struct{ node queryNode }
+
+func (n andNode) eval(s Subject, near Neighbourhood) truth {
+	left := n.left.eval(s, near)
+	if left == truthNo {
+		return truthNo
+	}
+	right := n.right.eval(s, near)
+	if right == truthNo {
+		return truthNo
+	}
+	if left == truthYes && right == truthYes {
+		return truthYes
+	}
+	return truthUnknown
+}
+
+func (n orNode) eval(s Subject, near Neighbourhood) truth {
+	left := n.left.eval(s, near)
+	if left == truthYes {
+		return truthYes
+	}
+	right := n.right.eval(s, near)
+	if right == truthYes {
+		return truthYes
+	}
+	if left == truthNo && right == truthNo {
+		return truthNo
+	}
+	return truthUnknown
+}
+
+func (n notNode) eval(s Subject, near Neighbourhood) truth {
+	switch n.node.eval(s, near) {
+	case truthYes:
+		return truthNo
+	case truthNo:
+		return truthYes
+	}
+	return truthUnknown
+}
+
+// compareNode — сравнение поля со значением
+type compareNode struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (n compareNode) eval(s Subject, near Neighbourhood) truth {
+	values, known := s.Field(n.field)
+	if !known {
+		return truthUnknown
+	}
+	negate := n.op == "!=" || n.op == "!~"
+	for _, v := range values {
+		if n.match(v) {
+			return truthOf(!negate)
+		}
+	}
+	return truthOf(negate)
+}
+
+func (n compareNode) match(v string) bool {
+	switch n.op {
+	case "=", "!=":
+		if strings.HasSuffix(n.field, "site") {
+			return v == n.value || strings.HasSuffix(v, "/"+n.value)
+		}
+		return v == n.value
+	case "=~", "!~":
+		return n.re.MatchString(v)
+	}
+	x, okX := queryNumber(v)
+	y, okY := queryNumber(n.value)
+	if !okX || !okY {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+// timeNode — пересечение интервала субъекта с интервалом [from, to]
+type timeNode struct {
+	from, to time.Duration
+}
+
+func (n timeNode) eval(s Subject, near Neighbourhood) truth {
+	from, to, known := s.Span()
+	if !known {
+		return truthUnknown
+	}
+	return truthOf(from <= n.to && to >= n.from)
+}
+
+// nearNode — узел не дальше hops рёбер от горутины
+type nearNode struct {
+	goroutine string
+	hops      int
+}
+
+func (n nearNode) eval(s Subject, near Neighbourhood) truth {
+	if near == nil || s.Key() == "" {
+		return truthUnknown
+	}
+	return truthOf(near(n.goroutine, n.hops)[s.Key()])
+}
+
+// queryNumber читает число или длительность (длительность — в наносекундах)
+func queryNumber(v string) (float64, bool) {
+	if x, err := strconv.ParseFloat(v, 64); err == nil {
+		return x, true
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return float64(d), true
+	}
+	return 0, false
+}
+
+// queryFields — поля, известные языку фильтров
+var queryFields = map[string]bool{
+	"kind": true, "time": true, "site": true, "source": true,
+	"goroutine.id": true, "goroutine.func": true, "goroutine.site": true, "goroutine.state": true,
+	"goroutine.wait": true, "goroutine.parent": true,
+	"channel.id": true, "channel.site": true, "channel.cap": true,
+}
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenPunct
+)
+
+type queryToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// queryWord — слово: имя поля, ключевое слово, число, длительность или значение без кавычек
+var queryWord = regexp.MustCompile(`^[A-Za-z0-9_./:+\-]+`)
+
+func lexQuery(expr string) ([]queryToken, error) {
+	var tokens []queryToken
+	for pos := 0; pos < len(expr); {
+		rest := expr[pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n':
+			pos++
+		case rest[0] == '"':
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid query: unterminated string at %d", pos)
+			}
+			value, _ := strconv.Unquote(quoted)
+			tokens = append(tokens, queryToken{kind: tokenString, text: value, pos: pos})
+			pos += len(quoted)
+		case strings.HasPrefix(rest, "=~"), strings.HasPrefix(rest, "!~"), strings.HasPrefix(rest, "!="),
+			strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="):
+			tokens = append(tokens, queryToken{kind: tokenOp, text: rest[:2], pos: pos})
+			pos += 2
+		case rest[0] == '=' || rest[0] == '<' || rest[0] == '>':
+			tokens = append(tokens, queryToken{kind: tokenOp, text: rest[:1], pos: pos})
+			pos++
+		case strings.ContainsRune("()[],", rune(rest[0])):
+			tokens = append(tokens, queryToken{kind: tokenPunct, text: rest[:1], pos: pos})
+			pos++
+		default:
+			word := queryWord.FindString(rest)
+			if word == "" {
+				return nil, fmt.Errorf("invalid query: unexpected %q at %d", rest[:1], pos)
+			}
+			tokens = append(tokens, queryToken{kind: tokenWord, text: word, pos: pos})
+			pos += len(word)
+		}
+	}
+	return append(tokens, queryToken{kind: tokenEOF, text: "end of query", pos: len(expr)}), nil
+}
+
+// queryParser — разбор выражения рекурсивным спуском: or → and → not → сравнение
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// keyword пропускает ключевое слово, если оно следующее
+func (p *queryParser) keyword(words ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokenWord {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(tok.text, w) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *queryParser) expect(kind tokenKind, text string) error {
+	tok := p.next()
+	if tok.kind != kind || text != "" && !strings.EqualFold(tok.text, text) {
+		return fmt.Errorf("invalid query: want %q, got %q at %d", text, tok.text, tok.pos)
+	}
+	return nil
+}
+
+func (p *queryParser) or() (queryNode, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) and() (queryNode, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) not() (queryNode, error) {
+	if p.keyword("not") {
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return p.primary()
+}
+
+func (p *queryParser) primary() (queryNode, error) {
+	if tok := p.peek(); tok.kind == tokenPunct && tok.text == "(" {
+		p.next()
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(tokenPunct, ")")
+	}
+	if p.keyword("near") {
+		return p.near()
+	}
+	if p.keyword("neighbourhood", "neighborhood") {
+		if err := p.expect(tokenWord, "of"); err != nil {
+			return nil, err
+		}
+		return p.near()
+	}
+	return p.compare()
+}
+
+// near разбирает окрестность: goroutine <id> [within <n> [hops]]; по умолчанию — соседние узлы
+func (p *queryParser) near() (queryNode, error) {
+	if err := p.expect(tokenWord, "goroutine"); err != nil {
+		return nil, err
+	}
+	id := p.next()
+	if id.kind != tokenWord && id.kind != tokenString {
+		return nil, fmt.Errorf("invalid query: want goroutine id, got %q at %d", id.text, id.pos)
+	}
+	node := nearNode{goroutine: id.text, hops: 1}
+	if p.keyword("within") {
+		tok := p.next()
+		hops, err := strconv.Atoi(tok.text)
+		if err != nil || hops < 0 {
+			return nil, fmt.Errorf("invalid query: want number of hops, got %q at %d", tok.text, tok.pos)
+		}
+		node.hops = hops
+		p.keyword("hops", "hop")
+	}
+	return node, nil
+}
+
+func (p *queryParser) compare() (queryNode, error) {
+	field := p.next()
+	if field.kind != tokenWord || !queryFields[strings.ToLower(field.text)] {
+		return nil, fmt.Errorf("invalid query: unknown field %q at %d", field.text, field.pos)
+	}
+	name := strings.ToLower(field.text)
+
+	if name == "time" {
+		return p.timeRange()
+	}
+	op := p.next()
+	if op.kind != tokenOp {
+		return nil, fmt.Errorf("invalid query: want operator after %s, got %q at %d", name, op.text, op.pos)
+	}
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, fmt.Errorf("invalid query: want value, got %q at %d", value.text, value.pos)
+	}
+	node := compareNode{field: name, op: op.text, value: value.text}
+	switch op.text {
+	case "=~", "!~":
+		re, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %v", err)
+		}
+		node.re = re
+	case "<", "<=", ">", ">=":
+		if _, ok := queryNumber(value.text); !ok {
+			return nil, fmt.Errorf("invalid query: want number or duration, got %q at %d", value.text, value.pos)
+		}
+	}
+	return node, nil
+}
+
+// timeRange разбирает условие на время: in [от, до] или сравнение с длительностью
+func (p *queryParser) timeRange() (queryNode, error) {
+	if p.keyword("in") {
+		if err := p.expect(tokenPunct, "["); err != nil {
+			return nil, err
+		}
+		from, err := p.duration()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenPunct, ","); err != nil {
+			return nil, err
+		}
+		to, err := p.duration()
+		if err != nil {
+			return nil, err
+		}
+		if from > to {
+			return nil, fmt.Errorf("invalid query: empty time range [%s, %s]", from, to)
+		}
+		return timeNode{from: from, to: to}, p.expect(tokenPunct, "]")
+	}
+
+	op := p.next()
+	d, err := p.duration()
+	if err != nil {
+		return nil, err
+	}
+	const forever = time.Duration(1<<63 - 1)
+	switch op.text {
+	case ">=":
+		return timeNode{from: d, to: forever}, nil
+	case ">":
+		return timeNode{from: d + 1, to: forever}, nil
+	case "<=":
+		return timeNode{from: -forever, to: d}, nil
+	case "<":
+		return timeNode{from: -forever, to: d - 1}, nil
+	}
+	return nil, fmt.Errorf("invalid query: want in, <, <=, > or >= after time, got %q at %d", op.text, op.pos)
+}
+
+func (p *queryParser) duration() (time.Duration, error) {
+	tok := p.next()
+	d, err := time.ParseDuration(tok.text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid query: want duration, got %q at %d", tok.text, tok.pos)
+	}
+	return d, nil
+}
@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Некорректное выражение отклоняется с указанием причины
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "", want: `unknown field "end of query"`},
+		{expr: "goroutine.color = red", want: `unknown field "goroutine.color"`},
+		{expr: "kind send", want: "want operator after kind"},
+		{expr: "kind =", want: "want value"},
+		{expr: "kind = send extra", want: `unexpected "extra"`},
+		{expr: "kind = send & kind = close", want: `unexpected "&"`},
+		{expr: `site = "pipeline.go`, want: "unterminated string"},
+		{expr: "(kind = send", want: `want ")"`},
+		{expr: `goroutine.func =~ "("`, want: "error parsing regexp"},
+		{expr: "channel.cap > many", want: "want number or duration"},
+		{expr: "time = 2s", want: "want in, <, <=, > or >= after time"},
+		{expr: "time in [2x, 5s]", want: "want duration"},
+		{expr: "time in [2s 5s]", want: `want ","`},
+		{expr: "time in [5s, 2s]", want: "empty time range [5s, 2s]"},
+		{expr: "near 17", want: `want "goroutine"`},
+		{expr: "near goroutine 17 within -1 hops", want: "want number of hops"},
+		{expr: "neighbourhood goroutine 17", want: `want "of"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			q, err := ParseQuery(tt.expr)
+			if err == nil {
+				t.Fatalf("выражение разобрано: %v", q)
+			}
+			if !strings.HasPrefix(err.Error(), "invalid query: ") || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ошибка %q, ожидалась %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// testSubject — субъект с заданными полями; поля, которых нет в fields, ему неизвестны
+type testSubject struct {
+	key      string
+	fields   map[string][]string
+	from, to time.Duration
+	timed    bool
+}
+
+func (s testSubject) Key() string {
+	return s.key
+}
+
+func (s testSubject) Field(name string) ([]string, bool) {
+	values, ok := s.fields[name]
+	return values, ok
+}
+
+func (s testSubject) Span() (time.Duration, time.Duration, bool) {
+	return s.from, s.to, s.timed
+}
+
+// Выражение вычисляется в трёхзначной логике: неизвестное поле не отбрасывает субъект, пока
+// исход не решён известными полями
+func TestQueryMatch(t *testing.T) {
+	send := testSubject{fields: map[string][]string{"kind": {"send"}}, from: 3 * time.Second, to: 3 * time.Second, timed: true}
+	worker := testSubject{
+		key: "g2",
+		fields: map[string][]string{
+			"goroutine.func":  {"main.worker"},
+			"goroutine.state": {"running"},
+			"channel.site":    {"/app/pipeline.go:42", "/app/log.go:7"},
+			"channel.cap":     {"10"},
+		},
+		from: time.Second, to: 2 * time.Second, timed: true,
+	}
+	near := func(goroutine string, hops int) map[string]bool {
+		if goroutine == "17" && hops == 2 {
+			return map[string]bool{"17": true, "g2": true}
+		}
+		return map[string]bool{goroutine: true}
+	}
+
+	tests := []struct {
+		expr    string
+		subject testSubject
+		near    Neighbourhood
+		want    bool
+	}{
+		{expr: "kind = send", subject: send, want: true},
+		{expr: "kind = receive", subject: send},
+		{expr: "kind != receive", subject: send, want: true},
+		{expr: "KIND = send AND NOT kind = receive", subject: send, want: true},
+		// неизвестное поле: неопределённость сохраняется в not, and с истиной и or с ложью
+		{expr: "goroutine.state = blocked", subject: send, want: true},
+		{expr: "not goroutine.state = blocked", subject: send, want: true},
+		{expr: "kind = send and goroutine.state = blocked", subject: send, want: true},
+		{expr: "kind = receive or goroutine.state = blocked", subject: send, want: true},
+		// известное поле решает исход вопреки неизвестному
+		{expr: "kind = receive and goroutine.state = blocked", subject: send},
+		{expr: "not (kind = send or goroutine.state = blocked)", subject: send},
+		{expr: "goroutine.func =~ \"^main\\\\.work\"", subject: worker, want: true},
+		{expr: "goroutine.func !~ worker", subject: worker},
+		{expr: "goroutine.func != main.worker or goroutine.state = running", subject: worker, want: true},
+		// места сравниваются по концу пути, поле с несколькими значениями совпадает по любому
+		{expr: `channel.site = "pipeline.go:42"`, subject: worker, want: true},
+		{expr: `channel.site = "line.go:42"`, subject: worker},
+		{expr: `channel.site != "log.go:7"`, subject: worker},
+		{expr: "channel.cap > 9", subject: worker, want: true},
+		{expr: "channel.cap <= 9", subject: worker},
+		{expr: "time in [1500ms, 5s]", subject: worker, want: true},
+		{expr: "time in [2s, 2s]", subject: worker, want: true},
+		{expr: "time in [2001ms, 5s]", subject: worker},
+		{expr: "time > 2s", subject: worker},
+		{expr: "time >= 2s", subject: worker, want: true},
+		{expr: "time < 1s", subject: worker},
+		{expr: "time in [10s, 20s]", subject: testSubject{}, want: true},
+		{expr: "near goroutine 17 within 2 hops", subject: worker, near: near, want: true},
+		{expr: "neighbourhood of goroutine 17", subject: worker, near: near},
+		// окрестность неизвестна в потоке событий и для самих событий
+		{expr: "near goroutine 17", subject: worker, want: true},
+		{expr: "near goroutine 17 within 2 hops", subject: send, near: near, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			q, err := ParseQuery(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.Match(tt.subject, tt.near); got != tt.want {
+				t.Fatalf("Match = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// filterGraph — main (1) запускает worker (2, работает с 1s по 2s) и logger (4s); worker отправляет
+// main в chan_1 в 1.5s, main отправляет logger в chan_2 в 4.5s
+func filterGraph() *GorutineGraph {
+	const start = int64(time.Second)
+	at := func(d time.Duration) string { return strconv.FormatInt(start+int64(d), 10) }
+	return &GorutineGraph{
+		Header: &TraceHeader{Start: at(0)},
+		Gorutines: map[string]Goroutine{
+			"1": {ID: "1", Func: "main.main", File: "/app/main.go:3", TS: at(0), State: StateRunning},
+			"2": {ID: "2", Func: "main.worker", File: "/app/pipeline.go:40", Parent: "1", SpawnSite: "/app/main.go:5",
+				TS: at(time.Second), EndTS: at(2 * time.Second), State: StateReturned},
+			"3": {ID: "3", Func: "main.logger", File: "/app/log.go:3", Parent: "1", SpawnSite: "/app/main.go:6",
+				TS: at(4 * time.Second), State: StateRunning},
+		},
+		Channels: map[string]Channel{
+			"chan_1": {ID: "1", Name: "chan_1", File: "/app/pipeline.go:42", TS: at(500 * time.Millisecond), Cap: "0",
+				Senders: []string{"2"}, Receivers: []string{"1"}},
+			"chan_2": {ID: "2", Name: "chan_2", File: "/app/log.go:7", TS: at(3 * time.Second), Cap: "10",
+				Senders: []string{"1"}, Receivers: []string{"3"}},
+		},
+		Edges: []Edge{
+			{From: "1", To: "2", Label: "spawn", TS: at(time.Second)},
+			{From: "1", To: "3", Label: "spawn", TS: at(4 * time.Second)},
+			{From: "2", To: "chan_1", Label: "send", TS: at(1500 * time.Millisecond)},
+			{From: "chan_1", To: "1", Label: "receive", TS: at(1500 * time.Millisecond)},
+			{From: "1", To: "chan_2", Label: "send", TS: at(4500 * time.Millisecond)},
+			{From: "chan_2", To: "3", Label: "receive", TS: at(4500 * time.Millisecond)},
+		},
+	}
+}
+
+// Фильтр графа оставляет горутины и каналы, прошедшие выражение, и рёбра между ними, время которых
+// проходит условия на time
+func TestGraphFilter(t *testing.T) {
+	tests := []struct {
+		expr       string
+		goroutines []string
+		channels   []string
+		edges      []string
+	}{
+		{
+			// канал проходит фильтр по горутинам, которые с ним работали
+			expr:       "goroutine.func = main.worker",
+			goroutines: []string{"2"},
+			channels:   []string{"chan_1"},
+			edges:      []string{"2 -send-> chan_1"},
+		},
+		{
+			// горутина проходит фильтр по каналам, с которыми работала
+			expr:       `channel.site = "pipeline.go:42"`,
+			goroutines: []string{"1", "2"},
+			channels:   []string{"chan_1"},
+			edges:      []string{"1 -spawn-> 2", "2 -send-> chan_1", "chan_1 -receive-> 1"},
+		},
+		{
+			// рёбра между оставшимися узлами вне интервала отбрасываются
+			expr:       "time in [1s, 2s]",
+			goroutines: []string{"1", "2"},
+			channels:   []string{"chan_1", "chan_2"},
+			edges:      []string{"1 -spawn-> 2", "2 -send-> chan_1", "chan_1 -receive-> 1"},
+		},
+		{
+			expr:       "time > 5s",
+			goroutines: []string{"1", "3"},
+			channels:   []string{"chan_1", "chan_2"},
+			edges:      []string{},
+		},
+		{
+			expr:       "near goroutine 2",
+			goroutines: []string{"1", "2"},
+			channels:   []string{"chan_1"},
+			edges:      []string{"1 -spawn-> 2", "2 -send-> chan_1", "chan_1 -receive-> 1"},
+		},
+		{
+			expr:       "kind = receive and goroutine.state = running",
+			goroutines: []string{"1", "3"},
+			channels:   []string{"chan_1", "chan_2"},
+			edges:      []string{"1 -send-> chan_2", "1 -spawn-> 3", "chan_1 -receive-> 1", "chan_2 -receive-> 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			q, err := ParseQuery(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filtered := filterGraph().Filter(q)
+
+			goroutines := make([]string, 0, len(filtered.Gorutines))
+			for id := range filtered.Gorutines {
+				goroutines = append(goroutines, id)
+			}
+			channels := make([]string, 0, len(filtered.Channels))
+			for name := range filtered.Channels {
+				channels = append(channels, name)
+			}
+			edges := make([]string, 0, len(filtered.Edges))
+			for _, e := range filtered.Edges {
+				edges = append(edges, e.From+" -"+e.Label+"-> "+e.To)
+			}
+			for _, list := range [][]string{goroutines, channels, edges} {
+				sort.Strings(list)
+			}
+			if !reflect.DeepEqual(goroutines, tt.goroutines) {
+				t.Errorf("горутины %v, ожидались %v", goroutines, tt.goroutines)
+			}
+			if !reflect.DeepEqual(channels, tt.channels) {
+				t.Errorf("каналы %v, ожидались %v", channels, tt.channels)
+			}
+			if !reflect.DeepEqual(edges, tt.edges) {
+				t.Errorf("рёбра %v, ожидались %v", edges, tt.edges)
+			}
+		})
+	}
+}
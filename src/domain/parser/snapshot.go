@@ -0,0 +1,31 @@
+package parser
+
+import (
+	"maps"
+	"slices"
+)
+
+// Snapshot возвращает копию графа, которую можно читать, пока исходный граф достраивается по
+// поступающим событиям: карты копируются, срезы обрезаются по текущей длине, поэтому дописывание
+// в исходный граф копию не затрагивает. Горутины, каналы и операции копируются по значению;
+// последние операции горутин не изменяются, а заменяются, их можно разделять
+func (g *GorutineGraph) Snapshot() *GorutineGraph {
+	snapshot := *g
+	snapshot.Gorutines = maps.Clone(g.Gorutines)
+	snapshot.Channels = make(map[string]Channel, len(g.Channels))
+	for name, ch := range g.Channels {
+		ch.Senders = slices.Clip(ch.Senders)
+		ch.Receivers = slices.Clip(ch.Receivers)
+		snapshot.Channels[name] = ch
+	}
+	snapshot.Edges = slices.Clip(g.Edges)
+	snapshot.Incidents = slices.Clip(g.Incidents)
+	snapshot.Diagnostics = slices.Clip(g.Diagnostics)
+	snapshot.Sources = slices.Clone(g.Sources)
+	snapshot.Blocking = BlockingProfile{
+		ByChannel:   maps.Clone(g.Blocking.ByChannel),
+		BySite:      maps.Clone(g.Blocking.BySite),
+		ByGoroutine: maps.Clone(g.Blocking.ByGoroutine),
+	}
+	return &snapshot
+}
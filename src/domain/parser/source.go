@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TraceSource — одна из объединённых трасс: её заголовок, завершение программы и сдвиг часов
+type TraceSource struct {
+	// Name — пространство имён источника: горутины и каналы источника получают ключи "<Name>/<id>"
+	Name     string
+	Header   *TraceHeader
+	Shutdown *Shutdown
+	// Offset — сдвиг, прибавленный ко всем временным меткам источника при выравнивании часов
+	Offset time.Duration
+}
+
+// Namespaced возвращает ключ горутины или канала в пространстве имён источника; пустое пространство
+// имён — трасса не объединялась
+func Namespaced(namespace string, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + "/" + key
+}
+
+// splitNamespace разделяет ключ на пространство имён источника и ключ внутри источника
+func splitNamespace(key string) (namespace string, local string) {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// SourcesReport — отчёт об объединённых трассах
+type SourcesReport struct {
+	Sources []TraceSource
+}
+
+// SourcesReport строит отчёт об источниках; для трассы из одного источника отчёт пуст
+func (g *GorutineGraph) SourcesReport() SourcesReport {
+	return SourcesReport{Sources: g.Sources}
+}
+
+func (r SourcesReport) String() string {
+	if len(r.Sources) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("объединено трасс: %d\n", len(r.Sources)))
+	for _, s := range r.Sources {
+		sb.WriteString(fmt.Sprintf("  %s: сдвиг часов %s", s.Name, s.Offset))
+		if s.Shutdown == nil {
+			sb.WriteString(", завершение программы не записано")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StackGroup — горутины с одинаковым стеком и причиной ожидания
+type StackGroup struct {
+	WaitReason string
+	// Stack — стек без аргументов вызовов и смещений: по нему сравниваются горутины
+	Stack      string
+	Goroutines []string
+	// MaxWait — наибольшее время ожидания в группе
+	MaxWait time.Duration
+}
+
+// StackGroupReport — горутины из дампа, сгруппированные по стекам; самые многочисленные группы первыми
+type StackGroupReport struct {
+	Groups []StackGroup
+}
+
+var (
+	// stackArgs — аргументы вызова в строке функции: main.worker(0xc000012345, 0x1)
+	stackArgs = regexp.MustCompile(`\([^()]*\)$`)
+	// stackOffset — смещение в строке места и регистры кадра: /app/main.go:12 +0x25 fp=... sp=... pc=...
+	stackOffset = regexp.MustCompile(` \+0x[0-9a-f]+.*$`)
+)
+
+// StackGroups группирует горутины, у которых известна причина ожидания (горутины из дампа),
+// по одинаковым стекам
+func (g *GorutineGraph) StackGroups() StackGroupReport {
+	groups := make(map[string]*StackGroup)
+	var keys []string
+	for _, id := range sortedIDs(g.Gorutines) {
+		gr := g.Gorutines[id]
+		if gr.WaitReason == "" {
+			continue
+		}
+		stack := normalizeStack(gr.Stack)
+		key := gr.WaitReason + "\n" + stack
+		group, ok := groups[key]
+		if !ok {
+			group = &StackGroup{WaitReason: gr.WaitReason, Stack: stack}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.Goroutines = append(group.Goroutines, id)
+		if gr.Waiting > group.MaxWait {
+			group.MaxWait = gr.Waiting
+		}
+	}
+
+	report := StackGroupReport{}
+	for _, key := range keys {
+		report.Groups = append(report.Groups, *groups[key])
+	}
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		return len(report.Groups[i].Goroutines) > len(report.Groups[j].Goroutines)
+	})
+	return report
+}
+
+func (r StackGroupReport) String() string {
+	if len(r.Groups) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("групп одинаковых стеков: %d\n", len(r.Groups)))
+	for _, group := range r.Groups {
+		title := group.WaitReason
+		if group.MaxWait > 0 {
+			title += fmt.Sprintf(", до %s", group.MaxWait)
+		}
+		sb.WriteString(fmt.Sprintf("  горутин: %d [%s]: %s\n", len(group.Goroutines), title, strings.Join(group.Goroutines, ", ")))
+		for _, line := range strings.Split(group.Stack, "\n") {
+			if line != "" {
+				sb.WriteString("    " + line + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// normalizeStack убирает из стека дампа значения аргументов и смещения, отличающиеся у горутин
+// с одинаковым путём выполнения
+func normalizeStack(stack string) string {
+	lines := strings.Split(stack, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		line = stackOffset.ReplaceAllString(line, "")
+		lines[i] = stackArgs.ReplaceAllString(line, "(...)")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// waitTitle описывает ожидание горутины из дампа: причина и время
+func waitTitle(gr Goroutine) string {
+	if gr.Waiting > 0 {
+		return fmt.Sprintf("%s, %s", gr.WaitReason, gr.Waiting)
+	}
+	return gr.WaitReason
+}
@@ -0,0 +1,29 @@
+package parser
+
+// Summary — итог анализа трассы в числах
+type Summary struct {
+	Goroutines int
+	Channels   int
+	Leaks      int
+	// Unfinished — горутины без завершения в трассе, закончившейся без завершения программы
+	Unfinished  int
+	Blocked     int
+	Deadlock    bool
+	Incidents   int
+	Diagnostics int
+}
+
+// Summary подводит итог анализа графа: утечки и взаимные блокировки ищутся по всему графу
+func (g *GorutineGraph) Summary() Summary {
+	deadlocks, leaks := g.Deadlocks(), g.Leaks()
+	return Summary{
+		Goroutines:  len(g.Gorutines),
+		Channels:    len(g.Channels),
+		Leaks:       len(leaks.Leaked),
+		Unfinished:  len(leaks.Unfinished),
+		Blocked:     len(deadlocks.Blocked),
+		Deadlock:    deadlocks.Found(),
+		Incidents:   len(g.Incidents),
+		Diagnostics: len(g.Diagnostics) + g.OmittedDiagnostics,
+	}
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"slices"
+	"sort"
+)
+
+// Trim ограничивает граф, который достраивается по событиям живой трассы: оставляет не больше
+// finished завершённых горутин (вытесняются давно завершённые) и не больше edges последних рёбер.
+// Вытесненные горутины удаляются и из участников каналов, профиля ожидания и рёбер. Каналы остаются:
+// по их счётчикам определяется, заблокированы ли операции живых горутин. Возвращает число вытесненных
+// горутин. Срезы графа заменяются новыми, поэтому снимки, сделанные до Trim, не меняются
+func (g *GorutineGraph) Trim(edges, finished int) int {
+	var ended []Goroutine
+	for _, gr := range g.Gorutines {
+		if gr.State == StateReturned || gr.State == StatePanicked || gr.State == StateGoexit {
+			ended = append(ended, gr)
+		}
+	}
+	removed := make(map[string]bool)
+	if len(ended) > finished {
+		sort.Slice(ended, func(i, j int) bool {
+			if ended[i].EndTS != ended[j].EndTS {
+				return tsLess(ended[i].EndTS, ended[j].EndTS)
+			}
+			return ended[i].ID < ended[j].ID
+		})
+		for _, gr := range ended[:len(ended)-finished] {
+			removed[gr.ID] = true
+			delete(g.Gorutines, gr.ID)
+			delete(g.Blocking.ByGoroutine, gr.ID)
+		}
+		for name, ch := range g.Channels {
+			senders := slices.DeleteFunc(slices.Clone(ch.Senders), func(id string) bool { return removed[id] })
+			receivers := slices.DeleteFunc(slices.Clone(ch.Receivers), func(id string) bool { return removed[id] })
+			if len(senders) != len(ch.Senders) || len(receivers) != len(ch.Receivers) {
+				ch.Senders, ch.Receivers = senders, receivers
+				g.Channels[name] = ch
+			}
+		}
+	}
+
+	if len(removed) == 0 && len(g.Edges) <= edges {
+		return 0
+	}
+	kept := make([]Edge, 0, min(len(g.Edges), edges))
+	for _, e := range g.Edges[max(len(g.Edges)-edges, 0):] {
+		if !removed[e.From] && !removed[e.To] {
+			kept = append(kept, e)
+		}
+	}
+	g.Edges = kept
+	return len(removed)
+}
@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"context"
+	"fmt"
 	"gtrace/src/application/commands"
 	"gtrace/src/common/config"
 	"gtrace/src/domain/cli"
@@ -12,13 +14,46 @@ func (c Cli) GoTrace(r *cli.Request) error {
 		Cleared:    false,
 		TargetPath: comm.TargetProject,
 		OutputPath: comm.OutputProject,
+		Strict:     comm.Strict,
+		Filter:     comm.Filter,
+		Live:       comm.Live,
 	}
 
-	_, err := c.app.Commands.GoTraceCli.Handle(r.Ctx, command)
-	if err != nil {
-		return err
+	ctx := r.Ctx
+	if comm.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, comm.Timeout)
+		defer cancel()
+	}
+	result, err := c.app.Commands.GoTraceCli.Handle(ctx, command)
+	printReport(result)
+	if res, ok := result.(commands.TraceResult); ok && res.TraceID != "" {
+		fmt.Printf("\nТрасса сохранена: %s (gtracer runs show %s)\n", res.TraceID, res.TraceID)
+	}
+	return err
+}
+
+func (c Cli) AnalyzeTrace(r *cli.Request) error {
+	comm := r.Data.(config.Analyze)
+	command := commands.AnalyzeTraceCommand{
+		TracePaths: comm.TracePaths,
+		Align:      comm.Align,
+		Offsets:    comm.Offsets,
+		Strict:     comm.Strict,
+		Filter:     comm.Filter,
+		DotPath:    comm.DotPath,
+		Events:     comm.Events,
 	}
 
-	return nil
+	result, err := c.app.Commands.AnalyzeTrace.Handle(r.Ctx, command)
+	printReport(result)
+	return err
+}
 
+// printReport выводит отчёт команды; отчёт печатается и тогда, когда команда нашла утечку или
+// взаимную блокировку и вернула ошибку
+func printReport(result any) {
+	if res, ok := result.(commands.TraceResult); ok {
+		fmt.Print(res.Report)
+	}
 }
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"gtrace/src/application/commands"
+	"gtrace/src/application/queries"
+	"gtrace/src/common/config"
+	"gtrace/src/domain/cli"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Runs выполняет действие над хранилищем трасс: list, show, tag или delete
+func (c Cli) Runs(r *cli.Request) error {
+	comm := r.Data.(config.Runs)
+	switch comm.Action {
+	case "list":
+		return c.listRuns(r, comm)
+	case "show":
+		return c.showRun(r, comm)
+	case "tag":
+		command := commands.TagTraceCommand{TraceID: comm.IDs[0], Add: comm.Tags}
+		if comm.Remove {
+			command = commands.TagTraceCommand{TraceID: comm.IDs[0], Remove: comm.Tags}
+		}
+		tags, err := c.app.Commands.TagTrace.Handle(r.Ctx, command)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", comm.IDs[0], strings.Join(tags.([]string), ", "))
+		return nil
+	case "delete":
+		var errs []error
+		for _, id := range comm.IDs {
+			if _, err := c.app.Commands.DeleteTrace.Handle(r.Ctx, commands.DeleteTraceCommand{TraceID: id}); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Printf("%s удалена\n", id)
+		}
+		return errors.Join(errs...)
+	}
+	return fmt.Errorf("unknown runs action %q", comm.Action)
+}
+
+func (c Cli) listRuns(r *cli.Request, comm config.Runs) error {
+	traces, err := c.app.Queries.ListTraces.Handle(r.Ctx, queries.ListTracesQuery{Tag: comm.Tag})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tСОЗДАНА\tТИП\tДЛИТЕЛЬНОСТЬ\tГОРУТИНЫ\tУТЕЧКИ\tБЛОКИРОВКА\tТЕГИ\tПРОЕКТ")
+	for _, trace := range traces {
+		deadlock := "-"
+		if trace.Summary.Deadlock {
+			deadlock = fmt.Sprintf("да (%d)", trace.Summary.Blocked)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			trace.ID, trace.Created.Format(time.DateTime), trace.Kind, formatDuration(trace.Duration),
+			trace.Summary.Goroutines, trace.Summary.Leaks, deadlock, strings.Join(trace.Tags, ","), trace.Project)
+	}
+	return w.Flush()
+}
+
+// showRun выводит метаданные трассы и отчёт по ней; с DotPath — записывает граф в формате DOT
+func (c Cli) showRun(r *cli.Request, comm config.Runs) error {
+	id := comm.IDs[0]
+	trace, err := c.app.Queries.GetTrace.Handle(r.Ctx, queries.GetTraceQuery{TraceID: id})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Трасса %s (%s)\n", trace.ID, trace.Kind)
+	fmt.Printf("  Создана:      %s\n", trace.Created.Format(time.DateTime))
+	fmt.Printf("  Проект:       %s\n", trace.Project)
+	if trace.Commit != "" {
+		fmt.Printf("  Коммит:       %s\n", trace.Commit)
+	}
+	if len(trace.Args) > 0 {
+		fmt.Printf("  Аргументы:    %s\n", strings.Join(trace.Args, " "))
+	}
+	fmt.Printf("  Длительность: %s\n", formatDuration(trace.Duration))
+	if len(trace.Sources) > 0 {
+		fmt.Printf("  Источники:    %s\n", strings.Join(trace.Sources, ", "))
+	}
+	if len(trace.Tags) > 0 {
+		fmt.Printf("  Теги:         %s\n", strings.Join(trace.Tags, ", "))
+	}
+	fmt.Printf("  Размер:       %d байт\n\n", trace.Size)
+
+	report, err := c.app.Queries.TraceReport.Handle(r.Ctx, queries.TraceReportQuery{TraceID: id, Filter: comm.Filter})
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	if comm.DotPath != "" {
+		graph, err := c.app.Queries.TraceGraph.Handle(r.Ctx, queries.TraceGraphQuery{TraceID: id, Filter: comm.Filter})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(comm.DotPath, []byte(graph.ToDot()), 0o644); err != nil {
+			return fmt.Errorf("запись графа: %w", err)
+		}
+	}
+	return nil
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
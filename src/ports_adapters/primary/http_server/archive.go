@@ -0,0 +1,226 @@
+package http_server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxUpload — наибольший размер загружаемого архива проекта
+	maxUpload = 256 << 20
+	// maxExtracted и maxEntries — наибольший суммарный размер файлов и число записей распакованного
+	// архива: сжатый архив в пределах maxUpload может распаковываться в гигабайты
+	maxExtracted = 1 << 30
+	maxEntries   = 20_000
+)
+
+var (
+	errArchiveFormat = errors.New("unsupported archive format: expected zip, tar or tar.gz")
+	// errArchiveLimit — архив превышает maxExtracted или maxEntries
+	errArchiveLimit = errors.New("archive is too large to extract")
+)
+
+// extractBudget — сколько записей и байт ещё можно распаковать
+type extractBudget struct {
+	entries int
+	size    int64
+}
+
+// entry учитывает очередную запись архива
+func (b *extractBudget) entry() error {
+	if b.entries--; b.entries < 0 {
+		return fmt.Errorf("%w: more than %d entries", errArchiveLimit, maxEntries)
+	}
+	return nil
+}
+
+// extractArchive распаковывает архив проекта (zip, tar или tar.gz — формат определяется по содержимому)
+// в каталог dir и возвращает корень проекта: dir или единственный каталог верхнего уровня, если go.mod
+// лежит в нём. Пути, выходящие за dir, и ссылки отклоняются, распаковка больше maxExtracted байт
+// или maxEntries записей прерывается с errArchiveLimit
+func extractArchive(r io.Reader, dir string) (string, error) {
+	// zip читается с конца, поэтому архив целиком сохраняется во временный файл
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return "", fmt.Errorf("upload: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	root := filepath.Join(dir, "project")
+	budget := &extractBudget{entries: maxEntries, size: maxExtracted}
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(tmp, head)
+	head = head[:n]
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		err = extractZip(tmp, size, root, budget)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bufio.NewReader(tmp)); err == nil {
+			err = extractTar(gz, root, budget)
+			gz.Close()
+		}
+	default:
+		err = extractTar(tmp, root, budget)
+	}
+	if err != nil {
+		return "", err
+	}
+	return projectRoot(root)
+}
+
+func extractZip(r io.ReaderAt, size int64, root string, budget *extractBudget) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	if len(archive.File) > budget.entries {
+		return fmt.Errorf("%w: more than %d entries", errArchiveLimit, maxEntries)
+	}
+	for _, f := range archive.File {
+		if err := budget.entry(); err != nil {
+			return err
+		}
+		path, err := archivePath(root, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			// заявленный размер проверяется заранее, фактический ограничивает writeFile
+			if f.UncompressedSize64 > uint64(budget.size) {
+				return fmt.Errorf("%w: more than %d bytes", errArchiveLimit, maxExtracted)
+			}
+			src, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("invalid zip archive: %w", err)
+			}
+			err = writeFile(path, src, mode, budget)
+			src.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported archive entry %q: only files and directories are allowed", f.Name)
+		}
+	}
+	return nil
+}
+
+func extractTar(r io.Reader, root string, budget *extractBudget) error {
+	archive := tar.NewReader(r)
+	entries := 0
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if entries == 0 {
+				return errArchiveFormat
+			}
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		entries++
+		if err := budget.entry(); err != nil {
+			return err
+		}
+		path, err := archivePath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(path, archive, hdr.FileInfo().Mode(), budget); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// глобальный заголовок pax (git archive) — не файл
+		default:
+			return fmt.Errorf("unsupported archive entry %q: only files and directories are allowed", hdr.Name)
+		}
+	}
+	if entries == 0 {
+		return errArchiveFormat
+	}
+	return nil
+}
+
+// archivePath возвращает путь записи архива внутри root; абсолютные пути и выход за root — ошибка
+func archivePath(root, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid archive entry %q: path escapes project directory", name)
+	}
+	return filepath.Join(root, clean), nil
+}
+
+// writeFile записывает файл записи архива; размер файла вычитается из budget, и запись,
+// которая его превышает, прерывается
+func writeFile(path string, r io.Reader, mode os.FileMode, budget *extractBudget) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, budget.size+1))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if budget.size -= n; budget.size < 0 {
+		f.Close()
+		return fmt.Errorf("%w: more than %d bytes", errArchiveLimit, maxExtracted)
+	}
+	return f.Close()
+}
+
+// projectRoot возвращает каталог с go.mod: root или его единственный подкаталог (архивы
+// часто содержат каталог проекта целиком)
+func projectRoot(root string) (string, error) {
+	if _, err := os.Stat(filepath.Join(root, "go.mod")); err == nil {
+		return root, nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", errors.New("archive is empty")
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		nested := filepath.Join(root, entries[0].Name())
+		if _, err := os.Stat(filepath.Join(nested, "go.mod")); err == nil {
+			return nested, nil
+		}
+	}
+	return "", errors.New("go.mod not found in archive")
+}
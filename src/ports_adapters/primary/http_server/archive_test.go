@@ -0,0 +1,188 @@
+package http_server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry — запись тестового архива: файл, каталог (имя с / на конце) или символическая ссылка
+type archiveEntry struct {
+	name string
+	body string
+	link string
+}
+
+func tarArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0o755, 0
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		if e.link != "" {
+			hdr.SetMode(fs.ModeSymlink | 0o777)
+			body = e.link
+		}
+		f, err := w.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Архив распаковывается в каталог задания; пути за его пределами и ссылки отклоняются
+func TestExtractArchive(t *testing.T) {
+	gomod := archiveEntry{name: "app/go.mod", body: "module app\n"}
+	main := archiveEntry{name: "app/main.go", body: "package main\n"}
+	tests := []struct {
+		name    string
+		archive func(*testing.T, ...archiveEntry) []byte
+		entries []archiveEntry
+		err     string
+	}{
+		{name: "tar", archive: tarArchive, entries: []archiveEntry{{name: "app/"}, gomod, main}},
+		{name: "zip", archive: zipArchive, entries: []archiveEntry{gomod, main}},
+		{name: "выход через .. в tar", archive: tarArchive, entries: []archiveEntry{gomod, {name: "app/../../evil.go", body: "x"}}, err: "escapes project directory"},
+		{name: "выход через .. в zip", archive: zipArchive, entries: []archiveEntry{gomod, {name: "../evil.go", body: "x"}}, err: "escapes project directory"},
+		{name: "абсолютный путь", archive: tarArchive, entries: []archiveEntry{{name: "/etc/evil", body: "x"}}, err: "escapes project directory"},
+		{name: "ссылка в tar", archive: tarArchive, entries: []archiveEntry{gomod, {name: "app/etc", link: "/etc"}}, err: "only files and directories"},
+		{name: "ссылка в zip", archive: zipArchive, entries: []archiveEntry{gomod, {name: "app/etc", link: "/etc"}}, err: "only files and directories"},
+		{name: "без go.mod", archive: tarArchive, entries: []archiveEntry{main}, err: "go.mod not found"},
+		{name: "не архив", archive: func(*testing.T, ...archiveEntry) []byte { return []byte("plain text") }, err: errArchiveFormat.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root, err := extractArchive(bytes.NewReader(tt.archive(t, tt.entries...)), dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.err)
+				}
+				if _, err := os.Lstat(filepath.Join(filepath.Dir(dir), "evil.go")); err == nil {
+					t.Fatal("файл записан за пределами каталога задания")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != filepath.Join(dir, "project", "app") {
+				t.Fatalf("корень проекта %s", root)
+			}
+			if data, err := os.ReadFile(filepath.Join(root, "main.go")); err != nil || string(data) != main.body {
+				t.Fatalf("main.go: %q, %v", data, err)
+			}
+		})
+	}
+}
+
+// Распаковка прерывается, когда записи или их суммарный размер превышают бюджет; размер файла
+// ограничивается при чтении, а не только по заголовку записи, который может его занижать
+func TestExtractLimits(t *testing.T) {
+	big := archiveEntry{name: "big.txt", body: strings.Repeat("0", 4096)}
+	small := archiveEntry{name: "small.txt", body: "0"}
+	tests := []struct {
+		name    string
+		extract func(t *testing.T, root string, budget *extractBudget) error
+		budget  extractBudget
+	}{
+		{
+			name: "размер tar",
+			extract: func(t *testing.T, root string, budget *extractBudget) error {
+				return extractTar(bytes.NewReader(tarArchive(t, small, big)), root, budget)
+			},
+			budget: extractBudget{entries: 10, size: 1024},
+		},
+		{
+			name: "размер zip",
+			extract: func(t *testing.T, root string, budget *extractBudget) error {
+				data := zipArchive(t, small, big)
+				return extractZip(bytes.NewReader(data), int64(len(data)), root, budget)
+			},
+			budget: extractBudget{entries: 10, size: 1024},
+		},
+		{
+			name: "чтение сверх бюджета",
+			extract: func(t *testing.T, root string, budget *extractBudget) error {
+				data := zipArchive(t, big)
+				archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				src, err := archive.File[0].Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer src.Close()
+				return writeFile(filepath.Join(root, big.name), src, 0o644, budget)
+			},
+			budget: extractBudget{entries: 10, size: 1024},
+		},
+		{
+			name: "записи tar",
+			extract: func(t *testing.T, root string, budget *extractBudget) error {
+				return extractTar(bytes.NewReader(tarArchive(t, small, small, small)), root, budget)
+			},
+			budget: extractBudget{entries: 2, size: 1024},
+		},
+		{
+			name: "записи zip",
+			extract: func(t *testing.T, root string, budget *extractBudget) error {
+				data := zipArchive(t, small, small, small)
+				return extractZip(bytes.NewReader(data), int64(len(data)), root, budget)
+			},
+			budget: extractBudget{entries: 2, size: 1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			err := tt.extract(t, root, &tt.budget)
+			if !errors.Is(err, errArchiveLimit) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, errArchiveLimit)
+			}
+			if info, err := os.Stat(filepath.Join(root, big.name)); err == nil && info.Size() > 1025 {
+				t.Fatalf("записано %d байт сверх бюджета", info.Size())
+			}
+		})
+	}
+}
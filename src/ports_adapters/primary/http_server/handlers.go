@@ -0,0 +1,63 @@
+package http_server
+
+import (
+	"encoding/json"
+	"fmt"
+	domain "gtrace/src/domain/parser"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// summaryView — итог анализа трассы в ответах API
+type summaryView struct {
+	Goroutines  int  `json:"goroutines"`
+	Channels    int  `json:"channels"`
+	Leaks       int  `json:"leaks"`
+	Unfinished  int  `json:"unfinished"`
+	Blocked     int  `json:"blocked"`
+	Deadlock    bool `json:"deadlock"`
+	Incidents   int  `json:"incidents"`
+	Diagnostics int  `json:"diagnostics"`
+}
+
+func newSummaryView(summary domain.Summary) *summaryView {
+	return &summaryView{
+		Goroutines:  summary.Goroutines,
+		Channels:    summary.Channels,
+		Leaks:       summary.Leaks,
+		Unfinished:  summary.Unfinished,
+		Blocked:     summary.Blocked,
+		Deadlock:    summary.Deadlock,
+		Incidents:   summary.Incidents,
+		Diagnostics: summary.Diagnostics,
+	}
+}
+
+// writeGraph пишет граф в формате из параметра format: json (по умолчанию) или dot
+func writeGraph(w http.ResponseWriter, r *http.Request, graph *domain.GorutineGraph) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, graph)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		io.WriteString(w, graph.ToDot())
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q: expected json or dot", format))
+	}
+}
+
+func formBool(value string) bool {
+	b, _ := strconv.ParseBool(value)
+	return b
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,293 @@
+package http_server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gtrace/src/application/commands"
+	"gtrace/src/application/jobs"
+	domain "gtrace/src/domain/parser"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jobRequest — задание для проекта по локальному пути сервера
+type jobRequest struct {
+	Path   string `json:"path"`
+	Strict bool   `json:"strict"`
+	Filter string `json:"filter"`
+}
+
+// jobView — задание в ответах API
+type jobView struct {
+	ID        string       `json:"id"`
+	State     jobs.State   `json:"state"`
+	Source    string       `json:"source"`
+	Strict    bool         `json:"strict,omitempty"`
+	Filter    string       `json:"filter,omitempty"`
+	Cancelled bool         `json:"cancelled,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Created   time.Time    `json:"created"`
+	Started   *time.Time   `json:"started,omitempty"`
+	Finished  *time.Time   `json:"finished,omitempty"`
+	Summary   *summaryView `json:"summary,omitempty"`
+	// TraceID — трасса задания в хранилище (/traces/{id})
+	TraceID string `json:"trace_id,omitempty"`
+	Report  string `json:"report,omitempty"`
+}
+
+// createJob принимает проект одним из способов:
+//   - multipart/form-data: архив в поле project (или локальный путь в поле path), поля strict и filter;
+//   - application/json: {"path": ..., "strict": ..., "filter": ...};
+//   - иначе тело запроса — архив (zip, tar, tar.gz), strict и filter — параметры запроса.
+//
+// Локальный путь принимается, только если он внутри каталога проектов сервера (иначе 403)
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		req     jobRequest
+		archive io.Reader
+		source  string
+	)
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid form: %w", err))
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		req = jobRequest{Path: r.FormValue("path"), Strict: formBool(r.FormValue("strict")), Filter: r.FormValue("filter")}
+		if file, header, err := r.FormFile("project"); err == nil {
+			defer file.Close()
+			archive, source = file, header.Filename
+		}
+	default:
+		req = jobRequest{Strict: formBool(r.URL.Query().Get("strict")), Filter: r.URL.Query().Get("filter")}
+		archive, source = r.Body, "upload"
+	}
+
+	if req.Filter != "" {
+		if _, err := domain.ParseQuery(req.Filter); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("filter: %w", err))
+			return
+		}
+	}
+	var target string
+	if archive == nil {
+		path, err := s.projectPath(req.Path)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errLocalPath) {
+				status = http.StatusForbidden
+			}
+			writeError(w, status, err)
+			return
+		}
+		target, source = path, req.Path
+	}
+
+	// рабочий каталог задания: распакованный архив и инструментированная копия проекта
+	dir, err := os.MkdirTemp("", "gtrace-job-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if archive != nil {
+		if target, err = extractArchive(archive, dir); err != nil {
+			os.RemoveAll(dir)
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) || errors.Is(err, errArchiveLimit) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeError(w, status, err)
+			return
+		}
+	}
+
+	// задание не зависит от запроса: оно выполняется, пока его не отменят или не истечёт таймаут
+	job, err := s.App.Jobs.Submit(context.Background(), jobs.Request{
+		Command: commands.TraceCommand{
+			TargetPath: target,
+			OutputPath: filepath.Join(dir, "output"),
+			Strict:     req.Strict,
+			Filter:     req.Filter,
+			Project:    source,
+		},
+		Source: source,
+		Finish: func(job jobs.Job) {
+			if err := os.RemoveAll(dir); err != nil {
+				s.logger.Warn("Не удалось удалить рабочий каталог задания", "job", job.ID, "error", err)
+			}
+		},
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, newJobView(job, false))
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	list := s.App.Jobs.List()
+	views := make([]jobView, 0, len(list))
+	for _, job := range list {
+		views = append(views, newJobView(job, false))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.App.Jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newJobView(job, true))
+}
+
+// cancelJob отменяет задание: программа останавливается вместе со всей группой процессов,
+// записанная часть трассы разбирается
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.App.Jobs.Cancel(id); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	job, err := s.App.Jobs.Get(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newJobView(job, false))
+}
+
+// getJobGraph возвращает граф трассы задания: format=json (по умолчанию) или dot. Параметр filter
+// задаёт фильтр подграфа вместо фильтра задания
+func (s *Server) getJobGraph(w http.ResponseWriter, r *http.Request) {
+	job, err := s.App.Jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	if job.Result == nil {
+		if job.State == jobs.StateFailed {
+			writeError(w, http.StatusConflict, fmt.Errorf("job failed: %s", job.Error))
+			return
+		}
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", job.State))
+		return
+	}
+
+	expr := job.Command.Filter
+	if r.URL.Query().Has("filter") {
+		expr = r.URL.Query().Get("filter")
+	}
+	graph := job.Result.Graph
+	if expr != "" {
+		query, err := domain.ParseQuery(expr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("filter: %w", err))
+			return
+		}
+		graph = graph.Filter(query)
+	}
+	writeGraph(w, r, graph)
+}
+
+func newJobView(job jobs.Job, report bool) jobView {
+	view := jobView{
+		ID:        job.ID,
+		State:     job.State,
+		Source:    job.Source,
+		Strict:    job.Command.Strict,
+		Filter:    job.Command.Filter,
+		Cancelled: job.Cancelled,
+		Error:     job.Error,
+		Created:   job.Created,
+	}
+	if !job.Started.IsZero() {
+		view.Started = &job.Started
+	}
+	if !job.Finished.IsZero() {
+		view.Finished = &job.Finished
+	}
+	if res := job.Result; res != nil {
+		view.Summary = newSummaryView(res.Graph.Summary())
+		view.TraceID = res.TraceID
+		if report {
+			view.Report = res.Report.String()
+		}
+	}
+	return view
+}
+
+// errLocalPath — локальный путь проекта запрещён настройками сервера
+var errLocalPath = errors.New("local project path is not allowed")
+
+// projectPath проверяет локальный путь проекта: абсолютный путь к каталогу с go.mod внутри
+// каталога проектов сервера, в том числе после раскрытия символических ссылок. Возвращает
+// раскрытый путь: задание работает с ним, а не с исходным
+func (s *Server) projectPath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("project is required: upload an archive or pass a local path")
+	}
+	if s.projects == "" {
+		return "", fmt.Errorf("%w: upload the project as an archive", errLocalPath)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("project path %q must be absolute", path)
+	}
+	root, err := filepath.EvalSymlinks(s.projects)
+	if err != nil {
+		return "", fmt.Errorf("projects directory: %w", err)
+	}
+	// путь вне каталога проектов отклоняется до обращения к файловой системе
+	if !withinDir(root, filepath.Clean(path)) && !withinDir(s.projects, filepath.Clean(path)) {
+		return "", fmt.Errorf("%w: %q is outside the projects directory", errLocalPath, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("project path %q not found", path)
+	}
+	if !withinDir(root, resolved) {
+		return "", fmt.Errorf("%w: %q is outside the projects directory", errLocalPath, path)
+	}
+	if _, err := os.Stat(filepath.Join(resolved, "go.mod")); err != nil {
+		return "", fmt.Errorf("go.mod not found in %q", path)
+	}
+	return resolved, nil
+}
+
+// withinDir сообщает, лежит ли path в каталоге dir или совпадает с ним
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writeJobError отвечает ошибкой очереди: 404 — задания нет, 409 — задание уже завершено
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, jobs.ErrJobFinished):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,150 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Локальный путь проекта принимается только внутри каталога проектов, в том числе после
+// раскрытия символических ссылок; без каталога проектов принимаются только архивы
+func TestProjectPath(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	for _, dir := range []string{filepath.Join(root, "app"), filepath.Join(root, "empty"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{filepath.Join(root, "app"), outside} {
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module app\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		projects  string
+		path      string
+		forbidden bool
+		ok        bool
+	}{
+		{name: "внутри каталога", projects: root, path: filepath.Join(root, "app"), ok: true},
+		{name: "только архивы", projects: "", path: filepath.Join(root, "app"), forbidden: true},
+		{name: "вне каталога", projects: root, path: outside, forbidden: true},
+		{name: "выход через ..", projects: root, path: root + "/app/../../" + filepath.Base(outside), forbidden: true},
+		{name: "символическая ссылка наружу", projects: root, path: filepath.Join(root, "escape"), forbidden: true},
+		{name: "относительный путь", projects: root, path: "app"},
+		{name: "без go.mod", projects: root, path: filepath.Join(root, "empty")},
+		{name: "несуществующий", projects: root, path: filepath.Join(root, "missing")},
+		{name: "пустой путь", projects: root, path: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{projects: tt.projects}
+			path, err := s.projectPath(tt.path)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("ошибка %v", err)
+				}
+				if want, _ := filepath.EvalSymlinks(tt.path); path != want {
+					t.Fatalf("путь %q, ожидался %q", path, want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("путь %q принят", path)
+			}
+			if errors.Is(err, errLocalPath) != tt.forbidden {
+				t.Fatalf("ошибка %v: запрет %v, ожидался %v", err, !tt.forbidden, tt.forbidden)
+			}
+		})
+	}
+}
+
+// Задание с некорректным фильтром или архивом — 400, с локальным путём без каталога проектов — 403,
+// с архивом сверх ограничений распаковки — 413; до очереди заданий такие запросы не доходят
+func TestCreateJobStatus(t *testing.T) {
+	var entries []archiveEntry
+	for i := range maxEntries + 1 {
+		entries = append(entries, archiveEntry{name: fmt.Sprintf("app/f%d.go", i)})
+	}
+	tests := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		status  int
+		err     string
+	}{
+		{
+			name: "локальный путь",
+			request: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"path": "/etc"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "некорректный фильтр",
+			request: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"path": "app", "filter": "kind ="}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			status: http.StatusBadRequest,
+			err:    "filter",
+		},
+		{
+			name: "не архив",
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader("plain text"))
+			},
+			status: http.StatusBadRequest,
+			err:    errArchiveFormat.Error(),
+		},
+		{
+			name: "слишком много записей",
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewReader(zipArchive(t, entries...)))
+			},
+			status: http.StatusRequestEntityTooLarge,
+			err:    errArchiveLimit.Error(),
+		},
+		{
+			name: "слишком много записей в форме",
+			request: func(t *testing.T) *http.Request {
+				return multipartRequest(t, "/jobs", map[string]string{"project/app.zip": string(zipArchive(t, entries...))}, nil)
+			},
+			status: http.StatusRequestEntityTooLarge,
+			err:    errArchiveLimit.Error(),
+		},
+	}
+	handler := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, handler, tt.request(t))
+			if resp.StatusCode != tt.status {
+				t.Fatalf("статус %d, ожидался %d: %v", resp.StatusCode, tt.status, body)
+			}
+			if msg, _ := body["error"].(string); !strings.Contains(msg, tt.err) {
+				t.Fatalf("ошибка %q, ожидалась %q", msg, tt.err)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	var jobs []any
+	if err := json.NewDecoder(rec.Body).Decode(&jobs); err != nil || len(jobs) != 0 {
+		t.Fatalf("задания: %v, %v", jobs, err)
+	}
+}
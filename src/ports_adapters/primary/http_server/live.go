@@ -0,0 +1,208 @@
+package http_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"gtrace/src/application/live"
+	"gtrace/src/application/queries"
+	domain "gtrace/src/domain/parser"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// liveView — живая трасса в ответах API
+type liveView struct {
+	ID      string     `json:"id"`
+	Remote  string     `json:"remote"`
+	Started time.Time  `json:"started"`
+	Ended   *time.Time `json:"ended,omitempty"`
+	Events  int        `json:"events"`
+	// Trimmed — завершённые горутины, вытесненные из графа живой трассы
+	Trimmed int      `json:"trimmed,omitempty"`
+	Program string   `json:"program,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// TraceID — трасса в хранилище (/traces/{id}) после закрытия соединения
+	TraceID string `json:"trace_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// liveStateView — состояние живой трассы: итог и горутины, которые сейчас ждут на каналах
+type liveStateView struct {
+	liveView
+	Summary   *summaryView  `json:"summary"`
+	Blocked   []blockedView `json:"blocked"`
+	AllAsleep bool          `json:"all_asleep"`
+}
+
+// blockedView — горутина, ожидающая операции с каналом: на каких каналах и кто мог бы её разбудить
+type blockedView struct {
+	Goroutine string   `json:"goroutine"`
+	Func      string   `json:"func,omitempty"`
+	Op        string   `json:"op"`
+	Channels  []string `json:"channels"`
+	Site      string   `json:"site,omitempty"`
+	Since     string   `json:"since,omitempty"`
+	WaitsFor  []string `json:"waits_for"`
+}
+
+func newLiveView(info live.Info) liveView {
+	view := liveView{
+		ID:      info.ID,
+		Remote:  info.Remote,
+		Started: info.Started,
+		Events:  info.Events,
+		Trimmed: info.Trimmed,
+		Program: info.Program,
+		Args:    info.Args,
+		TraceID: info.TraceID,
+		Error:   info.Error,
+	}
+	if !info.Ended.IsZero() {
+		view.Ended = &info.Ended
+	}
+	return view
+}
+
+func newLiveStateView(state queries.LiveState) liveStateView {
+	view := liveStateView{
+		liveView:  newLiveView(state.Info),
+		Summary:   newSummaryView(state.Summary),
+		Blocked:   make([]blockedView, 0, len(state.Blocked)),
+		AllAsleep: state.AllAsleep,
+	}
+	for _, b := range state.Blocked {
+		view.Blocked = append(view.Blocked, newBlockedView(b))
+	}
+	return view
+}
+
+func newBlockedView(b domain.BlockedGoroutine) blockedView {
+	view := blockedView{
+		Goroutine: b.Goroutine.ID,
+		Func:      b.Goroutine.Func,
+		Op:        b.Op.Kind,
+		Site:      b.Op.Site,
+		Since:     b.Op.TS,
+		WaitsFor:  b.WaitsFor,
+	}
+	if b.Op.Kind == "select" {
+		for _, c := range b.Op.Cases {
+			view.Channels = append(view.Channels, c.Channel)
+		}
+	} else {
+		view.Channels = []string{b.Op.Channel}
+	}
+	if view.WaitsFor == nil {
+		view.WaitsFor = []string{}
+	}
+	return view
+}
+
+func (s *Server) listLive(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.App.Queries.LiveSessions.Handle(r.Context(), queries.LiveSessionsQuery{})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	views := make([]liveView, 0, len(sessions))
+	for _, info := range sessions {
+		views = append(views, newLiveView(info))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// getLive возвращает состояние живой трассы; filter оставляет заблокированные горутины подграфа
+func (s *Server) getLive(w http.ResponseWriter, r *http.Request) {
+	state, err := s.App.Queries.LiveSession.Handle(r.Context(), queries.LiveSessionQuery{
+		SessionID: r.PathValue("id"),
+		Filter:    r.URL.Query().Get("filter"),
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newLiveStateView(state))
+}
+
+// getLiveGraph возвращает снимок графа живой трассы (format=json или dot); filter — подграф
+func (s *Server) getLiveGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := s.App.Queries.LiveGraph.Handle(r.Context(), queries.LiveGraphQuery{
+		SessionID: r.PathValue("id"),
+		Filter:    r.URL.Query().Get("filter"),
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeGraph(w, r, graph)
+}
+
+// streamLive рассылает живую трассу через Server-Sent Events:
+//   - state — состояние трассы (как GET /live/{id}) при подключении и не чаще раза в interval
+//     (по умолчанию 1s), если пришли новые события;
+//   - trace — событие, прошедшее filter, строкой текстового формата трассы; id — номер события;
+//   - end — программа закрыла соединение: последнее состояние с ID сохранённой трассы.
+//
+// Поток заканчивается вместе с соединением программы
+func (s *Server) streamLive(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	var interval time.Duration
+	if value := r.URL.Query().Get("interval"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 100*time.Millisecond {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("interval must be a duration of at least 100ms"))
+			return
+		}
+		interval = d
+	}
+
+	started := false
+	send := func(event, id string, data []byte) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	sent, err := s.App.Queries.LiveEvents.Handle(r.Context(), queries.LiveEventsQuery{
+		SessionID: r.PathValue("id"),
+		Filter:    r.URL.Query().Get("filter"),
+		Interval:  interval,
+		Send: func(update queries.LiveUpdate) error {
+			if update.Event != nil {
+				return send("trace", strconv.Itoa(update.Event.Seq), []byte(update.Event.Line))
+			}
+			data, err := json.Marshal(newLiveStateView(*update.State))
+			if err != nil {
+				return err
+			}
+			event := "state"
+			if !update.State.Info.Ended.IsZero() {
+				event = "end"
+			}
+			return send(event, "", data)
+		},
+	})
+	switch {
+	case err != nil && !started:
+		writeQueryError(w, err)
+	case err != nil:
+		// поток уже начат: подписчик отстал или отключился, остаётся записать в лог
+		s.logger.Warn("Поток живой трассы прерван", "session", r.PathValue("id"), "events", sent, "error", err)
+	}
+}
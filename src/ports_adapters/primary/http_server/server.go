@@ -1,7 +1,144 @@
 package http_server
 
-import "gtracer/src/application"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gtrace/src/application"
+	"gtrace/src/application/live"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
 
+const (
+	// shutdownTimeout — сколько сервер при остановке ждёт завершения запросов и выполняющихся заданий
+	shutdownTimeout = 30 * time.Second
+	// cancelTimeout — сколько ждать отменённые при остановке задания: их программы уничтожаются,
+	// записанные части трасс разбираются
+	cancelTimeout = 10 * time.Second
+	// retentionInterval — как часто сервер удаляет трассы по политике хранения
+	retentionInterval = time.Minute
+)
+
+// Server — HTTP API поверх application.App: задания «инструментирование — запуск — разбор трассы»
+// для проектов, загруженных архивом или заданных локальным путём, анализ загруженных трасс и
+// живые трассы, которые программы отправляют на адрес live во время выполнения
 type Server struct {
-	App application.App
+	App    application.App
+	logger *slog.Logger
+	http   *http.Server
+	// live — адрес приёма живых трасс (tcp:<адрес>:<порт> или unix:<путь>); пусто — не принимать
+	live string
+	// projects — каталог, внутри которого разрешены локальные пути проектов; пусто — только архивы
+	projects string
+}
+
+// Config — настройки сервера. API без аутентификации: по умолчанию сервер слушает только
+// локальный адрес, а задания не читают файлы сервера вне каталога Projects
+type Config struct {
+	Host     string
+	Port     string
+	Live     string
+	Projects string
+}
+
+func NewServer(app application.App, logger *slog.Logger, conf Config) *Server {
+	s := &Server{
+		App:      app,
+		logger:   logger,
+		live:     conf.Live,
+		projects: conf.Projects,
+	}
+	s.http = &http.Server{
+		Addr:              net.JoinHostPort(conf.Host, conf.Port),
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.createJob)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.cancelJob)
+	mux.HandleFunc("GET /jobs/{id}/graph", s.getJobGraph)
+	mux.HandleFunc("POST /traces", s.uploadTrace)
+	mux.HandleFunc("GET /traces", s.listTraces)
+	mux.HandleFunc("GET /traces/{id}", s.getTrace)
+	mux.HandleFunc("DELETE /traces/{id}", s.deleteTrace)
+	mux.HandleFunc("POST /traces/{id}/tags", s.tagTrace)
+	mux.HandleFunc("GET /traces/{id}/graph", s.getTraceGraph)
+	mux.HandleFunc("GET /traces/{id}/report", s.getTraceReport)
+	mux.HandleFunc("GET /traces/{id}/events", s.getTraceEvents)
+	mux.HandleFunc("GET /traces/{id}/goroutines/{goroutine...}", s.getGoroutine)
+	mux.HandleFunc("GET /traces/{id}/channels/{channel...}", s.getChannel)
+	mux.HandleFunc("GET /live", s.listLive)
+	mux.HandleFunc("GET /live/{id}", s.getLive)
+	mux.HandleFunc("GET /live/{id}/graph", s.getLiveGraph)
+	mux.HandleFunc("GET /live/{id}/events", s.streamLive)
+	return mux
+}
+
+// Run обслуживает запросы до отмены ctx, затем останавливает сервер: перестаёт принимать соединения,
+// дожидается текущих запросов и выполняющихся заданий (не дольше shutdownTimeout), затем отменяет
+// оставшиеся задания. Пока сервер работает, трассы удаляются по политике хранения раз в
+// retentionInterval. Потоки живых трасс закрываются сразу, соединения программ закрываются,
+// и их трассы сохраняются
+func (s *Server) Run(ctx context.Context) error {
+	// потоки SSE не заканчиваются сами: их контекст отменяется в начале остановки
+	streams, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+	s.http.BaseContext = func(net.Listener) context.Context { return streams }
+	s.http.RegisterOnShutdown(stopStreams)
+
+	liveDone := make(chan struct{})
+	if s.live == "" {
+		close(liveDone)
+	} else {
+		ln, err := live.Listen(s.live)
+		if err != nil {
+			return fmt.Errorf("приём живых трасс: %w", err)
+		}
+		go func() {
+			defer close(liveDone)
+			if err := s.App.Live.Serve(ctx, ln); err != nil {
+				s.logger.Error("Приём живых трасс остановлен", "error", err)
+			}
+		}()
+	}
+
+	go s.App.Traces.Expire(ctx, retentionInterval)
+
+	errc := make(chan error, 1)
+	go func() {
+		s.logger.Info("HTTP-сервер запущен", "addr", s.http.Addr)
+		errc <- s.http.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	<-liveDone
+
+	s.logger.Info("Остановка HTTP-сервера")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if !s.App.Jobs.Wait(shutdownCtx) {
+		s.logger.Warn("Задания не завершились до остановки сервера, выполняющиеся задания отменяются")
+		s.App.Jobs.CancelAll()
+		cancelCtx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+		s.App.Jobs.Wait(cancelCtx)
+	}
+	s.logger.Info("HTTP-сервер остановлен")
+	return nil
 }
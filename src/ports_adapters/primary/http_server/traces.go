@@ -0,0 +1,274 @@
+package http_server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gtrace/src/application/commands"
+	"gtrace/src/application/queries"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// traceView — сохранённая трасса в ответах API
+type traceView struct {
+	ID       string       `json:"id"`
+	Kind     string       `json:"kind"`
+	Created  time.Time    `json:"created"`
+	Project  string       `json:"project,omitempty"`
+	Commit   string       `json:"commit,omitempty"`
+	Args     []string     `json:"args,omitempty"`
+	Duration string       `json:"duration,omitempty"`
+	Tags     []string     `json:"tags"`
+	Sources  []string     `json:"sources,omitempty"`
+	Size     int64        `json:"size"`
+	Summary  *summaryView `json:"summary"`
+}
+
+// tagsRequest — изменение тегов трассы
+type tagsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+func newTraceView(trace queries.StoredTrace) traceView {
+	view := traceView{
+		ID:      trace.ID,
+		Kind:    trace.Kind,
+		Created: trace.Created,
+		Project: trace.Project,
+		Commit:  trace.Commit,
+		Args:    trace.Args,
+		Tags:    trace.Tags,
+		Sources: trace.Sources,
+		Size:    trace.Size,
+		Summary: newSummaryView(trace.Summary),
+	}
+	if trace.Duration > 0 {
+		view.Duration = trace.Duration.String()
+	}
+	if view.Tags == nil {
+		view.Tags = []string{}
+	}
+	return view
+}
+
+// uploadTrace принимает трассы любого поддерживаемого формата (трасса gtrace, трасса выполнения Go,
+// дамп горутин), разбирает их и сохраняет результат:
+//   - multipart/form-data: трассы в полях trace (несколько трасс объединяются, имя источника — имя
+//     файла без расширения), поля align, offset (имя=длительность, повторяется) и strict;
+//   - иначе тело запроса — одна трасса, strict — параметр запроса
+func (s *Server) uploadTrace(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var command commands.ImportTraceCommand
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid form: %w", err))
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		command.Align = r.FormValue("align")
+		command.Offsets = r.MultipartForm.Value["offset"]
+		command.Strict = formBool(r.FormValue("strict"))
+		for _, header := range r.MultipartForm.File["trace"] {
+			file, err := header.Open()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			defer file.Close()
+			command.Uploads = append(command.Uploads, commands.TraceUpload{Filename: header.Filename, Reader: file})
+		}
+	} else {
+		command.Strict = formBool(r.URL.Query().Get("strict"))
+		command.Uploads = []commands.TraceUpload{{Filename: "upload", Reader: r.Body}}
+	}
+	if command.Align != "" && command.Align != "start" && command.Align != "wall" {
+		writeError(w, http.StatusBadRequest, errors.New("align must be start or wall"))
+		return
+	}
+
+	result, err := s.App.Commands.ImportTrace.Handle(r.Context(), command)
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, status, err)
+		return
+	}
+	imported := result.(commands.ImportResult)
+	trace, err := s.App.Queries.GetTrace.Handle(r.Context(), queries.GetTraceQuery{TraceID: imported.TraceID})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/traces/"+trace.ID)
+	writeJSON(w, http.StatusCreated, newTraceView(trace))
+}
+
+// listTraces возвращает трассы хранилища в порядке сохранения; tag — только трассы с этим тегом
+func (s *Server) listTraces(w http.ResponseWriter, r *http.Request) {
+	traces, err := s.App.Queries.ListTraces.Handle(r.Context(), queries.ListTracesQuery{Tag: r.URL.Query().Get("tag")})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	views := make([]traceView, 0, len(traces))
+	for _, trace := range traces {
+		views = append(views, newTraceView(trace))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getTrace(w http.ResponseWriter, r *http.Request) {
+	trace, err := s.App.Queries.GetTrace.Handle(r.Context(), queries.GetTraceQuery{TraceID: r.PathValue("id")})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTraceView(trace))
+}
+
+// deleteTrace удаляет трассу из хранилища
+func (s *Server) deleteTrace(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.App.Commands.DeleteTrace.Handle(r.Context(), commands.DeleteTraceCommand{TraceID: r.PathValue("id")}); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tagTrace добавляет и снимает теги трассы: {"add": [...], "remove": [...]}; отвечает трассой
+func (s *Server) tagTrace(w http.ResponseWriter, r *http.Request) {
+	var req tagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	id := r.PathValue("id")
+	command := commands.TagTraceCommand{TraceID: id, Add: req.Add, Remove: req.Remove}
+	if _, err := s.App.Commands.TagTrace.Handle(r.Context(), command); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.getTrace(w, r)
+}
+
+// getTraceGraph возвращает граф трассы (format=json или dot); filter — подграф
+func (s *Server) getTraceGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := s.App.Queries.TraceGraph.Handle(r.Context(), queries.TraceGraphQuery{
+		TraceID: r.PathValue("id"),
+		Filter:  r.URL.Query().Get("filter"),
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeGraph(w, r, graph)
+}
+
+// getTraceReport возвращает отчёт анализа трассы: format=json (по умолчанию) или text — как в CLI
+func (s *Server) getTraceReport(w http.ResponseWriter, r *http.Request) {
+	report, err := s.App.Queries.TraceReport.Handle(r.Context(), queries.TraceReportQuery{
+		TraceID: r.PathValue("id"),
+		Filter:  r.URL.Query().Get("filter"),
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, report)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, report.String())
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q: expected json or text", format))
+	}
+}
+
+// getTraceEvents возвращает события трассы, прошедшие фильтр, строками текстового формата трассы
+func (s *Server) getTraceEvents(w http.ResponseWriter, r *http.Request) {
+	out := &eventsWriter{w: w}
+	written, err := s.App.Queries.TraceEvents.Handle(r.Context(), queries.TraceEventsQuery{
+		TraceID: r.PathValue("id"),
+		Filter:  r.URL.Query().Get("filter"),
+		Output:  out,
+	})
+	switch {
+	case err != nil && !out.started:
+		writeQueryError(w, err)
+	case err != nil:
+		// ответ уже начат: ошибку остаётся только записать в лог
+		s.logger.Error("Ошибка вывода событий трассы", "trace", r.PathValue("id"), "events", written, "error", err)
+	case !out.started:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) getGoroutine(w http.ResponseWriter, r *http.Request) {
+	details, err := s.App.Queries.GoroutineDetails.Handle(r.Context(), queries.GoroutineQuery{
+		TraceID:   r.PathValue("id"),
+		Goroutine: r.PathValue("goroutine"),
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+func (s *Server) getChannel(w http.ResponseWriter, r *http.Request) {
+	details, err := s.App.Queries.ChannelDetails.Handle(r.Context(), queries.ChannelQuery{
+		TraceID: r.PathValue("id"),
+		Channel: r.PathValue("channel"),
+	})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// eventsWriter начинает ответ с первой записанной строкой: до неё ещё можно ответить ошибкой
+type eventsWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *eventsWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		e.started = true
+	}
+	return e.w.Write(p)
+}
+
+// writeStoreError отвечает ошибкой команды хранилища: 404 — трассы нет
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, commands.ErrTraceNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// writeQueryError отвечает ошибкой запроса: 404 — трассы, горутины или канала нет, 400 — неверный фильтр
+func writeQueryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queries.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, queries.ErrInvalidFilter):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,162 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"gtrace/src/ports_adapters/secondary/service/app"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// uploadedTrace — программа запускает горутину и получает от неё значение
+const uploadedTrace = `[GTRACE] trace_header 1 go1.24.0 4 1000000000 ./app
+[GTRACE] channel_create 1 main.go:5 main.go:4 1000000100 0
+[GTRACE] go_spawn 1 2 main.worker main.go:6 main.go:4 1000000200
+[GTRACE] func_start 2 main.worker main.go:6 1000000300 2
+[GTRACE] channel_send 2 1 main.go:7 main.go:6 1000000400
+[GTRACE] channel_receive 1 1 main.go:8 main.go:4 1000000500
+[GTRACE] func_end 2 main.worker main.go:6 1000000600 return
+[GTRACE] shutdown 1 return main.go:9 1000000700 0
+`
+
+// newTestServer — сервер с хранилищем трасс во временном каталоге, без каталога проектов
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	application := app.InitApp(logger, app.Config{Jobs: 1, Store: t.TempDir()})
+	return NewServer(*application, logger, Config{}).routes()
+}
+
+// serve выполняет запрос и возвращает ответ; тело ответа — JSON
+func serve(t *testing.T, handler http.Handler, req *http.Request) (*http.Response, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	var body map[string]any
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("ответ %d не JSON: %v", resp.StatusCode, err)
+		}
+	}
+	return resp, body
+}
+
+// multipartRequest — запрос с формой: files — файлы полей, values — остальные поля
+func multipartRequest(t *testing.T, target string, files map[string]string, values map[string]string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for field, value := range values {
+		w.WriteField(field, value)
+	}
+	for name, content := range files {
+		field, filename, _ := strings.Cut(name, "/")
+		part, err := w.CreateFormFile(field, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, target, &buf)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+// Загруженная трасса разбирается и сохраняется (201 с адресом трассы); некорректный запрос — 400,
+// трасса, не разобранная в строгом режиме, — 400, неизвестная трасса — 404
+func TestUploadTrace(t *testing.T) {
+	broken := strings.Replace(uploadedTrace, "1000000200", "later", 1)
+	tests := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		status  int
+		err     string
+	}{
+		{
+			name: "тело запроса",
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/traces", strings.NewReader(uploadedTrace))
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "несколько трасс в форме",
+			request: func(t *testing.T) *http.Request {
+				return multipartRequest(t, "/traces",
+					map[string]string{"trace/a.log": uploadedTrace}, map[string]string{"align": "wall"})
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "нестрогий режим пропускает запись",
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/traces", strings.NewReader(broken))
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "строгий режим",
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/traces?strict=1", strings.NewReader(broken))
+			},
+			status: http.StatusBadRequest,
+			err:    "go_spawn",
+		},
+		{
+			name: "неизвестное выравнивание",
+			request: func(t *testing.T) *http.Request {
+				return multipartRequest(t, "/traces",
+					map[string]string{"trace/a.log": uploadedTrace}, map[string]string{"align": "end"})
+			},
+			status: http.StatusBadRequest,
+			err:    "align must be start or wall",
+		},
+		{
+			name: "испорченная форма",
+			request: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/traces", strings.NewReader("--x\r\n"))
+				req.Header.Set("Content-Type", "multipart/form-data; boundary=y")
+				return req
+			},
+			status: http.StatusBadRequest,
+			err:    "invalid form",
+		},
+	}
+	handler := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, handler, tt.request(t))
+			if resp.StatusCode != tt.status {
+				t.Fatalf("статус %d, ожидался %d: %v", resp.StatusCode, tt.status, body)
+			}
+			if tt.status != http.StatusCreated {
+				if msg, _ := body["error"].(string); !strings.Contains(msg, tt.err) {
+					t.Fatalf("ошибка %q, ожидалась %q", msg, tt.err)
+				}
+				return
+			}
+			id, _ := body["id"].(string)
+			if location := resp.Header.Get("Location"); id == "" || location != "/traces/"+id {
+				t.Fatalf("трасса %q по адресу %q", id, location)
+			}
+			resp, body = serve(t, handler, httptest.NewRequest(http.MethodGet, "/traces/"+id, nil))
+			if resp.StatusCode != http.StatusOK || body["id"] != id {
+				t.Fatalf("сохранённая трасса: %d %v", resp.StatusCode, body)
+			}
+		})
+	}
+
+	resp, body := serve(t, handler, httptest.NewRequest(http.MethodGet, "/traces/missing", nil))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("неизвестная трасса: %d %v", resp.StatusCode, body)
+	}
+}
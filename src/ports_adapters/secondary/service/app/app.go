@@ -3,18 +3,72 @@ package app
 import (
 	"gtrace/src/application"
 	"gtrace/src/application/commands"
+	"gtrace/src/application/jobs"
+	"gtrace/src/application/live"
+	"gtrace/src/application/queries"
 	"gtrace/src/ports_adapters/secondary/service/instrumented"
 	"gtrace/src/ports_adapters/secondary/service/parser"
+	"gtrace/src/ports_adapters/secondary/service/storage"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
 )
 
-func InitApp(logger *slog.Logger) *application.App {
+// Config — настройки приложения: Jobs заданий трассировки выполняются одновременно, каждое —
+// не дольше JobTimeout (0 — без ограничения). Трассы запусков и загрузок сохраняются в каталог
+// Store (пустой — каталог кэша пользователя) и удаляются по политике Retention
+type Config struct {
+	Jobs       int
+	JobTimeout time.Duration
+	Store      string
+	Retention  storage.Retention
+}
+
+func InitApp(logger *slog.Logger, conf Config) *application.App {
 	instrument := instrumented.New(logger)
 	pars := parser.NewParser(logger)
+	store := conf.Store
+	if store == "" {
+		store = DefaultStore()
+	}
+	traces := storage.NewTraces(store, conf.Retention, pars, logger)
+	goTrace := commands.NewGoTraceCommand(pars, traces, logger, instrument)
+	hub := live.NewHub(traces, logger)
 
 	return &application.App{
 		Commands: application.Command{
-			GoTraceCli: commands.NewGoTraceCommand(pars, logger, instrument),
+			GoTraceCli:   goTrace,
+			AnalyzeTrace: commands.NewAnalyzeTraceCommand(pars, logger),
+			ImportTrace:  commands.NewImportTraceCommand(pars, traces, logger),
+			TagTrace:     commands.NewTagTraceCommand(traces, logger),
+			DeleteTrace:  commands.NewDeleteTraceCommand(traces, logger),
+		},
+		Queries: application.Query{
+			ListTraces:       queries.NewListTracesQuery(traces, logger),
+			GetTrace:         queries.NewGetTraceQuery(traces, logger),
+			TraceGraph:       queries.NewTraceGraphQuery(traces, logger),
+			TraceReport:      queries.NewTraceReportQuery(traces, logger),
+			TraceEvents:      queries.NewTraceEventsQuery(pars, traces, logger),
+			GoroutineDetails: queries.NewGoroutineDetailsQuery(traces, logger),
+			ChannelDetails:   queries.NewChannelDetailsQuery(traces, logger),
+			LiveSessions:     queries.NewLiveSessionsQuery(hub, logger),
+			LiveSession:      queries.NewLiveSessionQuery(hub, logger),
+			LiveGraph:        queries.NewLiveGraphQuery(hub, logger),
+			LiveEvents:       queries.NewLiveEventsQuery(hub, logger),
 		},
+		Jobs:   jobs.NewQueue(goTrace, conf.Jobs, conf.JobTimeout, logger),
+		Live:   hub,
+		Traces: traces,
+	}
+}
+
+// DefaultStore — каталог хранилища трасс по умолчанию: gtrace/traces в каталоге кэша пользователя
+// (во временном каталоге, если каталог кэша не определён)
+func DefaultStore() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
 	}
+	return filepath.Join(dir, "gtrace", "traces")
 }
@@ -0,0 +1,95 @@
+package instrumented
+
+// chanCode — номера каналов сгенерированного пакета gtrace (gtrace/chan.go). Канал определяется
+// по адресу, а не по месту вызова; запись об адресе удаляется, когда канал собран сборщиком мусора,
+// поэтому таблица не растёт с числом созданных каналов, а канал на освободившемся адресе не получает
+// номер прежнего. Очистка привязывается через runtime.AddCleanup (Go 1.24), для старых версий Go
+// генерируется chanLegacyCode
+const chanCode = `//go:build go1.24
+
+package gtrace
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Идентификаторы каналов: канал определяется по адресу, а не по месту вызова.
+// При создании канал получает новый номер, каналы, созданные вне инструментированного кода
+// (time.After, ctx.Done()), получают номер при первой операции. Номер освобождается вместе
+// с каналом: очистка удаляет адрес из таблицы, когда канал собран
+var (
+	chanIDs sync.Map // адрес канала (uintptr) -> номер (uint64)
+	chanSeq atomic.Uint64
+)
+
+func chanID(ch any, create bool) uint64 {
+	v := reflect.ValueOf(ch)
+	if v.Pointer() == 0 {
+		return 0
+	}
+	ptr := v.UnsafePointer()
+	key := uintptr(ptr)
+
+	if !create {
+		if id, ok := chanIDs.Load(key); ok {
+			return id.(uint64)
+		}
+	}
+	id := chanSeq.Add(1)
+	if create {
+		chanIDs.Store(key, id)
+	} else if prev, loaded := chanIDs.LoadOrStore(key, id); loaded {
+		// другая горутина успела назначить номер этому же каналу
+		return prev.(uint64)
+	}
+	// очистка не держит канал: ключ — число, а не указатель. Адрес мог уже достаться новому каналу
+	// с другим номером, его запись не трогается
+	runtime.AddCleanup((*byte)(ptr), func(id uint64) {
+		chanIDs.CompareAndDelete(key, id)
+	}, id)
+	return id
+}
+`
+
+// chanLegacyCode — номера каналов для Go до 1.24 (gtrace/chan_legacy.go): без runtime.AddCleanup
+// адрес собранного канала из таблицы не удаляется
+const chanLegacyCode = `//go:build !go1.24
+
+package gtrace
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Идентификаторы каналов: канал определяется по адресу, а не по месту вызова.
+// При создании канал получает новый номер (адрес мог освободиться и достаться новому каналу),
+// каналы, созданные вне инструментированного кода (time.After, ctx.Done()), получают номер при первой
+// операции. До Go 1.24 нет runtime.AddCleanup: записи собранных каналов остаются в таблице, и канал,
+// созданный вне инструментированного кода на их адресе, получит прежний номер
+var (
+	chanIDs sync.Map // адрес канала (uintptr) -> номер (uint64)
+	chanSeq atomic.Uint64
+)
+
+func chanID(ch any, create bool) uint64 {
+	key := reflect.ValueOf(ch).Pointer()
+	if key == 0 {
+		return 0
+	}
+	if create {
+		id := chanSeq.Add(1)
+		chanIDs.Store(key, id)
+		return id
+	}
+	if id, ok := chanIDs.Load(key); ok {
+		return id.(uint64)
+	}
+	id, _ := chanIDs.LoadOrStore(key, chanSeq.Add(1))
+	return id.(uint64)
+}
+`
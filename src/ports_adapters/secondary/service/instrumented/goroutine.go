@@ -0,0 +1,315 @@
+package instrumented
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// instrumentGo превращает go-оператор в запуск сгенерированного замыкания с прямым вызовом функции.
+// Функция и аргументы вычисляются в родительской горутине в момент go-оператора и в исходном порядке,
+// как того требует спецификация; константы и nil подставляются в вызов как есть, чтобы сохранить
+// их нетипизированность, а нетипизированные неконстантные выражения (сравнения, сдвиги константы)
+// явно приводятся к типу, который они получили бы в исходном вызове. Результаты единственного
+// аргумента с несколькими значениями (go f(g())) принимаются в отдельные переменные.
+//
+//	{
+//		gtraceGo0Fn := s.handle
+//		gtraceGo0Arg0 := req
+//		go func(gtraceSpawn uint64) {
+//			gtraceG := gtrace.Start(gtraceSpawn, "(*main.Server).handle")
+//			defer gtraceG.End()
+//			gtraceGo0Fn(gtraceGo0Arg0, 10)
+//			gtraceG.Return()
+//		}(gtrace.Spawn("main.go:42", "(*main.Server).handle"))
+//	}
+func (r *rewriter) instrumentGo(n *ast.GoStmt) ast.Stmt {
+	index := r.tmpIndex
+	r.tmpIndex++
+
+	var hoisted []ast.Stmt
+	hoist := func(name string, expr ast.Expr) ast.Expr {
+		hoisted = append(hoisted, &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent(name)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{expr},
+		})
+		return ast.NewIdent(name)
+	}
+
+	fun := n.Call.Fun
+	if r.needsHoist(fun) {
+		fun = hoist(fmt.Sprintf("gtraceGo%dFn", index), fun)
+	}
+
+	callArgs := n.Call.Args
+	args := make([]ast.Expr, len(callArgs))
+	if tuple, ok := r.tupleArg(callArgs); ok {
+		// f(g()) с несколькими результатами g: результаты принимаются в отдельные переменные
+		assign := &ast.AssignStmt{Tok: token.DEFINE, Rhs: callArgs}
+		args = make([]ast.Expr, tuple.Len())
+		for j := range args {
+			name := fmt.Sprintf("gtraceGo%dArg%d", index, j)
+			assign.Lhs = append(assign.Lhs, ast.NewIdent(name))
+			args[j] = ast.NewIdent(name)
+		}
+		hoisted = append(hoisted, assign)
+		callArgs = nil
+	}
+	for j, arg := range callArgs {
+		if value, ok := r.hoistable(arg); ok {
+			args[j] = hoist(fmt.Sprintf("gtraceGo%dArg%d", index, j), value)
+		} else {
+			args[j] = arg
+		}
+	}
+
+	name := r.funcName(n.Call.Fun)
+	nameLit := &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(name)}
+	closure := &ast.FuncLit{
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{List: []*ast.Field{{
+				Names: []*ast.Ident{ast.NewIdent("gtraceSpawn")},
+				Type:  ast.NewIdent("uint64"),
+			}}},
+		},
+		// Return отмечает нормальный возврат; если до него дело не дошло, End определяет,
+		// была ли паника или runtime.Goexit
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("gtraceG")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{&ast.CallExpr{
+					Fun:  ast.NewIdent("gtrace.Start"),
+					Args: []ast.Expr{ast.NewIdent("gtraceSpawn"), nameLit},
+				}},
+			},
+			&ast.DeferStmt{
+				Call: &ast.CallExpr{
+					Fun: &ast.SelectorExpr{X: ast.NewIdent("gtraceG"), Sel: ast.NewIdent("End")},
+				},
+			},
+			&ast.ExprStmt{X: &ast.CallExpr{Fun: fun, Args: args, Ellipsis: n.Call.Ellipsis}},
+			&ast.ExprStmt{X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{X: ast.NewIdent("gtraceG"), Sel: ast.NewIdent("Return")},
+			}},
+		}},
+	}
+
+	goStmt := &ast.GoStmt{
+		Go: n.Go,
+		Call: &ast.CallExpr{
+			Fun: closure,
+			Args: []ast.Expr{&ast.CallExpr{
+				Fun:  ast.NewIdent("gtrace.Spawn"),
+				Args: []ast.Expr{r.site(n.Pos()), nameLit},
+			}},
+		},
+	}
+
+	if len(hoisted) == 0 {
+		return goStmt
+	}
+	return &ast.BlockStmt{List: append(hoisted, goStmt)}
+}
+
+// needsHoist сообщает, нужно ли вычислить функцию заранее: значения методов, переменные и вызовы,
+// возвращающие функцию, вычисляются в момент go-оператора. Функции уровня пакета (в том числе
+// обобщённые, тип которых выводится только из аргументов), встроенные функции, литералы функций
+// и сгенерированные вызовы gtrace вычислять не нужно.
+func (r *rewriter) needsHoist(fun ast.Expr) bool {
+	fun = unparen(fun)
+	switch f := fun.(type) {
+	case *ast.FuncLit:
+		return false
+	case *ast.IndexExpr:
+		fun = f.X
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	if isGtraceCall(fun) || r.builtin(fun) != "" {
+		return false
+	}
+	if fn, ok := r.info.Uses[funcIdent(fun)].(*types.Func); ok {
+		return fn.Type().(*types.Signature).Recv() != nil
+	}
+	return true
+}
+
+// tupleArg сообщает, что единственный аргумент вызова — вызов с несколькими результатами
+func (r *rewriter) tupleArg(args []ast.Expr) (*types.Tuple, bool) {
+	if len(args) != 1 {
+		return nil, false
+	}
+	tuple, ok := r.info.TypeOf(args[0]).(*types.Tuple)
+	return tuple, ok
+}
+
+// funcName возвращает полное имя запускаемой функции; литералы называются по nameFuncLits.
+// Одно и то же имя получают go_spawn и func_start горутины
+func (r *rewriter) funcName(fun ast.Expr) string {
+	fun = unparen(fun)
+	switch f := fun.(type) {
+	case *ast.FuncLit:
+		if name, ok := r.litNames[f]; ok {
+			return name
+		}
+	case *ast.IndexExpr:
+		fun = f.X
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	if fn, ok := r.info.Uses[funcIdent(fun)].(*types.Func); ok {
+		return fn.FullName()
+	}
+	return spawnFuncName(fun)
+}
+
+// hoistable возвращает выражение для временной переменной, в которую значение вычисляется заранее.
+// Нетипизированное по происхождению выражение приводится к типу, который оно получает из контекста.
+// false — выражение вычислять заранее не нужно (литерал, константа, nil) или тип нельзя назвать
+// в этом файле: тогда инструментирование завершается ошибкой (r.err), потому что выражение,
+// оставленное на месте, вычислялось бы уже в другой горутине или после выбора ветки
+func (r *rewriter) hoistable(expr ast.Expr) (ast.Expr, bool) {
+	tv, typed := r.info.Types[expr]
+	switch {
+	case isLiteral(expr), typed && (tv.Value != nil || tv.IsNil()):
+		return nil, false
+	case typed && r.untypedOrigin(expr):
+		typeName, err := r.typeName(tv.Type)
+		if err != nil {
+			if r.err == nil {
+				r.err = fmt.Errorf("%s: значение нельзя вычислить заранее: %v", r.fset.Position(expr.Pos()), err)
+			}
+			return nil, false
+		}
+		return &ast.CallExpr{Fun: ast.NewIdent(typeName), Args: []ast.Expr{expr}}, true
+	}
+	return expr, true
+}
+
+// untypedOrigin сообщает, что неконстантное выражение нетипизировано по происхождению (сравнение,
+// сдвиг константы, операции над такими выражениями) и получает тип только из контекста вызова
+func (r *rewriter) untypedOrigin(expr ast.Expr) bool {
+	expr = unparen(expr)
+	if tv, ok := r.info.Types[expr]; ok && tv.Value != nil {
+		return false
+	}
+	operand := func(e ast.Expr) bool {
+		tv, ok := r.info.Types[unparen(e)]
+		return ok && tv.Value != nil || r.untypedOrigin(e)
+	}
+
+	switch e := expr.(type) {
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return true
+		case token.SHL, token.SHR:
+			tv, ok := r.info.Types[unparen(e.X)]
+			return ok && tv.Value != nil
+		}
+		return operand(e.X) && operand(e.Y)
+	case *ast.UnaryExpr:
+		return e.Op != token.ARROW && e.Op != token.AND && operand(e.X)
+	}
+	return false
+}
+
+// typeName возвращает запись типа, допустимую в инструментируемом файле. Пакет типа, не импортированный
+// в файл, импортируется под новым именем gtraceImportN. Ошибка — тип нельзя назвать вне его пакета
+// (неэкспортируемый тип, пакет main или internal-пакет, недоступный отсюда)
+func (r *rewriter) typeName(t types.Type) (string, error) {
+	var err error
+	name := types.TypeString(t, func(p *types.Package) string {
+		if p == r.pkg {
+			return ""
+		}
+		if local, found := r.imports[p.Path()]; found {
+			return local
+		}
+		if !importable(p.Path(), r.pkg.Path()) || p.Name() == "main" {
+			err = fmt.Errorf("пакет %s нельзя импортировать из %s", p.Path(), r.pkg.Path())
+			return p.Name()
+		}
+		alias := fmt.Sprintf("gtraceImport%d", r.importAliases)
+		r.importAliases++
+		astutil.AddNamedImport(r.fset, r.file, alias, p.Path())
+		r.imports[p.Path()] = alias
+		return alias
+	})
+	if err != nil {
+		return "", err
+	}
+	if unexported := unexportedType(t, r.pkg); unexported != nil {
+		return "", fmt.Errorf("тип %s не экспортируется из пакета %s", unexported.Name(), unexported.Pkg().Path())
+	}
+	return name, nil
+}
+
+// unexportedType возвращает неэкспортируемый именованный тип чужого пакета, из которого состоит t
+func unexportedType(t types.Type, pkg *types.Package) *types.TypeName {
+	switch t := t.(type) {
+	case *types.Named:
+		if obj := t.Obj(); obj.Pkg() != nil && obj.Pkg() != pkg && !obj.Exported() {
+			return obj
+		}
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if obj := unexportedType(t.TypeArgs().At(i), pkg); obj != nil {
+				return obj
+			}
+		}
+	case *types.Alias:
+		return unexportedType(types.Unalias(t), pkg)
+	case *types.Pointer:
+		return unexportedType(t.Elem(), pkg)
+	case *types.Slice:
+		return unexportedType(t.Elem(), pkg)
+	case *types.Array:
+		return unexportedType(t.Elem(), pkg)
+	case *types.Map:
+		if obj := unexportedType(t.Key(), pkg); obj != nil {
+			return obj
+		}
+		return unexportedType(t.Elem(), pkg)
+	case *types.Chan:
+		return unexportedType(t.Elem(), pkg)
+	}
+	return nil
+}
+
+// importable сообщает, можно ли импортировать пакет path из пакета from с учётом правила internal
+func importable(path, from string) bool {
+	i := strings.LastIndex(path, "/internal/")
+	switch {
+	case i >= 0:
+	case strings.HasSuffix(path, "/internal"):
+		i = len(path) - len("/internal")
+	case path == "internal" || strings.HasPrefix(path, "internal/"):
+		return false
+	default:
+		return true
+	}
+	parent := path[:i]
+	return from == parent || strings.HasPrefix(from, parent+"/")
+}
+
+func funcIdent(fun ast.Expr) *ast.Ident {
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f
+	case *ast.SelectorExpr:
+		return f.Sel
+	}
+	return nil
+}
+
+func isLiteral(expr ast.Expr) bool {
+	_, ok := unparen(expr).(*ast.BasicLit)
+	return ok
+}
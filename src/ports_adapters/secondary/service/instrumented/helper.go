@@ -1,7 +1,10 @@
 package instrumented
 
 import (
+	"fmt"
 	"go/ast"
+	"go/token"
+	"go/types"
 	"os"
 	"path/filepath"
 	"strings"
@@ -25,61 +28,156 @@ func generateGtracePackage(projectRoot string) error {
 
 import (
 	"fmt"
-	"reflect"
+	"log"
+	"os"
 	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-func Wrap(fn interface{}, args ...interface{}) []interface{} {
-	// Получаем имя функции
-	fnName := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
-	if fnName == "" {
-		fnName = "anonymous"
-	}
+var spawnSeq uint64
 
-	// Получаем контекст (горутину и caller)
-	goroutine := getGoroutineName()
+// Spawn логирует go-оператор в родительской горутине и возвращает номер запуска, по которому
+// func_start дочерней горутины связывается с родителем
+// (формат: [GTRACE] go_spawn <родитель> <запуск> <функция> <место> <файл:строка> <timestamp>)
+func Spawn(name string, fnName string) uint64 {
+	spawn := atomic.AddUint64(&spawnSeq, 1)
 	caller := getCallerInfo(1)
-	timestamp := time.Now().UnixNano()
+	goroutine := getGoroutineName()
+	timestamp := now()
 
-	// Логируем начало вызова
-	fmt.Printf("[GTRACE] func_start %s %s %s %d\n",
-		goroutine, fnName, caller, timestamp)
+	emit("go_spawn", goroutine, spawn, fnName, name, caller, timestamp)
 
-	// Вызываем функцию через reflection (как было)
-	fnValue := reflect.ValueOf(fn)
-	if fnValue.Kind() != reflect.Func {
-		panic("Wrap: expected a function")
-	}
+	return spawn
+}
+
+// Goroutine — учёт жизни горутины, запущенной сгенерированным замыканием:
+//
+//	go func(gtraceSpawn uint64) {
+//		gtraceG := gtrace.Start(gtraceSpawn, "main.worker")
+//		defer gtraceG.End()
+//		worker(a, b)
+//		gtraceG.Return()
+//	}(gtrace.Spawn("main.go:10", "main.worker"))
+type Goroutine struct {
+	id       uint64
+	name     string
+	caller   string
+	returned bool
+}
 
-	fnType := fnValue.Type()
-	if len(args) != fnType.NumIn() {
-		panic("Wrap: incorrect number of arguments")
+// Start логирует начало горутины (формат: [GTRACE] func_start <контекст> <функция> <файл:строка> <timestamp> <запуск>).
+// Имя функции — то же, что в go_spawn: его выбирает инструментирование, в том числе для литералов функций
+func Start(spawn uint64, name string) *Goroutine {
+	_, file, line, _ := runtime.Caller(1)
+
+	g := &Goroutine{
+		id:     getGoroutineName(),
+		name:   name,
+		caller: fmt.Sprintf("%s:%d", file, line),
 	}
+	timestamp := now()
 
-	in := make([]reflect.Value, len(args))
-	for i, arg := range args {
-		argValue := reflect.ValueOf(arg)
-		argType := fnType.In(i)
-		if !argValue.Type().ConvertibleTo(argType) {
-			panic(fmt.Sprintf("Wrap: arg %d (%v) is not convertible to %v", i, argValue.Type(), argType))
-		}
-		in[i] = argValue.Convert(argType)
+	emit("func_start", g.id, g.name, g.caller, timestamp, spawn)
+
+	return g
+}
+
+// Return отмечает, что функция горутины вернула управление
+func (g *Goroutine) Return() {
+	g.returned = true
+}
+
+// End логирует завершение горутины с причиной
+// (формат: [GTRACE] func_end <контекст> <функция> <файл:строка> <timestamp> <return|panic|goexit> [<значение> <стек>]).
+// Значение и стек паники записываются в кавычках. Паника поднимается заново, поведение программы не меняется
+func (g *Goroutine) End() {
+	if g.returned {
+		g.end("return")
+		return
+	}
+	if r := recover(); r != nil {
+		g.end("panic", text(fmt.Sprint(r)), text(debug.Stack()))
+		// паника в горутине завершит процесс: трасса сбрасывается до того, как она поднимется заново
+		flush()
+		panic(r)
 	}
+	g.end("goexit")
+}
+
+func (g *Goroutine) end(reason string, details ...any) {
+	timestamp := now()
+	emit("func_end", append([]any{g.id, g.name, g.caller, timestamp, reason}, details...)...)
+}
 
-	out := fnValue.Call(in)
-	results := make([]interface{}, len(out))
-	for i, val := range out {
-		results[i] = val.Interface()
+// Shutdown логирует завершение программы возвратом из main; вызывается отложенно первой строкой main.
+// При панике в main логируется причина panic, паника поднимается заново
+// (формат: [GTRACE] shutdown <контекст> <return|exit|fatal|panic|signal> <файл:строка> <timestamp> <код_выхода>)
+func Shutdown() {
+	caller := getCallerInfo(1)
+	if r := recover(); r != nil {
+		shutdown("panic", caller, 2)
+		flush()
+		panic(r)
 	}
+	shutdown("return", caller, 0)
+	flush()
+}
 
-	// Логируем завершение вызова
-	timestampEnd := time.Now().UnixNano()
-	fmt.Printf("[GTRACE] func_end %s %s %s %d\n",
-		goroutine, fnName, caller, timestampEnd)
+// Exit заменяет os.Exit: логирует завершение программы, сбрасывает трассу и выходит с тем же кодом
+func Exit(code int) {
+	shutdown("exit", getCallerInfo(1), code)
+	flush()
+	os.Exit(code)
+}
+
+// Fatal, Fatalf и Fatalln заменяют log.Fatal*: сообщение пишется стандартным логгером с местом вызова,
+// затем логируется завершение программы с причиной fatal, трасса сбрасывается и программа выходит с кодом 1
+func Fatal(v ...any) {
+	log.Output(2, fmt.Sprint(v...))
+	fatal(getCallerInfo(1))
+}
+
+func Fatalf(format string, v ...any) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	fatal(getCallerInfo(1))
+}
+
+func Fatalln(v ...any) {
+	log.Output(2, fmt.Sprintln(v...))
+	fatal(getCallerInfo(1))
+}
+
+// LoggerFatal, LoggerFatalf и LoggerFatalln заменяют методы (*log.Logger).Fatal*
+func LoggerFatal(l *log.Logger, v ...any) {
+	l.Output(2, fmt.Sprint(v...))
+	fatal(getCallerInfo(1))
+}
+
+func LoggerFatalf(l *log.Logger, format string, v ...any) {
+	l.Output(2, fmt.Sprintf(format, v...))
+	fatal(getCallerInfo(1))
+}
 
-	return results
+func LoggerFatalln(l *log.Logger, v ...any) {
+	l.Output(2, fmt.Sprintln(v...))
+	fatal(getCallerInfo(1))
+}
+
+func fatal(caller string) {
+	shutdown("fatal", caller, 1)
+	flush()
+	os.Exit(1)
+}
+
+func shutdown(reason string, caller string, code int) {
+	goroutine := getGoroutineName()
+	timestamp := now()
+
+	emit("shutdown", goroutine, reason, caller, timestamp, code)
 }
 
 // getCallerInfo возвращает информацию о вызывающем коде в формате "файл:строка"
@@ -91,84 +189,167 @@ func getCallerInfo(skip int) string {
 	return fmt.Sprintf("%s:%d", file, line)
 }
 
-func getGoroutineName() string {
-	// Создаем буфер достаточного размера для стека
+// getGoroutineName возвращает номер текущей горутины (0, если его не удалось определить)
+func getGoroutineName() uint64 {
+	// Создаем буфер достаточного размера для первой строки стека
 	buf := make([]byte, 64)
 	// Получаем стек текущей горутины
 	n := runtime.Stack(buf, false)
-	// Берем только первую строку (которая содержит ID горутины)
-	stackInfo := string(buf[:n])
-	// Формат строки: "goroutine X [status]:"
-	lines := strings.SplitN(stackInfo, "\n", 2)
-	if len(lines) < 1 {
-		return "unknown"
-	}
-	// Извлекаем "goroutine X" из строки
-	fields := strings.Fields(lines[0])
+	// Формат первой строки: "goroutine X [status]:"
+	fields := strings.Fields(string(buf[:n]))
 	if len(fields) < 2 {
-		return "unknown"
+		return 0
 	}
-	return fields[1] // возвращаем только номер (X)
+	id, _ := strconv.ParseUint(fields[1], 10, 64) // возвращаем только номер (X)
+	return id
 }
 
-// WrappedMakeChan логирует создание канала (формат: [GTRACE] channel_create <канал> <файл:строка> <timestamp> <размер_буфера>)
-func WrappedMakeChan[T any](name string, ch chan T) chan T {
+// WrappedMakeChan логирует создание канала (формат: [GTRACE] channel_create <канал> <место_создания> <файл:строка> <timestamp> <размер_буфера>)
+func WrappedMakeChan[C ~chan T, T any](name string, ch C) C {
 	caller := getCallerInfo(1)
 	buffer := cap(ch)
-	timestamp := time.Now().UnixNano()
+	timestamp := now()
 
-	fmt.Printf("[GTRACE] channel_create %s %s %d %d\n",
-		name, caller, timestamp, buffer)
+	emit("channel_create", chanID(ch, true), name, caller, timestamp, buffer)
 
 	return ch
 }
 
-// WrappedSend логирует отправку в канал (формат: [GTRACE] channel_send <контекст> <канал> <файл:строка> <timestamp>)
-func WrappedSend[T any](ch chan<- T, val T, name string) {
+// Sender — отложенная отправка в канал: значение передаётся в Send, где к нему применяются
+// обычные правила присваивания Go
+type Sender[T any] struct {
+	ch   chan<- T
+	name string
+}
+
+// WrappedSend готовит отправку в канал: gtrace.WrappedSend(ch, name).Send(val)
+func WrappedSend[T any](ch chan<- T, name string) Sender[T] {
+	return Sender[T]{ch: ch, name: name}
+}
+
+// Send логирует начало отправки в канал и её завершение со временем ожидания
+// (формат: [GTRACE] channel_send <контекст> <канал> <место> <файл:строка> <timestamp>,
+// затем [GTRACE] channel_send_done <контекст> <канал> <место> <файл:строка> <timestamp> <ожидание_нс>)
+func (s Sender[T]) Send(val T) {
 	caller := getCallerInfo(1)
 	goroutine := getGoroutineName()
-	timestamp := time.Now().UnixNano()
+	id := chanID(s.ch, false)
+	start := time.Now()
 
-	fmt.Printf("[GTRACE] channel_send %s %s %s %d\n",
-		goroutine, name, caller, timestamp)
+	emit("channel_send", goroutine, id, s.name, caller, stamp(start.UnixNano()))
 
-	ch <- val
+	s.ch <- val
+	opDone("channel_send_done", goroutine, id, s.name, caller, start)
 }
 
-// WrappedReceive логирует получение из канала (формат: [GTRACE] channel_receive <контекст> <канал> <файл:строка> <timestamp>)
+// WrappedReceive логирует начало получения из канала и его завершение со временем ожидания
+// (формат: [GTRACE] channel_receive <контекст> <канал> <место> <файл:строка> <timestamp>,
+// затем [GTRACE] channel_receive_done <контекст> <канал> <место> <файл:строка> <timestamp> <ожидание_нс>)
 func WrappedReceive[T any](ch <-chan T, name string) T {
 	caller := getCallerInfo(1)
 	goroutine := getGoroutineName()
-	timestamp := time.Now().UnixNano()
+	id := chanID(ch, false)
+	start := time.Now()
 
-	fmt.Printf("[GTRACE] channel_receive %s %s %s %d\n",
-		goroutine, name, caller, timestamp)
+	emit("channel_receive", goroutine, id, name, caller, stamp(start.UnixNano()))
 
-	return <-ch
+	v := <-ch
+	opDone("channel_receive_done", goroutine, id, name, caller, start)
+	return v
 }
 
-// WrappedClose логирует закрытие канала (формат: [GTRACE] channel_close <контекст> <канал> <файл:строка> <timestamp>)
+// WrappedReceiveOk логирует получение из канала в форме v, ok := <-ch (формат тот же, что у WrappedReceive)
+func WrappedReceiveOk[T any](ch <-chan T, name string) (T, bool) {
+	caller := getCallerInfo(1)
+	goroutine := getGoroutineName()
+	id := chanID(ch, false)
+	start := time.Now()
+
+	emit("channel_receive", goroutine, id, name, caller, stamp(start.UnixNano()))
+
+	v, ok := <-ch
+	opDone("channel_receive_done", goroutine, id, name, caller, start)
+	return v, ok
+}
+
+// opDone логирует завершение операции с каналом и время, которое горутина ждала её выполнения
+func opDone(event string, goroutine uint64, id uint64, name string, caller string, start time.Time) {
+	end := time.Now()
+	emit(event, goroutine, id, name, caller, stamp(end.UnixNano()), end.Sub(start).Nanoseconds())
+}
+
+// WrappedClose логирует закрытие канала; закрытие не блокирует, поэтому события завершения у него нет (формат: [GTRACE] channel_close <контекст> <канал> <место> <файл:строка> <timestamp>)
 func WrappedClose[T any](ch chan<- T, name string) {
 	caller := getCallerInfo(1)
 	goroutine := getGoroutineName()
-	timestamp := time.Now().UnixNano()
+	timestamp := now()
+	id := chanID(ch, false)
 
-	fmt.Printf("[GTRACE] channel_close %s %s %s %d\n",
-		goroutine, name, caller, timestamp)
+	emit("channel_close", goroutine, id, name, caller, timestamp)
 
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Printf("[GTRACE] channel_close_error %s %s %s %d %v\n",
-				goroutine, name, caller, timestamp, r)
+			emit("channel_close_error", goroutine, id, name, caller, timestamp, text(fmt.Sprint(r)))
 		}
 	}()
 
 	close(ch)
 }
 
+// SelectEnter логирует вход в select с ветками, построенными SelectCase; ветка default в список не входит
+// (формат: [GTRACE] select_enter <контекст> <select> <файл:строка> <timestamp> <число_веток> <send|receive>:<канал>,...)
+func SelectEnter(name string, cases int, ops ...string) {
+	caller := getCallerInfo(1)
+	goroutine := getGoroutineName()
+	timestamp := now()
+	list := "-"
+	if len(ops) > 0 {
+		list = strings.Join(ops, ",")
+	}
+
+	emit("select_enter", goroutine, name, caller, timestamp, cases, text(list))
+}
+
+// SelectCase описывает ветку select для SelectEnter: направление и канал (0 для nil-канала)
+func SelectCase(dir string, ch any) string {
+	return fmt.Sprintf("%s:%d", dir, chanID(ch, false))
+}
+
+// SelectCaseChosen логирует выбранную ветку select; время ожидания select считается при разборе от select_enter (формат: [GTRACE] select_case_chosen <контекст> <ветка> <файл:строка> <timestamp> <send|receive> <канал>)
+func SelectCaseChosen(ch any, dir string, name string) {
+	caller := getCallerInfo(1)
+	goroutine := getGoroutineName()
+	timestamp := now()
+
+	emit("select_case_chosen", goroutine, name, caller, timestamp, dir, chanID(ch, false))
+}
+
+// SelectDefault логирует выбор ветки default (формат: [GTRACE] select_default <контекст> <ветка> <файл:строка> <timestamp>)
+func SelectDefault(name string) {
+	caller := getCallerInfo(1)
+	goroutine := getGoroutineName()
+	timestamp := now()
+
+	emit("select_default", goroutine, name, caller, timestamp)
+}
+
 `
-	filePath := filepath.Join(dirPath, "gtrace.go")
-	return os.WriteFile(filePath, []byte(code), 0o644)
+	if err := os.WriteFile(filepath.Join(dirPath, "gtrace.go"), []byte(code), 0o644); err != nil {
+		return err
+	}
+	files := map[string]string{
+		"sink.go":         sinkCode,
+		"sink_unix.go":    sinkUnixCode,
+		"sink_windows.go": sinkWindowsCode,
+		"chan.go":         chanCode,
+		"chan_legacy.go":  chanLegacyCode,
+	}
+	for name, code := range files {
+		if err := os.WriteFile(filepath.Join(dirPath, name), []byte(code), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Вспомогательная функция для относительного пути
@@ -177,14 +358,38 @@ func relPath(projectRoot, filePath string) string {
 	return rel
 }
 
-// Вспомогательная функция для получения имени переменной из ast.Expr
-func exprToString(expr ast.Expr) string {
-	switch v := expr.(type) {
-	case *ast.Ident:
-		return v.Name
-	default:
-		return "" // можно доработать для других случаев
+// Вспомогательная функция для строкового литерала "файл:строка"
+func siteLiteral(rel string, line int) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("\"%s:%d\"", rel, line)}
+}
+
+// Вспомогательная функция для имени запускаемой функции в go_spawn (без пробелов, чтобы не ломать формат строки)
+func spawnFuncName(fun ast.Expr) string {
+	if _, ok := unparen(fun).(*ast.FuncLit); ok {
+		return "anonymous"
+	}
+	return strings.Join(strings.Fields(types.ExprString(fun)), "")
+}
+
+// Вспомогательная функция для снятия скобок с выражения
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		p, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.X
+	}
+}
+
+// gtraceImportPath возвращает путь импорта сгенерированного пакета gtrace внутри проекта
+func gtraceImportPath(projectRoot string) string {
+	if data, err := os.ReadFile(filepath.Join(projectRoot, "go.mod")); err == nil {
+		if modulePath := modulePath(data); modulePath != "" {
+			return modulePath + "/gtrace"
+		}
 	}
+	return "gtrace"
 }
 
 func modulePath(modFile []byte) string {
@@ -0,0 +1,572 @@
+package instrumented
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// buildFixture инструментирует проект testdata/<name> и собирает его; возвращает путь к программе
+func buildFixture(t *testing.T, name string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("запуск инструментированной программы")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go не найден")
+	}
+	out := filepath.Join(t.TempDir(), name)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := New(logger).Processed(filepath.Join("testdata", name), out); err != nil {
+		t.Fatalf("инструментирование %s: %v", name, err)
+	}
+
+	// программа собирается отдельно: go run не передаёт программе сигнал остановки по таймауту
+	bin := filepath.Join(out, name+".bin")
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Dir = out
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("сборка %s: %v\n%s", name, err, output)
+	}
+	return bin
+}
+
+// runFixture собирает проект testdata/<name>, запускает его с переменными окружения env
+// и возвращает вывод программы и ошибку запуска. Зависшая программа — ошибка теста
+func runFixture(t *testing.T, name string, env ...string) (string, error) {
+	t.Helper()
+	bin := buildFixture(t, name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Dir = filepath.Dir(bin)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		t.Fatalf("%s завис:\n%s", name, output)
+	}
+	return string(output), err
+}
+
+// Сгенерированный пакет gtrace собирается для всех поддерживаемых систем, а не только для текущей
+func TestGeneratedPackageCrossCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("сборка для нескольких систем")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go не найден")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module crossbuild\n\ngo 1.24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateGtracePackage(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, goos := range []string{"linux", "darwin", "freebsd", "windows"} {
+		t.Run(goos, func(t *testing.T) {
+			build := exec.Command("go", "build", "./gtrace")
+			build.Dir = dir
+			build.Env = append(os.Environ(), "GOOS="+goos, "GOARCH=amd64", "CGO_ENABLED=0")
+			if output, err := build.CombinedOutput(); err != nil {
+				t.Fatalf("сборка для %s: %v\n%s", goos, err, output)
+			}
+		})
+	}
+}
+
+// update перезаписывает эталонные файлы инструментированного кода: go test -run TestRewriteGolden -update
+var update = flag.Bool("update", false, "перезаписать эталонные файлы testdata/*.golden")
+
+// Инструментирование обходит всё AST и определяет каналы по типам: операции с каналами
+// в литералах функций на любой глубине, в ветках case и под метками (closures), а также с каналами
+// из полей, map, срезов, результатов функций, алиасов и обобщённых функций (typed) заменяются
+// так же, как в телах функций; затенённые make и close остаются на месте
+func TestRewriteGolden(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go не найден")
+	}
+	for _, name := range []string{"closures", "typed"} {
+		t.Run(name, func(t *testing.T) {
+			out := t.TempDir()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			if err := New(logger).Processed(filepath.Join("testdata", name), out); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(out, "main.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("инструментированный код отличается от %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// Инструментированный код эталонных проектов собирается и работает как исходный
+func TestRewriteGoldenRuns(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{name: "closures", output: "1\n2\n3\n4\n"},
+		{name: "typed", output: "1\n2\n3\nfour\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := runFixture(t, tt.name, SinkEnv+"=file:"+filepath.Join(t.TempDir(), "trace.log"))
+			if err != nil {
+				t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+			}
+			if output != tt.output {
+				t.Fatalf("вывод %q, ожидался %q", output, tt.output)
+			}
+		})
+	}
+}
+
+// Трассировка не должна мешать рантайму обнаружить взаимную блокировку: приёмник трассы
+// не запускает планировщик сети, в том числе при отправке трассы по TCP
+func TestDeadlockIsReported(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	sinks := map[string]string{
+		"file": "file:" + filepath.Join(t.TempDir(), "trace.log"),
+		"tcp":  "file:" + filepath.Join(t.TempDir(), "trace.log") + ",tcp:" + ln.Addr().String(),
+	}
+	for name, sink := range sinks {
+		t.Run(name, func(t *testing.T) {
+			output, err := runFixture(t, "deadlock", SinkEnv+"="+sink)
+			if err == nil {
+				t.Fatalf("программа завершилась без ошибки:\n%s", output)
+			}
+			if !strings.Contains(output, "all goroutines are asleep") {
+				t.Fatalf("нет сообщения о взаимной блокировке:\n%s", output)
+			}
+		})
+	}
+}
+
+// Имя метода обобщённого типа содержит пробелы; строгий разбор трассы не должен терять
+// go_spawn, func_start и func_end такой горутины ни в текстовом, ни в двоичном формате
+func TestGenericMethodName(t *testing.T) {
+	const want = "(*example.com/gp.Pair[string, int]).Run"
+	for _, format := range []string{"text", "binary"} {
+		t.Run(format, func(t *testing.T) {
+			trace := filepath.Join(t.TempDir(), "trace.log")
+			output, err := runFixture(t, "generic", SinkEnv+"=file:"+trace, FormatEnv+"="+format)
+			if err != nil {
+				t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+			}
+			file, err := os.Open(trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			events, err := parser.NewEventReader(file, parser.ModeStrict)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			found := map[string]bool{}
+			for {
+				ev, err := events.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("строгий разбор трассы: %v", err)
+				}
+				switch ev := ev.(type) {
+				case *domain.GoSpawnEvent:
+					found[ev.Kind()] = found[ev.Kind()] || ev.Func == want
+				case *domain.FuncStartEvent:
+					found[ev.Kind()] = found[ev.Kind()] || ev.Func == want
+				case *domain.FuncEndEvent:
+					found[ev.Kind()] = found[ev.Kind()] || ev.Func == want
+				}
+			}
+			for _, kind := range []string{"go_spawn", "func_start", "func_end"} {
+				if !found[kind] {
+					t.Errorf("нет %s с функцией %q", kind, want)
+				}
+			}
+		})
+	}
+}
+
+// go_spawn и func_start горутины называют функцию одинаково; литералы функций называются
+// по месту в исходнике, как замыкания у компилятора Go
+func TestSpawnNames(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace.log")
+	output, err := runFixture(t, "spawnnames", SinkEnv+"=file:"+trace)
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	spawned, started := map[uint64]string{}, map[uint64]string{}
+	for _, ev := range traceEvents(t, trace) {
+		switch ev := ev.(type) {
+		case *domain.GoSpawnEvent:
+			spawned[ev.Spawn] = ev.Func
+		case *domain.FuncStartEvent:
+			started[ev.Spawn] = ev.Func
+		}
+	}
+	if !reflect.DeepEqual(spawned, started) {
+		t.Fatalf("функции go_spawn %v и func_start %v не совпадают", spawned, started)
+	}
+	var names []string
+	for _, name := range spawned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := "(*spawnnames.pool).run.func1, done, spawnnames.init.func1.1, spawnnames.main.func1, spawnnames.main.func1.1"
+	if got := strings.Join(names, ", "); got != want {
+		t.Fatalf("функции горутин %s, ожидались %s", got, want)
+	}
+}
+
+// Завершение горутины через runtime.Goexit и паникой записывается с причиной; паника со значением
+// и стеком записывается до того, как поднимется заново и завершит процесс, как без трассировки
+func TestGoroutineEndReasons(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace.log")
+	output, err := runFixture(t, "panics", SinkEnv+"=file:"+trace)
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 2 {
+		t.Fatalf("завершение %v, ожидался код 2:\n%s", err, output)
+	}
+	if !strings.Contains(output, "panic: boom 42") {
+		t.Fatalf("нет сообщения о панике:\n%s", output)
+	}
+
+	ends := map[string]*domain.FuncEndEvent{}
+	for _, ev := range traceEvents(t, trace) {
+		if ev, ok := ev.(*domain.FuncEndEvent); ok {
+			ends[ev.Func] = ev
+		}
+	}
+	if quit := ends["panics.quit"]; quit == nil || quit.Reason != "goexit" {
+		t.Errorf("завершение quit: %+v", quit)
+	}
+	crash := ends["panics.crash"]
+	if crash == nil || crash.Reason != "panic" || crash.PanicValue != "boom 42" || !strings.Contains(crash.Stack, "main.crash(") {
+		t.Fatalf("завершение crash: %+v", crash)
+	}
+
+	graph, err := parser.NewParser(slog.New(slog.NewTextHandler(io.Discard, nil))).ParseFromFile(trace, parser.ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := map[string]domain.GoroutineState{}
+	for _, gr := range graph.Gorutines {
+		states[gr.Func] = gr.State
+		if gr.State == domain.StatePanicked && gr.PanicValue != "boom 42" {
+			t.Errorf("значение паники %q", gr.PanicValue)
+		}
+	}
+	if states["panics.quit"] != domain.StateGoexit || states["panics.crash"] != domain.StatePanicked {
+		t.Errorf("итоговые состояния горутин: %v", states)
+	}
+}
+
+// Аргумент go-оператора с несколькими результатами вычисляется заранее в отдельные переменные
+func TestGoMultiValueArgument(t *testing.T) {
+	output, err := runFixture(t, "gotuple", SinkEnv+"=file:"+filepath.Join(t.TempDir(), "trace.log"))
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	if want := "1 one\n1 one\n"; output != want {
+		t.Fatalf("вывод %q, ожидался %q", output, want)
+	}
+}
+
+// Аргумент go-оператора, тип которого объявлен в неимпортированном пакете, приводится к типу
+// через добавленный импорт и вычисляется заранее
+func TestGoArgumentTypeImport(t *testing.T) {
+	output, err := runFixture(t, "goimport", SinkEnv+"=file:"+filepath.Join(t.TempDir(), "trace.log"))
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	if want := "2ns\n"; output != want {
+		t.Fatalf("вывод %q, ожидался %q", output, want)
+	}
+}
+
+// Каналы и отправляемые значения веток select вычисляются один раз и в порядке исходника
+func TestSelectOperandOrder(t *testing.T) {
+	output, err := runFixture(t, "selectorder", SinkEnv+"=file:"+filepath.Join(t.TempDir(), "trace.log"))
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	if want := "chA f chB 1\ntrue\n"; output != want {
+		t.Fatalf("вывод %q, ожидался %q", output, want)
+	}
+}
+
+// Тело range по каналу может объявлять имена, которые вводит инструментирование
+func TestRangeBodyRedeclares(t *testing.T) {
+	output, err := runFixture(t, "rangeshadow", SinkEnv+"=file:"+filepath.Join(t.TempDir(), "trace.log"))
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	if want := "60\n"; output != want {
+		t.Fatalf("вывод %q, ожидался %q", output, want)
+	}
+}
+
+// traceEvents строго разбирает файл трассы
+func traceEvents(t *testing.T, path string) []domain.Event {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := parser.NewEventReader(file, parser.ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []domain.Event
+	for {
+		ev, err := events.Next()
+		if err == io.EOF {
+			return all
+		}
+		if err != nil {
+			t.Fatalf("строгий разбор трассы: %v", err)
+		}
+		all = append(all, ev)
+	}
+}
+
+// Канал, созданный вне инструментированного кода на адресе собранного канала, не получает его номер:
+// каждая отправка в fixture идёт в свой канал, поэтому номера каналов у отправок не повторяются
+func TestChannelAddressReuse(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace.log")
+	output, err := runFixture(t, "chanreuse", SinkEnv+"=file:"+trace)
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	var reused, total int
+	if _, err := fmt.Sscanf(output, "reused %d of %d", &reused, &total); err != nil {
+		t.Fatalf("вывод %q: %v", output, err)
+	}
+	if reused == 0 {
+		t.Skip("адреса собранных каналов не достались новым")
+	}
+
+	sends := 0
+	channels := map[domain.ChannelID]bool{}
+	for _, ev := range traceEvents(t, trace) {
+		if send, ok := ev.(*domain.ChannelSendEvent); ok {
+			sends++
+			channels[send.Channel] = true
+		}
+	}
+	if sends != total || len(channels) != sends {
+		t.Fatalf("%d отправок в %d каналов (адресов переиспользовано: %d), ожидались %d разных каналов",
+			sends, len(channels), reused, total)
+	}
+}
+
+// При переполнении очереди трассы горутины ждут записи: события не теряются, а события
+// каждой горутины записаны в том порядке, в котором она их логировала
+func TestSinkOverflow(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace.log")
+	// шардов очереди столько же, сколько P: несколько шардов и на одном процессоре
+	output, err := runFixture(t, "burst", SinkEnv+"=file:"+trace, "GOMAXPROCS=8")
+	if err != nil {
+		t.Fatalf("программа завершилась с ошибкой %v:\n%s", err, output)
+	}
+	const sends = 8 * 5000
+	if want := fmt.Sprintln(sends); output != want {
+		t.Fatalf("вывод %q, ожидался %q", output, want)
+	}
+
+	done, started := 0, map[domain.GoroutineID]bool{}
+	for _, ev := range traceEvents(t, trace) {
+		switch ev := ev.(type) {
+		case *domain.ChannelSendEvent:
+			if started[ev.Goroutine] {
+				t.Fatalf("горутина %s начала отправку, не завершив предыдущую", ev.Goroutine)
+			}
+			started[ev.Goroutine] = true
+		case *domain.ChannelOpDoneEvent:
+			if ev.Dir != "send" {
+				break
+			}
+			if !started[ev.Goroutine] {
+				t.Fatalf("горутина %s завершила отправку раньше, чем начала", ev.Goroutine)
+			}
+			started[ev.Goroutine] = false
+			done++
+		}
+	}
+	if done != sends {
+		t.Fatalf("в трассе %d отправок, ожидалось %d", done, sends)
+	}
+}
+
+// По сигналу трасса сбрасывается. Сигнал, на который программа не подписана, завершает её,
+// как без трассировки, и логируется как завершение программы; сигнал, на который подписана,
+// программа обрабатывает сама
+func TestSignalFlush(t *testing.T) {
+	bin := buildFixture(t, "signals")
+	tests := []struct {
+		name   string
+		args   []string
+		env    []string
+		signal syscall.Signal
+		// killed — программа завершена сигналом; output — её вывод после "ready"
+		killed bool
+		output string
+		reason string
+		code   int
+	}{
+		{name: "GTRACE_SIGNALS", env: []string{SignalsEnv + "=1"}, signal: syscall.SIGTERM, killed: true, reason: "signal", code: 143},
+		{name: "подписка программы", args: []string{"notify"}, signal: syscall.SIGINT, output: "interrupted 4950\n", reason: "return"},
+		{name: "сигнал вне подписки", args: []string{"notify"}, signal: syscall.SIGTERM, killed: true, reason: "signal", code: 143},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := filepath.Join(t.TempDir(), "trace.log")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, bin, tt.args...)
+			cmd.Env = append(os.Environ(), append(tt.env, SinkEnv+"=file:"+trace)...)
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			out := bufio.NewReader(stdout)
+			if line, err := out.ReadString('\n'); line != "ready\n" {
+				t.Fatalf("программа не готова: %q, %v", line, err)
+			}
+			if err := cmd.Process.Signal(tt.signal); err != nil {
+				t.Fatal(err)
+			}
+			rest, _ := io.ReadAll(out)
+			err = cmd.Wait()
+			if ctx.Err() != nil {
+				t.Fatal("программа не завершилась по сигналу")
+			}
+
+			var status syscall.WaitStatus
+			if exit, ok := err.(*exec.ExitError); ok {
+				status, _ = exit.Sys().(syscall.WaitStatus)
+			}
+			if killed := status.Signaled() && status.Signal() == tt.signal; killed != tt.killed || !killed && err != nil {
+				t.Fatalf("завершение %v, ожидалось завершение сигналом: %v", err, tt.killed)
+			}
+			if string(rest) != tt.output {
+				t.Fatalf("вывод %q, ожидался %q", rest, tt.output)
+			}
+
+			var shutdown *domain.ShutdownEvent
+			sends := 0
+			for _, ev := range traceEvents(t, trace) {
+				switch ev := ev.(type) {
+				case *domain.ShutdownEvent:
+					shutdown = ev
+				case *domain.ChannelOpDoneEvent:
+					if ev.Dir == "send" {
+						sends++
+					}
+				}
+			}
+			if sends != 100 {
+				t.Errorf("в трассе %d отправок, ожидалось 100", sends)
+			}
+			if shutdown == nil || shutdown.Reason != tt.reason || shutdown.Code != tt.code {
+				t.Fatalf("завершение программы %+v, ожидалось %s с кодом %d", shutdown, tt.reason, tt.code)
+			}
+		})
+	}
+}
+
+// log.Fatal* и Fatal* логгера пишут сообщение с местом вызова, логируют завершение программы
+// с причиной fatal в том же месте и сбрасывают трассу до выхода с кодом 1
+func TestFatalShutdown(t *testing.T) {
+	bin := buildFixture(t, "fatal")
+	tests := []struct {
+		name    string
+		args    []string
+		prefix  string
+		message string
+	}{
+		{name: "log.Fatalf", message: "stop 1"},
+		{name: "Logger.Fatalln", args: []string{"logger"}, prefix: "app: ", message: "stop 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := filepath.Join(t.TempDir(), "trace.log")
+			cmd := exec.Command(bin, tt.args...)
+			cmd.Env = append(os.Environ(), SinkEnv+"=file:"+trace)
+			output, err := cmd.CombinedOutput()
+			if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+				t.Fatalf("завершение %v, ожидался код 1:\n%s", err, output)
+			}
+			// место вызова в сообщении — строка инструментированного main.go
+			line, ok := strings.CutPrefix(string(output), tt.prefix)
+			site, message, _ := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+			if !ok || !strings.HasPrefix(site, "main.go:") || message != tt.message {
+				t.Fatalf("вывод %q, ожидалось %q с местом вызова", output, tt.prefix+tt.message)
+			}
+
+			var shutdown *domain.ShutdownEvent
+			for _, ev := range traceEvents(t, trace) {
+				if ev, ok := ev.(*domain.ShutdownEvent); ok {
+					shutdown = ev
+				}
+			}
+			if shutdown == nil || shutdown.Reason != "fatal" || shutdown.Code != 1 || !strings.HasSuffix(string(shutdown.Caller), "/"+site) {
+				t.Fatalf("завершение программы %+v, ожидалось fatal с кодом 1 в %s", shutdown, site)
+			}
+		})
+	}
+}
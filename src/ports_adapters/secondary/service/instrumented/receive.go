@@ -0,0 +1,99 @@
+package instrumented
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// receiveOkCall строит gtrace.WrappedReceiveOk для правой части вида v, ok := <-ch
+func (r *rewriter) receiveOkCall(expr ast.Expr) *ast.CallExpr {
+	recv, ok := unparen(expr).(*ast.UnaryExpr)
+	if !ok || recv.Op != token.ARROW {
+		return nil
+	}
+	return &ast.CallExpr{
+		Fun: ast.NewIdent("gtrace.WrappedReceiveOk"),
+		Args: []ast.Expr{
+			recv.X,
+			r.site(recv.Pos()),
+		},
+	}
+}
+
+// instrumentRangeChan превращает for v := range ch в цикл с явным получением через gtrace.WrappedReceiveOk.
+// Канал вычисляется один раз, как и в исходном range. Тело цикла остаётся отдельным блоком:
+// объявления в нём (например, v := v) не сталкиваются с переменными получения.
+//
+//	for gtraceRange0 := ch; ; {
+//		v, gtraceOk0 := gtrace.WrappedReceiveOk(gtraceRange0, "main.go:10")
+//		if !gtraceOk0 {
+//			break
+//		}
+//		{
+//			...
+//		}
+//	}
+func (r *rewriter) instrumentRangeChan(s *ast.RangeStmt, index int) *ast.ForStmt {
+	rangeVar := fmt.Sprintf("gtraceRange%d", index)
+	okVar := fmt.Sprintf("gtraceOk%d", index)
+	// сгенерированные операторы стоят на строке for: без позиций printer переносит комментарий
+	// после открывающей скобки цикла внутрь присваивания
+	ident := func(name string) *ast.Ident {
+		return &ast.Ident{NamePos: s.For, Name: name}
+	}
+
+	var value ast.Expr = ident("_")
+	var bind ast.Stmt
+	key, isIdent := s.Key.(*ast.Ident)
+	switch {
+	case s.Key == nil || isIdent && key.Name == "_":
+	case s.Tok == token.DEFINE:
+		value = ident(key.Name)
+	default:
+		tmp := fmt.Sprintf("gtraceValue%d", index)
+		value = ident(tmp)
+		bind = &ast.AssignStmt{
+			Lhs: []ast.Expr{s.Key},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{ident(tmp)},
+		}
+	}
+
+	receive := &ast.AssignStmt{
+		Lhs: []ast.Expr{value, ident(okVar)},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: ident("gtrace.WrappedReceiveOk"),
+				Args: []ast.Expr{
+					ident(rangeVar),
+					r.site(s.Pos()),
+				},
+			},
+		},
+	}
+	stop := &ast.IfStmt{
+		If:   s.For,
+		Cond: &ast.UnaryExpr{OpPos: s.For, Op: token.NOT, X: ident(okVar)},
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{TokPos: s.For, Tok: token.BREAK}}},
+	}
+
+	list := []ast.Stmt{receive, stop}
+	if bind != nil {
+		list = append(list, bind)
+	}
+	if s.Body != nil {
+		list = append(list, &ast.BlockStmt{Lbrace: s.Body.Lbrace, List: s.Body.List, Rbrace: s.Body.Rbrace})
+	}
+
+	return &ast.ForStmt{
+		For: s.For,
+		Init: &ast.AssignStmt{
+			Lhs: []ast.Expr{ident(rangeVar)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{s.X},
+		},
+		Body: &ast.BlockStmt{List: list},
+	}
+}
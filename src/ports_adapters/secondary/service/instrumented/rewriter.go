@@ -0,0 +1,433 @@
+package instrumented
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// rewriter обходит всё AST файла и заменяет операции с каналами и запуски горутин на вызовы gtrace.
+// Узлы обрабатываются на выходе (post-order), поэтому к моменту замены оператора
+// всё, что вложено в него (включая литералы функций), уже инструментировано.
+// Что является каналом и встроенной функцией, определяется по информации о типах.
+type rewriter struct {
+	fset     *token.FileSet
+	info     *types.Info
+	pkg      *types.Package
+	rel      string
+	tmpIndex int
+	modified bool
+	// hookedImports — пакеты, вызовы из которых заменены вызовами gtrace (см. hooks); их импорт
+	// может оказаться неиспользуемым
+	hookedImports map[string]bool
+
+	// commOps — операции в заголовках веток select, которые должны остаться как есть
+	commOps map[ast.Node]bool
+	// chanRanges — циклы range по каналу; запоминаются до замены вложенных выражений,
+	// пока для них ещё есть информация о типах
+	chanRanges map[*ast.RangeStmt]bool
+	// imports — имена, под которыми пакеты импортированы в файл ("" для точечного импорта)
+	imports map[string]string
+	// litNames — имена литералов функций (см. nameFuncLits)
+	litNames map[*ast.FuncLit]string
+
+	file *ast.File
+	// importAliases — число импортов, добавленных в файл, чтобы назвать тип (см. typeName)
+	importAliases int
+	// err — первая ошибка инструментирования: на ней обход прекращается
+	err error
+}
+
+func newRewriter(fset *token.FileSet, info *types.Info, pkg *types.Package, rel string) *rewriter {
+	return &rewriter{
+		fset:          fset,
+		info:          info,
+		pkg:           pkg,
+		rel:           rel,
+		imports:       make(map[string]string),
+		hookedImports: make(map[string]bool),
+		commOps:       make(map[ast.Node]bool),
+		chanRanges:    make(map[*ast.RangeStmt]bool),
+		litNames:      make(map[*ast.FuncLit]string),
+	}
+}
+
+// rewrite инструментирует файл и сообщает, был ли он изменён. Ошибка — файл нельзя
+// инструментировать, не изменив поведение программы
+func (r *rewriter) rewrite(file *ast.File) (bool, error) {
+	r.file = file
+	for _, spec := range file.Imports {
+		pkgName := r.info.PkgNameOf(spec)
+		switch {
+		case pkgName != nil:
+			r.imports[pkgName.Imported().Path()] = pkgName.Name()
+		case spec.Name != nil && spec.Name.Name == ".":
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+				r.imports[path] = ""
+			}
+		}
+	}
+
+	r.nameFuncLits(file)
+	astutil.Apply(file, r.pre, r.post)
+	if r.err != nil {
+		return false, r.err
+	}
+	for path := range r.hookedImports {
+		if !astutil.UsesImport(file, path) {
+			astutil.DeleteImport(r.fset, file, path)
+		}
+	}
+	return r.modified, nil
+}
+
+// nameFuncLits называет литералы функций файла так же, как компилятор Go называет замыкания:
+// <функция>.func1, <функция>.func2 по порядку в исходнике, вложенные — <литерал>.1; литералы
+// в объявлениях уровня пакета — <пакет>.init.funcN. Имена назначаются до инструментирования,
+// поэтому сгенерированные замыкания не сдвигают нумерацию
+func (r *rewriter) nameFuncLits(file *ast.File) {
+	for _, decl := range file.Decls {
+		prefix := r.pkg.Path() + ".init"
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			if fn, ok := r.info.Defs[fd.Name].(*types.Func); ok {
+				prefix = fn.FullName()
+			}
+		}
+		r.nameNestedLits(decl, prefix+".func")
+	}
+}
+
+func (r *rewriter) nameNestedLits(root ast.Node, prefix string) {
+	n := 0
+	ast.Inspect(root, func(node ast.Node) bool {
+		lit, ok := node.(*ast.FuncLit)
+		if !ok || node == root {
+			return true
+		}
+		n++
+		name := prefix + strconv.Itoa(n)
+		r.litNames[lit] = name
+		r.nameNestedLits(lit, name+".")
+		return false
+	})
+}
+
+func (r *rewriter) site(pos token.Pos) *ast.BasicLit {
+	lit := siteLiteral(r.rel, r.fset.Position(pos).Line)
+	// у литерала позиция исходной операции: без неё printer переносит следующий комментарий внутрь вызова
+	lit.ValuePos = pos
+	return lit
+}
+
+func (r *rewriter) pre(c *astutil.Cursor) bool {
+	switch n := c.Node().(type) {
+	case *ast.CommClause:
+		switch comm := n.Comm.(type) {
+		case *ast.SendStmt:
+			r.commOps[comm] = true
+		case *ast.ExprStmt:
+			r.commOps[unparen(comm.X)] = true
+		case *ast.AssignStmt:
+			if len(comm.Rhs) == 1 {
+				r.commOps[unparen(comm.Rhs[0])] = true
+			}
+		}
+
+	case *ast.RangeStmt:
+		if chanOf(r.info.TypeOf(n.X)) != nil {
+			r.chanRanges[n] = true
+		}
+
+	// v, ok := <-ch — заменяем на gtrace.WrappedReceiveOk до того, как <-ch попадёт в post
+	case *ast.AssignStmt:
+		if len(n.Lhs) == 2 && len(n.Rhs) == 1 && !r.commOps[unparen(n.Rhs[0])] {
+			if call := r.receiveOkCall(n.Rhs[0]); call != nil {
+				n.Rhs[0] = call
+				r.modified = true
+			}
+		}
+	case *ast.ValueSpec:
+		if len(n.Names) == 2 && len(n.Values) == 1 {
+			if call := r.receiveOkCall(n.Values[0]); call != nil {
+				n.Values[0] = call
+				r.modified = true
+			}
+		}
+	}
+	return true
+}
+
+func (r *rewriter) post(c *astutil.Cursor) bool {
+	if r.err != nil {
+		return false
+	}
+	switch n := c.Node().(type) {
+	// 1. make(chan ...) — оборачиваем в gtrace.WrappedMakeChan, close(ch) — заменяем на gtrace.WrappedClose
+	case *ast.CallExpr:
+		if r.builtin(n.Fun) == "make" && isBidirectional(chanOf(r.info.TypeOf(n))) {
+			c.Replace(&ast.CallExpr{
+				Fun:  ast.NewIdent("gtrace.WrappedMakeChan"),
+				Args: []ast.Expr{r.site(n.Pos()), n},
+			})
+			r.modified = true
+			break
+		}
+		if r.builtin(n.Fun) == "close" && len(n.Args) == 1 {
+			c.Replace(&ast.CallExpr{
+				Fun:  ast.NewIdent("gtrace.WrappedClose"),
+				Args: []ast.Expr{n.Args[0], r.site(n.Pos())},
+			})
+			r.modified = true
+			break
+		}
+		// os.Exit(code), log.Fatal(...), signal.Notify(c, ...) и другие функции из hooks — заменяем
+		// на функции gtrace с той же сигнатурой
+		if path, ok := r.hook(n); ok {
+			r.hookedImports[path] = true
+			r.modified = true
+		}
+
+	// 2. go ... — заменяем на запуск сгенерированного замыкания (см. instrumentGo). gtrace.Spawn
+	// вычисляется как аргумент go-оператора, то есть в родительской горутине, и связывает её с дочерней
+	case *ast.GoStmt:
+		if n.Call == nil {
+			break
+		}
+		c.Replace(r.instrumentGo(n))
+		r.modified = true
+
+	// 3. ch <- val — заменяем на gtrace.WrappedSend(ch, ...).Send(val): значение передаётся
+	// отдельным вызовом, чтобы к нему применялись обычные правила присваивания (интерфейсы, nil, константы)
+	case *ast.SendStmt:
+		if r.commOps[n] {
+			break
+		}
+		c.Replace(&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: &ast.CallExpr{
+						Fun:  ast.NewIdent("gtrace.WrappedSend"),
+						Args: []ast.Expr{n.Chan, r.site(n.Pos())},
+					},
+					Sel: ast.NewIdent("Send"),
+				},
+				Args: []ast.Expr{n.Value},
+			},
+		})
+		r.modified = true
+
+	// 4. <-ch — заменяем на gtrace.WrappedReceive
+	case *ast.UnaryExpr:
+		if n.Op != token.ARROW || r.commOps[n] {
+			break
+		}
+		c.Replace(&ast.CallExpr{
+			Fun:  ast.NewIdent("gtrace.WrappedReceive"),
+			Args: []ast.Expr{n.X, r.site(n.Pos())},
+		})
+		r.modified = true
+
+	// 5. for v := range ch — заменяем на цикл с gtrace.WrappedReceiveOk
+	case *ast.RangeStmt:
+		if !r.chanRanges[n] {
+			break
+		}
+		c.Replace(r.instrumentRangeChan(n, r.tmpIndex))
+		r.tmpIndex++
+		r.modified = true
+
+	// 6. select — логируем вход и выбранную ветку. Метка select переносится на блок с ним,
+	// чтобы goto к ней выполнял вход в select заново (см. replaceSelect)
+	case *ast.SelectStmt:
+		if _, labeled := c.Parent().(*ast.LabeledStmt); labeled {
+			break
+		}
+		r.replaceSelect(c, n, nil)
+	case *ast.LabeledStmt:
+		if s, ok := n.Stmt.(*ast.SelectStmt); ok {
+			r.replaceSelect(c, s, n)
+		}
+
+	// 7. func main() — первой строкой добавляем defer gtrace.Shutdown(): событие завершения
+	// логируется при возврате из main и при панике в ней
+	case *ast.FuncDecl:
+		if r.pkg.Name() != "main" || n.Recv != nil || n.Name.Name != "main" || n.Body == nil {
+			break
+		}
+		shutdown := &ast.DeferStmt{Call: &ast.CallExpr{Fun: ast.NewIdent("gtrace.Shutdown")}}
+		n.Body.List = append([]ast.Stmt{shutdown}, n.Body.List...)
+		r.modified = true
+	}
+	return true
+}
+
+// replaceSelect заменяет select блоком с подготовкой веток. Метка select (label != nil) ставится
+// на блок: goto извне не может перейти внутрь блока, а goto изнутри select должен снова вычислить
+// каналы и залогировать вход. break с этой меткой внутри select переименовывается в break
+// с новой меткой самого select:
+//
+//	L:
+//		{
+//			gtrace.SelectEnter(...)
+//		gtraceSel0:
+//			select {
+//			case <-gtraceSel0Ch0:
+//				break gtraceSel0
+//			}
+//		}
+func (r *rewriter) replaceSelect(c *astutil.Cursor, s *ast.SelectStmt, label *ast.LabeledStmt) {
+	index := r.tmpIndex
+	prelude := r.instrumentSelect(s, index)
+	r.tmpIndex++
+	if prelude == nil {
+		return
+	}
+	block := &ast.BlockStmt{List: append(prelude, ast.Stmt(s))}
+	r.modified = true
+	if label == nil {
+		c.Replace(block)
+		return
+	}
+	if breaks := labeledBreaks(s.Body, label.Label.Name); len(breaks) > 0 {
+		name := fmt.Sprintf("gtraceSel%d", index)
+		for _, b := range breaks {
+			b.Label = ast.NewIdent(name)
+		}
+		block.List[len(block.List)-1] = &ast.LabeledStmt{Label: ast.NewIdent(name), Stmt: s}
+	}
+	label.Stmt = block
+}
+
+// labeledBreaks возвращает операторы break с меткой name внутри body; литералы функций
+// пропускаются: метки видны только в своей функции
+func labeledBreaks(body ast.Node, name string) []*ast.BranchStmt {
+	var breaks []*ast.BranchStmt
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BranchStmt:
+			if n.Tok == token.BREAK && n.Label != nil && n.Label.Name == name {
+				breaks = append(breaks, n)
+			}
+		}
+		return true
+	})
+	return breaks
+}
+
+// builtin возвращает имя встроенной функции, если выражение ссылается на неё (а не на затеняющее её имя)
+func (r *rewriter) builtin(fun ast.Expr) string {
+	ident, ok := unparen(fun).(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	if b, ok := r.info.Uses[ident].(*types.Builtin); ok {
+		return b.Name()
+	}
+	return ""
+}
+
+// hooks — функции и методы стандартной библиотеки (по types.Func.FullName), вызовы которых заменяются
+// функциями gtrace с той же сигнатурой; получатель метода передаётся первым аргументом. os.Exit
+// и log.Fatal* не выполняют отложенные вызовы main, поэтому событие завершения программы
+// логируется прямо перед выходом. Подписки программы на сигналы запоминаются: по сигналу трасса
+// сбрасывается, а сигнал, на который программа не подписана, завершает её, как без gtrace
+var hooks = map[string]string{
+	"os.Exit":                 "gtrace.Exit",
+	"log.Fatal":               "gtrace.Fatal",
+	"log.Fatalf":              "gtrace.Fatalf",
+	"log.Fatalln":             "gtrace.Fatalln",
+	"(*log.Logger).Fatal":     "gtrace.LoggerFatal",
+	"(*log.Logger).Fatalf":    "gtrace.LoggerFatalf",
+	"(*log.Logger).Fatalln":   "gtrace.LoggerFatalln",
+	"os/signal.Notify":        "gtrace.SignalNotify",
+	"os/signal.NotifyContext": "gtrace.SignalNotifyContext",
+	"os/signal.Stop":          "gtrace.SignalStop",
+	"os/signal.Reset":         "gtrace.SignalReset",
+}
+
+// hook заменяет вызов функции из hooks вызовом gtrace и возвращает путь пакета заменённой функции.
+// Метод, унаследованный через встроенное поле, не заменяется: получатель пришлось бы выбирать по пути полей
+func (r *rewriter) hook(call *ast.CallExpr) (string, bool) {
+	fn, ok := r.info.Uses[funcIdent(unparen(call.Fun))].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return "", false
+	}
+	hook, ok := hooks[fn.FullName()]
+	if !ok {
+		return "", false
+	}
+	if fn.Type().(*types.Signature).Recv() != nil {
+		sel, ok := unparen(call.Fun).(*ast.SelectorExpr)
+		if !ok {
+			return "", false
+		}
+		selection := r.info.Selections[sel]
+		if selection == nil || selection.Kind() != types.MethodVal || len(selection.Index()) != 1 {
+			return "", false
+		}
+		recv := sel.X
+		if _, ptr := selection.Recv().Underlying().(*types.Pointer); !ptr {
+			recv = &ast.UnaryExpr{Op: token.AND, X: recv}
+		}
+		call.Args = append([]ast.Expr{recv}, call.Args...)
+	}
+	call.Fun = ast.NewIdent(hook)
+	return fn.Pkg().Path(), true
+}
+
+// isGtraceCall проверяет, что вызов уже сгенерирован инструментированием
+func isGtraceCall(fun ast.Expr) bool {
+	ident, ok := fun.(*ast.Ident)
+	return ok && strings.HasPrefix(ident.Name, "gtrace.")
+}
+
+// chanOf возвращает тип канала для типа операнда: учитываются именованные типы, алиасы
+// и параметры типа, у которых все допустимые типы — каналы с одинаковым типом элемента
+func chanOf(t types.Type) *types.Chan {
+	if t == nil {
+		return nil
+	}
+	if tp, ok := t.(*types.TypeParam); ok {
+		return coreChan(tp)
+	}
+	ch, _ := t.Underlying().(*types.Chan)
+	return ch
+}
+
+func coreChan(tp *types.TypeParam) *types.Chan {
+	iface, ok := tp.Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+	var core *types.Chan
+	for j := 0; j < iface.NumEmbeddeds(); j++ {
+		var terms []types.Type
+		switch e := iface.EmbeddedType(j).(type) {
+		case *types.Union:
+			for k := 0; k < e.Len(); k++ {
+				terms = append(terms, e.Term(k).Type())
+			}
+		default:
+			terms = append(terms, e)
+		}
+		for _, term := range terms {
+			ch := chanOf(term)
+			if ch == nil || core != nil && !types.Identical(core.Elem(), ch.Elem()) {
+				return nil
+			}
+			core = ch
+		}
+	}
+	return core
+}
+
+func isBidirectional(ch *types.Chan) bool {
+	return ch != nil && ch.Dir() == types.SendRecv
+}
@@ -0,0 +1,128 @@
+package instrumented
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// instrumentSelect возвращает операторы, которые нужно выполнить перед select: каналы всех веток
+// и отправляемые значения вычисляются заранее (один раз и в порядке исходника, как это делает сам select),
+// затем логируется вход в select вместе с каналами веток. Первой строкой каждой ветки добавляется
+// логирование выбранной ветки. Вызывающий код оборачивает результат вместе с select в блок:
+//
+//	{
+//		gtraceSel0Ch0 := ch
+//		gtraceSel0Ch1 := out()
+//		gtraceSel0V1 := next()
+//		gtrace.SelectEnter("main.go:10", 3, gtrace.SelectCase("receive", gtraceSel0Ch0), gtrace.SelectCase("send", gtraceSel0Ch1))
+//		select {
+//		case v := <-gtraceSel0Ch0:
+//			gtrace.SelectCaseChosen(gtraceSel0Ch0, "receive", "main.go:11")
+//			...
+//		case gtraceSel0Ch1 <- gtraceSel0V1:
+//			gtrace.SelectCaseChosen(gtraceSel0Ch1, "send", "main.go:13")
+//			...
+//		default:
+//			gtrace.SelectDefault("main.go:15")
+//			...
+//		}
+//	}
+func (r *rewriter) instrumentSelect(s *ast.SelectStmt, index int) []ast.Stmt {
+	if s.Body == nil {
+		return nil
+	}
+
+	var prelude []ast.Stmt
+	var cases []ast.Expr
+	for j, commStmt := range s.Body.List {
+		commClause, ok := commStmt.(*ast.CommClause)
+		if !ok {
+			continue
+		}
+		if commClause.Comm == nil {
+			chosen := &ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun:  ast.NewIdent("gtrace.SelectDefault"),
+					Args: []ast.Expr{r.site(commClause.Pos())},
+				},
+			}
+			commClause.Body = append([]ast.Stmt{chosen}, commClause.Body...)
+			continue
+		}
+
+		chanExpr, dir := commChan(commClause.Comm)
+		if chanExpr == nil {
+			continue
+		}
+		tmp := ast.NewIdent(fmt.Sprintf("gtraceSel%dCh%d", index, j))
+		prelude = append(prelude, &ast.AssignStmt{
+			Lhs: []ast.Expr{tmp},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{*chanExpr},
+		})
+		*chanExpr = ast.NewIdent(tmp.Name)
+		// отправляемое значение вычисляется сразу после канала своей ветки
+		if send, ok := commClause.Comm.(*ast.SendStmt); ok {
+			if value, ok := r.hoistable(send.Value); ok {
+				v := ast.NewIdent(fmt.Sprintf("gtraceSel%dV%d", index, j))
+				prelude = append(prelude, &ast.AssignStmt{
+					Lhs: []ast.Expr{v},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{value},
+				})
+				send.Value = ast.NewIdent(v.Name)
+			}
+		}
+		cases = append(cases, &ast.CallExpr{
+			Fun: ast.NewIdent("gtrace.SelectCase"),
+			Args: []ast.Expr{
+				&ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("%q", dir)},
+				ast.NewIdent(tmp.Name),
+			},
+		})
+
+		chosen := &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: ast.NewIdent("gtrace.SelectCaseChosen"),
+				Args: []ast.Expr{
+					ast.NewIdent(tmp.Name),
+					&ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("%q", dir)},
+					r.site(commClause.Pos()),
+				},
+			},
+		}
+		commClause.Body = append([]ast.Stmt{chosen}, commClause.Body...)
+	}
+
+	enter := &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: ast.NewIdent("gtrace.SelectEnter"),
+			Args: append([]ast.Expr{
+				r.site(s.Pos()),
+				&ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("%d", len(s.Body.List))},
+			}, cases...),
+		},
+	}
+
+	return append(prelude, enter)
+}
+
+// commChan возвращает указатель на выражение канала в заголовке ветки select и направление операции
+func commChan(comm ast.Stmt) (*ast.Expr, string) {
+	switch c := comm.(type) {
+	case *ast.SendStmt:
+		return &c.Chan, "send"
+	case *ast.ExprStmt:
+		if recv, ok := unparen(c.X).(*ast.UnaryExpr); ok && recv.Op == token.ARROW {
+			return &recv.X, "receive"
+		}
+	case *ast.AssignStmt:
+		if len(c.Rhs) == 1 {
+			if recv, ok := unparen(c.Rhs[0]).(*ast.UnaryExpr); ok && recv.Op == token.ARROW {
+				return &recv.X, "receive"
+			}
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,603 @@
+package instrumented
+
+// SinkEnv — переменная окружения, через которую инструментированной программе передаётся приёмник трассы
+// (file:<путь>, fd:<дескриптор>, unix:<путь к сокету> или tcp:<адрес>:<порт>; несколько — через запятую)
+const SinkEnv = "GTRACE_SINK"
+
+// FormatEnv — переменная окружения с форматом трассы: text (по умолчанию) или binary
+const FormatEnv = "GTRACE_FORMAT"
+
+// SignalsEnv — переменная окружения: 1 — сбрасывать трассу по SIGINT, SIGTERM и SIGHUP, даже если
+// программа сама не подписана на сигналы (ценой обнаружения взаимной блокировки рантаймом)
+const SignalsEnv = "GTRACE_SIGNALS"
+
+// sinkCode — приёмник событий сгенерированного пакета gtrace (gtrace/sink.go). События не пишутся
+// в stdout программы: вывод программы не смешивается с трассой и не может её испортить.
+// Двоичный формат разбирается в parser/binary.go, константы формата должны совпадать
+const sinkCode = `package gtrace
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SinkEnv — переменная окружения, через которую gtrace задаёт приёмник трассы:
+// file:<путь>, fd:<номер унаследованного дескриптора>, unix:<путь к сокету> или tcp:<IP>:<порт>
+// (например, сервер gtrace для живой трассы). Несколько приёмников перечисляются через запятую.
+// Если переменная не задана, события пишутся в stdout
+const SinkEnv = "GTRACE_SINK"
+
+// FormatEnv — переменная окружения с форматом трассы: text (строки [GTRACE] ..., по умолчанию) или binary
+const FormatEnv = "GTRACE_FORMAT"
+
+// SignalsEnv — переменная окружения: 1 — сбрасывать трассу по SIGINT, SIGTERM и SIGHUP, даже если
+// программа сама не подписана на сигналы. Подписка на сигналы отключает обнаружение взаимной
+// блокировки рантаймом ("all goroutines are asleep"), поэтому без неё трасса сбрасывается по сигналу,
+// только если программа подписывается на сигналы сама (см. SignalNotify)
+const SignalsEnv = "GTRACE_SIGNALS"
+
+// sinkBatch — размер пакета событий одного шарда очереди. Переполнение пакета — не потеря событий:
+// emit будит горутину записи и ждёт, пока она заберёт пакеты. Пропуск отправки или получения
+// исказил бы граф и отчёт о блокировках, поэтому при отстающем приёмнике программа замедляется
+const sinkBatch = 1024
+
+// Двоичный формат: binaryMagic, версия формата (uvarint) и заголовок — версия Go, GOMAXPROCS,
+// время начала (varint, наносекунды) и аргументы программы (строки: uvarint-длина и байты).
+// Затем записи событий: число полей (uvarint) и поля, первое поле — тип события. Каждое поле
+// начинается с uvarint, младшие 3 бита которого — тег, а остальные — значение тега
+const (
+	binaryMagic   = "GTRACEB"
+	binaryVersion = 1
+
+	tagRef    = 0 // строка из таблицы строк: номер строки
+	tagIntern = 1 // новая строка таблицы: длина, затем байты; строка получает следующий номер
+	tagText   = 2 // строка вне таблицы (значения паник, стеки): длина, затем байты
+	tagInt    = 3 // целое число в zigzag-кодировке
+	tagStamp  = 4 // временная метка: zigzag-разность с предыдущей меткой (первая — со временем начала)
+)
+
+// stamp — временная метка события в наносекундах
+type stamp int64
+
+// text — строковое поле, которое почти не повторяется; в текстовом формате пишется в кавычках
+type text string
+
+func now() stamp {
+	return stamp(time.Now().UnixNano())
+}
+
+// record — событие для записи; seq — его номер в трассе
+type record struct {
+	seq    uint64
+	kind   string
+	fields []any
+}
+
+// Очередь событий разбита на шарды по числу P при старте: emit пишет событие в пакет случайного
+// шарда под его замком, так что горутины на разных P почти не соперничают. Номер события выдаётся
+// под замком шарда, поэтому горутина записи, прочитав последний выданный номер до обхода шардов,
+// заберёт все события до него; события с большими номерами ждут следующего обхода. Так события
+// пишутся в порядке номеров, а события одной горутины — в порядке, в котором она их логировала
+type shard struct {
+	mu     sync.Mutex
+	events []record
+	_      [64]byte // соседние шарды не делят строку кэша
+}
+
+var (
+	shards  []shard
+	lastSeq atomic.Uint64
+	// wake будит горутину записи; отправка не блокирует: одного ожидающего сигнала достаточно
+	wake = make(chan struct{}, 1)
+
+	// rounds оповещает об окончании обхода шардов; written — номер, до которого события записаны
+	roundMu sync.Mutex
+	rounds  = sync.NewCond(&roundMu)
+	round   uint64
+	written uint64
+)
+
+// encoder кодирует события в формат трассы; вызывается только из горутины записи
+type encoder interface {
+	header(start stamp)
+	event(kind string, fields []any)
+}
+
+func init() {
+	start := now()
+	w := openSinks(os.Getenv(SinkEnv))
+
+	buf := bufio.NewWriterSize(w, 64<<10)
+	var enc encoder = &textEncoder{w: buf}
+	if os.Getenv(FormatEnv) == "binary" {
+		enc = &binaryEncoder{w: buf, strings: make(map[string]uint64), prev: start}
+	}
+	enc.header(start)
+
+	shards = make([]shard, runtime.GOMAXPROCS(0))
+	go writeRecords(buf, enc)
+	if os.Getenv(SignalsEnv) == "1" {
+		watchSignals()
+	}
+}
+
+// openSinks открывает приёмники из SinkEnv. Недоступный приёмник пропускается с сообщением в stderr;
+// если недоступны все, события пишутся в stderr
+func openSinks(spec string) io.Writer {
+	var sinks []io.Writer
+	for _, s := range strings.Split(spec, ",") {
+		w, err := openSink(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gtrace: приёмник трассы %q недоступен: %v\n", s, err)
+			continue
+		}
+		sinks = append(sinks, w)
+	}
+	switch len(sinks) {
+	case 0:
+		fmt.Fprintln(os.Stderr, "gtrace: события пишутся в stderr")
+		return os.Stderr
+	case 1:
+		return sinks[0]
+	}
+	return &teeSink{sinks: sinks}
+}
+
+// teeSink пишет трассу во все приёмники. Приёмник, запись в который не удалась (например, сервер
+// закрыл соединение), отключается, остальные продолжают получать трассу
+type teeSink struct {
+	sinks []io.Writer
+}
+
+func (t *teeSink) Write(p []byte) (int, error) {
+	alive := t.sinks[:0]
+	for _, w := range t.sinks {
+		if _, err := w.Write(p); err == nil {
+			alive = append(alive, w)
+		}
+	}
+	t.sinks = alive
+	if len(alive) == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
+
+// openSink открывает приёмник. Файл и сокет открываются как блокирующие дескрипторы в обход
+// планировщика сети Go: пока он не запущен, рантайм по-прежнему обнаруживает
+// "all goroutines are asleep", и трассировка не меняет поведение заблокированной программы.
+// Поэтому пакет не импортирует net: его импорт запускает планировщик сети в любой программе,
+// и зависшая программа висела бы вечно вместо аварийного завершения
+func openSink(spec string) (io.Writer, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "":
+		return os.Stdout, nil
+	case "file":
+		fd, err := syscall.Open(target, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_TRUNC|syscall.O_CLOEXEC, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return os.NewFile(uintptr(fd), target), nil
+	case "fd":
+		fd, err := strconv.Atoi(target)
+		if err != nil {
+			return nil, err
+		}
+		f := os.NewFile(uintptr(fd), "gtrace-sink")
+		if f == nil {
+			return nil, fmt.Errorf("некорректный дескриптор %d", fd)
+		}
+		return f, nil
+	case "unix":
+		fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Connect(fd, &syscall.SockaddrUnix{Name: target}); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+		return os.NewFile(uintptr(fd), target), nil
+	case "tcp":
+		sa, family, err := tcpAddr(target)
+		if err != nil {
+			return nil, err
+		}
+		fd, err := syscall.Socket(family, syscall.SOCK_STREAM, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Connect(fd, sa); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+		return os.NewFile(uintptr(fd), target), nil
+	}
+	return nil, fmt.Errorf("неизвестный тип приёмника %q", kind)
+}
+
+// tcpAddr разбирает адрес <IP>:<порт> (localhost и пустой адрес — 127.0.0.1). Имена хостов
+// не разрешаются: резолвер из net запустил бы планировщик сети
+func tcpAddr(target string) (syscall.Sockaddr, int, error) {
+	if port, ok := strings.CutPrefix(target, "localhost:"); ok {
+		target = "127.0.0.1:" + port
+	} else if strings.HasPrefix(target, ":") {
+		target = "127.0.0.1" + target
+	}
+	addr, err := netip.ParseAddrPort(target)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ожидается <IP>:<порт>: %v", err)
+	}
+	if ip := addr.Addr().Unmap(); ip.Is4() {
+		return &syscall.SockaddrInet4{Port: int(addr.Port()), Addr: ip.As4()}, syscall.AF_INET, nil
+	}
+	return &syscall.SockaddrInet6{Port: int(addr.Port()), Addr: addr.Addr().As16()}, syscall.AF_INET6, nil
+}
+
+// writeRecords забирает пакеты шардов и пишет события в приёмник в порядке номеров. Буфер
+// сбрасывается после каждого обхода, поэтому при аварийном завершении программы теряются только
+// события, ещё не дошедшие до записи. Ошибки записи не прерывают программу: трасса просто обрывается
+func writeRecords(w *bufio.Writer, enc encoder) {
+	var pending []record
+	for range wake {
+		last := lastSeq.Load()
+		for i := range shards {
+			s := &shards[i]
+			s.mu.Lock()
+			pending = append(pending, s.events...)
+			for j := range s.events {
+				s.events[j] = record{}
+			}
+			s.events = s.events[:0]
+			s.mu.Unlock()
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].seq < pending[j].seq })
+		n := 0
+		for ; n < len(pending) && pending[n].seq <= last; n++ {
+			enc.event(pending[n].kind, pending[n].fields)
+		}
+		pending = append(pending[:0], pending[n:]...)
+		w.Flush()
+		if len(pending) > 0 {
+			notify()
+		}
+
+		roundMu.Lock()
+		round++
+		written = last
+		rounds.Broadcast()
+		roundMu.Unlock()
+	}
+}
+
+// notify будит горутину записи
+func notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// emit ставит событие в очередь на запись; кодирование и запись выполняет горутина записи,
+// вызывающая горутина ждёт, только если пакет её шарда переполнен (см. sinkBatch)
+func emit(kind string, fields ...any) {
+	s := &shards[rand.Intn(len(shards))]
+	s.mu.Lock()
+	for len(s.events) >= sinkBatch {
+		s.mu.Unlock()
+		awaitRound()
+		s.mu.Lock()
+	}
+	s.events = append(s.events, record{seq: lastSeq.Add(1), kind: kind, fields: fields})
+	s.mu.Unlock()
+	notify()
+}
+
+// awaitRound будит горутину записи и ждёт, пока она закончит обход шардов
+func awaitRound() {
+	roundMu.Lock()
+	defer roundMu.Unlock()
+	r := round
+	notify()
+	for round == r {
+		rounds.Wait()
+	}
+}
+
+// flush ждёт, пока все события, поставленные в очередь до вызова, будут записаны в приёмник
+func flush() {
+	last := lastSeq.Load()
+	roundMu.Lock()
+	defer roundMu.Unlock()
+	for written < last {
+		notify()
+		rounds.Wait()
+	}
+}
+
+// Сигналы завершения. Подписка на сигналы отключает обнаружение взаимной блокировки рантаймом,
+// поэтому gtrace подписывается на них, только когда программа подписывается сама (signal.Notify
+// и signal.NotifyContext заменяются на SignalNotify и SignalNotifyContext) или задана SignalsEnv
+var (
+	exitSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+	signals     = make(chan os.Signal, 1)
+	watchOnce   sync.Once
+
+	signalMu sync.Mutex
+	// handled — подписки программы: канал (или ключ NotifyContext) и его сигналы
+	handled = make(map[any]subscription)
+)
+
+// subscription — сигналы одной подписки программы; all — подписка на все сигналы
+type subscription struct {
+	all     bool
+	signals []os.Signal
+}
+
+func (s subscription) has(sig os.Signal) bool {
+	if s.all {
+		return true
+	}
+	for _, x := range s.signals {
+		if x == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// watchSignals подписывается на сигналы завершения: по сигналу трасса сбрасывается. Если программа
+// сама не подписана на сигнал, логируется завершение программы (shutdown с причиной signal и кодом
+// signalExitCode), подписка снимается и программа завершается, как без gtrace (см. raise)
+func watchSignals() {
+	signal.Notify(signals, exitSignals...)
+	watchOnce.Do(func() {
+		go func() {
+			for sig := range signals {
+				if programHandles(sig) {
+					flush()
+					continue
+				}
+				num, _ := sig.(syscall.Signal)
+				shutdown("signal", sig.String(), signalExitCode(num))
+				flush()
+				signal.Stop(signals)
+				raise(num)
+			}
+		}()
+	})
+}
+
+func programHandles(sig os.Signal) bool {
+	signalMu.Lock()
+	defer signalMu.Unlock()
+	for _, s := range handled {
+		if s.has(sig) {
+			return true
+		}
+	}
+	return false
+}
+
+func subscribe(key any, sig []os.Signal) {
+	signalMu.Lock()
+	s := handled[key]
+	s.all = s.all || len(sig) == 0
+	s.signals = append(s.signals, sig...)
+	handled[key] = s
+	signalMu.Unlock()
+	watchSignals()
+}
+
+// SignalNotify заменяет signal.Notify: по сигналу, на который программа подписана, трасса только
+// сбрасывается, и программа обрабатывает сигнал сама
+func SignalNotify(c chan<- os.Signal, sig ...os.Signal) {
+	signal.Notify(c, sig...)
+	subscribe(c, sig)
+}
+
+// SignalNotifyContext заменяет signal.NotifyContext; подписка снимается вызовом stop
+func SignalNotifyContext(parent context.Context, sig ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, sig...)
+	key := new(byte)
+	subscribe(key, sig)
+	return ctx, func() {
+		stop()
+		signalMu.Lock()
+		delete(handled, key)
+		signalMu.Unlock()
+	}
+}
+
+// SignalStop заменяет signal.Stop
+func SignalStop(c chan<- os.Signal) {
+	signal.Stop(c)
+	signalMu.Lock()
+	delete(handled, c)
+	signalMu.Unlock()
+}
+
+// SignalReset заменяет signal.Reset: сброс снимает и подписку gtrace, сигналы снова завершают
+// программу без сброса трассы
+func SignalReset(sig ...os.Signal) {
+	signal.Reset(sig...)
+	signalMu.Lock()
+	defer signalMu.Unlock()
+	if len(sig) == 0 {
+		handled = make(map[any]subscription)
+		return
+	}
+	reset := subscription{signals: sig}
+	for key, s := range handled {
+		var kept []os.Signal
+		for _, x := range s.signals {
+			if !reset.has(x) {
+				kept = append(kept, x)
+			}
+		}
+		s.signals = kept
+		handled[key] = s
+	}
+}
+
+// textEncoder пишет события строками [GTRACE] <тип> <поля...>; поля с пробелами, кавычками
+// и пустые пишутся строками Go в кавычках, как в parser.EncodeEvent
+type textEncoder struct {
+	w *bufio.Writer
+}
+
+// header пишет заголовок событием trace_header <версия> <версия_go> <gomaxprocs> <timestamp> <аргументы...>
+func (e *textEncoder) header(start stamp) {
+	fields := []any{binaryVersion, runtime.Version(), runtime.GOMAXPROCS(0), start}
+	for _, arg := range os.Args {
+		fields = append(fields, text(arg))
+	}
+	e.event("trace_header", fields)
+}
+
+func (e *textEncoder) event(kind string, fields []any) {
+	e.w.WriteString("[GTRACE] ")
+	e.w.WriteString(kind)
+	for _, f := range fields {
+		e.w.WriteByte(' ')
+		switch v := f.(type) {
+		case string:
+			// имена функций с аргументами типов содержат пробелы: (*pkg.Pair[string, int]).Run
+			if v == "" || strings.ContainsAny(v, " \t\n\"") {
+				e.w.WriteString(strconv.Quote(v))
+			} else {
+				e.w.WriteString(v)
+			}
+		case text:
+			e.w.WriteString(strconv.Quote(string(v)))
+		default:
+			fmt.Fprint(e.w, v)
+		}
+	}
+	e.w.WriteByte('\n')
+}
+
+// binaryEncoder пишет события в двоичном формате: повторяющиеся строки (функции, места операций)
+// записываются один раз и дальше передаются номером, временные метки — разностью с предыдущей
+type binaryEncoder struct {
+	w       *bufio.Writer
+	strings map[string]uint64
+	prev    stamp
+	buf     [binary.MaxVarintLen64]byte
+}
+
+func (e *binaryEncoder) header(start stamp) {
+	e.w.WriteString(binaryMagic)
+	e.uvarint(binaryVersion)
+	e.bytes(runtime.Version())
+	e.uvarint(uint64(runtime.GOMAXPROCS(0)))
+	e.w.Write(e.buf[:binary.PutVarint(e.buf[:], int64(start))])
+	e.uvarint(uint64(len(os.Args)))
+	for _, arg := range os.Args {
+		e.bytes(arg)
+	}
+}
+
+func (e *binaryEncoder) event(kind string, fields []any) {
+	e.uvarint(uint64(len(fields) + 1))
+	e.str(kind)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			e.str(v)
+		case text:
+			e.uvarint(uint64(len(v))<<3 | tagText)
+			e.w.WriteString(string(v))
+		case stamp:
+			e.uvarint(zigzag(int64(v-e.prev))<<3 | tagStamp)
+			e.prev = v
+		case int:
+			e.uvarint(zigzag(int64(v))<<3 | tagInt)
+		case int64:
+			e.uvarint(zigzag(v)<<3 | tagInt)
+		case uint64:
+			e.uvarint(zigzag(int64(v))<<3 | tagInt)
+		default:
+			e.uvarint(uint64(len(fmt.Sprint(v)))<<3 | tagText)
+			e.w.WriteString(fmt.Sprint(v))
+		}
+	}
+}
+
+func (e *binaryEncoder) str(s string) {
+	if ref, ok := e.strings[s]; ok {
+		e.uvarint(ref<<3 | tagRef)
+		return
+	}
+	e.strings[s] = uint64(len(e.strings))
+	e.uvarint(uint64(len(s))<<3 | tagIntern)
+	e.w.WriteString(s)
+}
+
+func (e *binaryEncoder) bytes(s string) {
+	e.uvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *binaryEncoder) uvarint(v uint64) {
+	e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+`
+
+// sinkUnixCode — завершение программы по сигналу в Unix (gtrace/sink_unix.go): сигнал посылается
+// заново, и программа завершается им, как без gtrace
+const sinkUnixCode = `//go:build !windows
+
+package gtrace
+
+import "syscall"
+
+// signalExitCode — код завершения программы, убитой сигналом, как его сообщает оболочка
+func signalExitCode(sig syscall.Signal) int {
+	return 128 + int(sig)
+}
+
+// raise посылает сигнал заново; подписка gtrace на него уже снята
+func raise(sig syscall.Signal) {
+	syscall.Kill(syscall.Getpid(), sig)
+}
+`
+
+// sinkWindowsCode — завершение программы по сигналу в Windows (gtrace/sink_windows.go): послать
+// сигнал процессу нельзя, поэтому программа завершается с кодом, с которым рантайм Go завершает её
+// по Ctrl+C без подписки
+const sinkWindowsCode = `package gtrace
+
+import (
+	"os"
+	"syscall"
+)
+
+func signalExitCode(syscall.Signal) int {
+	return 2
+}
+
+func raise(syscall.Signal) {
+	os.Exit(signalExitCode(0))
+}
+`
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// горутины отправляют значения быстрее, чем горутина записи трассы успевает их писать:
+// пакеты очереди трассы переполняются
+
+const (
+	senders = 8
+	values  = 5000
+)
+
+func main() {
+	ch := make(chan int, 64)
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < values; j++ {
+				ch <- j
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	fmt.Println(n)
+}
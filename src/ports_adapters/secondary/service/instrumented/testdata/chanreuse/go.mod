@@ -0,0 +1,3 @@
+module chanreuse
+
+go 1.22
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// каналы первой партии создаются инструментированным make, половина из них собирается сборщиком
+// мусора; каналы второй партии создаются вне инструментированного кода (reflect.MakeChan) и занимают
+// освободившиеся адреса. Живая половина не даёт вернуть страницы памяти, так что адреса переиспользуются
+
+const batch = 1000
+
+func main() {
+	var keep []chan int
+	freed := make(map[string]bool, batch)
+	for i := 0; i < 2*batch; i++ {
+		ch := make(chan int, 1)
+		ch <- i
+		<-ch
+		if i%2 == 0 {
+			keep = append(keep, ch)
+		} else {
+			freed[fmt.Sprintf("%p", ch)] = true
+		}
+	}
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reused := 0
+	for i := 0; i < batch; i++ {
+		ch := reflect.MakeChan(reflect.TypeOf((chan int)(nil)), 1).Interface().(chan int)
+		ch <- i
+		<-ch
+		if freed[fmt.Sprintf("%p", ch)] {
+			reused++
+		}
+		keep = append(keep, ch)
+	}
+	fmt.Println("reused", reused, "of", 3*batch)
+}
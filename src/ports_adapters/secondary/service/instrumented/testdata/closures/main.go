@@ -0,0 +1,62 @@
+package main
+
+import "fmt"
+
+// операции с каналами в литералах функций на любой глубине, в ветках case и под метками
+
+var results = func() chan int { return make(chan int, 1) }()
+
+func each(values []int, f func(int)) {
+	for _, v := range values {
+		f(v)
+	}
+}
+
+func main() {
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+	}()
+
+	go func() {
+		results <- 1
+	}()
+
+	read := func() int { return <-results }
+	fmt.Println(read())
+
+	each([]int{2, 3}, func(v int) {
+		switch {
+		case v%2 == 0:
+			results <- v
+		default:
+			go func() { results <- v }()
+		}
+		fmt.Println(<-results)
+	})
+
+loop:
+	for {
+		select {
+		case v := <-results:
+			fmt.Println(v)
+		default:
+			break loop
+		}
+	}
+
+	// goto к select с меткой снаружи и изнутри, break с его меткой
+	n := 2
+	goto retry
+retry:
+	select {
+	case results <- n:
+		<-results
+		n++
+		if n == 4 {
+			break retry
+		}
+		goto retry
+	}
+	fmt.Println(n)
+}
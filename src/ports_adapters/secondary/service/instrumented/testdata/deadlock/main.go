@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// горутины pass ждут друг друга, main ждёт done: рантайм должен завершить программу
+// с "all goroutines are asleep"
+func pass(in, out chan int) {
+	v := <-in
+	out <- v
+}
+
+func main() {
+	x := make(chan int)
+	y := make(chan int)
+	go pass(x, y)
+	go pass(y, x)
+	done := make(chan bool)
+	<-done
+	fmt.Println("unreachable")
+}
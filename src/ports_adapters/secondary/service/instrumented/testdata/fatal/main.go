@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// программа завершается log.Fatalf или, с аргументом logger, методом Fatalln своего логгера;
+// горутина worker к этому моменту ещё ждёт
+
+func main() {
+	block := make(chan struct{})
+	go func() {
+		<-block
+	}()
+
+	log.SetFlags(log.Lshortfile)
+	if len(os.Args) > 1 && os.Args[1] == "logger" {
+		logger := log.New(os.Stderr, "app: ", log.Lshortfile)
+		logger.Fatalln("stop", 2)
+	}
+	log.Fatalf("stop %d", 1)
+}
@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// Pair — обобщённый тип: полное имя метода экземпляра содержит пробел между аргументами типа
+type Pair[K comparable, V any] struct {
+	key   K
+	value V
+	out   chan V
+}
+
+func (p *Pair[K, V]) Run() {
+	p.out <- p.value
+}
+
+func main() {
+	p := &Pair[string, int]{key: "a", value: 1, out: make(chan int)}
+	go p.Run()
+	fmt.Println(p.key, <-p.out)
+}
@@ -0,0 +1,3 @@
+module goimport
+
+go 1.22
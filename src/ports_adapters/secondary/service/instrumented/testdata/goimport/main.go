@@ -0,0 +1,12 @@
+package main
+
+// аргумент go-оператора получает тип из пакета, который этот файл не импортирует (time.Duration):
+// сдвиг вычисляется в момент go-оператора, а не в запущенной горутине
+
+func main() {
+	done := make(chan struct{})
+	n := 1
+	go wait(1<<n, done)
+	n = 10
+	<-done
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func wait(d time.Duration, done chan<- struct{}) {
+	fmt.Println(d)
+	done <- struct{}{}
+}
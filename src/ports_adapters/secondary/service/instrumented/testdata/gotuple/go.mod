@@ -0,0 +1,3 @@
+module gotuple
+
+go 1.22
@@ -0,0 +1,28 @@
+package main
+
+import "fmt"
+
+// аргумент go-оператора — вызов с несколькими результатами: go f(g())
+
+type collector struct {
+	out chan string
+}
+
+func (c collector) collect(n int, s string) {
+	c.out <- fmt.Sprintf("%d %s", n, s)
+}
+
+func pair() (int, string) {
+	return 1, "one"
+}
+
+func main() {
+	c := collector{out: make(chan string)}
+	go c.collect(pair())
+	fmt.Println(<-c.out)
+
+	go func(n int, s string) {
+		c.out <- fmt.Sprintf("%d %s", n, s)
+	}(pair())
+	fmt.Println(<-c.out)
+}
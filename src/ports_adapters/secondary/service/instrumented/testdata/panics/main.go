@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// одна горутина завершается через runtime.Goexit, другая паникует и завершает процесс
+
+func quit(wg *sync.WaitGroup) {
+	defer wg.Done()
+	runtime.Goexit()
+}
+
+func crash() {
+	panic(fmt.Sprintf("boom %d", 42))
+}
+
+func main() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go quit(&wg)
+	wg.Wait()
+
+	go crash()
+	select {}
+}
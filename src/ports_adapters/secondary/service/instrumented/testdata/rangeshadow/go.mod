@@ -0,0 +1,3 @@
+module rangeshadow
+
+go 1.22
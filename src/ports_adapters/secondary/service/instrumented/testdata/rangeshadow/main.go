@@ -0,0 +1,21 @@
+package main
+
+import "fmt"
+
+// тело range по каналу объявляет те же имена, что и получение: v := v
+func main() {
+	ch := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		ch <- i
+	}
+	close(ch)
+
+	sum := 0
+	for v := range ch {
+		v := v
+		gtraceOk0 := v * 10
+		sum += gtraceOk0
+	}
+
+	fmt.Println(sum)
+}
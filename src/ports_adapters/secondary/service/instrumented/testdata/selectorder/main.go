@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// операнды веток select вычисляются один раз и в порядке исходника: канал и значение отправки,
+// затем канал следующей ветки
+
+var order []string
+
+func trace(name string) {
+	order = append(order, name)
+}
+
+func chA(ch chan int) chan int {
+	trace("chA")
+	return ch
+}
+
+func f() int {
+	trace("f")
+	return 1
+}
+
+func chB(ch chan int) chan int {
+	trace("chB")
+	return ch
+}
+
+type flag bool
+
+func main() {
+	a := make(chan int, 1)
+	b := make(chan int)
+	select {
+	case chA(a) <- f():
+	case <-chB(b):
+	}
+	fmt.Println(strings.Join(order, " "), <-a)
+
+	// нетипизированное значение получает тип элемента канала
+	n := len(order)
+	flags := make(chan flag, 1)
+	var none chan int
+	select {
+	case flags <- n > 0:
+	case none <- 2:
+	}
+	fmt.Println(<-flags)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// программа обменивается сотней значений и ждёт сигнала. С аргументом notify она подписана
+// на SIGINT сама и по нему завершается возвратом из main; остальные сигналы завершают её
+
+func main() {
+	results := make(chan int)
+	go func() {
+		for i := 0; i < 100; i++ {
+			results <- i
+		}
+		close(results)
+	}()
+	sum := 0
+	for v := range results {
+		sum += v
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "notify" {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		fmt.Println("ready")
+		<-c
+		fmt.Println("interrupted", sum)
+		return
+	}
+	fmt.Println("ready")
+	select {}
+}
@@ -0,0 +1,3 @@
+module spawnnames
+
+go 1.22
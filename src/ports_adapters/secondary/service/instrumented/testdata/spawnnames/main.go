@@ -0,0 +1,35 @@
+package main
+
+import "sync"
+
+// горутины с литералами функций: в теле функции, вложенные и в объявлении уровня пакета
+
+var start = func(wg *sync.WaitGroup) {
+	go func() { wg.Done() }()
+}
+
+type pool struct {
+	wg sync.WaitGroup
+}
+
+func (p *pool) run() {
+	p.wg.Add(1)
+	go func() { p.wg.Done() }()
+	p.wg.Wait()
+}
+
+func main() {
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		inner := make(chan struct{})
+		go func() { close(inner) }()
+		<-inner
+	}()
+	done := func() { wg.Done() }
+	go done()
+	start(&wg)
+	wg.Wait()
+	new(pool).run()
+}
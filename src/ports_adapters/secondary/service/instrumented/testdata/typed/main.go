@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// каналы определяются по типу операнда, а не по виду выражения
+
+type Queue = chan int
+
+type Events chan string
+
+type server struct {
+	in    chan int
+	peers map[string]chan int
+}
+
+func newChan[T any](n int) chan T {
+	return make(chan T, n)
+}
+
+func results() chan int {
+	return make(Queue, 1)
+}
+
+// make и close, затенённые локальными функциями, не инструментируются
+func shadowed() {
+	make := func(n int) []int { return nil }
+	close := func(v []int) {}
+	close(make(1))
+}
+
+func main() {
+	s := server{in: make(chan int, 1), peers: map[string]chan int{"a": newChan[int](1)}}
+	s.in <- 1
+	fmt.Println(<-s.in)
+	s.peers["a"] <- 2
+	fmt.Println(<-s.peers["a"])
+
+	all := []chan int{results()}
+	all[0] <- 3
+	fmt.Println(<-all[0])
+
+	events := make(Events, 1)
+	events <- "four"
+	fmt.Println(<-events)
+	close(events)
+	for e := range events { // закрытый канал: тело не выполняется
+		fmt.Println(e)
+	}
+
+	shadowed()
+}
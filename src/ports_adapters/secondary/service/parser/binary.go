@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Двоичный формат трассы пишет рантайм gtrace (instrumented/sink.go), константы должны совпадать.
+// Трасса начинается с binaryMagic, версии формата и заголовка: версия Go, GOMAXPROCS, время начала
+// и аргументы программы. Затем идут записи событий: число полей и поля, первое поле — тип события.
+// Поле начинается с uvarint, младшие 3 бита которого — тег, остальные — значение тега
+const (
+	binaryMagic   = "GTRACEB"
+	binaryVersion = 1
+
+	tagRef    = 0 // строка из таблицы строк: номер строки
+	tagIntern = 1 // новая строка таблицы: длина, затем байты
+	tagText   = 2 // строка вне таблицы: длина, затем байты
+	tagInt    = 3 // целое число в zigzag-кодировке
+	tagStamp  = 4 // временная метка: zigzag-разность с предыдущей меткой
+)
+
+// maxBinaryString — предельная длина строки двоичной трассы: защита от испорченной длины
+const maxBinaryString = 16 << 20
+
+var (
+	// errTruncated — трасса оборвана на середине записи
+	errTruncated = errors.New("trace truncated")
+	// errCorrupt — запись испорчена; границы следующих записей неизвестны, поэтому чтение прекращается
+	errCorrupt = errors.New("trace corrupted")
+)
+
+// isBinaryTrace сообщает, что трасса начинается с заголовка двоичного формата
+func isBinaryTrace(reader *bufio.Reader) bool {
+	magic, err := reader.Peek(len(binaryMagic))
+	return err == nil && string(magic) == binaryMagic
+}
+
+// binaryDecoder читает события двоичной трассы и возвращает их полями текстового формата:
+// строки берутся из таблицы, временные метки восстанавливаются из разностей
+type binaryDecoder struct {
+	r       *bufio.Reader
+	strings []string
+	prev    int64
+	header  []string
+	// record — номер последней прочитанной записи (заголовок — запись 0)
+	record int
+	// failed — после ошибки записи чтение не продолжается
+	failed bool
+}
+
+func newBinaryDecoder(reader *bufio.Reader) (*binaryDecoder, error) {
+	if _, err := reader.Discard(len(binaryMagic)); err != nil {
+		return nil, err
+	}
+	d := &binaryDecoder{r: reader}
+
+	version, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("binary trace header: %w", err)
+	}
+	if version != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary trace version %d", version)
+	}
+	goVersion, err := d.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("binary trace header: %w", err)
+	}
+	procs, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("binary trace header: %w", err)
+	}
+	start, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("binary trace header: %w", err)
+	}
+	argc, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("binary trace header: %w", err)
+	}
+	d.prev = start
+	d.header = []string{"[GTRACE]", "trace_header", strconv.FormatUint(version, 10), goVersion,
+		strconv.FormatUint(procs, 10), strconv.FormatInt(start, 10)}
+	for i := uint64(0); i < argc; i++ {
+		arg, err := d.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("binary trace header: %w", err)
+		}
+		d.header = append(d.header, arg)
+	}
+	return d, nil
+}
+
+// next возвращает следующее событие и номер его записи; первым возвращается заголовок в виде события trace_header
+func (d *binaryDecoder) next() ([]string, int, error) {
+	if d.header != nil {
+		parts := d.header
+		d.header = nil
+		return parts, 0, nil
+	}
+	if d.failed {
+		return nil, d.record, io.EOF
+	}
+
+	count, err := binary.ReadUvarint(d.r)
+	if err == io.EOF {
+		return nil, d.record, io.EOF
+	}
+	d.record++
+	if err != nil {
+		return nil, d.record, d.fail(err)
+	}
+	if count == 0 || count > 1<<10 {
+		return nil, d.record, d.fail(fmt.Errorf("invalid binary trace record: %d fields", count))
+	}
+
+	parts := make([]string, 1, count+1)
+	parts[0] = "[GTRACE]"
+	for i := uint64(0); i < count; i++ {
+		field, err := d.field()
+		if err != nil {
+			return nil, d.record, d.fail(err)
+		}
+		parts = append(parts, field)
+	}
+	return parts, d.record, nil
+}
+
+func (d *binaryDecoder) field() (string, error) {
+	head, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	value := head >> 3
+	switch head & 7 {
+	case tagRef:
+		if value >= uint64(len(d.strings)) {
+			return "", fmt.Errorf("invalid binary trace string reference %d", value)
+		}
+		return d.strings[value], nil
+	case tagIntern:
+		s, err := d.read(value)
+		if err != nil {
+			return "", err
+		}
+		d.strings = append(d.strings, s)
+		return s, nil
+	case tagText:
+		return d.read(value)
+	case tagInt:
+		return strconv.FormatInt(unzigzag(value), 10), nil
+	case tagStamp:
+		d.prev += unzigzag(value)
+		return strconv.FormatInt(d.prev, 10), nil
+	}
+	return "", fmt.Errorf("invalid binary trace field tag %d", head&7)
+}
+
+func (d *binaryDecoder) bytes() (string, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	return d.read(n)
+}
+
+func (d *binaryDecoder) read(n uint64) (string, error) {
+	if n > maxBinaryString {
+		return "", fmt.Errorf("invalid binary trace string length %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// fail прекращает чтение и отличает обрыв трассы посреди записи от испорченной записи
+func (d *binaryDecoder) fail(err error) error {
+	d.failed = true
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errTruncated
+	}
+	return fmt.Errorf("%w: %v", errCorrupt, err)
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,280 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// traceText и traceStamp — поля записи, которые рантайм пишет строкой вне таблицы и временной меткой
+type (
+	traceText  string
+	traceStamp int64
+)
+
+// binaryTrace пишет трассу так же, как binaryEncoder рантайма (instrumented/sink.go)
+type binaryTrace struct {
+	buf     bytes.Buffer
+	strings map[string]uint64
+	prev    int64
+}
+
+func newBinaryTrace(version uint64, start int64, args ...string) *binaryTrace {
+	t := &binaryTrace{strings: make(map[string]uint64), prev: start}
+	t.buf.WriteString(binaryMagic)
+	t.uvarint(version)
+	t.bytes("go1.24.0")
+	t.uvarint(4)
+	t.buf.Write(binary.AppendVarint(nil, start))
+	t.uvarint(uint64(len(args)))
+	for _, arg := range args {
+		t.bytes(arg)
+	}
+	return t
+}
+
+func (t *binaryTrace) event(kind string, fields ...any) *binaryTrace {
+	t.uvarint(uint64(len(fields) + 1))
+	t.str(kind)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			t.str(v)
+		case traceText:
+			t.uvarint(uint64(len(v))<<3 | tagText)
+			t.buf.WriteString(string(v))
+		case traceStamp:
+			t.uvarint(zigzag(int64(v)-t.prev)<<3 | tagStamp)
+			t.prev = int64(v)
+		case int:
+			t.uvarint(zigzag(int64(v))<<3 | tagInt)
+		}
+	}
+	return t
+}
+
+func (t *binaryTrace) str(s string) {
+	if ref, ok := t.strings[s]; ok {
+		t.uvarint(ref<<3 | tagRef)
+		return
+	}
+	t.strings[s] = uint64(len(t.strings))
+	t.uvarint(uint64(len(s))<<3 | tagIntern)
+	t.buf.WriteString(s)
+}
+
+func (t *binaryTrace) bytes(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *binaryTrace) uvarint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// decodeBinary читает записи двоичной трассы до конца или до первой ошибки
+func decodeBinary(trace []byte) ([][]string, error) {
+	d, err := newBinaryDecoder(bufio.NewReader(bytes.NewReader(trace)))
+	if err != nil {
+		return nil, err
+	}
+	var records [][]string
+	for {
+		parts, _, err := d.next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, parts)
+	}
+}
+
+// Записи двоичной трассы читаются в те же поля, что и строки текстового формата
+func TestBinaryDecoder(t *testing.T) {
+	const start = 1_000_000_000
+	header := "[GTRACE] trace_header 1 go1.24.0 4 1000000000 ./app -v"
+	tests := []struct {
+		name  string
+		trace *binaryTrace
+		want  []string
+		// once — строки, которые должны попасть в трассу один раз: повторы передаются номером в таблице
+		once []string
+	}{
+		{
+			name:  "только заголовок",
+			trace: newBinaryTrace(binaryVersion, start, "./app", "-v"),
+			want:  []string{header},
+		},
+		{
+			name: "таблица строк",
+			trace: newBinaryTrace(binaryVersion, start, "./app", "-v").
+				event("go_spawn", 1, 2, "main.worker", "main.go:10", "main.go:9", traceStamp(start+500)).
+				event("func_start", 2, "main.worker", "main.go:10", traceStamp(start+600), 2).
+				event("func_end", 2, "main.worker", "main.go:10", traceStamp(start+700), "return"),
+			want: []string{
+				header,
+				"[GTRACE] go_spawn 1 2 main.worker main.go:10 main.go:9 1000000500",
+				"[GTRACE] func_start 2 main.worker main.go:10 1000000600 2",
+				"[GTRACE] func_end 2 main.worker main.go:10 1000000700 return",
+			},
+			once: []string{"main.worker", "main.go:10"},
+		},
+		{
+			name: "разности меток",
+			trace: newBinaryTrace(binaryVersion, start, "./app", "-v").
+				event("channel_create", 1, "main.go:5", "main.go:4", traceStamp(start+5_000_000_000), 0).
+				event("channel_send", 1, 1, "main.go:6", "main.go:4", traceStamp(start+300)).
+				event("channel_receive", 2, 1, "main.go:7", "main.go:4", traceStamp(start-100)),
+			want: []string{
+				header,
+				"[GTRACE] channel_create 1 main.go:5 main.go:4 6000000000 0",
+				"[GTRACE] channel_send 1 1 main.go:6 main.go:4 1000000300",
+				"[GTRACE] channel_receive 2 1 main.go:7 main.go:4 999999900",
+			},
+		},
+		{
+			name: "многобайтовые и отрицательные числа",
+			trace: newBinaryTrace(binaryVersion, start, "./app", "-v").
+				event("channel_create", 1<<40, "main.go:5", "main.go:4", traceStamp(start), 300).
+				event("shutdown", 1, "exit", "main.go:20", traceStamp(start+1), -1),
+			want: []string{
+				header,
+				"[GTRACE] channel_create 1099511627776 main.go:5 main.go:4 1000000000 300",
+				"[GTRACE] shutdown 1 exit main.go:20 1000000001 -1",
+			},
+		},
+		{
+			name: "строки вне таблицы",
+			trace: newBinaryTrace(binaryVersion, start, "./app", "-v").
+				event("func_end", 2, "main.worker", "main.go:10", traceStamp(start), "panic",
+					traceText("boom: bad value"), traceText("goroutine 2 [running]:\nmain.worker()")),
+			want: []string{
+				header,
+				`[GTRACE] func_end 2 main.worker main.go:10 1000000000 panic "boom: bad value" "goroutine 2 [running]:\nmain.worker()"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := tt.trace.buf.Bytes()
+			got, err := decodeBinary(trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want [][]string
+			for _, line := range tt.want {
+				parts, err := splitFields(line)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, parts)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("записи:\n%q\nожидались:\n%q", got, want)
+			}
+			for _, s := range tt.once {
+				if n := bytes.Count(trace, []byte(s)); n != 1 {
+					t.Errorf("строка %q записана %d раз", s, n)
+				}
+			}
+		})
+	}
+}
+
+// Оборванная и испорченная записи прерывают чтение с ошибкой, неизвестная версия формата не читается
+func TestBinaryDecoderErrors(t *testing.T) {
+	const start = 1_000_000_000
+	valid := newBinaryTrace(binaryVersion, start).
+		event("channel_create", 1, "main.go:5", "main.go:4", traceStamp(start), 0).
+		event("channel_close", 1, 1, "main.go:6", "main.go:4", traceStamp(start+1)).buf.Bytes()
+	badRef := newBinaryTrace(binaryVersion, start).
+		event("channel_create", 1, "main.go:5", "main.go:4", traceStamp(start), 0)
+	badRef.uvarint(2)
+	badRef.uvarint(7<<3 | tagRef)
+	badRef.uvarint(1<<3 | tagInt)
+	badTag := newBinaryTrace(binaryVersion, start)
+	badTag.uvarint(1)
+	badTag.uvarint(1<<3 | 6)
+
+	tests := []struct {
+		name    string
+		trace   []byte
+		records int
+		err     string
+	}{
+		{name: "обрыв записи", trace: valid[:len(valid)-2], records: 2, err: errTruncated.Error()},
+		{name: "ссылка вне таблицы строк", trace: badRef.buf.Bytes(), records: 2, err: "string reference 7"},
+		{name: "неизвестный тег", trace: badTag.buf.Bytes(), records: 1, err: "field tag 6"},
+		{name: "неизвестная версия", trace: newBinaryTrace(binaryVersion+1, start).buf.Bytes(), err: "unsupported binary trace version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBinary(tt.trace)
+			if len(got) != tt.records {
+				t.Fatalf("записей %d, ожидалось %d: %q", len(got), tt.records, got)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.err)
+			}
+			if tt.err == errTruncated.Error() && !errors.Is(err, errTruncated) {
+				t.Fatalf("ошибка %v — не обрыв трассы", err)
+			}
+		})
+	}
+}
+
+// Двоичная трасса и её текстовая запись дают одинаковый граф, в том числе с оборванной последней записью
+func TestParseBinaryTrace(t *testing.T) {
+	const start = 1_000_000_000
+	trace := newBinaryTrace(binaryVersion, start, "./app").
+		event("channel_create", 1, "main.go:5", "main.go:4", traceStamp(start+100), 0).
+		event("go_spawn", 1, 2, "main.worker", "main.go:6", "main.go:4", traceStamp(start+200)).
+		event("func_start", 2, "main.worker", "main.go:6", traceStamp(start+300), 2).
+		event("channel_send", 2, 1, "main.go:7", "main.go:6", traceStamp(start+400)).
+		event("channel_receive", 1, 1, "main.go:8", "main.go:4", traceStamp(start+500)).
+		event("func_end", 2, "main.worker", "main.go:6", traceStamp(start+600), "return").
+		event("shutdown", 1, "return", "main.go:9", traceStamp(start+700), 0)
+	text := `[GTRACE] trace_header 1 go1.24.0 4 1000000000 ./app
+[GTRACE] channel_create 1 main.go:5 main.go:4 1000000100 0
+[GTRACE] go_spawn 1 2 main.worker main.go:6 main.go:4 1000000200
+[GTRACE] func_start 2 main.worker main.go:6 1000000300 2
+[GTRACE] channel_send 2 1 main.go:7 main.go:6 1000000400
+[GTRACE] channel_receive 1 1 main.go:8 main.go:4 1000000500
+[GTRACE] func_end 2 main.worker main.go:6 1000000600 return
+[GTRACE] shutdown 1 return main.go:9 1000000700 0
+`
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fromText, err := p.Parse(strings.NewReader(text), ModeLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole := trace.buf.Bytes()
+	fromBinary, err := p.Parse(bytes.NewReader(whole), ModeLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromBinary, fromText) {
+		t.Fatalf("граф двоичной трассы:\n%+v\nграф текстовой:\n%+v", fromBinary, fromText)
+	}
+
+	// программа убита во время записи shutdown: трасса разбирается без последней записи
+	truncated, err := p.Parse(bytes.NewReader(whole[:len(whole)-1]), ModeLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated.Shutdown != nil || len(truncated.Gorutines) != len(fromText.Gorutines) {
+		t.Fatalf("граф оборванной трассы: %+v", truncated)
+	}
+}
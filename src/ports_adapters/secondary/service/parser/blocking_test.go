@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"gtrace/src/domain/parser"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Время ожидания складывается по каналам, местам операций и горутинам: отправка и получение
+// записывают его в channel_*_done, ожидание select — от входа в select до выбора ветки
+func TestBlockingTotals(t *testing.T) {
+	const trace = `[GTRACE] trace_header 1 go1.24.0 4 1000000000 ./app
+[GTRACE] channel_create 1 main.go:5 main.go:4 1000000100 0
+[GTRACE] go_spawn 1 2 main.producer main.go:6 main.go:4 1000000200
+[GTRACE] func_start 2 main.producer main.go:6 1000000300 2
+[GTRACE] channel_send 2 1 main.go:7 main.go:6 1000000400
+[GTRACE] channel_receive 1 1 main.go:8 main.go:4 1000000500
+[GTRACE] channel_send_done 2 1 main.go:7 main.go:6 1000000600 200
+[GTRACE] channel_receive_done 1 1 main.go:8 main.go:4 1000000600 100
+[GTRACE] channel_send 2 1 main.go:7 main.go:6 1000000700
+[GTRACE] select_enter 1 main.go:10 main.go:4 1000002000 1 receive:1
+[GTRACE] channel_send_done 2 1 main.go:7 main.go:6 1000005000 4300
+[GTRACE] select_case_chosen 1 main.go:11 main.go:4 1000005000 receive 1
+[GTRACE] func_end 2 main.producer main.go:6 1000005100 return
+`
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	graph, err := p.Parse(strings.NewReader(trace), ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := parser.BlockingProfile{
+		ByChannel: map[string]parser.BlockedTime{
+			"chan_1": {Ops: 4, Total: 200 + 100 + 4300 + 3000, Max: 4300},
+		},
+		BySite: map[string]parser.BlockedTime{
+			"main.go:7":  {Ops: 2, Total: 200 + 4300, Max: 4300},
+			"main.go:8":  {Ops: 1, Total: 100, Max: 100},
+			"main.go:11": {Ops: 1, Total: 3000, Max: 3000},
+		},
+		ByGoroutine: map[string]parser.BlockedTime{
+			"1": {Ops: 2, Total: 100 + 3000, Max: 3000},
+			"2": {Ops: 2, Total: 200 + 4300, Max: 4300},
+		},
+	}
+	if !reflect.DeepEqual(graph.Blocking, want) {
+		t.Fatalf("время ожидания:\n%+v\nожидалось:\n%+v", graph.Blocking, want)
+	}
+
+	// в отчёте разрезы отсортированы по суммарному ожиданию
+	report := graph.Blocking.String()
+	if strings.Index(report, "main.go:7 ") > strings.Index(report, "main.go:11 ") ||
+		strings.Index(report, "main.go:11 ") > strings.Index(report, "main.go:8 ") {
+		t.Errorf("места операций не отсортированы по ожиданию:\n%s", report)
+	}
+}
@@ -0,0 +1,262 @@
+package parser
+
+import (
+	"gtrace/src/domain/parser"
+	"strconv"
+	"time"
+)
+
+// GraphBuilder — потребитель потока событий, собирающий граф горутин и каналов. Помимо графа
+// хранит только события, ожидающие пары
+type GraphBuilder struct {
+	graph *parser.GorutineGraph
+	// go_spawn и func_start связываются по номеру запуска в любом порядке
+	spawns         map[string]spawn
+	startedBySpawn map[string]string
+	// namespace — источник текущего события при объединении трасс
+	namespace string
+}
+
+func NewGraphBuilder() *GraphBuilder {
+	return &GraphBuilder{
+		graph: &parser.GorutineGraph{
+			Gorutines: make(map[string]parser.Goroutine),
+			Channels:  make(map[string]parser.Channel),
+			Edges:     []parser.Edge{},
+		},
+		spawns:         make(map[string]spawn),
+		startedBySpawn: make(map[string]string),
+	}
+}
+
+// Add добавляет событие в граф. События неизвестных типов пропускаются
+func (s *GraphBuilder) Add(ev parser.Event) {
+	switch ev := ev.(type) {
+	case *parser.SourcedEvent:
+		s.namespace = ev.Source
+		s.source()
+		s.Add(ev.Event)
+		s.namespace = ""
+
+	case *parser.TraceHeaderEvent:
+		header := &parser.TraceHeader{
+			Version:    ev.Version,
+			GoVersion:  ev.GoVersion,
+			GOMAXPROCS: strconv.Itoa(ev.GOMAXPROCS),
+			Start:      ev.Start.String(),
+			Args:       ev.Args,
+		}
+		if source := s.source(); source != nil {
+			source.Header = header
+			if s.graph.Header != nil {
+				return
+			}
+		}
+		s.graph.Header = header
+
+	case *parser.ChannelCreateEvent:
+		channelName := s.channel(ev.Channel)
+		ch := s.graph.Channels[channelName]
+		ch.File = string(ev.Site)
+		ch.TS = ev.TS.String()
+		ch.Cap = strconv.Itoa(ev.Cap)
+		s.graph.Channels[channelName] = ch
+
+	case *parser.GoSpawnEvent:
+		parent := s.goroutine(ev.Goroutine)
+		spawnID := s.spawnID(ev.Spawn)
+		ensureGoroutine(s.graph, parent)
+		sp := spawn{parent: parent, site: string(ev.Site), ts: ev.TS.String()}
+		if child, ok := s.startedBySpawn[spawnID]; ok {
+			linkSpawn(s.graph, child, sp)
+			delete(s.startedBySpawn, spawnID)
+			return
+		}
+		s.spawns[spawnID] = sp
+
+	case *parser.FuncStartEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		spawnID := s.spawnID(ev.Spawn)
+		gr := s.graph.Gorutines[goroutineID]
+		gr.ID = goroutineID
+		gr.Func = ev.Func
+		gr.File = string(ev.Caller)
+		gr.TS = ev.TS.String()
+		gr.State = parser.StateRunning
+		s.graph.Gorutines[goroutineID] = gr
+		if sp, ok := s.spawns[spawnID]; ok {
+			linkSpawn(s.graph, goroutineID, sp)
+			delete(s.spawns, spawnID)
+		} else {
+			s.startedBySpawn[spawnID] = goroutineID
+		}
+
+	case *parser.FuncEndEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		gr := ensureGoroutine(s.graph, goroutineID)
+		gr.EndTS = ev.TS.String()
+		switch ev.Reason {
+		case "return":
+			gr.State = parser.StateReturned
+		case "goexit":
+			gr.State = parser.StateGoexit
+		case "panic":
+			gr.State = parser.StatePanicked
+			gr.PanicValue = ev.PanicValue
+			gr.Stack = ev.Stack
+		}
+		s.graph.Gorutines[goroutineID] = gr
+
+	case *parser.ChannelSendEvent:
+		s.channelOp(ev.Op, "send", ev.Channel)
+
+	case *parser.ChannelReceiveEvent:
+		s.channelOp(ev.Op, "receive", ev.Channel)
+
+	case *parser.SelectEnterEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		op := parser.ChannelOp{Kind: "select", Site: string(ev.Site), TS: ev.TS.String(), Default: ev.Default}
+		for _, c := range ev.Cases {
+			op.Cases = append(op.Cases, parser.ChannelOp{Kind: c.Dir, Channel: s.channel(c.Channel)})
+		}
+		for _, c := range op.Cases {
+			addParticipant(s.graph, goroutineID, c.Kind, c.Channel)
+		}
+		setLastOp(s.graph, goroutineID, op)
+
+	case *parser.SelectCaseChosenEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		channelName := s.channel(ev.Channel)
+		s.graph.Edges = append(s.graph.Edges, opEdge(goroutineID, ev.Dir, channelName, ev.TS.String()))
+		countOp(s.graph, goroutineID, ev.Dir, channelName)
+		// ожидание select — от входа в select до выбора ветки
+		if last := s.graph.Gorutines[goroutineID].LastOp; last != nil && last.Kind == "select" && !last.Done {
+			if wait, ok := tsSince(last.TS, ev.TS.String()); ok {
+				s.graph.Blocking.Record(channelName, s.site(ev.Site), goroutineID, wait)
+			}
+		}
+		setLastOp(s.graph, goroutineID, parser.ChannelOp{Kind: ev.Dir, Channel: channelName, Site: string(ev.Site), TS: ev.TS.String(), Done: true})
+
+	case *parser.ChannelOpDoneEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		channelName := s.channel(ev.Channel)
+		s.graph.Blocking.Record(channelName, s.site(ev.Site), goroutineID, ev.Wait)
+		gr := ensureGoroutine(s.graph, goroutineID)
+		if gr.LastOp != nil && gr.LastOp.Channel == channelName {
+			done := *gr.LastOp
+			done.Done = true
+			gr.LastOp = &done
+			s.graph.Gorutines[goroutineID] = gr
+		}
+
+	case *parser.ChannelCloseEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		channelName := s.channel(ev.Channel)
+		ch := s.graph.Channels[channelName]
+		ch.TS = ev.TS.String()
+		ch.Closed = true
+		s.graph.Channels[channelName] = ch
+		s.graph.Edges = append(s.graph.Edges, parser.Edge{
+			From:  goroutineID,
+			To:    channelName,
+			Label: "close",
+			TS:    ev.TS.String(),
+		})
+		// закрытие будит получателей, поэтому закрывшая горутина считается отправителем
+		addParticipant(s.graph, goroutineID, "send", channelName)
+
+	case *parser.ChannelCloseErrorEvent:
+		s.graph.Incidents = append(s.graph.Incidents, parser.Incident{
+			Kind:      parser.IncidentCloseError,
+			Goroutine: s.goroutine(ev.Goroutine),
+			Channel:   s.channel(ev.Channel),
+			Site:      string(ev.Site),
+			TS:        ev.TS.String(),
+			Message:   ev.Message,
+		})
+
+	case *parser.GoroutineStateEvent:
+		goroutineID := s.goroutine(ev.Goroutine)
+		gr := ensureGoroutine(s.graph, goroutineID)
+		gr.WaitReason = ev.Reason
+		gr.Waiting = ev.Wait
+		gr.Stack = ev.Stack
+		s.graph.Gorutines[goroutineID] = gr
+
+	case *parser.ShutdownEvent:
+		shutdown := &parser.Shutdown{
+			Goroutine: s.goroutine(ev.Goroutine),
+			Reason:    ev.Reason,
+			File:      string(ev.Caller),
+			TS:        ev.TS.String(),
+			Code:      strconv.Itoa(ev.Code),
+		}
+		if source := s.source(); source != nil {
+			source.Shutdown = shutdown
+			for _, other := range s.graph.Sources {
+				if other.Shutdown == nil {
+					return
+				}
+			}
+		}
+		s.graph.Shutdown = shutdown
+	}
+}
+
+// Graph возвращает собранный граф
+func (s *GraphBuilder) Graph() *parser.GorutineGraph {
+	return s.graph
+}
+
+// channelOp добавляет начатую отправку или получение: ребро, счётчики канала и последнюю операцию горутины
+func (s *GraphBuilder) channelOp(op parser.Op, dir string, channel parser.ChannelID) {
+	goroutineID := s.goroutine(op.Goroutine)
+	channelName := s.channel(channel)
+	s.graph.Edges = append(s.graph.Edges, opEdge(goroutineID, dir, channelName, op.TS.String()))
+	countOp(s.graph, goroutineID, dir, channelName)
+	setLastOp(s.graph, goroutineID, parser.ChannelOp{Kind: dir, Channel: channelName, Site: string(op.Site), TS: op.TS.String()})
+}
+
+// SetSource задаёт источник и сдвиг часов объединяемой трассы до её первого события
+func (s *GraphBuilder) SetSource(name string, offset time.Duration) {
+	s.namespace = name
+	s.source().Offset = offset
+	s.namespace = ""
+}
+
+// source возвращает источник текущего события, добавляя его в граф при первой встрече;
+// nil — трассы не объединяются
+func (s *GraphBuilder) source() *parser.TraceSource {
+	if s.namespace == "" {
+		return nil
+	}
+	for i := range s.graph.Sources {
+		if s.graph.Sources[i].Name == s.namespace {
+			return &s.graph.Sources[i]
+		}
+	}
+	s.graph.Sources = append(s.graph.Sources, parser.TraceSource{Name: s.namespace})
+	return &s.graph.Sources[len(s.graph.Sources)-1]
+}
+
+// goroutine возвращает ключ горутины в графе
+func (s *GraphBuilder) goroutine(id parser.GoroutineID) string {
+	return parser.Namespaced(s.namespace, id.String())
+}
+
+// spawnID возвращает номер запуска, по которому связываются go_spawn и func_start
+func (s *GraphBuilder) spawnID(spawn uint64) string {
+	return parser.Namespaced(s.namespace, strconv.FormatUint(spawn, 10))
+}
+
+// channel возвращает ключ канала и добавляет канал в граф, если он не встречался в channel_create;
+// номер канала остаётся номером внутри источника (0 — nil-канал)
+func (s *GraphBuilder) channel(id parser.ChannelID) string {
+	return ensureChannel(s.graph, parser.Namespaced(s.namespace, id.Key()), id.String())
+}
+
+// site возвращает место операции для профиля ожидания: места одинаковых файлов разных трасс
+// учитываются отдельно
+func (s *GraphBuilder) site(site parser.Site) string {
+	return parser.Namespaced(s.namespace, string(site))
+}
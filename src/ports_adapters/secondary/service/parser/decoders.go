@@ -0,0 +1,264 @@
+package parser
+
+import (
+	"fmt"
+	"gtrace/src/domain/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decoder разбирает поля события одного типа (без [GTRACE] и типа) в типизированное событие
+type decoder func(f *fields) parser.Event
+
+// decoders — разбор каждого типа событий, которые пишет рантайм gtrace (instrumented/helper.go)
+// или получают импортёры других форматов (goroutine_state — из дампа горутин, dump.go).
+// Раскладка полей должна совпадать с вызовами emit в рантайме
+var decoders = map[string]decoder{
+	"trace_header": func(f *fields) parser.Event {
+		f.require(4)
+		return &parser.TraceHeaderEvent{
+			Version:    f.str(0),
+			GoVersion:  f.str(1),
+			GOMAXPROCS: f.int(2),
+			Start:      f.ts(3),
+			Args:       f.rest(4),
+		}
+	},
+	"go_spawn": func(f *fields) parser.Event {
+		f.require(6)
+		return &parser.GoSpawnEvent{
+			Op:    parser.Op{Goroutine: f.goroutine(0), Site: f.site(3), Caller: f.site(4), TS: f.ts(5)},
+			Spawn: f.uint(1),
+			Func:  f.str(2),
+		}
+	},
+	"func_start": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.FuncStartEvent{
+			Goroutine: f.goroutine(0),
+			Func:      f.str(1),
+			Caller:    f.site(2),
+			TS:        f.ts(3),
+			Spawn:     f.uint(4),
+		}
+	},
+	"func_end": func(f *fields) parser.Event {
+		f.require(5)
+		ev := &parser.FuncEndEvent{
+			Goroutine: f.goroutine(0),
+			Func:      f.str(1),
+			Caller:    f.site(2),
+			TS:        f.ts(3),
+			Reason:    f.str(4),
+		}
+		switch ev.Reason {
+		case "return", "goexit":
+		case "panic":
+			f.require(7)
+			ev.PanicValue = f.str(5)
+			ev.Stack = f.str(6)
+		default:
+			f.fail(4, "reason")
+		}
+		return ev
+	},
+	"channel_create": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.ChannelCreateEvent{
+			Channel: f.channel(0),
+			Site:    f.site(1),
+			Caller:  f.site(2),
+			TS:      f.ts(3),
+			Cap:     f.int(4),
+		}
+	},
+	"channel_send": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.ChannelSendEvent{Op: f.op(), Channel: f.channel(1)}
+	},
+	"channel_receive": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.ChannelReceiveEvent{Op: f.op(), Channel: f.channel(1)}
+	},
+	"channel_send_done":    opDone("send"),
+	"channel_receive_done": opDone("receive"),
+	"channel_close": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.ChannelCloseEvent{Op: f.op(), Channel: f.channel(1)}
+	},
+	"channel_close_error": func(f *fields) parser.Event {
+		f.require(6)
+		return &parser.ChannelCloseErrorEvent{Op: f.op(), Channel: f.channel(1), Message: f.str(5)}
+	},
+	"select_enter": func(f *fields) parser.Event {
+		f.require(5)
+		ev := &parser.SelectEnterEvent{
+			Op: parser.Op{Goroutine: f.goroutine(0), Site: f.site(1), Caller: f.site(2), TS: f.ts(3)},
+		}
+		// ветки select с каналами: <send|receive>:<канал>,...; ветка default не попадает в список.
+		// Трассы без списка веток не позволяют отличить ветку default
+		if len(f.parts) < 6 {
+			return ev
+		}
+		if list := f.str(5); list != "-" {
+			for _, c := range strings.Split(list, ",") {
+				dir, id, ok := strings.Cut(c, ":")
+				channel, err := strconv.ParseUint(id, 10, 64)
+				if !ok || err != nil {
+					f.fail(5, "case")
+					return ev
+				}
+				ev.Cases = append(ev.Cases, parser.SelectCase{Dir: dir, Channel: parser.ChannelID(channel)})
+			}
+		}
+		ev.Default = f.int(4) > len(ev.Cases)
+		return ev
+	},
+	"select_case_chosen": func(f *fields) parser.Event {
+		f.require(6)
+		return &parser.SelectCaseChosenEvent{
+			Op:      parser.Op{Goroutine: f.goroutine(0), Site: f.site(1), Caller: f.site(2), TS: f.ts(3)},
+			Dir:     f.str(4),
+			Channel: f.channel(5),
+		}
+	},
+	"select_default": func(f *fields) parser.Event {
+		f.require(4)
+		return &parser.SelectDefaultEvent{
+			Op: parser.Op{Goroutine: f.goroutine(0), Site: f.site(1), Caller: f.site(2), TS: f.ts(3)},
+		}
+	},
+	"shutdown": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.ShutdownEvent{
+			Goroutine: f.goroutine(0),
+			Reason:    f.str(1),
+			Caller:    f.site(2),
+			TS:        f.ts(3),
+			Code:      f.int(4),
+		}
+	},
+	"goroutine_state": func(f *fields) parser.Event {
+		f.require(5)
+		return &parser.GoroutineStateEvent{
+			Goroutine: f.goroutine(0),
+			Reason:    f.str(1),
+			Wait:      time.Duration(f.int64(2)),
+			Site:      f.site(3),
+			Stack:     f.str(4),
+		}
+	},
+}
+
+// opDone — разбор завершения отправки или получения
+func opDone(dir string) decoder {
+	return func(f *fields) parser.Event {
+		f.require(6)
+		return &parser.ChannelOpDoneEvent{
+			Op:      f.op(),
+			Channel: f.channel(1),
+			Dir:     dir,
+			Wait:    time.Duration(f.int64(5)),
+		}
+	}
+}
+
+// decodeEvent разбирает событие, разбитое на поля так же, как строка текстового формата.
+// Событие неизвестного типа возвращается как RawEvent
+func decodeEvent(parts []string) (parser.Event, error) {
+	kind := parts[1]
+	decode, ok := decoders[kind]
+	if !ok {
+		return &parser.RawEvent{Type: kind, Fields: parts[2:]}, nil
+	}
+	f := &fields{kind: kind, parts: parts[2:]}
+	ev := decode(f)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return ev, nil
+}
+
+// fields читает поля события по номеру; первая ошибка запоминается, последующие чтения
+// возвращают нулевые значения
+type fields struct {
+	kind  string
+	parts []string
+	err   error
+}
+
+// require проверяет, что у события не меньше n полей
+func (f *fields) require(n int) {
+	if f.err == nil && len(f.parts) < n {
+		f.err = fmt.Errorf("invalid %s format: %d fields, want %d", f.kind, len(f.parts), n)
+	}
+}
+
+// fail запоминает ошибку в поле i; what — что это за поле
+func (f *fields) fail(i int, what string) {
+	if f.err == nil {
+		f.err = fmt.Errorf("invalid %s %s: %q", f.kind, what, f.str(i))
+	}
+}
+
+func (f *fields) str(i int) string {
+	if f.err != nil || i >= len(f.parts) {
+		return ""
+	}
+	return f.parts[i]
+}
+
+func (f *fields) rest(i int) []string {
+	if f.err != nil || i >= len(f.parts) {
+		return nil
+	}
+	return f.parts[i:]
+}
+
+func (f *fields) site(i int) parser.Site {
+	return parser.Site(f.str(i))
+}
+
+func (f *fields) int64(i int) int64 {
+	if f.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(f.str(i), 10, 64)
+	if err != nil {
+		f.fail(i, "field "+strconv.Itoa(i))
+	}
+	return v
+}
+
+func (f *fields) int(i int) int {
+	return int(f.int64(i))
+}
+
+func (f *fields) uint(i int) uint64 {
+	if f.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseUint(f.str(i), 10, 64)
+	if err != nil {
+		f.fail(i, "field "+strconv.Itoa(i))
+	}
+	return v
+}
+
+func (f *fields) ts(i int) parser.Timestamp {
+	return parser.Timestamp(f.int64(i))
+}
+
+func (f *fields) goroutine(i int) parser.GoroutineID {
+	return parser.GoroutineID(f.uint(i))
+}
+
+func (f *fields) channel(i int) parser.ChannelID {
+	return parser.ChannelID(f.uint(i))
+}
+
+// op читает общие поля операций с каналом: горутина, канал (пропускается), место, вызов, время
+func (f *fields) op() parser.Op {
+	return parser.Op{Goroutine: f.goroutine(0), Site: f.site(2), Caller: f.site(3), TS: f.ts(4)}
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"gtrace/src/domain/parser"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Каждое поле записи попадает в своё поле типизированного события; раскладка должна совпадать
+// с вызовами emit в рантайме (instrumented/helper.go)
+func TestDecoders(t *testing.T) {
+	op := parser.Op{Goroutine: 7, Site: "main.go:10", Caller: "/app/main.go:12", TS: 1000}
+	tests := []struct {
+		line string
+		want parser.Event
+	}{
+		{
+			line: `[GTRACE] trace_header 1 go1.24.0 4 1000 ./app -v "a b"`,
+			want: &parser.TraceHeaderEvent{Version: "1", GoVersion: "go1.24.0", GOMAXPROCS: 4, Start: 1000, Args: []string{"./app", "-v", "a b"}},
+		},
+		{
+			line: `[GTRACE] go_spawn 7 3 main.worker main.go:10 /app/main.go:12 1000`,
+			want: &parser.GoSpawnEvent{Op: op, Spawn: 3, Func: "main.worker"},
+		},
+		{
+			line: `[GTRACE] func_start 8 main.worker /app/main.go:20 1000 3`,
+			want: &parser.FuncStartEvent{Goroutine: 8, Func: "main.worker", Caller: "/app/main.go:20", TS: 1000, Spawn: 3},
+		},
+		{
+			line: `[GTRACE] func_end 8 main.worker /app/main.go:20 1000 goexit`,
+			want: &parser.FuncEndEvent{Goroutine: 8, Func: "main.worker", Caller: "/app/main.go:20", TS: 1000, Reason: "goexit"},
+		},
+		{
+			line: `[GTRACE] func_end 8 main.worker /app/main.go:20 1000 panic "boom" "goroutine 8 [running]:\nmain.worker()"`,
+			want: &parser.FuncEndEvent{Goroutine: 8, Func: "main.worker", Caller: "/app/main.go:20", TS: 1000, Reason: "panic",
+				PanicValue: "boom", Stack: "goroutine 8 [running]:\nmain.worker()"},
+		},
+		{
+			line: `[GTRACE] channel_create 5 main.go:10 /app/main.go:12 1000 2`,
+			want: &parser.ChannelCreateEvent{Channel: 5, Site: "main.go:10", Caller: "/app/main.go:12", TS: 1000, Cap: 2},
+		},
+		{
+			line: `[GTRACE] channel_send 7 5 main.go:10 /app/main.go:12 1000`,
+			want: &parser.ChannelSendEvent{Op: op, Channel: 5},
+		},
+		{
+			line: `[GTRACE] channel_receive 7 5 main.go:10 /app/main.go:12 1000`,
+			want: &parser.ChannelReceiveEvent{Op: op, Channel: 5},
+		},
+		{
+			line: `[GTRACE] channel_send_done 7 5 main.go:10 /app/main.go:12 1000 250`,
+			want: &parser.ChannelOpDoneEvent{Op: op, Channel: 5, Dir: "send", Wait: 250 * time.Nanosecond},
+		},
+		{
+			line: `[GTRACE] channel_receive_done 7 5 main.go:10 /app/main.go:12 1000 0`,
+			want: &parser.ChannelOpDoneEvent{Op: op, Channel: 5, Dir: "receive"},
+		},
+		{
+			line: `[GTRACE] channel_close 7 5 main.go:10 /app/main.go:12 1000`,
+			want: &parser.ChannelCloseEvent{Op: op, Channel: 5},
+		},
+		{
+			line: `[GTRACE] channel_close_error 7 5 main.go:10 /app/main.go:12 1000 "close of closed channel"`,
+			want: &parser.ChannelCloseErrorEvent{Op: op, Channel: 5, Message: "close of closed channel"},
+		},
+		{
+			line: `[GTRACE] select_enter 7 main.go:10 /app/main.go:12 1000 3 send:5,receive:6`,
+			want: &parser.SelectEnterEvent{Op: op, Cases: []parser.SelectCase{{Dir: "send", Channel: 5}, {Dir: "receive", Channel: 6}}, Default: true},
+		},
+		{
+			// трасса без списка веток: ветку default не отличить
+			line: `[GTRACE] select_enter 7 main.go:10 /app/main.go:12 1000 2`,
+			want: &parser.SelectEnterEvent{Op: op},
+		},
+		{
+			line: `[GTRACE] select_case_chosen 7 main.go:10 /app/main.go:12 1000 receive 6`,
+			want: &parser.SelectCaseChosenEvent{Op: op, Dir: "receive", Channel: 6},
+		},
+		{
+			line: `[GTRACE] select_default 7 main.go:10 /app/main.go:12 1000`,
+			want: &parser.SelectDefaultEvent{Op: op},
+		},
+		{
+			line: `[GTRACE] shutdown 1 exit /app/main.go:30 1000 3`,
+			want: &parser.ShutdownEvent{Goroutine: 1, Reason: "exit", Caller: "/app/main.go:30", TS: 1000, Code: 3},
+		},
+		{
+			line: `[GTRACE] goroutine_state 7 "chan receive" 60000000000 /app/main.go:12 "main.worker()"`,
+			want: &parser.GoroutineStateEvent{Goroutine: 7, Reason: "chan receive", Wait: time.Minute, Site: "/app/main.go:12", Stack: "main.worker()"},
+		},
+		{
+			line: `[GTRACE] future_event 1 2`,
+			want: &parser.RawEvent{Type: "future_event", Fields: []string{"1", "2"}},
+		},
+	}
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.want.Kind(), func(t *testing.T) {
+			parts, err := splitFields(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			covered[parts[1]] = true
+			ev, err := decodeEvent(parts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ev, tt.want) {
+				t.Errorf("событие:\n%+v\nожидалось:\n%+v", ev, tt.want)
+			}
+		})
+	}
+	for kind := range decoders {
+		if !covered[kind] {
+			t.Errorf("нет записи %s", kind)
+		}
+	}
+}
+
+// Запись с недостающими или некорректными полями — ошибка, а не событие с нулевыми полями
+func TestDecodersReject(t *testing.T) {
+	tests := []struct {
+		line string
+		err  string
+	}{
+		{line: `[GTRACE] channel_close 7 5 main.go:10 /app/main.go:12`, err: "invalid channel_close format: 4 fields, want 5"},
+		{line: `[GTRACE] channel_send x 5 main.go:10 /app/main.go:12 1000`, err: `invalid channel_send field 0: "x"`},
+		{line: `[GTRACE] func_end 8 main.worker /app/main.go:20 1000 crash`, err: `invalid func_end reason: "crash"`},
+		{line: `[GTRACE] func_end 8 main.worker /app/main.go:20 1000 panic "boom"`, err: "invalid func_end format: 6 fields, want 7"},
+		{line: `[GTRACE] select_enter 7 main.go:10 /app/main.go:12 1000 1 send`, err: `invalid select_enter case: "send"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			parts, err := splitFields(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev, err := decodeEvent(parts)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("событие %+v, ошибка %v, ожидалась %q", ev, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"gtrace/src/domain/parser"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Дамп горутин (kill -QUIT, GOTRACEBACK, /debug/pprof/goroutine?debug=2) — снимок зависшей программы:
+// для каждой горутины известны причина и время ожидания, стек и создатель, но не история операций.
+// Каждая горутина становится func_start (и go_spawn, если известна создавшая горутина), операцией,
+// на которой она ждёт, и goroutine_state со стеком. Каналы в дампе не идентифицируются, поэтому,
+// как и для трассы выполнения Go, ожидание на канале отображается на канал-заглушку места операции.
+// Время в дампе не записано: все события получают нулевую временную метку
+
+var (
+	// dumpHeader — заголовок горутины: "goroutine 7 [chan receive, 2 minutes]:";
+	// с GOTRACEBACK=system и выше после номера идут поля gp=... m=...
+	dumpHeader = regexp.MustCompile(`^goroutine (\d+)(?: \S+=\S+)* \[(.*)\]:$`)
+	// dumpCreatedBy — создатель горутины; "in goroutine N" пишется начиная с Go 1.21
+	dumpCreatedBy = regexp.MustCompile(`^created by (\S+)(?: in goroutine (\d+))?$`)
+	// dumpLocation — место кадра: "\t/app/main.go:12 +0x25"; с GOTRACEBACK=system и выше дальше идут fp=... sp=... pc=...
+	dumpLocation = regexp.MustCompile(`^\t(.+?:\d+)(?: \+0x[0-9a-f]+)?(?: \S+=\S+)*$`)
+	// dumpCall — вызов функции в кадре: "main.worker(0xc000012345, 0x1)"
+	dumpCall = regexp.MustCompile(`^(.+)\([^()]*\)$`)
+	// dumpMinutes — время ожидания в заголовке горутины
+	dumpMinutes = regexp.MustCompile(`^(\d+) minutes?$`)
+	// dumpWrapper — обёртка, которую компилятор создаёт для go-оператора с аргументами: main.main.gowrap1
+	dumpWrapper = regexp.MustCompile(`\.gowrap\d+$`)
+)
+
+// isGoroutineDump сообщает, что поток — дамп горутин: в начале есть заголовок горутины и нет событий gtrace
+func isGoroutineDump(reader *bufio.Reader) bool {
+	head, _ := reader.Peek(reader.Size())
+	if bytes.Contains(head, []byte("[GTRACE]")) {
+		return false
+	}
+	for _, line := range strings.Split(string(head), "\n") {
+		if dumpHeader.MatchString(strings.TrimRight(line, "\r")) {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpFrame — кадр стека: функция и её место
+type dumpFrame struct {
+	fn   string
+	site string
+}
+
+// dumpGoroutine — горутина дампа, собираемая по строкам
+type dumpGoroutine struct {
+	id     string
+	line   int
+	reason string
+	wait   time.Duration
+	frames []dumpFrame
+	stack  []string
+	// parent и spawnSite — создавшая горутина и место go-оператора
+	parent    string
+	creator   string
+	spawnSite string
+}
+
+// dumpDecoder переводит горутины дампа в события gtrace в виде полей текстового формата
+type dumpDecoder struct {
+	scanner  *bufio.Scanner
+	line     int
+	done     bool
+	current  *dumpGoroutine
+	pending  [][]string
+	channels siteChannels
+}
+
+func newGoroutineDumpEventReader(reader *bufio.Reader, mode Mode) *EventReader {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	d := &dumpDecoder{scanner: scanner, channels: make(siteChannels)}
+	// заголовок отличает снимок от трассы, оборванной до завершения программы, в том числе
+	// для дампа, в котором только горутины рантайма
+	d.emit(0, "trace_header", parser.TraceVersionDump, "", "0", "0")
+	return &EventReader{next: d.next, scanner: scanner, mode: mode}
+}
+
+// next возвращает следующее событие gtrace и номер строки заголовка горутины, из которой оно получено
+func (d *dumpDecoder) next() ([]string, int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return nil, d.line, io.EOF
+		}
+		if !d.scanner.Scan() {
+			d.done = true
+			d.flush()
+			continue
+		}
+		d.line++
+		d.parse(strings.TrimRight(d.scanner.Text(), "\r"))
+	}
+	parts := d.pending[0]
+	d.pending = d.pending[1:]
+	line, _ := strconv.Atoi(parts[0])
+	parts[0] = "[GTRACE]"
+	return parts, line, nil
+}
+
+func (d *dumpDecoder) parse(text string) {
+	if m := dumpHeader.FindStringSubmatch(text); m != nil {
+		d.flush()
+		g := &dumpGoroutine{id: m[1], line: d.line}
+		state := strings.Split(m[2], ", ")
+		g.reason = state[0]
+		for _, s := range state[1:] {
+			if minutes := dumpMinutes.FindStringSubmatch(s); minutes != nil {
+				n, _ := strconv.Atoi(minutes[1])
+				g.wait = time.Duration(n) * time.Minute
+			}
+		}
+		d.current = g
+		return
+	}
+
+	g := d.current
+	switch {
+	case g == nil:
+		// строки вне горутин: SIGQUIT, регистры, вывод программы
+	case text == "":
+		d.flush()
+	case strings.HasPrefix(text, "created by "):
+		g.stack = append(g.stack, text)
+		if m := dumpCreatedBy.FindStringSubmatch(text); m != nil {
+			g.creator, g.parent = m[1], m[2]
+		}
+	case strings.HasPrefix(text, "\t"):
+		g.stack = append(g.stack, text)
+		m := dumpLocation.FindStringSubmatch(text)
+		if m == nil {
+			return
+		}
+		switch {
+		case g.creator != "":
+			g.spawnSite = m[1]
+		case len(g.frames) > 0 && g.frames[len(g.frames)-1].site == "":
+			g.frames[len(g.frames)-1].site = m[1]
+		}
+	default:
+		g.stack = append(g.stack, text)
+		m := dumpCall.FindStringSubmatch(text)
+		if m == nil || dumpWrapper.MatchString(m[1]) {
+			return
+		}
+		// runtime.goexit и runtime.main — нижние кадры горутин с GOTRACEBACK=system и выше:
+		// функцией горутины остаётся функция, запущенная go-оператором, или main.main
+		if m[1] != "runtime.goexit" && (m[1] != "runtime.main" || len(g.frames) == 0) {
+			g.frames = append(g.frames, dumpFrame{fn: m[1]})
+		}
+	}
+}
+
+// emit добавляет событие; номер строки временно хранится на месте префикса [GTRACE]
+func (d *dumpDecoder) emit(line int, kind string, fields ...string) {
+	d.pending = append(d.pending, append([]string{strconv.Itoa(line), kind}, fields...))
+}
+
+// flush переводит собранную горутину в события; горутины рантайма пропускаются
+func (d *dumpDecoder) flush() {
+	g := d.current
+	d.current = nil
+	if g == nil || len(g.frames) == 0 {
+		return
+	}
+	entry := g.frames[len(g.frames)-1]
+	if isSystemFunc(entry.fn) {
+		return
+	}
+	site := g.frames[0].site
+	for _, f := range g.frames {
+		if !isSystemFunc(f.fn) {
+			site = f.site
+			break
+		}
+	}
+
+	// номер запуска — номер горутины
+	if g.parent != "" {
+		d.emit(g.line, "go_spawn", g.parent, g.id, entry.fn, g.spawnSite, g.spawnSite, "0")
+	}
+	d.emit(g.line, "func_start", g.id, entry.fn, entry.site, "0", g.id)
+
+	reason, qualifier, _ := strings.Cut(g.reason, " (")
+	switch {
+	case qualifier != "" && (reason == "select" || strings.HasPrefix(reason, "chan ")):
+		// select без веток или операция с nil-каналом: горутина не проснётся
+		d.emit(g.line, "select_enter", g.id, site, site, "0", "0", "-")
+	case reason == "chan send" || reason == "chan receive":
+		id, created := d.channels.get(site)
+		ch := strconv.FormatUint(id, 10)
+		if created {
+			d.emit(g.line, "channel_create", ch, site, site, "0", "0")
+		}
+		d.emit(g.line, "channel_"+strings.TrimPrefix(reason, "chan "), g.id, ch, site, site, "0")
+	case reason == "select":
+		d.emit(g.line, "select_enter", g.id, site, site, "0", "0", "-")
+	}
+
+	d.emit(g.line, "goroutine_state", g.id, g.reason, strconv.FormatInt(int64(g.wait), 10), site,
+		strings.Join(g.stack, "\n"))
+}
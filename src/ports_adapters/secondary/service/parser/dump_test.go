@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"bufio"
+	"gtrace/src/domain/parser"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpGoroutineWant — ожидаемые поля горутины, импортированной из дампа
+type dumpGoroutineWant struct {
+	Func       string
+	Parent     string
+	SpawnSite  string
+	WaitReason string
+	Waiting    time.Duration
+}
+
+// Горутины дампа получают функцию, создателя, причину и время ожидания; горутины рантайма
+// пропускаются, одинаковые стеки группируются, а ожидание на каналах анализируется как в трассе
+func TestGoroutineDump(t *testing.T) {
+	tests := []struct {
+		dump       string
+		goroutines map[string]dumpGoroutineWant
+		// groups — горутины групп одинаковых стеков, самые многочисленные первыми
+		groups   [][]string
+		blocked  []string
+		deadlock bool
+		// unfinished — в дампе нет завершения программы: живые горутины не утечки, а незавершённые
+		unfinished int
+	}{
+		{
+			// SIGQUIT с GOTRACEBACK=system: поля gp= m=, кадры runtime.main и runtime.goexit, обёртки gowrap
+			dump: "sigquit.txt",
+			goroutines: map[string]dumpGoroutineWant{
+				"1":  {Func: "main.main", WaitReason: "chan receive", Waiting: 2 * time.Minute},
+				"18": {Func: "main.worker", Parent: "1", SpawnSite: "/app/main.go:17", WaitReason: "chan send", Waiting: 2 * time.Minute},
+				"19": {Func: "main.worker", Parent: "1", SpawnSite: "/app/main.go:17", WaitReason: "chan send", Waiting: 2 * time.Minute},
+				"20": {Func: "main.idle", Parent: "1", SpawnSite: "/app/main.go:19", WaitReason: "select (no cases)"},
+			},
+			groups:     [][]string{{"18", "19"}, {"1"}, {"20"}},
+			blocked:    []string{"1", "18", "19", "20"},
+			deadlock:   true,
+			unfinished: 4,
+		},
+		{
+			// /debug/pprof/goroutine?debug=2: создатель без "in goroutine N", кадры без смещений
+			dump: "pprof.txt",
+			goroutines: map[string]dumpGoroutineWant{
+				"7": {Func: "example.com/svc/batch.(*Runner).Run", WaitReason: "semacquire", Waiting: 5 * time.Minute},
+				"8": {Func: "example.com/svc/batch.(*Runner).loop", WaitReason: "select"},
+				"9": {Func: "example.com/svc/batch.(*Runner).drain", WaitReason: "chan receive"},
+			},
+			groups:     [][]string{{"7"}, {"8"}, {"9"}},
+			blocked:    []string{"8", "9"},
+			unfinished: 3,
+		},
+	}
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, tt := range tests {
+		t.Run(tt.dump, func(t *testing.T) {
+			graph, err := p.ParseFromFile(filepath.Join("testdata", "dumps", tt.dump), ModeStrict)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if graph.Header == nil || graph.Header.Version != parser.TraceVersionDump {
+				t.Errorf("заголовок %+v, ожидалась версия %q", graph.Header, parser.TraceVersionDump)
+			}
+
+			got := make(map[string]dumpGoroutineWant)
+			for id, gr := range graph.Gorutines {
+				got[id] = dumpGoroutineWant{Func: gr.Func, Parent: gr.Parent, SpawnSite: gr.SpawnSite,
+					WaitReason: gr.WaitReason, Waiting: gr.Waiting}
+			}
+			if !reflect.DeepEqual(got, tt.goroutines) {
+				t.Errorf("горутины:\n%+v\nожидались:\n%+v", got, tt.goroutines)
+			}
+
+			var groups [][]string
+			for _, group := range graph.StackGroups().Groups {
+				groups = append(groups, group.Goroutines)
+			}
+			if !reflect.DeepEqual(groups, tt.groups) {
+				t.Errorf("группы стеков %v, ожидались %v", groups, tt.groups)
+			}
+
+			report := graph.Deadlocks()
+			var blocked []string
+			for _, b := range report.Blocked {
+				blocked = append(blocked, b.Goroutine.ID)
+			}
+			if !reflect.DeepEqual(blocked, tt.blocked) {
+				t.Errorf("заблокированы %v, ожидались %v", blocked, tt.blocked)
+			}
+			if report.Found() != tt.deadlock {
+				t.Errorf("взаимная блокировка %v, ожидалась %v", report.Found(), tt.deadlock)
+			}
+			leaks := graph.Leaks()
+			if len(leaks.Leaked) != 0 || len(leaks.Unfinished) != tt.unfinished {
+				t.Errorf("утечек %d и незавершённых горутин %d, ожидалось 0 и %d", len(leaks.Leaked), len(leaks.Unfinished), tt.unfinished)
+			}
+		})
+	}
+}
+
+// Дамп без горутин программы (только горутины рантайма) всё равно помечается как снимок
+func TestGoroutineDumpHeaderWithoutUserGoroutines(t *testing.T) {
+	dump := "goroutine 2 [force gc (idle)]:\nruntime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)\n\t/usr/local/go/src/runtime/proc.go:435 +0xce\n" +
+		"created by runtime.init.7 in goroutine 1\n\t/usr/local/go/src/runtime/proc.go:336 +0x1a\n"
+	graph, err := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil))).Parse(strings.NewReader(dump), ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.Header == nil || graph.Header.Version != parser.TraceVersionDump || len(graph.Gorutines) != 0 {
+		t.Fatalf("заголовок %+v, горутины %v", graph.Header, graph.Gorutines)
+	}
+}
+
+// Дамп распознаётся по заголовку горутины, но трасса gtrace со стеком паники — не дамп
+func TestIsGoroutineDump(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{name: "дамп", input: "goroutine 1 [running]:\nmain.main()\n", want: true},
+		{name: "дамп после вывода программы", input: "starting\nSIGQUIT: quit\n\ngoroutine 1 gp=0xc000002380 m=0 [chan receive]:\n", want: true},
+		{name: "трасса со стеком паники", input: "[GTRACE] func_end 1 main.main main.go:3 1 panic boom \"goroutine 1 [running]:\"\n"},
+		{name: "произвольный текст", input: "goroutine 1 is running\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isGoroutineDump(bufio.NewReader(strings.NewReader(tt.input))); got != tt.want {
+				t.Fatalf("isGoroutineDump = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
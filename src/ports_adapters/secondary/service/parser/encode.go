@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"gtrace/src/domain/parser"
+	"strconv"
+	"strings"
+)
+
+// EncodeEvent записывает событие строкой текстового формата ([GTRACE] <тип> <поля...>) — обратное
+// к decoders: раскладка полей та же. Поля с пробелами, кавычками или пустые записываются строками
+// Go в кавычках. Событие объединённой трассы записывается с именем источника перед строкой
+func EncodeEvent(ev parser.Event) string {
+	prefix := ""
+	if sourced, ok := ev.(*parser.SourcedEvent); ok {
+		prefix = sourced.Source + " "
+		ev = sourced.Event
+	}
+	fields := append([]string{"[GTRACE]", ev.Kind()}, encodeFields(ev)...)
+	for i, f := range fields {
+		if f == "" || strings.ContainsAny(f, " \t\n\"") {
+			fields[i] = strconv.Quote(f)
+		}
+	}
+	return prefix + strings.Join(fields, " ")
+}
+
+func encodeFields(ev parser.Event) []string {
+	switch ev := ev.(type) {
+	case *parser.TraceHeaderEvent:
+		return append([]string{ev.Version, ev.GoVersion, strconv.Itoa(ev.GOMAXPROCS), ev.Start.String()}, ev.Args...)
+	case *parser.GoSpawnEvent:
+		return []string{ev.Goroutine.String(), strconv.FormatUint(ev.Spawn, 10), ev.Func, string(ev.Site), string(ev.Caller), ev.TS.String()}
+	case *parser.FuncStartEvent:
+		return []string{ev.Goroutine.String(), ev.Func, string(ev.Caller), ev.TS.String(), strconv.FormatUint(ev.Spawn, 10)}
+	case *parser.FuncEndEvent:
+		fields := []string{ev.Goroutine.String(), ev.Func, string(ev.Caller), ev.TS.String(), ev.Reason}
+		if ev.Reason == "panic" {
+			fields = append(fields, ev.PanicValue, ev.Stack)
+		}
+		return fields
+	case *parser.ChannelCreateEvent:
+		return []string{ev.Channel.String(), string(ev.Site), string(ev.Caller), ev.TS.String(), strconv.Itoa(ev.Cap)}
+	case *parser.ChannelSendEvent:
+		return encodeOp(ev.Op, ev.Channel)
+	case *parser.ChannelReceiveEvent:
+		return encodeOp(ev.Op, ev.Channel)
+	case *parser.ChannelOpDoneEvent:
+		return append(encodeOp(ev.Op, ev.Channel), strconv.FormatInt(int64(ev.Wait), 10))
+	case *parser.ChannelCloseEvent:
+		return encodeOp(ev.Op, ev.Channel)
+	case *parser.ChannelCloseErrorEvent:
+		return append(encodeOp(ev.Op, ev.Channel), ev.Message)
+	case *parser.SelectEnterEvent:
+		cases := make([]string, 0, len(ev.Cases))
+		for _, c := range ev.Cases {
+			cases = append(cases, c.Dir+":"+c.Channel.String())
+		}
+		n := len(ev.Cases)
+		if ev.Default {
+			n++
+		}
+		list := strings.Join(cases, ",")
+		if list == "" {
+			list = "-"
+		}
+		return []string{ev.Goroutine.String(), string(ev.Site), string(ev.Caller), ev.TS.String(), strconv.Itoa(n), list}
+	case *parser.SelectCaseChosenEvent:
+		return []string{ev.Goroutine.String(), string(ev.Site), string(ev.Caller), ev.TS.String(), ev.Dir, ev.Channel.String()}
+	case *parser.SelectDefaultEvent:
+		return []string{ev.Goroutine.String(), string(ev.Site), string(ev.Caller), ev.TS.String()}
+	case *parser.ShutdownEvent:
+		return []string{ev.Goroutine.String(), ev.Reason, string(ev.Caller), ev.TS.String(), strconv.Itoa(ev.Code)}
+	case *parser.GoroutineStateEvent:
+		return []string{ev.Goroutine.String(), ev.Reason, strconv.FormatInt(int64(ev.Wait), 10), string(ev.Site), ev.Stack}
+	case *parser.RawEvent:
+		return ev.Fields
+	}
+	return nil
+}
+
+// encodeOp — поля операции с каналом: горутина, канал, место, место вызова, время
+func encodeOp(op parser.Op, channel parser.ChannelID) []string {
+	return []string{op.Goroutine.String(), channel.String(), string(op.Site), string(op.Caller), op.TS.String()}
+}
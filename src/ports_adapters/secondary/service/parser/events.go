@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"gtrace/src/domain/parser"
+	"io"
+	"strings"
+)
+
+// maxLineSize — предельная длина строки текстовой трассы: func_end с паникой содержит весь стек горутины
+const maxLineSize = 16 << 20
+
+// Mode — режим обработки некорректных записей трассы
+type Mode int
+
+const (
+	// ModeLenient — некорректные записи пропускаются и попадают в диагностику
+	ModeLenient Mode = iota
+	// ModeStrict — некорректная запись прерывает разбор, в том числе оборванная последняя запись,
+	// которую оставляет программа, убитая во время записи: строгий режим нужен там, где неполная
+	// трасса должна быть ошибкой (CI)
+	ModeStrict
+)
+
+// eventSource возвращает поля следующего события в виде разбитой строки текстового формата
+// ([GTRACE] <тип> <поля...>) и номер строки или записи; io.EOF — события закончились
+type eventSource func() (parts []string, line int, err error)
+
+// maxDiagnostics — сколько пропущенных записей потока хранится подробно; остальные только
+// считаются, чтобы испорченная трасса не занимала память пропорционально своему размеру
+const maxDiagnostics = 1000
+
+// diagnostics — пропущенные записи: первые maxDiagnostics и число остальных
+type diagnostics struct {
+	list    []parser.Diagnostic
+	omitted int
+}
+
+func (d *diagnostics) add(diag parser.Diagnostic) {
+	if len(d.list) < maxDiagnostics {
+		d.list = append(d.list, diag)
+		return
+	}
+	d.omitted++
+}
+
+// EventReader читает события трассы по одному, не загружая трассу в память: в памяти держится
+// только текущее событие (и таблица строк двоичного формата). Разбор графа, фильтрация
+// и преобразование трассы — потребители этого потока
+type EventReader struct {
+	next    eventSource
+	scanner *bufio.Scanner
+	mode    Mode
+	// diagnostics — пропущенные записи
+	diagnostics diagnostics
+}
+
+// NewEventReader создаёт поток событий трассы; формат (текстовый, двоичный, трасса выполнения Go
+// или дамп горутин) определяется по первым байтам
+func NewEventReader(input io.Reader, mode Mode) (*EventReader, error) {
+	reader := bufio.NewReaderSize(input, 64<<10)
+	if isBinaryTrace(reader) {
+		return newBinaryEventReader(reader, mode)
+	}
+	if isRuntimeTrace(reader) {
+		return newRuntimeTraceEventReader(reader, mode)
+	}
+	if isGoroutineDump(reader) {
+		return newGoroutineDumpEventReader(reader, mode), nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	return newTextEventReader(scanner, mode), nil
+}
+
+// NewStreamEventReader создаёт поток событий трассы, которая поступает по мере записи (например,
+// из сокета работающей программы). Распознаются только форматы gtrace, текстовый и двоичный:
+// распознавание дампа горутин заглядывает на 64 КБ вперёд и ждало бы, пока программа их запишет
+func NewStreamEventReader(input io.Reader, mode Mode) (*EventReader, error) {
+	reader := bufio.NewReaderSize(input, 64<<10)
+	if isBinaryTrace(reader) {
+		return newBinaryEventReader(reader, mode)
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	return newTextEventReader(scanner, mode), nil
+}
+
+// newTextEventReader читает текстовую трассу построчно. Последняя строка без перевода строки —
+// запись, оборванная на середине: её поля могут выглядеть корректными (обрезанная метка времени
+// остаётся числом), поэтому такая строка не разбирается, а считается оборванной.
+// scanner не должен быть начат: newTextEventReader задаёт ему разбиение на строки
+func newTextEventReader(scanner *bufio.Scanner, mode Mode) *EventReader {
+	terminated := true
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			terminated = advance > 0 && data[advance-1] == '\n'
+		}
+		return advance, token, err
+	})
+	line := 0
+	return &EventReader{
+		scanner: scanner,
+		mode:    mode,
+		next: func() ([]string, int, error) {
+			for scanner.Scan() {
+				line++
+				text := scanner.Text()
+				if !strings.HasPrefix(text, "[GTRACE]") {
+					continue
+				}
+				if !terminated {
+					return strings.Fields(text), line, errTruncated
+				}
+				parts, err := splitFields(text)
+				if err != nil {
+					return strings.Fields(text), line, errors.New("invalid quoted field")
+				}
+				return parts, line, nil
+			}
+			return nil, line, io.EOF
+		},
+	}
+}
+
+func newBinaryEventReader(reader *bufio.Reader, mode Mode) (*EventReader, error) {
+	decoder, err := newBinaryDecoder(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &EventReader{next: decoder.next, mode: mode}, nil
+}
+
+// Next возвращает следующее событие; io.EOF — события закончились
+func (r *EventReader) Next() (parser.Event, error) {
+	for {
+		parts, line, err := r.next()
+		if err == io.EOF {
+			if r.scanner != nil && r.scanner.Err() != nil {
+				return nil, fmt.Errorf("scanner error: %v", r.scanner.Err())
+			}
+			return nil, io.EOF
+		}
+		if err == nil && len(parts) < 2 {
+			continue
+		}
+		var ev parser.Event
+		if err == nil {
+			ev, err = decodeEvent(parts)
+		}
+		if err == nil {
+			return ev, nil
+		}
+
+		diag := parser.Diagnostic{Line: line, Reason: err.Error()}
+		if len(parts) > 1 {
+			diag.Kind = parts[1]
+		}
+		r.diagnostics.add(diag)
+		if r.mode == ModeStrict {
+			return nil, diag
+		}
+	}
+}
+
+// Diagnostics возвращает первые maxDiagnostics записей, пропущенных к текущему моменту
+func (r *EventReader) Diagnostics() []parser.Diagnostic {
+	return r.diagnostics.list
+}
+
+// OmittedDiagnostics возвращает число пропущенных записей, не вошедших в Diagnostics
+func (r *EventReader) OmittedDiagnostics() int {
+	return r.diagnostics.omitted
+}
@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"gtrace/src/domain/parser"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// badTrace — трасса с заголовком, одним событием и bad испорченными записями
+func badTrace(bad int) string {
+	var sb strings.Builder
+	sb.WriteString("[GTRACE] trace_header 1 go1.24.0 4 1000 ./app\n")
+	sb.WriteString("[GTRACE] func_start 1 main.main main.go:3 1100 1\n")
+	for range bad {
+		sb.WriteString("[GTRACE] channel_send x\n")
+	}
+	return sb.String()
+}
+
+// Подробно хранятся только первые maxDiagnostics пропущенных записей, остальные считаются;
+// итог и отчёт учитывают все
+func TestDiagnosticsAreCapped(t *testing.T) {
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tests := []struct {
+		name  string
+		parse func() (*parser.GorutineGraph, error)
+		total int
+	}{
+		{
+			name: "одна трасса",
+			parse: func() (*parser.GorutineGraph, error) {
+				return p.Parse(strings.NewReader(badTrace(maxDiagnostics+5)), ModeLenient)
+			},
+			total: maxDiagnostics + 5,
+		},
+		{
+			name: "объединение трасс",
+			parse: func() (*parser.GorutineGraph, error) {
+				return p.Merge([]MergeInput{
+					{Name: "a", Reader: strings.NewReader(badTrace(maxDiagnostics - 10))},
+					{Name: "b", Reader: strings.NewReader(badTrace(30))},
+				}, AlignStart, ModeLenient)
+			},
+			total: maxDiagnostics + 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := tt.parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(graph.Diagnostics) != maxDiagnostics || graph.OmittedDiagnostics != tt.total-maxDiagnostics {
+				t.Fatalf("записей %d и ещё %d, ожидалось %d и ещё %d",
+					len(graph.Diagnostics), graph.OmittedDiagnostics, maxDiagnostics, tt.total-maxDiagnostics)
+			}
+			if got := graph.Summary().Diagnostics; got != tt.total {
+				t.Errorf("в итоге пропущено %d записей, ожидалось %d", got, tt.total)
+			}
+			report := graph.DiagnosticsReport().String()
+			if tail := fmt.Sprintf("  и ещё %d\n", tt.total-maxDiagnostics); !strings.HasSuffix(report, tail) {
+				t.Errorf("отчёт не заканчивается на %q:\n%s", tail, report[len(report)-200:])
+			}
+		})
+	}
+}
+
+// В нестрогом режиме некорректные записи пропускаются с диагностикой, в строгом — прерывают разбор,
+// в том числе оборванная последняя запись
+func TestModes(t *testing.T) {
+	const start = 1_000_000_000
+	text := `[GTRACE] trace_header 1 go1.24.0 4 1000000000 ./app
+[GTRACE] go_spawn 1 2 main.worker main.go:6 main.go:4 1000000200
+[GTRACE] func_start 2 main.worker main.go:6 1000000300 2
+`
+	binary := newBinaryTrace(binaryVersion, start, "./app").
+		event("go_spawn", 1, 2, "main.worker", "main.go:6", "main.go:4", traceStamp(start+200)).
+		event("func_start", 2, "main.worker", "main.go:6", traceStamp(start+300), 2).buf.Bytes()
+	tests := []struct {
+		name  string
+		trace string
+		// events — события, прочитанные в нестрогом режиме; diagnostic — пропущенная запись
+		events     int
+		diagnostic parser.Diagnostic
+	}{
+		{name: "корректная трасса", trace: text, events: 3},
+		{
+			name:       "некорректная запись",
+			trace:      strings.Replace(text, "1000000200", "later", 1),
+			events:     2,
+			diagnostic: parser.Diagnostic{Line: 2, Kind: "go_spawn"},
+		},
+		{
+			name:       "оборванная последняя строка",
+			trace:      text + "[GTRACE] func_end 2 main.wor",
+			events:     3,
+			diagnostic: parser.Diagnostic{Line: 4, Kind: "func_end"},
+		},
+		{
+			// обрезанная метка времени остаётся числом, и без проверки перевода строки запись
+			// разобралась бы как корректная
+			name:       "оборванная последняя строка с полным набором полей",
+			trace:      text + "[GTRACE] channel_send 1 1 main.go:5 main.go:5 1000000",
+			events:     3,
+			diagnostic: parser.Diagnostic{Line: 4, Kind: "channel_send"},
+		},
+		{
+			name:       "оборванная последняя запись двоичной трассы",
+			trace:      string(binary[:len(binary)-1]),
+			events:     2,
+			diagnostic: parser.Diagnostic{Line: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []Mode{ModeLenient, ModeStrict} {
+				events, err := NewEventReader(strings.NewReader(tt.trace), mode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				read := 0
+				for {
+					_, err = events.Next()
+					if err != nil {
+						break
+					}
+					read++
+				}
+				failed := tt.diagnostic != (parser.Diagnostic{})
+				if mode == ModeStrict {
+					var diag parser.Diagnostic
+					if failed != errors.As(err, &diag) {
+						t.Fatalf("строгий режим: ошибка %v", err)
+					}
+					if failed && (diag.Line != tt.diagnostic.Line || diag.Kind != tt.diagnostic.Kind) {
+						t.Errorf("строгий режим: ошибка в записи %d (%s), ожидалась %d (%s)", diag.Line, diag.Kind, tt.diagnostic.Line, tt.diagnostic.Kind)
+					}
+					continue
+				}
+				if err != io.EOF {
+					t.Fatalf("нестрогий режим: ошибка %v", err)
+				}
+				if read != tt.events {
+					t.Errorf("нестрогий режим: прочитано %d событий, ожидалось %d", read, tt.events)
+				}
+				diagnostics := events.Diagnostics()
+				if !failed {
+					if len(diagnostics) != 0 {
+						t.Errorf("нестрогий режим: пропущены записи %v", diagnostics)
+					}
+					continue
+				}
+				if len(diagnostics) != 1 || diagnostics[0].Line != tt.diagnostic.Line || diagnostics[0].Kind != tt.diagnostic.Kind {
+					t.Errorf("нестрогий режим: пропущены %v, ожидалась запись %d (%s)", diagnostics, tt.diagnostic.Line, tt.diagnostic.Kind)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package parser
+
+import (
+	"gtrace/src/domain/parser"
+	"strconv"
+	"time"
+)
+
+// FilterReader — поток событий, прошедших фильтр (см. parser.Query). Поля горутин и каналов
+// событию известны по уже прочитанным func_start и channel_create, поэтому FilterReader читает
+// все события источника и помнит функции горутин и места создания каналов. Окрестность горутины
+// в потоке не известна: near событие не отбрасывает
+type FilterReader struct {
+	events  eventStream
+	query   *parser.Query
+	matcher *EventMatcher
+}
+
+func NewFilterReader(events eventStream, query *parser.Query) *FilterReader {
+	return &FilterReader{
+		events:  events,
+		query:   query,
+		matcher: NewEventMatcher(),
+	}
+}
+
+// Next возвращает следующее событие, прошедшее фильтр; io.EOF — события закончились
+func (r *FilterReader) Next() (parser.Event, error) {
+	for {
+		ev, err := r.events.Next()
+		if err != nil {
+			return nil, err
+		}
+		r.matcher.Observe(ev)
+		if r.matcher.Match(ev, r.query) {
+			return ev, nil
+		}
+	}
+}
+
+// Diagnostics возвращает записи, пропущенные источником
+func (r *FilterReader) Diagnostics() []parser.Diagnostic {
+	return r.events.Diagnostics()
+}
+
+// OmittedDiagnostics возвращает число пропущенных источником записей, не вошедших в Diagnostics
+func (r *FilterReader) OmittedDiagnostics() int {
+	return r.events.OmittedDiagnostics()
+}
+
+// EventMatcher проверяет события потока по фильтрам. Поля горутин и каналов события берутся из
+// прошедших через Observe func_start и channel_create, поэтому Observe вызывается для каждого
+// события потока, а не только для проверяемых
+type EventMatcher struct {
+	// start — начало трассы: время из первого заголовка или первая временная метка
+	start    parser.Timestamp
+	funcs    map[string]string
+	sites    map[string]string
+	channels map[string]parser.ChannelCreateEvent
+}
+
+func NewEventMatcher() *EventMatcher {
+	return &EventMatcher{
+		funcs:    make(map[string]string),
+		sites:    make(map[string]string),
+		channels: make(map[string]parser.ChannelCreateEvent),
+	}
+}
+
+// Observe запоминает начало трассы, функции горутин и каналы
+func (m *EventMatcher) Observe(ev parser.Event) {
+	s := m.subject(ev)
+	if ts, ok := s.event.Time(); ok && m.start == 0 {
+		m.start = ts
+	}
+	switch ev := s.event.(type) {
+	case *parser.FuncStartEvent:
+		key := parser.Namespaced(s.source, ev.Goroutine.String())
+		m.funcs[key] = ev.Func
+		m.sites[key] = string(ev.Caller)
+	case *parser.ChannelCreateEvent:
+		m.channels[parser.Namespaced(s.source, ev.Channel.Key())] = *ev
+	}
+}
+
+// Match сообщает, что событие проходит фильтр
+func (m *EventMatcher) Match(ev parser.Event, query *parser.Query) bool {
+	return query.Match(m.subject(ev), nil)
+}
+
+func (m *EventMatcher) subject(ev parser.Event) eventSubject {
+	s := eventSubject{matcher: m, event: ev}
+	if sourced, ok := ev.(*parser.SourcedEvent); ok {
+		s.source = sourced.Source
+		s.event = sourced.Event
+	}
+	return s
+}
+
+// eventSubject — событие трассы как субъект фильтра
+type eventSubject struct {
+	matcher *EventMatcher
+	source  string
+	event   parser.Event
+}
+
+func (s eventSubject) Key() string {
+	return ""
+}
+
+func (s eventSubject) Field(name string) ([]string, bool) {
+	switch name {
+	case "kind":
+		return []string{s.event.Kind(), shortKind(s.event)}, true
+	case "site":
+		return []string{eventSite(s.event)}, true
+	case "source":
+		return []string{s.source}, true
+	case "goroutine.id":
+		if g, ok := eventGoroutine(s.event); ok {
+			return []string{parser.Namespaced(s.source, g.String()), g.String()}, true
+		}
+		return nil, true
+	case "goroutine.func", "goroutine.site":
+		g, ok := eventGoroutine(s.event)
+		if !ok {
+			return nil, true
+		}
+		key := parser.Namespaced(s.source, g.String())
+		if name == "goroutine.func" {
+			return []string{s.matcher.funcs[key]}, true
+		}
+		return []string{s.matcher.sites[key]}, true
+	case "channel.id", "channel.site", "channel.cap":
+		var values []string
+		for _, id := range eventChannels(s.event) {
+			key := parser.Namespaced(s.source, id.Key())
+			switch name {
+			case "channel.id":
+				values = append(values, key, id.String())
+			case "channel.site":
+				if ch, ok := s.matcher.channels[key]; ok {
+					values = append(values, string(ch.Site))
+				}
+			case "channel.cap":
+				if ch, ok := s.matcher.channels[key]; ok {
+					values = append(values, strconv.Itoa(ch.Cap))
+				}
+			}
+		}
+		return values, true
+	}
+	// состояние, причина ожидания и родитель горутины известны только графу
+	return nil, false
+}
+
+func (s eventSubject) Span() (time.Duration, time.Duration, bool) {
+	ts, ok := s.event.Time()
+	if !ok {
+		return 0, 0, false
+	}
+	at := ts.Sub(s.matcher.start)
+	return at, at, true
+}
+
+// shortKind — тип события в языке фильтров: направление операции с каналом (send, receive, close),
+// select, spawn, start, end и т.д.
+func shortKind(ev parser.Event) string {
+	switch ev := ev.(type) {
+	case *parser.GoSpawnEvent:
+		return "spawn"
+	case *parser.FuncStartEvent:
+		return "start"
+	case *parser.FuncEndEvent:
+		return "end"
+	case *parser.ChannelCreateEvent:
+		return "create"
+	case *parser.ChannelSendEvent:
+		return "send"
+	case *parser.ChannelReceiveEvent:
+		return "receive"
+	case *parser.ChannelOpDoneEvent:
+		return ev.Dir
+	case *parser.ChannelCloseEvent:
+		return "close"
+	case *parser.ChannelCloseErrorEvent:
+		return "close_error"
+	case *parser.SelectEnterEvent:
+		return "select"
+	case *parser.SelectCaseChosenEvent:
+		return ev.Dir
+	case *parser.SelectDefaultEvent:
+		return "default"
+	case *parser.GoroutineStateEvent:
+		return "state"
+	case *parser.TraceHeaderEvent:
+		return "header"
+	}
+	return ev.Kind()
+}
+
+// eventGoroutine возвращает горутину, выполнившую операцию события
+func eventGoroutine(ev parser.Event) (parser.GoroutineID, bool) {
+	switch ev := ev.(type) {
+	case *parser.GoSpawnEvent:
+		return ev.Goroutine, true
+	case *parser.FuncStartEvent:
+		return ev.Goroutine, true
+	case *parser.FuncEndEvent:
+		return ev.Goroutine, true
+	case *parser.ChannelSendEvent:
+		return ev.Goroutine, true
+	case *parser.ChannelReceiveEvent:
+		return ev.Goroutine, true
+	case *parser.ChannelOpDoneEvent:
+		return ev.Goroutine, true
+	case *parser.ChannelCloseEvent:
+		return ev.Goroutine, true
+	case *parser.ChannelCloseErrorEvent:
+		return ev.Goroutine, true
+	case *parser.SelectEnterEvent:
+		return ev.Goroutine, true
+	case *parser.SelectCaseChosenEvent:
+		return ev.Goroutine, true
+	case *parser.SelectDefaultEvent:
+		return ev.Goroutine, true
+	case *parser.ShutdownEvent:
+		return ev.Goroutine, true
+	case *parser.GoroutineStateEvent:
+		return ev.Goroutine, true
+	}
+	return 0, false
+}
+
+// eventChannels возвращает каналы события; у select — каналы всех веток
+func eventChannels(ev parser.Event) []parser.ChannelID {
+	switch ev := ev.(type) {
+	case *parser.ChannelCreateEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.ChannelSendEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.ChannelReceiveEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.ChannelOpDoneEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.ChannelCloseEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.ChannelCloseErrorEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.SelectCaseChosenEvent:
+		return []parser.ChannelID{ev.Channel}
+	case *parser.SelectEnterEvent:
+		channels := make([]parser.ChannelID, 0, len(ev.Cases))
+		for _, c := range ev.Cases {
+			channels = append(channels, c.Channel)
+		}
+		return channels
+	}
+	return nil
+}
+
+// eventSite возвращает место события в исходниках
+func eventSite(ev parser.Event) string {
+	switch ev := ev.(type) {
+	case *parser.GoSpawnEvent:
+		return string(ev.Site)
+	case *parser.FuncStartEvent:
+		return string(ev.Caller)
+	case *parser.FuncEndEvent:
+		return string(ev.Caller)
+	case *parser.ChannelCreateEvent:
+		return string(ev.Site)
+	case *parser.ChannelSendEvent:
+		return string(ev.Site)
+	case *parser.ChannelReceiveEvent:
+		return string(ev.Site)
+	case *parser.ChannelOpDoneEvent:
+		return string(ev.Site)
+	case *parser.ChannelCloseEvent:
+		return string(ev.Site)
+	case *parser.ChannelCloseErrorEvent:
+		return string(ev.Site)
+	case *parser.SelectEnterEvent:
+		return string(ev.Site)
+	case *parser.SelectCaseChosenEvent:
+		return string(ev.Site)
+	case *parser.SelectDefaultEvent:
+		return string(ev.Site)
+	case *parser.ShutdownEvent:
+		return string(ev.Caller)
+	case *parser.GoroutineStateEvent:
+		return string(ev.Site)
+	}
+	return ""
+}
@@ -1 +1,152 @@
 package parser
+
+import (
+	"gtrace/src/domain/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ensureChannel возвращает ключ канала и добавляет его в граф, если канал не встречался в channel_create
+// (например, создан вне инструментированного кода: time.After, ctx.Done())
+func ensureChannel(graph *parser.GorutineGraph, channelName string, id string) string {
+	if _, ok := graph.Channels[channelName]; !ok {
+		graph.Channels[channelName] = parser.Channel{
+			ID:   id,
+			Name: channelName,
+		}
+	}
+	return channelName
+}
+
+// siteChannels — каналы-заглушки по месту операции для форматов, в которых каналы не идентифицируются
+// (трасса выполнения Go, дамп горутин)
+type siteChannels map[string]uint64
+
+// get возвращает канал-заглушку места операции; created — канал встретился впервые
+func (c siteChannels) get(site string) (id uint64, created bool) {
+	if id, ok := c[site]; ok {
+		return id, false
+	}
+	id = uint64(len(c) + 1)
+	c[site] = id
+	return id, true
+}
+
+// spawn — go-оператор, для которого ещё не встретился func_start дочерней горутины
+type spawn struct {
+	parent string
+	site   string
+	ts     string
+}
+
+// ensureGoroutine возвращает горутину и добавляет её в граф, если для неё не было func_start
+// (например, main или горутина, запущенная вне инструментированного кода)
+func ensureGoroutine(graph *parser.GorutineGraph, id string) parser.Goroutine {
+	gr, ok := graph.Gorutines[id]
+	if !ok {
+		gr = parser.Goroutine{ID: id}
+		graph.Gorutines[id] = gr
+	}
+	return gr
+}
+
+// linkSpawn связывает дочернюю горутину с родителем и добавляет ребро spawn
+func linkSpawn(graph *parser.GorutineGraph, child string, sp spawn) {
+	gr := graph.Gorutines[child]
+	gr.Parent = sp.parent
+	gr.SpawnSite = sp.site
+	graph.Gorutines[child] = gr
+	graph.Edges = append(graph.Edges, parser.Edge{
+		From:  sp.parent,
+		To:    child,
+		Label: "spawn",
+		TS:    sp.ts,
+	})
+}
+
+// opEdge возвращает ребро операции с каналом по направлению данных: отправка — из горутины в канал,
+// получение — из канала в горутину
+func opEdge(goroutineID string, dir string, channelName string, ts string) parser.Edge {
+	if dir == "receive" {
+		return parser.Edge{From: channelName, To: goroutineID, Label: dir, TS: ts}
+	}
+	return parser.Edge{From: goroutineID, To: channelName, Label: dir, TS: ts}
+}
+
+// setLastOp запоминает последнюю операцию горутины с каналом
+func setLastOp(graph *parser.GorutineGraph, id string, op parser.ChannelOp) {
+	gr := ensureGoroutine(graph, id)
+	gr.LastOp = &op
+	graph.Gorutines[id] = gr
+}
+
+// countOp учитывает начатую операцию с каналом: отправку или получение
+func countOp(graph *parser.GorutineGraph, id string, dir string, channelName string) {
+	ch := graph.Channels[channelName]
+	switch dir {
+	case "send":
+		ch.Sends++
+	case "receive":
+		ch.Receives++
+	}
+	graph.Channels[channelName] = ch
+	addParticipant(graph, id, dir, channelName)
+}
+
+// addParticipant запоминает горутину среди отправителей или получателей канала
+func addParticipant(graph *parser.GorutineGraph, id string, dir string, channelName string) {
+	ch := graph.Channels[channelName]
+	participants := &ch.Receivers
+	if dir == "send" {
+		participants = &ch.Senders
+	}
+	for _, p := range *participants {
+		if p == id {
+			return
+		}
+	}
+	*participants = append(*participants, id)
+	graph.Channels[channelName] = ch
+}
+
+// tsSince возвращает время между двумя временными метками трассы (наносекунды)
+func tsSince(from, to string) (time.Duration, bool) {
+	start, errStart := strconv.ParseInt(from, 10, 64)
+	end, errEnd := strconv.ParseInt(to, 10, 64)
+	if errStart != nil || errEnd != nil || end < start {
+		return 0, false
+	}
+	return time.Duration(end - start), true
+}
+
+// splitFields разбивает строку трассы на поля по пробелам; поле, начинающееся с кавычки,
+// читается как строка Go в кавычках (значения паник, стеки) и может содержать пробелы
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return fields, nil
+		}
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, value)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
@@ -0,0 +1,217 @@
+package parser
+
+import (
+	"fmt"
+	"gtrace/src/domain/parser"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Объединение трасс нескольких программ (или нескольких запусков одной) в один поток событий.
+// Номера горутин, каналов и запусков действуют только внутри трассы, поэтому каждое событие
+// помечается источником (parser.SourcedEvent), и в графе ключи получают пространство имён источника.
+// Часы источников выравниваются сдвигом временных меток, после чего события источников
+// сливаются по времени: в памяти держится по одному событию каждого источника
+
+// Align — способ выравнивания часов объединяемых трасс
+type Align int
+
+const (
+	// AlignStart — начала трасс (время из заголовка) совмещаются с самым ранним из них: запуски,
+	// сделанные в разное время, накладываются друг на друга
+	AlignStart Align = iota
+	// AlignWall — временные метки не меняются: программы работали одновременно на одной машине
+	AlignWall
+)
+
+// MergeInput — объединяемая трасса
+type MergeInput struct {
+	// Name — пространство имён источника; пустое имя заменяется именем файла или номером трассы
+	Name   string
+	Path   string
+	Reader io.Reader
+	// Offset — явный сдвиг часов источника, прибавляется после выравнивания
+	Offset time.Duration
+}
+
+// ParseMergeInput разбирает описание трассы из командной строки: [имя=]путь
+func ParseMergeInput(spec string) MergeInput {
+	if name, path, ok := strings.Cut(spec, "="); ok && name != "" && !strings.ContainsAny(name, `/\`) {
+		return MergeInput{Name: name, Path: path}
+	}
+	return MergeInput{Path: spec}
+}
+
+// SourceName возвращает имя источника: заданное или имя файла без расширения
+func (in MergeInput) SourceName() string {
+	if in.Name == "" && in.Path != "" {
+		return strings.TrimSuffix(filepath.Base(in.Path), filepath.Ext(in.Path))
+	}
+	return in.Name
+}
+
+// ParseOffset разбирает явный сдвиг часов из командной строки: имя=длительность (например, b=-1.5s)
+func ParseOffset(spec string) (name string, offset time.Duration, err error) {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok || name == "" {
+		return "", 0, fmt.Errorf("invalid offset format: %q, want name=duration", spec)
+	}
+	offset, err = time.ParseDuration(value)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid offset format: %q: %v", spec, err)
+	}
+	return name, offset, nil
+}
+
+// mergeSource — источник объединяемого потока: читатель событий и его очередное событие
+type mergeSource struct {
+	name   string
+	events *EventReader
+	offset time.Duration
+	head   parser.Event
+	// ts — временная метка очередного события после сдвига; у событий без метки — метка предыдущего
+	ts   parser.Timestamp
+	done bool
+}
+
+// MergeReader — поток событий нескольких трасс, упорядоченный по выровненному времени
+type MergeReader struct {
+	sources []*mergeSource
+}
+
+// NewMergeReader открывает объединяемые трассы и выравнивает их часы по заголовкам. Имена источников
+// должны быть уникальны: занятое имя получает суффикс с номером трассы
+func NewMergeReader(inputs []MergeInput, align Align, mode Mode) (*MergeReader, error) {
+	r := &MergeReader{}
+	names := make(map[string]bool)
+	for i, input := range inputs {
+		name := input.SourceName()
+		if name == "" {
+			name = "trace" + strconv.Itoa(i+1)
+		}
+		// имя с суффиксом тоже может быть занято (a, a, a-2): суффикс добавляется, пока имя не станет уникальным
+		for names[name] {
+			name += "-" + strconv.Itoa(i+1)
+		}
+		names[name] = true
+
+		events, err := NewEventReader(input.Reader, mode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		source := &mergeSource{name: name, events: events, offset: input.Offset}
+		if err := source.advance(); err != nil {
+			return nil, err
+		}
+		r.sources = append(r.sources, source)
+	}
+
+	if align == AlignStart {
+		// начало трассы без заголовка (или с нулевым временем, как у дампа горутин) неизвестно:
+		// такой источник сдвигается только явным смещением
+		var base parser.Timestamp
+		for _, source := range r.sources {
+			if start, ok := source.start(); ok && (base == 0 || start < base) {
+				base = start
+			}
+		}
+		for _, source := range r.sources {
+			if start, ok := source.start(); ok {
+				source.offset += base.Sub(start)
+			}
+		}
+	}
+	for _, source := range r.sources {
+		if source.head != nil {
+			source.head.Shift(source.offset)
+			source.ts, _ = source.head.Time()
+		}
+	}
+	return r, nil
+}
+
+// Sources возвращает источники в порядке трасс с итоговыми сдвигами их часов
+func (r *MergeReader) Sources() []parser.TraceSource {
+	sources := make([]parser.TraceSource, 0, len(r.sources))
+	for _, source := range r.sources {
+		sources = append(sources, parser.TraceSource{Name: source.name, Offset: source.offset})
+	}
+	return sources
+}
+
+// Next возвращает следующее по времени событие (parser.SourcedEvent); io.EOF — все трассы закончились.
+// При равных метках первым идёт источник, указанный раньше
+func (r *MergeReader) Next() (parser.Event, error) {
+	var next *mergeSource
+	for _, source := range r.sources {
+		if source.done {
+			continue
+		}
+		if next == nil || source.ts < next.ts {
+			next = source
+		}
+	}
+	if next == nil {
+		return nil, io.EOF
+	}
+
+	ev := &parser.SourcedEvent{Source: next.name, Event: next.head}
+	if err := next.advance(); err != nil {
+		return nil, err
+	}
+	if next.head != nil {
+		next.head.Shift(next.offset)
+		if ts, ok := next.head.Time(); ok {
+			next.ts = ts
+		}
+	}
+	return ev, nil
+}
+
+// Diagnostics возвращает первые maxDiagnostics пропущенных записей всех трасс с именем источника
+func (r *MergeReader) Diagnostics() []parser.Diagnostic {
+	return r.diagnostics().list
+}
+
+// OmittedDiagnostics возвращает число пропущенных записей всех трасс, не вошедших в Diagnostics
+func (r *MergeReader) OmittedDiagnostics() int {
+	return r.diagnostics().omitted
+}
+
+func (r *MergeReader) diagnostics() diagnostics {
+	var all diagnostics
+	for _, source := range r.sources {
+		for _, d := range source.events.Diagnostics() {
+			d.Source = source.name
+			all.add(d)
+		}
+		all.omitted += source.events.OmittedDiagnostics()
+	}
+	return all
+}
+
+// advance читает очередное событие источника
+func (s *mergeSource) advance() error {
+	ev, err := s.events.Next()
+	if err == io.EOF {
+		s.head, s.done = nil, true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.name, err)
+	}
+	s.head = ev
+	return nil
+}
+
+// start возвращает время начала трассы из заголовка; заголовок — первое событие трассы
+func (s *mergeSource) start() (parser.Timestamp, bool) {
+	header, ok := s.head.(*parser.TraceHeaderEvent)
+	if !ok || header.Start == 0 {
+		return 0, false
+	}
+	return header.Start, true
+}
@@ -0,0 +1,212 @@
+package parser
+
+import (
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Трассы двух программ: a началась в 1000, b — в 5000 (наносекунды). Обе запускают горутину 2
+// и создают канал 1, так что без пространств имён их горутины и каналы совпали бы
+const (
+	mergeTraceA = `[GTRACE] trace_header 1 go1.24.0 4 1000 ./a
+[GTRACE] channel_create 1 a.go:5 a.go:4 1100 0
+[GTRACE] go_spawn 1 2 main.worker a.go:6 a.go:4 1200
+[GTRACE] func_start 2 main.worker a.go:6 1210 2
+[GTRACE] channel_send 1 1 a.go:7 a.go:4 1300
+`
+	mergeTraceB = `[GTRACE] trace_header 1 go1.24.0 4 5000 ./b
+[GTRACE] channel_create 1 b.go:5 b.go:4 5100 0
+[GTRACE] go_spawn 1 2 main.worker b.go:6 b.go:4 5250
+[GTRACE] func_start 2 main.worker b.go:6 5260 2
+[GTRACE] channel_receive 2 1 b.go:8 b.go:4 5300
+`
+	// mergeTraceDump — трасса без времени начала, как у дампа горутин
+	mergeTraceDump = `[GTRACE] trace_header dump "" 0 0
+[GTRACE] func_start 9 main.idle main.go:3 0 9
+`
+)
+
+// Трассы сливаются по выровненному времени, события помечаются источником; при равных метках
+// первым идёт источник, указанный раньше
+func TestMergeReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  []MergeInput
+		align   Align
+		offsets []time.Duration
+		want    []string
+	}{
+		{
+			name:    "выравнивание по началу",
+			inputs:  []MergeInput{{Name: "a", Reader: strings.NewReader(mergeTraceA)}, {Name: "b", Reader: strings.NewReader(mergeTraceB)}},
+			align:   AlignStart,
+			offsets: []time.Duration{0, -4000},
+			want: []string{
+				"a [GTRACE] trace_header 1 go1.24.0 4 1000 ./a",
+				"b [GTRACE] trace_header 1 go1.24.0 4 1000 ./b",
+				"a [GTRACE] channel_create 1 a.go:5 a.go:4 1100 0",
+				"b [GTRACE] channel_create 1 b.go:5 b.go:4 1100 0",
+				"a [GTRACE] go_spawn 1 2 main.worker a.go:6 a.go:4 1200",
+				"a [GTRACE] func_start 2 main.worker a.go:6 1210 2",
+				"b [GTRACE] go_spawn 1 2 main.worker b.go:6 b.go:4 1250",
+				"b [GTRACE] func_start 2 main.worker b.go:6 1260 2",
+				"a [GTRACE] channel_send 1 1 a.go:7 a.go:4 1300",
+				"b [GTRACE] channel_receive 2 1 b.go:8 b.go:4 1300",
+			},
+		},
+		{
+			name:    "выравнивание по началу и явный сдвиг",
+			inputs:  []MergeInput{{Name: "a", Reader: strings.NewReader(mergeTraceA)}, {Name: "b", Reader: strings.NewReader(mergeTraceB), Offset: -100}},
+			align:   AlignStart,
+			offsets: []time.Duration{0, -4100},
+			want: []string{
+				"b [GTRACE] trace_header 1 go1.24.0 4 900 ./b",
+				"a [GTRACE] trace_header 1 go1.24.0 4 1000 ./a",
+				"b [GTRACE] channel_create 1 b.go:5 b.go:4 1000 0",
+				"a [GTRACE] channel_create 1 a.go:5 a.go:4 1100 0",
+				"b [GTRACE] go_spawn 1 2 main.worker b.go:6 b.go:4 1150",
+				"b [GTRACE] func_start 2 main.worker b.go:6 1160 2",
+				"a [GTRACE] go_spawn 1 2 main.worker a.go:6 a.go:4 1200",
+				"b [GTRACE] channel_receive 2 1 b.go:8 b.go:4 1200",
+				"a [GTRACE] func_start 2 main.worker a.go:6 1210 2",
+				"a [GTRACE] channel_send 1 1 a.go:7 a.go:4 1300",
+			},
+		},
+		{
+			name:    "общие часы",
+			inputs:  []MergeInput{{Name: "b", Reader: strings.NewReader(mergeTraceB)}, {Name: "a", Reader: strings.NewReader(mergeTraceA), Offset: 4000}},
+			align:   AlignWall,
+			offsets: []time.Duration{0, 4000},
+			want: []string{
+				"b [GTRACE] trace_header 1 go1.24.0 4 5000 ./b",
+				"a [GTRACE] trace_header 1 go1.24.0 4 5000 ./a",
+				"b [GTRACE] channel_create 1 b.go:5 b.go:4 5100 0",
+				"a [GTRACE] channel_create 1 a.go:5 a.go:4 5100 0",
+				"a [GTRACE] go_spawn 1 2 main.worker a.go:6 a.go:4 5200",
+				"a [GTRACE] func_start 2 main.worker a.go:6 5210 2",
+				"b [GTRACE] go_spawn 1 2 main.worker b.go:6 b.go:4 5250",
+				"b [GTRACE] func_start 2 main.worker b.go:6 5260 2",
+				"b [GTRACE] channel_receive 2 1 b.go:8 b.go:4 5300",
+				"a [GTRACE] channel_send 1 1 a.go:7 a.go:4 5300",
+			},
+		},
+		{
+			// начало дампа неизвестно: он сдвигается только явным смещением, вместе с нулевым временем заголовка
+			name:    "трасса без времени начала",
+			inputs:  []MergeInput{{Name: "a", Reader: strings.NewReader(mergeTraceA)}, {Name: "dump", Reader: strings.NewReader(mergeTraceDump), Offset: 1250}},
+			align:   AlignStart,
+			offsets: []time.Duration{0, 1250},
+			want: []string{
+				"a [GTRACE] trace_header 1 go1.24.0 4 1000 ./a",
+				"a [GTRACE] channel_create 1 a.go:5 a.go:4 1100 0",
+				"a [GTRACE] go_spawn 1 2 main.worker a.go:6 a.go:4 1200",
+				"a [GTRACE] func_start 2 main.worker a.go:6 1210 2",
+				`dump [GTRACE] trace_header dump "" 0 1250`,
+				"dump [GTRACE] func_start 9 main.idle main.go:3 1250 9",
+				"a [GTRACE] channel_send 1 1 a.go:7 a.go:4 1300",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := NewMergeReader(tt.inputs, tt.align, ModeStrict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var offsets []time.Duration
+			for _, source := range events.Sources() {
+				offsets = append(offsets, source.Offset)
+			}
+			if !reflect.DeepEqual(offsets, tt.offsets) {
+				t.Errorf("сдвиги %v, ожидались %v", offsets, tt.offsets)
+			}
+
+			var got []string
+			for {
+				ev, err := events.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, EncodeEvent(ev))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("события:\n%s\nожидались:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// Источники без имени получают номер трассы, занятые имена — суффикс с номером трассы
+func TestMergeReaderSourceNames(t *testing.T) {
+	tests := []struct {
+		inputs []MergeInput
+		want   []string
+	}{
+		{inputs: []MergeInput{{}, {}}, want: []string{"trace1", "trace2"}},
+		{inputs: []MergeInput{{Path: "/tmp/run.log"}, {Path: "/var/run.log"}}, want: []string{"run", "run-2"}},
+		{inputs: []MergeInput{{Name: "api"}, {Path: "api.log"}, {}}, want: []string{"api", "api-2", "trace3"}},
+		{inputs: []MergeInput{{Name: "a"}, {Name: "a"}, {Name: "a-2"}}, want: []string{"a", "a-2", "a-2-3"}},
+		{inputs: []MergeInput{{Name: "trace2"}, {}}, want: []string{"trace2", "trace2-2"}},
+	}
+	for _, tt := range tests {
+		for i := range tt.inputs {
+			tt.inputs[i].Reader = strings.NewReader(mergeTraceA)
+		}
+		events, err := NewMergeReader(tt.inputs, AlignStart, ModeStrict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, source := range events.Sources() {
+			got = append(got, source.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("имена %v, ожидались %v", got, tt.want)
+		}
+	}
+}
+
+// В объединённом графе горутины и каналы разных трасс не смешиваются, а связи остаются внутри трассы
+func TestMergeGraph(t *testing.T) {
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	graph, err := p.Merge([]MergeInput{
+		{Name: "a", Reader: strings.NewReader(mergeTraceA)},
+		{Name: "b", Reader: strings.NewReader(mergeTraceB), Offset: -100},
+	}, AlignStart, ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a/1", "a/2", "b/1", "b/2"} {
+		if _, ok := graph.Gorutines[id]; !ok {
+			t.Errorf("нет горутины %s", id)
+		}
+	}
+	if parent := graph.Gorutines["b/2"].Parent; parent != "b/1" {
+		t.Errorf("горутину b/2 запустила %q, ожидалась b/1", parent)
+	}
+	if len(graph.Channels) != 2 {
+		t.Errorf("каналов %d, ожидалось 2: %v", len(graph.Channels), graph.Channels)
+	}
+	for _, e := range graph.Edges {
+		if from, to := strings.Split(e.From, "/")[0], strings.Split(e.To, "/")[0]; from != to {
+			t.Errorf("связь %s -> %s между трассами", e.From, e.To)
+		}
+	}
+	want := map[string]time.Duration{"a": 0, "b": -4100}
+	for _, source := range graph.Sources {
+		if source.Offset != want[source.Name] {
+			t.Errorf("сдвиг %s: %v, ожидался %v", source.Name, source.Offset, want[source.Name])
+		}
+	}
+	if len(graph.Sources) != len(want) {
+		t.Errorf("источники %v, ожидались %v", graph.Sources, want)
+	}
+}
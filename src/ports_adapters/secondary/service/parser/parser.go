@@ -8,7 +8,6 @@ import (
 
 	"io"
 	"log/slog"
-	"strings"
 )
 
 type Parser struct {
@@ -20,101 +19,177 @@ func NewParser(logger *slog.Logger) *Parser {
 	return &Parser{logger: logger}
 }
 
-func (p *Parser) ParseFromCmd(input io.Reader) (*parser.GorutineGraph, error) {
-	scanner := bufio.NewScanner(input)
-	graph, err := p.ParseGorutineTrace(scanner)
-	if err != nil {
-		p.logger.Error("failed to parse graph", slog.String("error", err.Error()))
-		return nil, err
-	}
-	return graph, nil
+func (p *Parser) ParseFromCmd(input io.Reader, mode Mode) (*parser.GorutineGraph, error) {
+	return p.Parse(input, mode)
 }
 
-func (p *Parser) ParseFromFile(filePath string) (*parser.GorutineGraph, error) {
+func (p *Parser) ParseFromFile(filePath string, mode Mode) (*parser.GorutineGraph, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		p.logger.Error("failed to open file", slog.String("error", err.Error()))
+		return nil, err
 	}
-	scanner := bufio.NewScanner(file)
 	defer file.Close()
 
-	graph, err := p.ParseGorutineTrace(scanner)
+	return p.Parse(file, mode)
+}
+
+// Parse разбирает трассу в текстовом или двоичном формате; формат определяется по первым байтам,
+// mode задаёт обработку некорректных записей
+func (p *Parser) Parse(input io.Reader, mode Mode) (*parser.GorutineGraph, error) {
+	events, err := NewEventReader(input, mode)
 	if err != nil {
 		p.logger.Error("failed to parse graph", slog.String("error", err.Error()))
 		return nil, err
 	}
-	return graph, nil
+	return p.build(events, NewGraphBuilder())
+}
+
+// ParseGorutineTrace разбирает текстовую трассу: строки [GTRACE] ...; остальные строки пропускаются
+func (p *Parser) ParseGorutineTrace(scanner *bufio.Scanner, mode Mode) (*parser.GorutineGraph, error) {
+	return p.build(newTextEventReader(scanner, mode), NewGraphBuilder())
 }
 
-func (p *Parser) ParseGorutineTrace(scanner *bufio.Scanner) (*parser.GorutineGraph, error) {
-	graph := &parser.GorutineGraph{
-		Gorutines: make(map[string]parser.Goroutine),
-		Channels:  make(map[string]parser.Channel),
-		Edges:     []parser.Edge{},
+// ParseBinaryTrace разбирает трассу в двоичном формате (см. binary.go)
+func (p *Parser) ParseBinaryTrace(reader *bufio.Reader, mode Mode) (*parser.GorutineGraph, error) {
+	events, err := newBinaryEventReader(reader, mode)
+	if err != nil {
+		p.logger.Error("failed to parse graph", slog.String("error", err.Error()))
+		return nil, err
 	}
+	return p.build(events, NewGraphBuilder())
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "[GTRACE]") {
-			continue
-		}
+// ParseFiles разбирает трассы из файлов (Path): одну трассу без сдвига часов — как есть, иначе
+// объединяет их (см. Merge)
+func (p *Parser) ParseFiles(inputs []MergeInput, align Align, mode Mode) (*parser.GorutineGraph, error) {
+	if len(inputs) == 1 && inputs[0].Offset == 0 {
+		return p.ParseFromFile(inputs[0].Path, mode)
+	}
+	return p.MergeFiles(inputs, align, mode)
+}
 
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
+// MergeFiles объединяет трассы из файлов (Path) в один граф, см. Merge
+func (p *Parser) MergeFiles(inputs []MergeInput, align Align, mode Mode) (*parser.GorutineGraph, error) {
+	opened, closeAll, err := p.open(inputs)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll()
+	return p.Merge(opened, align, mode)
+}
+
+// WriteEvents пишет в w события трасс из файлов (Path), прошедшие фильтр (nil — все), строками
+// текстового формата (см. EncodeEvent) и возвращает их число. Несколько трасс объединяются, как в Merge
+func (p *Parser) WriteEvents(inputs []MergeInput, align Align, mode Mode, query *parser.Query, w io.Writer) (int, error) {
+	opened, closeAll, err := p.open(inputs)
+	if err != nil {
+		return 0, err
+	}
+	defer closeAll()
+
+	var events eventStream
+	if len(opened) == 1 && opened[0].Offset == 0 {
+		events, err = NewEventReader(opened[0].Reader, mode)
+	} else {
+		events, err = NewMergeReader(opened, align, mode)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if query != nil {
+		events = NewFilterReader(events, query)
+	}
+
+	written := 0
+	for {
+		ev, err := events.Next()
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return written, err
+		}
+		if _, err := fmt.Fprintln(w, EncodeEvent(ev)); err != nil {
+			return written, err
+		}
+		written++
+	}
+	p.logDiagnostics(events)
+	return written, nil
+}
 
-		switch parts[1] {
-		case "channel_create":
-			if len(parts) < 5 {
-				return nil, fmt.Errorf("invalid channel_create format: %s", line)
-			}
-			channelName := fmt.Sprintf("chan_%s_%s", parts[2], parts[3])
-			graph.Channels[channelName] = parser.Channel{
-				Name: channelName,
-				File: parts[3],
-				TS:   parts[4],
-			}
-
-		case "func_start":
-			if len(parts) < 6 {
-				return nil, fmt.Errorf("invalid func_start format: %s", line)
-			}
-			goroutineID := parts[2]
-			graph.Gorutines[goroutineID] = parser.Goroutine{
-				ID:   goroutineID,
-				Func: parts[3],
-				File: parts[4],
-				TS:   parts[5],
-			}
-
-		case "channel_send":
-			if len(parts) < 5 {
-				return nil, fmt.Errorf("invalid channel_send format: %s", line)
-			}
-			fromGoroutine := parts[2]
-			channelName := fmt.Sprintf("chan_%s_%s", parts[3], parts[4])
-			graph.Edges = append(graph.Edges, parser.Edge{
-				From:  fromGoroutine,
-				To:    channelName,
-				Label: "send",
-			})
-
-		case "channel_close":
-			if len(parts) < 5 {
-				return nil, fmt.Errorf("invalid channel_close format: %s", line)
-			}
-			channelName := fmt.Sprintf("chan_%s_%s", parts[3], parts[4])
-			if ch, ok := graph.Channels[channelName]; ok {
-				ch.TS = parts[5]
-				graph.Channels[channelName] = ch
-			}
+// open открывает файлы трасс; closeAll закрывает открытые
+func (p *Parser) open(inputs []MergeInput) (opened []MergeInput, closeAll func(), err error) {
+	var files []*os.File
+	closeAll = func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, input := range inputs {
+		file, err := os.Open(input.Path)
+		if err != nil {
+			p.logger.Error("failed to open file", slog.String("error", err.Error()))
+			closeAll()
+			return nil, nil, err
 		}
+		files = append(files, file)
+		input.Reader = file
+		opened = append(opened, input)
+	}
+	return opened, closeAll, nil
+}
+
+// Merge объединяет трассы (Reader) в один граф: горутины и каналы каждой трассы получают
+// пространство имён источника, часы выравниваются способом align и явными сдвигами трасс
+func (p *Parser) Merge(inputs []MergeInput, align Align, mode Mode) (*parser.GorutineGraph, error) {
+	events, err := NewMergeReader(inputs, align, mode)
+	if err != nil {
+		p.logger.Error("failed to parse graph", slog.String("error", err.Error()))
+		return nil, err
+	}
+	builder := NewGraphBuilder()
+	for _, source := range events.Sources() {
+		builder.SetSource(source.Name, source.Offset)
 	}
+	return p.build(events, builder)
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scanner error: %v", err)
+// logDiagnostics пишет в журнал записи, пропущенные потоком
+func (p *Parser) logDiagnostics(events eventStream) {
+	for _, d := range events.Diagnostics() {
+		p.logger.Warn("Запись трассы пропущена", slog.String("error", d.Error()))
+	}
+	if omitted := events.OmittedDiagnostics(); omitted > 0 {
+		p.logger.Warn("Пропущены ещё записи трассы", slog.Int("count", omitted))
+	}
+}
+
+// eventStream — поток событий, по которому собирается граф: одна трасса или объединение нескольких
+type eventStream interface {
+	Next() (parser.Event, error)
+	Diagnostics() []parser.Diagnostic
+	OmittedDiagnostics() int
+}
+
+// build собирает граф по потоку событий
+func (p *Parser) build(events eventStream, builder *GraphBuilder) (*parser.GorutineGraph, error) {
+	for {
+		ev, err := events.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.logger.Error("failed to parse graph", slog.String("error", err.Error()))
+			return nil, err
+		}
+		builder.Add(ev)
 	}
+	graph := builder.Graph()
+	graph.Diagnostics = events.Diagnostics()
+	graph.OmittedDiagnostics = events.OmittedDiagnostics()
+	p.logDiagnostics(events)
 
 	return graph, nil
 }
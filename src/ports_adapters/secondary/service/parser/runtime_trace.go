@@ -0,0 +1,260 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"gtrace/src/domain/parser"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/trace"
+)
+
+// Трасса выполнения Go (runtime/trace: go test -trace, trace.Start) не требует инструментирования,
+// но видит только то, что видит планировщик: создание, блокировку, пробуждение и завершение горутин.
+// Каналы в ней не идентифицируются, а операции без блокировки не записываются. Поэтому каждая
+// блокировка на канале или select отображается на канал-заглушку места операции: операции
+// в одном месте исходников попадают в один канал, а отправка и получение в разных местах — в разные.
+// Канал-заглушка «создаётся» в месте операции как небуферизованный: в трассу попадают только операции,
+// которые заблокировались
+
+// runtimeTraceHeader — начало трассы выполнения: "go 1.NN trace"
+var runtimeTraceHeader = regexp.MustCompile(`^go 1\.\d+ trace`)
+
+// isRuntimeTrace сообщает, что поток — трасса выполнения Go
+func isRuntimeTrace(reader *bufio.Reader) bool {
+	header, _ := reader.Peek(16)
+	return runtimeTraceHeader.Match(header)
+}
+
+// runtimeBlock — горутина, ожидающая на канале или в select
+type runtimeBlock struct {
+	kind    string
+	channel uint64
+	site    string
+	ts      int64
+}
+
+// runtimeTraceDecoder переводит события трассы выполнения в события gtrace в виде полей текстового формата
+type runtimeTraceDecoder struct {
+	r         *trace.Reader
+	goVersion string
+	// record — номер последнего прочитанного события трассы выполнения
+	record int
+	failed bool
+	// pending — события gtrace, полученные из одного события трассы выполнения
+	pending [][]string
+	// header — заголовок ещё не выдан: он выдаётся по первому событию, когда известно время начала
+	header bool
+	// offset переводит монотонное время трассы выполнения во время Unix (по снимку часов, Go 1.25+)
+	offset int64
+	// ignored — системные горутины рантайма
+	ignored  map[trace.GoID]bool
+	blocked  map[trace.GoID]runtimeBlock
+	channels siteChannels
+}
+
+func newRuntimeTraceEventReader(reader *bufio.Reader, mode Mode) (*EventReader, error) {
+	head, _ := reader.Peek(16)
+	goVersion := strings.TrimSpace(strings.TrimSuffix(strings.TrimRight(string(head), "\x00"), "trace"))
+	r, err := trace.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("runtime trace: %w", err)
+	}
+	d := &runtimeTraceDecoder{
+		r:         r,
+		goVersion: strings.ReplaceAll(goVersion, " ", ""),
+		header:    true,
+		ignored:   make(map[trace.GoID]bool),
+		blocked:   make(map[trace.GoID]runtimeBlock),
+		channels:  make(siteChannels),
+	}
+	return &EventReader{next: d.next, mode: mode}, nil
+}
+
+// next возвращает следующее событие gtrace и номер события трассы выполнения, из которого оно получено
+func (d *runtimeTraceDecoder) next() ([]string, int, error) {
+	for len(d.pending) == 0 {
+		if d.failed {
+			return nil, d.record, io.EOF
+		}
+		ev, err := d.r.ReadEvent()
+		if err == io.EOF {
+			return nil, d.record, io.EOF
+		}
+		d.record++
+		if err != nil {
+			d.failed = true
+			if err == io.ErrUnexpectedEOF {
+				return nil, d.record, errTruncated
+			}
+			return nil, d.record, fmt.Errorf("%w: runtime trace: %v", errCorrupt, err)
+		}
+		d.convert(ev)
+	}
+	parts := d.pending[0]
+	d.pending = d.pending[1:]
+	return parts, d.record, nil
+}
+
+func (d *runtimeTraceDecoder) emit(kind string, fields ...string) {
+	d.pending = append(d.pending, append([]string{"[GTRACE]", kind}, fields...))
+}
+
+func (d *runtimeTraceDecoder) convert(ev trace.Event) {
+	switch ev.Kind() {
+	case trace.EventSync:
+		if snapshot := ev.Sync().ClockSnapshot; snapshot != nil && d.header {
+			d.offset = snapshot.Wall.UnixNano() - int64(snapshot.Trace)
+		}
+	case trace.EventStateTransition:
+		if st := ev.StateTransition(); st.Resource.Kind == trace.ResourceGoroutine {
+			d.goroutine(ev, st)
+		}
+	}
+	if d.header {
+		d.header = false
+		header := []string{"[GTRACE]", "trace_header", parser.TraceVersionRuntime, d.goVersion, "0", d.ts(ev.Time())}
+		d.pending = append([][]string{header}, d.pending...)
+	}
+}
+
+// goroutine переводит смену состояния горутины
+func (d *runtimeTraceDecoder) goroutine(ev trace.Event, st trace.StateTransition) {
+	id := st.Resource.Goroutine()
+	from, to := st.Goroutine()
+	ts := d.ts(ev.Time())
+	g := strconv.FormatInt(int64(id), 10)
+
+	switch {
+	case from == trace.GoNotExist && to != trace.GoNotExist:
+		entry := entryFunc(st.Stack)
+		if isSystemFunc(entry) {
+			d.ignored[id] = true
+			return
+		}
+		// номер запуска — номер дочерней горутины
+		creator := ev.Goroutine()
+		if creator != trace.NoGoroutine && !d.ignored[creator] {
+			site := userSite(ev.Stack())
+			d.emit("go_spawn", strconv.FormatInt(int64(creator), 10), g, entry, site, site, ts)
+		}
+		d.emit("func_start", g, entry, userSite(st.Stack), ts, g)
+		return
+
+	case from == trace.GoUndetermined:
+		// горутина существовала до начала трассы; функция известна, только если записан её стек
+		entry := entryFunc(st.Stack)
+		if isSystemFunc(entry) {
+			d.ignored[id] = true
+			return
+		}
+		if entry != "" {
+			d.emit("func_start", g, entry, userSite(st.Stack), ts, g)
+		}
+	}
+	if d.ignored[id] {
+		return
+	}
+
+	switch {
+	case to == trace.GoNotExist:
+		delete(d.blocked, id)
+		d.emit("func_end", g, "", "", ts, "return")
+
+	case to == trace.GoWaiting:
+		stack := st.Stack
+		if stack == trace.NoStack {
+			stack = ev.Stack()
+		}
+		d.block(id, g, st.Reason, userSite(stack), ev.Time(), ts)
+
+	case from == trace.GoWaiting:
+		b, ok := d.blocked[id]
+		if !ok {
+			return
+		}
+		delete(d.blocked, id)
+		ch := strconv.FormatUint(b.channel, 10)
+		switch b.kind {
+		case "select":
+			d.emit("select_case_chosen", g, b.site, b.site, ts, "select", ch)
+		default:
+			wait := strconv.FormatInt(int64(ev.Time())-b.ts, 10)
+			d.emit("channel_"+b.kind+"_done", g, ch, b.site, b.site, ts, wait)
+		}
+	}
+}
+
+// block переводит блокировку горутины на канале или в select; прочие причины ожидания пропускаются
+func (d *runtimeTraceDecoder) block(id trace.GoID, g string, reason string, site string, at trace.Time, ts string) {
+	b := runtimeBlock{site: site, ts: int64(at)}
+	switch reason {
+	case "chan send":
+		b.kind = "send"
+	case "chan receive":
+		b.kind = "receive"
+	case "select":
+		b.kind = "select"
+	case "forever":
+		// select{} или операция с nil-каналом: select без веток, горутина не проснётся
+		d.emit("select_enter", g, site, site, ts, "0", "-")
+		return
+	default:
+		return
+	}
+	b.channel = d.channel(site, ts)
+	if b.kind == "select" {
+		d.emit("select_enter", g, site, site, ts, "0", "-")
+	} else {
+		d.emit("channel_"+b.kind, g, strconv.FormatUint(b.channel, 10), site, site, ts)
+	}
+	d.blocked[id] = b
+}
+
+// channel возвращает канал-заглушку места операции
+func (d *runtimeTraceDecoder) channel(site string, ts string) uint64 {
+	id, created := d.channels.get(site)
+	if created {
+		d.emit("channel_create", strconv.FormatUint(id, 10), site, site, ts, "0")
+	}
+	return id
+}
+
+func (d *runtimeTraceDecoder) ts(t trace.Time) string {
+	return strconv.FormatInt(int64(t)+d.offset, 10)
+}
+
+// entryFunc возвращает функцию, с которой начинается стек горутины (пусто, если стек не записан)
+func entryFunc(stack trace.Stack) string {
+	entry := ""
+	for frame := range stack.Frames() {
+		entry = frame.Func
+	}
+	return entry
+}
+
+// userSite возвращает место верхнего кадра стека вне рантайма
+func userSite(stack trace.Stack) string {
+	site := ""
+	for frame := range stack.Frames() {
+		if site == "" {
+			site = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !isSystemFunc(frame.Func) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+	}
+	return site
+}
+
+// isSystemFunc сообщает, что функция принадлежит рантайму; runtime.main — главная горутина программы
+func isSystemFunc(name string) bool {
+	if name == "runtime.main" {
+		return false
+	}
+	return strings.HasPrefix(name, "runtime.") || strings.HasPrefix(name, "internal/") ||
+		strings.HasPrefix(name, "runtime/")
+}
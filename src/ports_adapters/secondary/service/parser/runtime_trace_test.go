@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"gtrace/src/domain/parser"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runtimeTrace — трасса выполнения программы testdata/runtime: main запускает worker, который ждёт
+// значения из values, а затем в select отправляет в done, пока main спит
+func runtimeTrace(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "runtime", "channels.trace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Трасса выполнения импортируется без инструментирования: создание горутины становится go_spawn,
+// блокировки на канале и в select — операциями с каналами-заглушками мест операций
+func TestRuntimeTrace(t *testing.T) {
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	graph, err := p.Parse(bytes.NewReader(runtimeTrace(t)), ModeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if graph.Header.Version != "runtime" || !strings.HasPrefix(graph.Header.GoVersion, "go1.") {
+		t.Errorf("заголовок: %+v", graph.Header)
+	}
+	worker := graph.Gorutines["9"]
+	if worker.Func != "main.worker" || worker.Parent != "1" || worker.SpawnSite != "app/main.go:30" ||
+		worker.State != parser.StateReturned {
+		t.Errorf("горутина worker: %+v", worker)
+	}
+
+	channels := make(map[string]string)
+	for name, ch := range graph.Channels {
+		channels[name] = ch.File
+	}
+	// место создания канала-заглушки — место операции: получение из values и select
+	want := map[string]string{"chan_1": "app/main.go:13", "chan_2": "app/main.go:14"}
+	if !reflect.DeepEqual(channels, want) {
+		t.Errorf("каналы %v, ожидались %v", channels, want)
+	}
+
+	var edges []string
+	for _, e := range graph.Edges {
+		edges = append(edges, e.From+" "+e.Label+" "+e.To)
+	}
+	if got := strings.Join(edges, ", "); got != "1 spawn 9, chan_1 receive 9, 9 select chan_2" {
+		t.Errorf("рёбра: %s", got)
+	}
+	if blocked := graph.Blocking.ByGoroutine["9"]; blocked.Ops != 2 || blocked.Total <= 0 {
+		t.Errorf("ожидание worker: %+v", blocked)
+	}
+}
+
+// Оборванная трасса выполнения в строгом режиме прерывает разбор, в нестрогом — разбирается
+// до места обрыва
+func TestRuntimeTraceTruncated(t *testing.T) {
+	data := runtimeTrace(t)
+	data = data[:len(data)-100]
+	p := NewParser(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var diag parser.Diagnostic
+	if _, err := p.Parse(bytes.NewReader(data), ModeStrict); !errors.As(err, &diag) {
+		t.Fatalf("строгий режим: ошибка %v", err)
+	}
+	graph, err := p.Parse(bytes.NewReader(data), ModeLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Diagnostics) != 1 {
+		t.Errorf("нестрогий режим: пропущены %v, ожидалась одна запись", graph.Diagnostics)
+	}
+}
@@ -0,0 +1,38 @@
+// Программа записывает трассу выполнения channels.trace:
+//
+//	go run -trimpath . channels.trace
+package main
+
+import (
+	"os"
+	"runtime/trace"
+	"time"
+)
+
+func worker(values chan int, done chan struct{}) {
+	<-values
+	select {
+	case done <- struct{}{}:
+	case <-time.After(time.Second):
+	}
+}
+
+func main() {
+	f, err := os.Create(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	if err := trace.Start(f); err != nil {
+		panic(err)
+	}
+	values := make(chan int)
+	done := make(chan struct{})
+	go worker(values, done)
+	time.Sleep(10 * time.Millisecond)
+	values <- 1
+	time.Sleep(10 * time.Millisecond)
+	<-done
+	time.Sleep(10 * time.Millisecond)
+	trace.Stop()
+	f.Close()
+}
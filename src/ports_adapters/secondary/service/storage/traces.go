@@ -0,0 +1,502 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	domain "gtrace/src/domain/parser"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTraceNotFound возвращается, если трассы с таким ID нет в хранилище
+var ErrTraceNotFound = errors.New("trace not found")
+
+// metaFile — файл метаданных в каталоге трассы; каталог без него — незавершённое сохранение
+const metaFile = "meta.json"
+
+// orphanAge — сколько каталог без meta.json, созданный не этим процессом, может не изменяться, прежде
+// чем считаться брошенным (запуск упал или был убит до сохранения) и быть удалённым. Пока запуск
+// пишет трассу, её файлы изменяются; до удаления размер каталога учитывается в MaxSize
+const orphanAge = time.Hour
+
+// maxCachedGraphs — сколько разобранных графов хранилище держит в памяти; графы остальных трасс
+// разбираются заново при обращении
+const maxCachedGraphs = 16
+
+// Откуда трасса попала в хранилище
+const (
+	// KindRun — трасса запуска программы командой run или заданием сервера
+	KindRun = "run"
+	// KindUpload — загруженная трасса
+	KindUpload = "upload"
+	// KindLive — живая трасса, которую программа отправляла на сервер во время выполнения
+	KindLive = "live"
+)
+
+// Retention — политика хранения: пока превышен любой из пределов, удаляются самые старые трассы.
+// Нулевой предел не ограничивает. Последняя сохранённая трасса не удаляется
+type Retention struct {
+	MaxCount int
+	MaxAge   time.Duration
+	MaxSize  int64
+}
+
+// Meta — метаданные трассы (meta.json в её каталоге)
+type Meta struct {
+	ID      string
+	Kind    string
+	Created time.Time
+	// Project — путь к трассированному проекту (для загруженных трасс — имена файлов)
+	Project string
+	// Commit — коммит git проекта ("-dirty" — с незафиксированными изменениями), если проект в git
+	Commit string
+	// Args — аргументы программы из заголовка трассы
+	Args []string
+	// Duration — время выполнения программы (для загруженных трасс не известно)
+	Duration time.Duration
+	Tags     []string
+	Summary  domain.Summary
+	// Files — файлы трассы в её каталоге; несколько файлов объединяются в один граф
+	Files  []File
+	Align  string
+	Strict bool
+	// Size — размер файлов трассы в байтах
+	Size int64
+}
+
+// File — файл трассы: имя источника, имя файла в каталоге трассы и явный сдвиг часов
+type File struct {
+	Name   string
+	Path   string
+	Offset time.Duration
+}
+
+// Trace — трасса из хранилища: метаданные, файлы для повторного чтения событий и граф
+type Trace struct {
+	Meta   Meta
+	Inputs []parser.MergeInput
+	Align  parser.Align
+	Mode   parser.Mode
+	Graph  *domain.GorutineGraph
+}
+
+// Traces — хранилище трасс в файловой системе: каталог dir/<ID> с файлами трассы и meta.json.
+// Метаданные читаются при создании хранилища и перечитываются при обращении к списку и неизвестной
+// трассе: в тот же каталог могут сохранять другие процессы (команда run при работающем сервере).
+// Графы разбираются при обращении; в памяти остаются графы maxCachedGraphs последних использованных трасс
+type Traces struct {
+	dir       string
+	retention Retention
+	parser    *parser.Parser
+	logger    *slog.Logger
+
+	mu     sync.Mutex
+	meta   map[string]Meta
+	graphs map[string]*domain.GorutineGraph
+	// pending — каталоги, созданные Create и ещё не сохранённые; invalid — каталоги с некорректным
+	// meta.json, о которых уже предупреждали
+	pending map[string]bool
+	invalid map[string]bool
+	// recent — ID трасс с графами в памяти, от давно использованных к недавним
+	recent []string
+}
+
+func NewTraces(dir string, retention Retention, parserService *parser.Parser, logger *slog.Logger) *Traces {
+	s := &Traces{
+		dir:       dir,
+		retention: retention,
+		parser:    parserService,
+		logger:    logger,
+		meta:      make(map[string]Meta),
+		graphs:    make(map[string]*domain.GorutineGraph),
+		pending:   make(map[string]bool),
+		invalid:   make(map[string]bool),
+	}
+	s.load()
+	return s
+}
+
+// load читает метаданные сохранённых трасс и применяет политику хранения
+func (s *Traces) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyRetention()
+}
+
+// scan сверяет метаданные с каталогом хранилища: читает meta.json трасс, сохранённых другими
+// процессами, забывает трассы, каталоги которых удалены, и возвращает каталоги без meta.json,
+// кроме создаваемых этим процессом. Вызывается под s.mu
+func (s *Traces) scan() (orphans []string) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Warn("Не удалось прочитать хранилище трасс", "dir", s.dir, "error", err)
+		}
+		return nil
+	}
+	found := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() {
+			continue
+		}
+		found[id] = true
+		if _, ok := s.meta[id]; ok || s.invalid[id] {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, id, metaFile))
+		if os.IsNotExist(err) {
+			if !s.pending[id] {
+				orphans = append(orphans, id)
+			}
+			continue
+		}
+		var meta Meta
+		if err == nil {
+			err = json.Unmarshal(data, &meta)
+		}
+		if err != nil || meta.ID != id {
+			s.logger.Warn("Некорректные метаданные трассы", "id", id, "error", err)
+			s.invalid[id] = true
+			continue
+		}
+		s.meta[id] = meta
+	}
+	for id := range s.meta {
+		if !found[id] {
+			s.forget(id)
+		}
+	}
+	return orphans
+}
+
+// Create выделяет ID новой трассы и создаёт её каталог для файлов трассы
+func (s *Traces) Create() (id string, dir string, err error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(b)
+	dir = filepath.Join(s.dir, id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", fmt.Errorf("создание каталога трассы: %w", err)
+	}
+	s.pending[id] = true
+	return id, dir, nil
+}
+
+// Save сохраняет метаданные трассы, созданной Create, и её граф; затем применяет политику хранения.
+// ID, Created, Size и Summary заполняются хранилищем. graph == nil — граф разбирается из файлов трассы
+func (s *Traces) Save(id string, meta Meta, graph *domain.GorutineGraph) (Meta, error) {
+	if graph == nil {
+		trace := s.trace(id, meta)
+		parsed, err := s.parser.ParseFiles(trace.Inputs, trace.Align, trace.Mode)
+		if err != nil {
+			return Meta{}, fmt.Errorf("разбор трассы %s: %w", id, err)
+		}
+		graph = parsed
+	}
+	meta.ID = id
+	meta.Created = time.Now()
+	meta.Summary = graph.Summary()
+	meta.Size = 0
+	for _, f := range meta.Files {
+		info, err := os.Stat(filepath.Join(s.dir, id, f.Path))
+		if err != nil {
+			return Meta{}, err
+		}
+		meta.Size += info.Size()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writeMeta(meta); err != nil {
+		return Meta{}, err
+	}
+	delete(s.pending, id)
+	s.meta[id] = meta
+	s.cacheGraph(id, graph)
+	s.logger.Info("Трасса сохранена", "id", id, "kind", meta.Kind, "size", meta.Size)
+	s.applyRetention()
+	return meta, nil
+}
+
+// Discard удаляет каталог трассы, которую не удалось сохранить
+func (s *Traces) Discard(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+		s.logger.Warn("Не удалось удалить каталог трассы", "id", id, "error", err)
+	}
+	delete(s.pending, id)
+}
+
+// lookup возвращает метаданные трассы, перечитывая каталог хранилища, если трасса неизвестна.
+// Вызывается под s.mu
+func (s *Traces) lookup(id string) (Meta, bool) {
+	if meta, ok := s.meta[id]; ok {
+		return meta, true
+	}
+	s.scan()
+	meta, ok := s.meta[id]
+	return meta, ok
+}
+
+// Get возвращает трассу по ID, при первом обращении разбирая её файлы; ErrTraceNotFound — трассы нет
+func (s *Traces) Get(id string) (*Trace, error) {
+	s.mu.Lock()
+	meta, ok := s.lookup(id)
+	graph := s.graphs[id]
+	if graph != nil {
+		s.cacheGraph(id, graph)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTraceNotFound, id)
+	}
+
+	trace := s.trace(id, meta)
+	trace.Graph = graph
+	if trace.Graph != nil {
+		return trace, nil
+	}
+
+	graph, err := s.parser.ParseFiles(trace.Inputs, trace.Align, trace.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("разбор трассы %s: %w", id, err)
+	}
+	s.mu.Lock()
+	if _, ok := s.meta[id]; ok {
+		s.cacheGraph(id, graph)
+	}
+	s.mu.Unlock()
+	trace.Graph = graph
+	return trace, nil
+}
+
+// trace возвращает трассу без графа: файлы и параметры их разбора из метаданных
+func (s *Traces) trace(id string, meta Meta) *Trace {
+	trace := &Trace{Meta: meta, Align: parser.AlignStart, Mode: parser.ModeLenient}
+	if meta.Align == "wall" {
+		trace.Align = parser.AlignWall
+	}
+	if meta.Strict {
+		trace.Mode = parser.ModeStrict
+	}
+	for _, f := range meta.Files {
+		trace.Inputs = append(trace.Inputs, parser.MergeInput{
+			Name:   f.Name,
+			Path:   filepath.Join(s.dir, id, f.Path),
+			Offset: f.Offset,
+		})
+	}
+	return trace
+}
+
+// Meta возвращает метаданные трассы, не разбирая её файлы
+func (s *Traces) Meta(id string) (Meta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.lookup(id)
+	if !ok {
+		return Meta{}, fmt.Errorf("%w: %s", ErrTraceNotFound, id)
+	}
+	return meta, nil
+}
+
+// List возвращает метаданные трасс в порядке сохранения; с тегом — только трассы с этим тегом.
+// Каталог хранилища перечитывается: в список попадают трассы, сохранённые другими процессами
+func (s *Traces) List(tag string) []Meta {
+	s.mu.Lock()
+	s.scan()
+	list := make([]Meta, 0, len(s.meta))
+	for _, meta := range s.meta {
+		if tag == "" || slices.Contains(meta.Tags, tag) {
+			list = append(list, meta)
+		}
+	}
+	s.mu.Unlock()
+	sortByCreated(list)
+	return list
+}
+
+// Tag добавляет трассе теги add и снимает теги remove
+func (s *Traces) Tag(id string, add []string, remove []string) (Meta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.lookup(id)
+	if !ok {
+		return Meta{}, fmt.Errorf("%w: %s", ErrTraceNotFound, id)
+	}
+	tags := slices.DeleteFunc(slices.Clone(meta.Tags), func(tag string) bool {
+		return slices.Contains(remove, tag)
+	})
+	for _, tag := range add {
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	meta.Tags = tags
+	if err := s.writeMeta(meta); err != nil {
+		return Meta{}, err
+	}
+	s.meta[id] = meta
+	return meta, nil
+}
+
+// Delete удаляет трассу
+func (s *Traces) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lookup(id); !ok {
+		return fmt.Errorf("%w: %s", ErrTraceNotFound, id)
+	}
+	return s.delete(id)
+}
+
+func (s *Traces) delete(id string) error {
+	if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+		return fmt.Errorf("удаление трассы %s: %w", id, err)
+	}
+	s.forget(id)
+	return nil
+}
+
+// forget убирает трассу из памяти хранилища. Вызывается под s.mu
+func (s *Traces) forget(id string) {
+	delete(s.meta, id)
+	delete(s.graphs, id)
+	s.recent = slices.DeleteFunc(s.recent, func(r string) bool { return r == id })
+}
+
+// cacheGraph запоминает граф трассы как недавно использованный и вытесняет графы сверх
+// maxCachedGraphs. Вызывается под s.mu
+func (s *Traces) cacheGraph(id string, graph *domain.GorutineGraph) {
+	s.graphs[id] = graph
+	s.recent = append(slices.DeleteFunc(s.recent, func(r string) bool { return r == id }), id)
+	for len(s.recent) > maxCachedGraphs {
+		delete(s.graphs, s.recent[0])
+		s.recent = s.recent[1:]
+	}
+}
+
+// Expire применяет политику хранения каждые every до отмены ctx: на долго работающем сервере
+// трассы старше MaxAge удаляются, даже если новые трассы не сохраняются
+func (s *Traces) Expire(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.applyRetention()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// applyRetention удаляет брошенные каталоги без meta.json (см. orphanAge) и самые старые трассы,
+// пока превышен любой из пределов; размер остальных каталогов без meta.json входит в MaxSize.
+// Самая новая трасса не удаляется. Вызывается под s.mu
+func (s *Traces) applyRetention() {
+	var total int64
+	for _, id := range s.scan() {
+		size, modified, err := dirUsage(filepath.Join(s.dir, id))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				s.logger.Warn("Не удалось прочитать каталог несохранённой трассы", "id", id, "error", err)
+			}
+			continue
+		}
+		if time.Since(modified) > orphanAge {
+			if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+				s.logger.Warn("Не удалось удалить каталог несохранённой трассы", "id", id, "error", err)
+			} else {
+				s.logger.Info("Удалён каталог несохранённой трассы", "id", id, "modified", modified)
+				continue
+			}
+		}
+		total += size
+	}
+
+	list := make([]Meta, 0, len(s.meta))
+	for _, meta := range s.meta {
+		list = append(list, meta)
+		total += meta.Size
+	}
+	sortByCreated(list)
+
+	r := s.retention
+	count := len(list)
+	for _, meta := range list[:max(len(list)-1, 0)] {
+		exceeded := (r.MaxCount > 0 && count > r.MaxCount) ||
+			(r.MaxAge > 0 && time.Since(meta.Created) > r.MaxAge) ||
+			(r.MaxSize > 0 && total > r.MaxSize)
+		if !exceeded {
+			break
+		}
+		if err := s.delete(meta.ID); err != nil {
+			s.logger.Warn("Не удалось удалить трассу по политике хранения", "id", meta.ID, "error", err)
+			continue
+		}
+		s.logger.Info("Трасса удалена по политике хранения", "id", meta.ID, "created", meta.Created)
+		count--
+		total -= meta.Size
+	}
+}
+
+// writeMeta записывает meta.json через временный файл, чтобы прерванная запись не испортила метаданные
+func (s *Traces) writeMeta(meta Meta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, meta.ID, metaFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// dirUsage возвращает суммарный размер файлов каталога и время последнего изменения в нём
+func dirUsage(dir string) (size int64, modified time.Time, err error) {
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			size += info.Size()
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+	return size, modified, err
+}
+
+func sortByCreated(list []Meta) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+}
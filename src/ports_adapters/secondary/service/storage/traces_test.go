@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gtrace/src/ports_adapters/secondary/service/parser"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTraces(t *testing.T, retention Retention) *Traces {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewTraces(t.TempDir(), retention, parser.NewParser(logger), logger)
+}
+
+// save сохраняет пустую трассу и возвращает её ID
+func save(t *testing.T, s *Traces) string {
+	t.Helper()
+	id, _, err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(id, Meta{Kind: KindUpload}, parser.NewGraphBuilder().Graph()); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// В памяти остаются графы только maxCachedGraphs последних использованных трасс
+func TestTracesGraphCacheIsBounded(t *testing.T) {
+	s := newTestTraces(t, Retention{})
+	first := save(t, s)
+	second := save(t, s)
+	for range maxCachedGraphs - 2 {
+		save(t, s)
+	}
+	// обращение к first делает его недавно использованным: вытесняется second
+	if _, err := s.Get(first); err != nil {
+		t.Fatal(err)
+	}
+	save(t, s)
+
+	if len(s.graphs) != maxCachedGraphs || len(s.recent) != maxCachedGraphs {
+		t.Fatalf("графов в памяти %d (recent %d), ожидалось %d", len(s.graphs), len(s.recent), maxCachedGraphs)
+	}
+	if s.graphs[first] == nil {
+		t.Errorf("граф недавно использованной трассы %s вытеснен", first)
+	}
+	if s.graphs[second] != nil {
+		t.Errorf("граф давно использованной трассы %s остался в памяти", second)
+	}
+	if len(s.List("")) != maxCachedGraphs+1 {
+		t.Errorf("вытеснение графа не должно удалять трассу")
+	}
+}
+
+// Expire удаляет устаревшие трассы, даже если новые не сохраняются; последняя трасса остаётся
+func TestTracesExpire(t *testing.T) {
+	s := newTestTraces(t, Retention{MaxAge: 50 * time.Millisecond})
+	save(t, s)
+	newest := save(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Expire(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.List("")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("трасс %d, ожидалась одна", len(s.List("")))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if list := s.List(""); list[0].ID != newest {
+		t.Fatalf("осталась трасса %s, ожидалась последняя %s", list[0].ID, newest)
+	}
+}
+
+// orphan создаёт каталог трассы без meta.json с файлом size байт, изменённый age назад
+func orphan(t *testing.T, s *Traces, size int, age time.Duration) string {
+	t.Helper()
+	dir := filepath.Join(s.dir, fmt.Sprintf("orphan-%d-%s", size, age))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "1.trace")
+	if err := os.WriteFile(file, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-age)
+	for _, path := range []string{file, dir} {
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Брошенные каталоги без meta.json удаляются, недавно изменявшиеся — остаются и учитываются в MaxSize;
+// каталог, который создаёт этот же процесс, не трогается
+func TestTracesOrphans(t *testing.T) {
+	s := newTestTraces(t, Retention{MaxSize: 100})
+	stale := orphan(t, s, 10, 2*orphanAge)
+	active := orphan(t, s, 1000, time.Minute)
+	pending, pendingDir, err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * orphanAge)
+	if err := os.Chtimes(pendingDir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	first := save(t, s)
+	second := save(t, s)
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("брошенный каталог не удалён: %v", err)
+	}
+	if _, err := os.Stat(active); err != nil {
+		t.Errorf("каталог, который ещё записывается, удалён: %v", err)
+	}
+	if _, err := os.Stat(pendingDir); err != nil {
+		t.Errorf("каталог создаваемой трассы %s удалён: %v", pending, err)
+	}
+	// активный каталог превышает MaxSize: удаляются все трассы, кроме последней
+	if _, err := s.Meta(first); !errors.Is(err, ErrTraceNotFound) {
+		t.Errorf("трасса %s не удалена по размеру: %v", first, err)
+	}
+	if list := s.List(""); len(list) != 1 || list[0].ID != second {
+		t.Errorf("трассы %v, ожидалась только %s", list, second)
+	}
+}
+
+// Трассы, сохранённые в тот же каталог другим процессом, появляются в списке, а удалённые им — исчезают
+func TestTracesSharedDir(t *testing.T) {
+	server := newTestTraces(t, Retention{})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cli := NewTraces(server.dir, Retention{}, parser.NewParser(logger), logger)
+
+	id := save(t, cli)
+	if list := server.List(""); len(list) != 1 || list[0].ID != id {
+		t.Fatalf("трассы %v, ожидалась %s", list, id)
+	}
+	other := save(t, cli)
+	if _, err := server.Get(other); err != nil {
+		t.Fatalf("трасса %s не найдена: %v", other, err)
+	}
+	if err := cli.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if list := server.List(""); len(list) != 1 || list[0].ID != other {
+		t.Fatalf("трассы %v, ожидалась только %s", list, other)
+	}
+}